@@ -117,6 +117,18 @@ rules:
   - list
   - watch
   - patch
+- apiGroups:
+  - ""
+  resources:
+  - pods
+  verbs:
+  - update
+- apiGroups:
+  - ""
+  resources:
+  - endpoints
+  verbs:
+  - get
 - apiGroups:
   - ""
   resources:
@@ -148,6 +160,17 @@ rules:
   verbs:
   - create
 {{- end }}      
+{{- if .Values.executor.poolmgr.resourceQuota }}
+- apiGroups:
+  - ""
+  resources:
+  - resourcequotas
+  verbs:
+  - create
+  - get
+  - update
+  - delete
+{{- end }}
 - apiGroups:
   - apps
   resources:
@@ -43,6 +43,8 @@ func Run(ctx context.Context, logger *zap.Logger) {
 	specializePayload := flag.String("specialize-request", "", "JSON payload for specialize request")
 	secretDir := flag.String("secret-dir", "", "Path to shared secrets directory")
 	configDir := flag.String("cfgmap-dir", "", "Path to shared configmap directory")
+	port := flag.String("port", "8000", "Port to serve fetcher requests on")
+	runtimePort := flag.Int("runtime-port", fetcher.DefaultRuntimePort, "Port of the runtime container to send specialize requests to")
 
 	flag.Parse()
 	if flag.NArg() == 0 {
@@ -76,6 +78,7 @@ func Run(ctx context.Context, logger *zap.Logger) {
 	if err != nil {
 		logger.Fatal("error making fetcher", zap.Error(err))
 	}
+	f.WithRuntimePort(*runtimePort)
 
 	// do specialization in other goroutine to prevent blocking in newdeploy
 	go func() {
@@ -87,7 +90,7 @@ func Run(ctx context.Context, logger *zap.Logger) {
 				logger.Fatal("error decoding specialize request", zap.Error(err))
 			}
 
-			err = f.SpecializePod(ctx, specializeReq.FetchReq, specializeReq.LoadReq)
+			_, err = f.SpecializePod(ctx, specializeReq.FetchReq, specializeReq.LoadReq)
 			if err != nil {
 				logger.Fatal("error specializing function pod", zap.Error(err))
 			}
@@ -96,9 +99,11 @@ func Run(ctx context.Context, logger *zap.Logger) {
 	}()
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/fetch", f.FetchHandler)
-	mux.HandleFunc("/specialize", f.SpecializeHandler)
-	mux.HandleFunc("/upload", f.UploadHandler)
+	authToken := os.Getenv(fetcher.AuthTokenEnv)
+	mux.HandleFunc("/fetch", fetcher.AuthHandler(authToken, f.FetchHandler))
+	mux.HandleFunc("/specialize", fetcher.AuthHandler(authToken, f.SpecializeHandler))
+	mux.HandleFunc("/upload", fetcher.AuthHandler(authToken, f.UploadHandler))
+	mux.HandleFunc("/reset", fetcher.AuthHandler(authToken, f.ResetHandler))
 	mux.HandleFunc("/version", f.VersionHandler)
 	mux.HandleFunc("/wsevent/start", f.WsStartHandler)
 	mux.HandleFunc("/wsevent/end", f.WsEndHandler)
@@ -119,9 +124,9 @@ func Run(ctx context.Context, logger *zap.Logger) {
 	logger.Info("fetcher ready to receive requests")
 
 	handler := otelUtils.GetHandlerWithOTEL(mux, "fission-fetcher", otelUtils.UrlsToIgnore("/healthz", "/readiness-healthz"))
-	httpserver.StartServer(ctx, logger, "fetcher", "8000", handler)
+	httpserver.StartServer(ctx, logger, "fetcher", *port, handler)
 }
 
 func fetcherUsage() {
-	fmt.Println("Usage: fetcher [-specialize-on-startup] [-specialize-request <json>] [-secret-dir <string>] [-cfgmap-dir <string>] <shared volume path>")
+	fmt.Println("Usage: fetcher [-specialize-on-startup] [-specialize-request <json>] [-secret-dir <string>] [-cfgmap-dir <string>] [-port <string>] [-runtime-port <int>] <shared volume path>")
 }
@@ -123,10 +123,16 @@ const (
 )
 
 // executor kubernetes object label key
+//
+// These keys are set and selected on by the executor, the controller, the
+// CLI and the log forwarder alike, and are part of the immutable selector of
+// the pool deployments, so they can't be renamed or prefixed without
+// migrating the objects already labeled with them.
 const (
 	ENVIRONMENT_NAMESPACE     = "environmentNamespace"
 	ENVIRONMENT_NAME          = "environmentName"
 	ENVIRONMENT_UID           = "environmentUid"
+	ENVIRONMENT_GENERATION    = "environmentGeneration"
 	FUNCTION_NAMESPACE        = "functionNamespace"
 	FUNCTION_NAME             = "functionName"
 	FUNCTION_UID              = "functionUid"
@@ -139,6 +145,97 @@ const (
 	ANNOTATION_SVC_HOST = "svcHost"
 )
 
+// function annotations requesting a service of its own for the specialized
+// pod of a poolmgr function
+const (
+	// ANNOTATION_SVC_TYPE is the type of the service: ClusterIP, NodePort,
+	// LoadBalancer or SvcTypeHeadless. NodePort and LoadBalancer services
+	// are only created if the operator allows exposing function services.
+	ANNOTATION_SVC_TYPE = "executor.fission.io/service-type"
+	// SvcTypeHeadless asks for a ClusterIP service without a cluster IP,
+	// which resolves straight to the address of the pod of the function.
+	SvcTypeHeadless = "Headless"
+	// ANNOTATION_SVC_NODE_PORT pins the node port of the runtime port.
+	ANNOTATION_SVC_NODE_PORT = "executor.fission.io/node-port"
+	// ANNOTATION_SVC_PUBLISH_NOT_READY routes traffic to the pod even while
+	// it fails its readiness probe, "true" or "false" (default).
+	ANNOTATION_SVC_PUBLISH_NOT_READY = "executor.fission.io/publish-not-ready-addresses"
+	// ANNOTATION_SVC_ANNOTATION_PREFIX prefixes function annotations set on
+	// the service without the prefix, e.g. to configure a load balancer or a
+	// service mesh. They also apply to the services of pools creating one
+	// for every function. Like NodePort and LoadBalancer services, they are
+	// only set if the operator allows exposing function services, and only
+	// with the prefixes the operator allows.
+	ANNOTATION_SVC_ANNOTATION_PREFIX = "service.executor.fission.io/"
+)
+
+// ANNOTATION_HEADLESS_SVC makes the services poolmgr creates for the
+// functions of an environment headless, "true" or "false" (default), e.g.
+// for gRPC streaming runtimes. It applies to ClusterIP services only.
+const ANNOTATION_HEADLESS_SVC = "executor.fission.io/headless-service"
+
+// ANNOTATION_PREWARM is the number of pods to specialize for a poolmgr
+// function as soon as the pool of its environment is created. Functions
+// with a service of their own are served by a single pod, so they get at
+// most one.
+const ANNOTATION_PREWARM = "executor.fission.io/prewarm"
+
+// ANNOTATION_SPECIALIZE_PROBE is how poolmgr checks that the runtime of an
+// environment serves a function once its pod is specialized: SpecializeProbeHTTP
+// (default) relies on the specialize request alone, SpecializeProbeGRPC also
+// waits for the gRPC server of the runtime to report SERVING through the
+// grpc.health.v1 protocol.
+const ANNOTATION_SPECIALIZE_PROBE = "executor.fission.io/specialize-probe"
+
+const (
+	SpecializeProbeHTTP = "http"
+	SpecializeProbeGRPC = "grpc"
+)
+
+// ANNOTATION_SPECIALIZE_PROBE_PATH makes SpecializeProbeHTTP also wait for
+// GET requests of this path to the runtime of a specialized pod to return
+// ANNOTATION_SPECIALIZE_PROBE_STATUS (default 200), for runtimes which
+// can't serve the function as soon as they answer the specialize request.
+const (
+	ANNOTATION_SPECIALIZE_PROBE_PATH   = "executor.fission.io/specialize-probe-path"
+	ANNOTATION_SPECIALIZE_PROBE_STATUS = "executor.fission.io/specialize-probe-status"
+)
+
+// ANNOTATION_FETCHER_FILENAME is the name fetchers write the functions of an
+// environment as, for runtimes which import them by name or extension, e.g.
+// handler.py. It must be a plain file name. It's ignored by environments
+// loading any number of functions per container, whose functions are named
+// by their UID.
+const ANNOTATION_FETCHER_FILENAME = "executor.fission.io/fetcher-filename"
+
+// ANNOTATION_MAX_PODS caps the pods of the pool of a poolmgr environment,
+// generic and specialized, e.g. to share a cluster between environments.
+// It must be greater than the pool size.
+const ANNOTATION_MAX_PODS = "executor.fission.io/max-pods"
+
+// ANNOTATION_POD_READY_TIMEOUT is how long the pool of a poolmgr
+// environment waits for a generic pod to become ready, as a duration, e.g.
+// "30s" for an image which starts fast or "15m" for a large one. It
+// overrides the timeout of the executor and is read when the pool is
+// created.
+const ANNOTATION_POD_READY_TIMEOUT = "executor.fission.io/pod-ready-timeout"
+
+// ANNOTATION_ADOPT_POD_SELECTOR is a label selector of running pods the
+// pool of a poolmgr environment specializes besides the pods of its own
+// deployment, e.g. pods provisioned ahead of time on warm nodes. Adopted
+// pods are claimed like the pool's own: they must be labeled with MANAGED
+// "true" and run the fetcher and the runtime of the environment. The pool
+// doesn't own them, so it neither creates nor replaces them and doesn't
+// roll them to new environment specs. Once specialized they're deleted when
+// idle, like the pool's own pods, regardless of whatever manages them. The
+// selector is read when the pool is created. Pods labeled with an
+// environment, such as the pods of other pools, are never adopted: a broad
+// selector, e.g. "executorType=poolmgr", would otherwise specialize
+// functions on the runtime of another environment. Any other matching pod
+// is trusted to run the environment's runtime, so the selector must only
+// match pods provisioned for it.
+const ANNOTATION_ADOPT_POD_SELECTOR = "executor.fission.io/adopt-pod-selector"
+
 const (
 	ArchiveLiteralSizeLimit int64 = 256 * 1024
 )
@@ -17,6 +17,8 @@ limitations under the License.
 package v1
 
 import (
+	"time"
+
 	asv2beta2 "k8s.io/api/autoscaling/v2beta2"
 	apiv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -882,3 +884,12 @@ func (fn Function) GetRequestPerPod() int {
 	}
 	return fn.Spec.RequestsPerPod
 }
+
+// GetFunctionTimeout returns how long a request to the function may run,
+// DEFAULT_FUNCTION_TIMEOUT seconds unless the function sets its own.
+func (fn Function) GetFunctionTimeout() time.Duration {
+	if fn.Spec.FunctionTimeout <= 0 {
+		return time.Duration(DEFAULT_FUNCTION_TIMEOUT) * time.Second
+	}
+	return time.Duration(fn.Spec.FunctionTimeout) * time.Second
+}
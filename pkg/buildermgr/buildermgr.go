@@ -63,7 +63,7 @@ func Start(ctx context.Context, logger *zap.Logger, storageSvcUrl string) error
 	envWatcher.Run(ctx)
 
 	pkgWatcher := makePackageWatcher(bmLogger, fissionClient,
-		kubernetesClient, storageSvcUrl,
+		kubernetesClient, storageSvcUrl, fetcherConfig.AuthToken(),
 		utils.GetK8sInformersForNamespaces(kubernetesClient, time.Minute*30, fv1.Pods),
 		utils.GetInformersForNamespaces(fissionClient, time.Minute*30, fv1.PackagesResource))
 	pkgWatcher.Run(ctx)
@@ -24,6 +24,7 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/hashicorp/go-multierror"
@@ -34,6 +35,7 @@ import (
 	"github.com/fission/fission/pkg/crd"
 	ferror "github.com/fission/fission/pkg/error"
 	"github.com/fission/fission/pkg/executor/client"
+	"github.com/fission/fission/pkg/executor/executortype/poolmgr"
 	"github.com/fission/fission/pkg/executor/fscache"
 	"github.com/fission/fission/pkg/utils/httpserver"
 	"github.com/fission/fission/pkg/utils/metrics"
@@ -111,7 +113,7 @@ func (executor *Executor) getServiceForFunctionAPI(w http.ResponseWriter, r *htt
 
 	serviceName, err := executor.getServiceForFunction(ctx, fn)
 	if err != nil {
-		code, msg := ferror.GetHTTPError(err)
+		code, msg := getFunctionServiceHTTPError(err)
 		logger.Error("error getting service for function",
 			zap.Error(err),
 			zap.String("function", fn.ObjectMeta.Name),
@@ -122,6 +124,32 @@ func (executor *Executor) getServiceForFunctionAPI(w http.ResponseWriter, r *htt
 	executor.writeResponse(w, serviceName, fn.ObjectMeta.Name)
 }
 
+// getFunctionServiceHTTPError maps errors of creating a function service
+// to an HTTP status code and message. Specialization errors tell the stage
+// which failed.
+func getFunctionServiceHTTPError(err error) (int, string) {
+	code, msg := ferror.GetHTTPError(err)
+	var specializeErr *poolmgr.SpecializeError
+	if errors.As(err, &specializeErr) {
+		msg = fmt.Sprintf("%s stage of specialization failed: %s", specializeErr.Stage, msg)
+	}
+	switch {
+	case errors.Is(err, poolmgr.ErrPodReadyTimeout), errors.Is(err, poolmgr.ErrNoReadyPods),
+		errors.Is(err, poolmgr.ErrNoPodIP), errors.Is(err, poolmgr.ErrControllerUnavailable):
+		code = http.StatusServiceUnavailable
+	case (errors.Is(err, poolmgr.ErrFetcherFailed) || errors.Is(err, poolmgr.ErrRuntimeLoadFailed)) &&
+		code == http.StatusInternalServerError:
+		code = http.StatusBadGateway
+	case errors.Is(err, poolmgr.ErrSpecializeTimeout):
+		code = http.StatusGatewayTimeout
+	case errors.Is(err, poolmgr.ErrNodePortConflict):
+		code = http.StatusConflict
+	case errors.Is(err, poolmgr.ErrPoolAtCapacity):
+		code = http.StatusTooManyRequests
+	}
+	return code, msg
+}
+
 func (executor *Executor) writeResponse(w http.ResponseWriter, serviceName string, fnName string) {
 	_, err := w.Write([]byte(serviceName))
 	if err != nil {
@@ -231,10 +259,31 @@ func (executor *Executor) tapServices(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// apiServerCheckTimeout bounds the API server check of readyHandler, so that
+// the readiness probe fails rather than hangs on an unresponsive API server.
+const apiServerCheckTimeout = 2 * time.Second
+
 func (executor *Executor) healthHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// readyHandler reports the executor ready as long as it can reach the
+// kubernetes API server, which every executor type needs to create and
+// look up function services. Unlike healthHandler, which only tells that
+// the executor is up, it depends on the API server, so an outage takes
+// executors out of service rather than restarting them.
+func (executor *Executor) readyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), apiServerCheckTimeout)
+	defer cancel()
+	err := executor.fissionClient.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Error()
+	if err != nil {
+		executor.logger.Error("kubernetes API server unreachable", zap.Error(err))
+		http.Error(w, "kubernetes API server unreachable", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
 func (executor *Executor) unTapService(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	body, err := io.ReadAll(r.Body)
@@ -284,6 +333,7 @@ func (executor *Executor) GetHandler() http.Handler {
 	r.HandleFunc("/v2/tapService", executor.tapService).Methods("POST") // for backward compatibility
 	r.HandleFunc("/v2/tapServices", executor.tapServices).Methods("POST")
 	r.HandleFunc("/healthz", executor.healthHandler).Methods("GET")
+	r.HandleFunc("/readyz", executor.readyHandler).Methods("GET")
 	r.HandleFunc("/v2/unTapService", executor.unTapService).Methods("POST")
 	r.HandleFunc("/v2/debugInfo", executor.dumpDebugInfo).Methods("GET")
 	return r
@@ -291,7 +341,7 @@ func (executor *Executor) GetHandler() http.Handler {
 
 // Serve starts an HTTP server.
 func (executor *Executor) Serve(ctx context.Context, port int) {
-	handler := otelUtils.GetHandlerWithOTEL(executor.GetHandler(), "fission-executor", otelUtils.UrlsToIgnore("/healthz"))
+	handler := otelUtils.GetHandlerWithOTEL(executor.GetHandler(), "fission-executor", otelUtils.UrlsToIgnore("/healthz", "/readyz"))
 	httpserver.StartServer(ctx, executor.logger, "executor", fmt.Sprintf("%d", port), handler)
 
 }
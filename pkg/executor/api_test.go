@@ -0,0 +1,80 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/rest"
+
+	"github.com/fission/fission/pkg/generated/clientset/versioned"
+	"github.com/fission/fission/pkg/utils/loggerfactory"
+)
+
+func TestHealthHandlers(t *testing.T) {
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, err := w.Write([]byte(`{"major":"1","minor":"25","gitVersion":"v1.25.4"}`))
+		if err != nil {
+			t.Errorf("error writing response: %v", err)
+		}
+	}))
+	defer apiServer.Close()
+	hungAPIServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer hungAPIServer.Close()
+
+	tests := []struct {
+		name      string
+		host      string
+		wantReady int
+	}{
+		{"api server reachable", apiServer.URL, http.StatusOK},
+		{"api server unreachable", "http://127.0.0.1:1", http.StatusServiceUnavailable},
+		{"api server not responding", hungAPIServer.URL, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fissionClient, err := versioned.NewForConfig(&rest.Config{Host: tt.host})
+			if err != nil {
+				t.Fatalf("Error creating fission client: %v", err)
+			}
+			executor := &Executor{logger: loggerfactory.GetLogger(), fissionClient: fissionClient}
+
+			// the liveness probe doesn't depend on the API server
+			w := httptest.NewRecorder()
+			executor.GetHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			if w.Code != http.StatusOK {
+				t.Errorf("/healthz status %d, want %d", w.Code, http.StatusOK)
+			}
+
+			start := time.Now()
+			w = httptest.NewRecorder()
+			executor.GetHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if w.Code != tt.wantReady {
+				t.Errorf("/readyz status %d, want %d", w.Code, tt.wantReady)
+			}
+			if elapsed := time.Since(start); elapsed > 2*apiServerCheckTimeout {
+				t.Errorf("/readyz took %v, want at most about %v", elapsed, apiServerCheckTimeout)
+			}
+		})
+	}
+}
@@ -269,7 +269,11 @@ func StartExecutor(ctx context.Context, logger *zap.Logger, port int) error {
 		return errors.Wrap(err, "error waiting for CRDs")
 	}
 
-	fetcherConfig, err := fetcherConfig.MakeFetcherConfig("/userfunc")
+	sharedMountPath := os.Getenv("FETCHER_SHARED_MOUNT_PATH")
+	if len(sharedMountPath) == 0 {
+		sharedMountPath = "/userfunc"
+	}
+	fetcherConfig, err := fetcherConfig.MakeFetcherConfig(sharedMountPath)
 	if err != nil {
 		return errors.Wrap(err, "Error making fetcher config")
 	}
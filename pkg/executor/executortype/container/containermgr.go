@@ -457,6 +457,7 @@ func (caaf *Container) fnCreate(ctx context.Context, fn *fv1.Function) (*fscache
 		Address:           svcAddress,
 		KubernetesObjects: kubeObjRefs,
 		Executor:          fv1.ExecutorTypeContainer,
+		RequestTimeout:    fn.GetFunctionTimeout(),
 	}
 
 	_, err = caaf.fsCache.Add(*fsvc)
@@ -211,7 +211,7 @@ func (deploy *NewDeploy) getDeploymentSpec(ctx context.Context, fn *fv1.Function
 			{
 				Name: "http-env",
 				// Now that we have added Port field in spec, should we make this configurable too?
-				ContainerPort: int32(8888),
+				ContainerPort: deploy.fetcherConfig.RuntimePort(),
 			},
 		},
 		Resources: resources,
@@ -337,7 +337,7 @@ func (deploy *NewDeploy) createOrGetSvc(ctx context.Context, deployLabels map[st
 					Name: "http-env",
 					Port: int32(80),
 					// Since Function spec now supports Port , should we make this configurable too?
-					TargetPort: intstr.FromInt(8888),
+					TargetPort: intstr.FromInt(int(deploy.fetcherConfig.RuntimePort())),
 				},
 			},
 			Selector: deployLabels,
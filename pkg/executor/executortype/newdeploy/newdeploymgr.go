@@ -500,6 +500,7 @@ func (deploy *NewDeploy) fnCreate(ctx context.Context, fn *fv1.Function) (*fscac
 		Address:           svcAddress,
 		KubernetesObjects: kubeObjRefs,
 		Executor:          fv1.ExecutorTypeNewdeploy,
+		RequestTimeout:    fn.GetFunctionTimeout(),
 	}
 
 	_, err = deploy.fsCache.Add(*fsvc)
@@ -18,10 +18,16 @@ package poolmgr
 
 import fv1 "github.com/fission/fission/pkg/apis/core/v1"
 
+// defaultPoolSize is the pool size of environments older than version 3,
+// which can't set their own.
+const defaultPoolSize = 3
+
+// getEnvPoolSize returns the number of generic pods to keep warm for env,
+// as set in its spec.
 func getEnvPoolSize(env *fv1.Environment) int32 {
 	var poolsize int32
 	if env.Spec.Version < 3 {
-		poolsize = 3
+		poolsize = defaultPoolSize
 	} else {
 		poolsize = int32(env.Spec.Poolsize)
 	}
@@ -34,6 +40,6 @@ func getSpecializedPodLabels(env *fv1.Environment) map[string]string {
 	specialPodLabels[fv1.ENVIRONMENT_NAME] = env.ObjectMeta.Name
 	specialPodLabels[fv1.ENVIRONMENT_NAMESPACE] = env.ObjectMeta.Namespace
 	specialPodLabels[fv1.ENVIRONMENT_UID] = string(env.ObjectMeta.UID)
-	specialPodLabels["managed"] = "false"
+	specialPodLabels[fv1.MANAGED] = "false"
 	return specialPodLabels
 }
@@ -40,8 +40,10 @@ func getIstioServiceLabels(fnName string) map[string]string {
 // FunctionEventHandlers provides handlers for function resource events.
 // Based on function create/update/delete event, we create role binding
 // for the secret/configmap access which is used by fetcher component.
-// If istio is enabled, we create a service for the function.
-func FunctionEventHandlers(ctx context.Context, logger *zap.Logger, kubernetesClient kubernetes.Interface, fissionfnNamespace string, istioEnabled bool) k8sCache.ResourceEventHandlerFuncs {
+// If istio is enabled, we create a service for the function, exposing the
+// fetcher and runtime ports of its pod.
+func FunctionEventHandlers(ctx context.Context, logger *zap.Logger, kubernetesClient kubernetes.Interface, fissionfnNamespace string, istioEnabled bool,
+	fetcherPort, runtimePort int32) k8sCache.ResourceEventHandlerFuncs {
 	return k8sCache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
 			fn := obj.(*fv1.Function)
@@ -63,8 +65,8 @@ func FunctionEventHandlers(ctx context.Context, logger *zap.Logger, kubernetesCl
 				// create a same name service for function
 				// since istio only allows the traffic to service
 				sel := map[string]string{
-					"functionName": fn.ObjectMeta.Name,
-					"functionUid":  string(fn.ObjectMeta.UID),
+					fv1.FUNCTION_NAME: fn.ObjectMeta.Name,
+					fv1.FUNCTION_UID:  string(fn.ObjectMeta.UID),
 				}
 
 				svcName := utils.GetFunctionIstioServiceName(fn.ObjectMeta.Name, fn.ObjectMeta.Namespace)
@@ -85,14 +87,14 @@ func FunctionEventHandlers(ctx context.Context, logger *zap.Logger, kubernetesCl
 							{
 								Name:       "http-fetcher",
 								Protocol:   apiv1.ProtocolTCP,
-								Port:       8000,
-								TargetPort: intstr.FromInt(8000),
+								Port:       fetcherPort,
+								TargetPort: intstr.FromInt(int(fetcherPort)),
 							},
 							{
 								Name:       "http-env",
 								Protocol:   apiv1.ProtocolTCP,
-								Port:       8888,
-								TargetPort: intstr.FromInt(8888),
+								Port:       runtimePort,
+								TargetPort: intstr.FromInt(int(runtimePort)),
 							},
 						},
 						Selector: sel,
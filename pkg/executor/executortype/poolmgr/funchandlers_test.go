@@ -0,0 +1,69 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/utils"
+)
+
+// TestFunctionEventHandlersIstioService checks that the istio service of a
+// function exposes the configured ports, which the pool sends requests to.
+func TestFunctionEventHandlersIstioService(t *testing.T) {
+	ctx := context.Background()
+	kubernetesClient := fake.NewSimpleClientset()
+	handlers := FunctionEventHandlers(ctx, zap.NewNop(), kubernetesClient, "fission-function", true, 8001, 9000)
+
+	fn := &fv1.Function{
+		ObjectMeta: metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"},
+		Spec:       fv1.FunctionSpec{Environment: fv1.EnvironmentReference{Name: "env", Namespace: metav1.NamespaceDefault}},
+	}
+	handlers.AddFunc(fn)
+
+	svcName := utils.GetFunctionIstioServiceName(fn.ObjectMeta.Name, fn.ObjectMeta.Namespace)
+	envNs := utils.DefaultNSResolver().GetFunctionNS(fn.Spec.Environment.Namespace)
+	svc, err := kubernetesClient.CoreV1().Services(envNs).Get(ctx, svcName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting istio service: %v", err)
+	}
+	var ports []int32
+	for _, port := range svc.Spec.Ports {
+		if port.TargetPort.IntVal != port.Port {
+			t.Errorf("service port %d targets port %v", port.Port, port.TargetPort)
+		}
+		ports = append(ports, port.Port)
+	}
+	if want := []int32{8001, 9000}; !reflect.DeepEqual(ports, want) {
+		t.Errorf("service ports = %v, want %v", ports, want)
+	}
+	wantSelector := map[string]string{fv1.FUNCTION_NAME: "fn", fv1.FUNCTION_UID: "fn-uid"}
+	if !reflect.DeepEqual(svc.Spec.Selector, wantSelector) {
+		t.Errorf("service selector = %v, want %v", svc.Spec.Selector, wantSelector)
+	}
+	if svc.Spec.Type != apiv1.ServiceTypeClusterIP {
+		t.Errorf("service type = %q, want %q", svc.Spec.Type, apiv1.ServiceTypeClusterIP)
+	}
+}
@@ -18,16 +18,22 @@ package poolmgr
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"math"
+	"math/rand"
 	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/dchest/uniuri"
+	"github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 	appsv1 "k8s.io/api/apps/v1"
@@ -38,15 +44,21 @@ import (
 	"k8s.io/apimachinery/pkg/labels"
 	k8sTypes "k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
 	"k8s.io/client-go/util/workqueue"
 	metricsclient "k8s.io/metrics/pkg/client/clientset/versioned"
 
 	fv1 "github.com/fission/fission/pkg/apis/core/v1"
 	"github.com/fission/fission/pkg/crd"
 	"github.com/fission/fission/pkg/executor/fscache"
+	"github.com/fission/fission/pkg/executor/metrics"
+	"github.com/fission/fission/pkg/fetcher"
 	fetcherClient "github.com/fission/fission/pkg/fetcher/client"
 	fetcherConfig "github.com/fission/fission/pkg/fetcher/config"
 	"github.com/fission/fission/pkg/generated/clientset/versioned"
@@ -55,16 +67,115 @@ import (
 	otelUtils "github.com/fission/fission/pkg/utils/otel"
 )
 
+// defaultPodReadyTimeout is used when no pod ready timeout is given to the pool.
+const defaultPodReadyTimeout = 300 * time.Second
+
+// maxPodRechoices caps how often getFuncSvc chooses another pod when the
+// chosen one goes away while being specialized.
+const maxPodRechoices = 3
+
+// partialPodTimeout is how long a pod whose specialization timed out is
+// kept for the next attempt for the function before it's deleted.
+const partialPodTimeout = 2 * time.Minute
+
+// maxChoosePodBackoff caps the delay between attempts of choosePod to get a
+// ready pod or relabel it.
+const maxChoosePodBackoff = 5 * time.Second
+
+// defaultNoReadyPodsThreshold is how long a pool may have no ready pods
+// before choosePod fails fast with ErrNoReadyPods.
+const defaultNoReadyPodsThreshold = 5 * time.Minute
+
+const (
+	// defaultSvcEndpointsTimeout bounds the wait for the service of a
+	// specialized pod to list the pod as an endpoint.
+	defaultSvcEndpointsTimeout = 30 * time.Second
+	svcEndpointsPollInterval   = 100 * time.Millisecond
+)
+
+var (
+	// ErrPodReadyTimeout is returned when no ready pod could be chosen within the pod ready timeout.
+	ErrPodReadyTimeout = errors.New("timeout: waited too long to get a ready pod")
+	// ErrNoReadyPods is returned when the pool has had no ready pods for longer than
+	// the no ready pods threshold, e.g. because the environment image fails to start.
+	ErrNoReadyPods = errors.New("pool has no ready pods")
+	// ErrNoPodIP is returned when the chosen pod has no IP address to specialize it with.
+	ErrNoPodIP = errors.New("pod has no IP")
+	// ErrFetcherFailed is returned when fetcher fails to fetch the function into the chosen pod.
+	ErrFetcherFailed = errors.New("fetcher failed to specialize pod")
+	// ErrRuntimeLoadFailed is returned when the runtime of the chosen pod fails to load the fetched function.
+	ErrRuntimeLoadFailed = errors.New("runtime failed to load function")
+	// ErrSpecializeTimeout is returned when fetching and loading the function into the chosen pod takes too long.
+	ErrSpecializeTimeout = errors.New("timeout: specializing pod took too long")
+	// ErrControllerUnavailable is returned without specializing a pod while fetchers keep failing to fetch functions.
+	ErrControllerUnavailable = errors.New("unavailable: fetching functions keeps failing")
+	// ErrPoolAtCapacity is returned without specializing a pod when the pool has as many pods as its environment allows.
+	ErrPoolAtCapacity = errors.New("pool at capacity")
+	// ErrSvcEndpointsTimeout is returned when the service of a specialized pod doesn't route to the pod in time.
+	ErrSvcEndpointsTimeout = errors.New("timeout: waited too long for service endpoints")
+	// ErrNodePortConflict is returned when the node port a function asks for is used by another function.
+	ErrNodePortConflict = errors.New("node port already used by another function")
+	// ErrInvalidEnvironment is returned when a pool can't be created for an environment.
+	ErrInvalidEnvironment = errors.New("invalid environment")
+	// ErrMissingDependency is returned when a pool is made without a dependency it can't work without.
+	ErrMissingDependency = errors.New("missing pool dependency")
+
+	errPodAlreadyClaimed = errors.New("pod already claimed")
+)
+
+// SpecializeError is returned when a stage of specializing the chosen pod
+// fails, along with the status of the fetcher response. It matches
+// ErrFetcherFailed for the fetch stage and ErrRuntimeLoadFailed for the
+// load stage.
+type SpecializeError struct {
+	Stage      string // fetcher.SpecializeStageFetch or fetcher.SpecializeStageLoad
+	StatusCode int    // status of the fetcher response, 0 if there was none
+	Err        error
+}
+
+// makeSpecializeError returns the SpecializeError of a failed specialize
+// request. Fetchers of older releases don't report the stage, their errors
+// are attributed to the fetch stage.
+func makeSpecializeError(err error) *SpecializeError {
+	specializeErr := &SpecializeError{Stage: fetcher.SpecializeStageFetch, Err: err}
+	var httpErr *fetcherClient.HTTPError
+	if errors.As(err, &httpErr) {
+		specializeErr.StatusCode = httpErr.StatusCode
+		if httpErr.Stage == fetcher.SpecializeStageLoad {
+			specializeErr.Stage = fetcher.SpecializeStageLoad
+		}
+	}
+	return specializeErr
+}
+
+func (e *SpecializeError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("%v in %s stage: %v", e.sentinel(), e.Stage, e.Err)
+	}
+	return fmt.Sprintf("%v in %s stage with status %d: %v", e.sentinel(), e.Stage, e.StatusCode, e.Err)
+}
+
+func (e *SpecializeError) Unwrap() []error {
+	return []error{e.sentinel(), e.Err}
+}
+
+func (e *SpecializeError) sentinel() error {
+	if e.Stage == fetcher.SpecializeStageLoad {
+		return ErrRuntimeLoadFailed
+	}
+	return ErrFetcherFailed
+}
+
 type (
 	// GenericPool represents a generic environment pool
 	GenericPool struct {
 		logger                   *zap.Logger
 		env                      *fv1.Environment
-		deployment               *appsv1.Deployment            // kubernetes deployment
 		fnNamespace              string                        // namespace to keep our resources
 		podReadyTimeout          time.Duration                 // timeout for generic pods to become ready
 		fsCache                  *fscache.FunctionServiceCache // cache funcSvc's by function, address and podname
 		useSvc                   bool                          // create k8s service for specialized pods
+		svcPolicy                functionSvcPolicy             // what functions may ask for on their services
 		useIstio                 bool
 		runtimeImagePullPolicy   apiv1.PullPolicy // pull policy for generic pool to created env deployment
 		kubernetesClient         kubernetes.Interface
@@ -74,12 +185,97 @@ type (
 		stopReadyPodControllerCh chan struct{}
 		readyPodLister           corelisters.PodLister
 		readyPodListerSynced     cache.InformerSynced
+		adoptSelector            labels.Selector // pods adopted besides the pool's own, nil if none, see watchAdoptedPods
 		readyPodQueue            workqueue.DelayingInterface
-		poolInstanceID           string // small random string to uniquify pod names
-		instanceID               string // poolmgr instance id
+		readyPodQueueMu          sync.Mutex // serializes taking keys of readyPodQueue, see pollReadyPodKey
+		poolInstanceID           string     // small random string to uniquify pod names
+		instanceID               string     // poolmgr instance id
 		podSpecPatch             *apiv1.PodSpec
+		podSelector              PodSelector   // picks the preferred node for specialization, nil keeps queue order
+		rand                     *rand.Rand    // random source of pod selection, seeded from time
+		specializeMaxRetries     int           // attempts for the specialize request on connection errors and 502/503/504
+		svcEndpointsTimeout      time.Duration // timeout for the service of a specialized pod to route to it
+		specializeTimeout        time.Duration // timeout to fetch and load a function, 0 uses the function's specialization timeout
+		fetcherPort              int32         // port of the fetcher container
+		runtimePort              int32         // port of the runtime container
+		// rolls out changes of the pool deployment, see getDeploymentStrategy
+		deploymentStrategy appsv1.DeploymentStrategy
 		// TODO: move this field into fsCache
 		podFSVCMap sync.Map
+		// pods to keep warm by function UID, shared with the idle object reaper
+		prewarmed *sync.Map
+		// functions pre-warmed in this pool by function UID, see PreWarm
+		preWarmedFunctions sync.Map
+		// in-flight getFuncSvc calls by function, see getFuncSvc
+		funcSvcCalls sync.Map
+		// pods whose specialization timed out by function key, see keepPartialPod
+		partialPods  sync.Map
+		recorder     record.EventRecorder // records events on the pool deployment, may be nil
+		onPodFailure podFailureHandler    // notified of failed specialized pods, may be nil
+		// PoolHooks are run on the lifecycle events of the pool pods
+		PoolHooks
+
+		// deployment is the kubernetes deployment of the pool, replaced
+		// as the pool is scaled and updated. It's read without a lock,
+		// changes to it are serialized by deploymentLock so that they
+		// apply to the latest deployment.
+		deployment     atomic.Pointer[appsv1.Deployment]
+		deploymentLock sync.Mutex
+
+		// pool autoscaling, enabled when maxReplicas is greater than minReplicas
+		minReplicas       atomic.Int32 // pool size, replaced as the environment is updated
+		maxReplicas       int32
+		autoscaleInterval time.Duration
+		scaleDownDelay    time.Duration
+		starvedRequests   atomic.Int32 // choosePod calls that found no ready pod waiting
+		lastStarved       time.Time
+		lookAhead         int32         // ready pods to keep waiting as pods get specialized, 0 disables
+		scaleUpCh         chan struct{} // wakes up the autoscaler, see lookAheadScaleUp
+
+		lastColdStart atomic.Int64 // unix nanoseconds of the last pod specialization
+		// lastColdStartDuration is how long the last pod specialization
+		// took, in nanoseconds
+		lastColdStartDuration atomic.Int64
+
+		noReadyPodsSince     atomic.Int64  // unix nanoseconds the pool ran out of ready pods, 0 while it has some
+		noReadyPodsThreshold time.Duration // fail choosePod fast past this long without ready pods, 0 disables
+		// readyPodsPollInterval is how often ready pods are checked while
+		// waiting for them, see nextReadyPodKey
+		readyPodsPollInterval time.Duration
+		// specializeURL is the URL template specialize requests are sent
+		// to instead of the fetcher port of the pod, see getFetcherURL
+		specializeURL string
+		// generationHealthDeadline is how long a new generation of pods
+		// gets to turn ready before the pool falls back to the previous
+		// one, 0 disables, see generationRollout
+		generationHealthDeadline time.Duration
+		rollout                  atomic.Pointer[generationRollout] // latest rollout of the pool pods, nil if none
+		// specializedPodMaxAge is how long a specialized pod serves its
+		// function before it's recycled, see GenericPoolManager.reapIdleServices
+		specializedPodMaxAge time.Duration
+		// fetchBreaker fails specializations fast while fetchers keep
+		// failing to reach the source of the functions, nil if disabled
+		fetchBreaker *fetchBreaker
+
+		// specialized pods of the pool, see watchSpecializedPods
+		specializedPods corelisters.PodLister
+		// nodes of the cluster, nil unless the pool manager watches
+		// them, see onUnavailableNode
+		nodeLister corelisters.NodeLister
+		// resourceQuota caps the pods of the pool namespace at the max
+		// pods of the environment, see applyResourceQuota
+		resourceQuota bool
+
+		// specialization throttling, see acquireSpecializeSlot
+		specializeSlots chan struct{} // one per specialization in progress, nil doesn't bound them
+		specializing    atomic.Int32  // specializations in progress
+	}
+
+	// funcSvcCall is a getFuncSvc call shared by concurrent callers
+	funcSvcCall struct {
+		wg   sync.WaitGroup
+		fsvc *fscache.FuncSvc
+		err  error
 	}
 )
 
@@ -95,18 +291,37 @@ func MakeGenericPool(
 	fetcherConfig *fetcherConfig.Config,
 	instanceID string,
 	enableIstio bool,
-	podSpecPatch *apiv1.PodSpec) *GenericPool {
-
-	gpLogger := logger.Named("generic_pool")
+	podReadyTimeout time.Duration,
+	podSpecPatch *apiv1.PodSpec,
+	podSelector PodSelector,
+	cfg *poolConfig) (*GenericPool, error) {
+
+	switch {
+	case logger == nil:
+		return nil, errors.Wrap(ErrMissingDependency, "logger is nil")
+	case kubernetesClient == nil:
+		return nil, errors.Wrap(ErrMissingDependency, "kubernetes client is nil")
+	case env == nil:
+		return nil, errors.Wrap(ErrMissingDependency, "environment is nil")
+	}
 
-	podReadyTimeoutStr := os.Getenv("POD_READY_TIMEOUT")
-	podReadyTimeout, err := time.ParseDuration(podReadyTimeoutStr)
+	err := validatePoolEnvironment(env)
 	if err != nil {
-		podReadyTimeout = 300 * time.Second
-		gpLogger.Error("failed to parse pod ready timeout duration from 'POD_READY_TIMEOUT' - set to the default value",
-			zap.Error(err),
-			zap.String("value", podReadyTimeoutStr),
-			zap.Duration("default", podReadyTimeout))
+		return nil, err
+	}
+
+	// every log line of the pool carries the environment it belongs to
+	gpLogger := logger.Named("generic_pool").With(zap.String("env", env.ObjectMeta.Name),
+		zap.String("envNamespace", env.ObjectMeta.Namespace))
+
+	if podReadyTimeout <= 0 {
+		podReadyTimeout = defaultPodReadyTimeout
+	}
+	if timeout, ok := getEnvPodReadyTimeout(env); ok {
+		podReadyTimeout = timeout
+	}
+	if cfg == nil {
+		cfg = defaultPoolConfig()
 	}
 
 	gpLogger.Info("creating pool", zap.Any("environment", env))
@@ -129,25 +344,152 @@ func MakeGenericPool(
 		poolInstanceID:           uniuri.NewLen(8),
 		instanceID:               instanceID,
 		podFSVCMap:               sync.Map{},
+		prewarmed:                &sync.Map{},
 		podSpecPatch:             podSpecPatch,
+		podSelector:              podSelector,
+		rand:                     newRand(time.Now().UnixNano()),
+		specializeMaxRetries:     cfg.specializeMaxRetries,
+		svcEndpointsTimeout:      defaultSvcEndpointsTimeout,
+		autoscaleInterval:        defaultAutoscaleInterval,
+		scaleDownDelay:           cfg.scaleDownDelay,
+		noReadyPodsThreshold:     cfg.noReadyPodsThreshold,
+		readyPodsPollInterval:    cfg.readyPodsPollInterval,
+		generationHealthDeadline: cfg.generationHealthDeadline,
+		runtimeImagePullPolicy:   cfg.runtimeImagePullPolicy,
+		maxReplicas:              cfg.maxReplicas,
+		lookAhead:                cfg.lookAhead,
+		deploymentStrategy:       cfg.deploymentStrategy,
+		resourceQuota:            cfg.resourceQuota,
+		specializeURL:            cfg.specializeURL,
+		svcPolicy:                cfg.svcPolicy,
+		scaleUpCh:                make(chan struct{}, 1),
 	}
 
-	gp.runtimeImagePullPolicy = utils.GetImagePullPolicy(os.Getenv("RUNTIME_IMAGE_PULL_POLICY"))
+	gp.fetcherPort, gp.runtimePort = getPorts(fetcherConfig)
+	gp.fetchBreaker = makeFetchBreaker(gpLogger, env, cfg)
+	if cfg.maxSpecializing > 0 {
+		gp.specializeSlots = make(chan struct{}, cfg.maxSpecializing)
+	}
 
-	return gp
+	return gp, nil
+}
+
+// validatePoolEnvironment checks what the pool deployment needs from the
+// environment, so that a broken environment fails right away rather than
+// when Kubernetes rejects the deployment or its pods.
+func validatePoolEnvironment(env *fv1.Environment) error {
+	result := &multierror.Error{}
+	result = multierror.Append(result, fv1.ValidateKubeName("Environment.Name", env.ObjectMeta.Name))
+	if len(env.Spec.Runtime.Image) == 0 {
+		result = multierror.Append(result, fv1.MakeValidationErr(fv1.ErrorInvalidValue, "EnvironmentSpec.Runtime.Image",
+			env.Spec.Runtime.Image, "runtime image must not be empty"))
+	}
+	switch probe := env.ObjectMeta.Annotations[fv1.ANNOTATION_SPECIALIZE_PROBE]; probe {
+	case "", fv1.SpecializeProbeHTTP, fv1.SpecializeProbeGRPC:
+	default:
+		result = multierror.Append(result, fv1.MakeValidationErr(fv1.ErrorUnsupportedType, fv1.ANNOTATION_SPECIALIZE_PROBE,
+			probe, "must be http or grpc"))
+	}
+	if path, ok := env.ObjectMeta.Annotations[fv1.ANNOTATION_SPECIALIZE_PROBE_PATH]; ok {
+		if !strings.HasPrefix(path, "/") {
+			result = multierror.Append(result, fv1.MakeValidationErr(fv1.ErrorInvalidValue, fv1.ANNOTATION_SPECIALIZE_PROBE_PATH,
+				path, "must start with /"))
+		}
+		if env.ObjectMeta.Annotations[fv1.ANNOTATION_SPECIALIZE_PROBE] == fv1.SpecializeProbeGRPC {
+			result = multierror.Append(result, fv1.MakeValidationErr(fv1.ErrorInvalidValue, fv1.ANNOTATION_SPECIALIZE_PROBE_PATH,
+				path, "only applies to the http specialize probe"))
+		}
+	}
+	if code, ok := env.ObjectMeta.Annotations[fv1.ANNOTATION_SPECIALIZE_PROBE_STATUS]; ok {
+		if value, err := strconv.Atoi(code); err != nil || value < 100 || value > 599 {
+			result = multierror.Append(result, fv1.MakeValidationErr(fv1.ErrorInvalidValue, fv1.ANNOTATION_SPECIALIZE_PROBE_STATUS,
+				code, "must be an HTTP status code"))
+		}
+	}
+	if _, err := getAdoptSelector(env); err != nil {
+		result = multierror.Append(result, fv1.MakeValidationErr(fv1.ErrorInvalidValue, fv1.ANNOTATION_ADOPT_POD_SELECTOR,
+			env.ObjectMeta.Annotations[fv1.ANNOTATION_ADOPT_POD_SELECTOR], err.Error()))
+	}
+	if maxPods, ok := env.ObjectMeta.Annotations[fv1.ANNOTATION_MAX_PODS]; ok {
+		if value, err := strconv.ParseInt(maxPods, 10, 32); err != nil || int32(value) <= getEnvPoolSize(env) {
+			result = multierror.Append(result, fv1.MakeValidationErr(fv1.ErrorInvalidValue, fv1.ANNOTATION_MAX_PODS,
+				maxPods, "must be a number greater than the pool size"))
+		}
+	}
+	if timeout, ok := env.ObjectMeta.Annotations[fv1.ANNOTATION_POD_READY_TIMEOUT]; ok {
+		if value, err := time.ParseDuration(timeout); err != nil || value <= 0 {
+			result = multierror.Append(result, fv1.MakeValidationErr(fv1.ErrorInvalidValue, fv1.ANNOTATION_POD_READY_TIMEOUT,
+				timeout, "must be a positive duration"))
+		}
+	}
+	if headless, ok := env.ObjectMeta.Annotations[fv1.ANNOTATION_HEADLESS_SVC]; ok {
+		if _, err := strconv.ParseBool(headless); err != nil {
+			result = multierror.Append(result, fv1.MakeValidationErr(fv1.ErrorInvalidValue, fv1.ANNOTATION_HEADLESS_SVC,
+				headless, "must be true or false"))
+		}
+	}
+	if filename, ok := env.ObjectMeta.Annotations[fv1.ANNOTATION_FETCHER_FILENAME]; ok && !fetcherConfig.ValidTargetFilename(filename) {
+		result = multierror.Append(result, fv1.MakeValidationErr(fv1.ErrorInvalidValue, fv1.ANNOTATION_FETCHER_FILENAME,
+			filename, "must be a file name without directories"))
+	}
+	if err := result.ErrorOrNil(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidEnvironment, fv1.AggregateValidationErrors("Environment", err))
+	}
+	return nil
+}
+
+// getEnvPodReadyTimeout returns the pod ready timeout the environment asks
+// for, if any.
+func getEnvPodReadyTimeout(env *fv1.Environment) (time.Duration, bool) {
+	timeout, ok := env.ObjectMeta.Annotations[fv1.ANNOTATION_POD_READY_TIMEOUT]
+	if !ok {
+		return 0, false
+	}
+	value, err := time.ParseDuration(timeout)
+	if err != nil || value <= 0 {
+		return 0, false
+	}
+	return value, true
+}
+
+// getPorts returns the fetcher and runtime ports of the fetcher config,
+// or the default ports without config.
+func getPorts(cfg *fetcherConfig.Config) (int32, int32) {
+	if cfg == nil {
+		return fetcherConfig.DefaultFetcherPort, fetcher.DefaultRuntimePort
+	}
+	return cfg.Port(), cfg.RuntimePort()
 }
 
 func (gp *GenericPool) setup(ctx context.Context) error {
+	if gp.fetcherConfig != nil {
+		err := gp.fetcherConfig.CheckAuthTokenSecret(ctx, gp.kubernetesClient, gp.fnNamespace)
+		if err != nil {
+			return err
+		}
+	}
 	// create the pool
 	err := gp.createPoolDeployment(ctx, gp.env)
 	if err != nil {
 		return err
 	}
+	gp.recordEvent(apiv1.EventTypeNormal, "PoolCreated", "Created pool for environment %s/%s",
+		gp.env.ObjectMeta.Namespace, gp.env.ObjectMeta.Name)
+	err = gp.applyResourceQuota(ctx)
+	if err != nil {
+		gp.logger.Error("error applying resource quota of pool", zap.Error(err))
+		gp.recordEvent(apiv1.EventTypeWarning, "ResourceQuotaFailed", "Failed to apply resource quota: %v", err)
+	}
 	err = gp.setupReadyPodController()
 	if err != nil {
 		return err
 	}
+	gp.watchSpecializedPods()
+	go gp.cleanupStaleServicesPeriodically()
 	go gp.updateCPUUtilizationSvc(ctx)
+	if gp.maxReplicas > 0 {
+		go gp.autoscalePool()
+	}
 	return nil
 }
 
@@ -157,7 +499,7 @@ func (gp *GenericPool) getEnvironmentPoolLabels(env *fv1.Environment) map[string
 	envLabels[fv1.ENVIRONMENT_NAME] = env.ObjectMeta.Name
 	envLabels[fv1.ENVIRONMENT_NAMESPACE] = env.ObjectMeta.Namespace
 	envLabels[fv1.ENVIRONMENT_UID] = string(env.ObjectMeta.UID)
-	envLabels["managed"] = "true" // this allows us to easily find pods managed by the deployment
+	envLabels[fv1.MANAGED] = "true" // this allows us to easily find pods managed by the deployment
 	return envLabels
 }
 
@@ -218,13 +560,22 @@ func (gp *GenericPool) updateCPUUtilizationSvc(ctx context.Context) {
 				checkDuration = 30
 			}
 		}
-		time.Sleep(time.Duration(checkDuration) * time.Second)
+		select {
+		case <-gp.stopReadyPodControllerCh:
+			return
+		case <-time.After(time.Duration(checkDuration) * time.Second):
+		}
 	}
 }
 
 // choosePod picks a ready pod from the pool and relabels it, waiting if necessary.
 // returns the key and pod API object.
-func (gp *GenericPool) choosePod(ctx context.Context, newLabels map[string]string) (string, *apiv1.Pod, error) {
+func (gp *GenericPool) choosePod(ctx context.Context, newLabels map[string]string) (key string, chosen *apiv1.Pod, err error) {
+	ctx, span := startSpan(ctx, "choosePod", otelUtils.GetAttributesForEnv(gp.env)...)
+	defer func() {
+		span.SetAttributes(otelUtils.GetAttributesForPod(chosen)...)
+		endSpan(span, err)
+	}()
 	startTime := time.Now()
 	podTimeout := startTime.Add(gp.podReadyTimeout)
 	deadline, ok := ctx.Deadline()
@@ -235,16 +586,28 @@ func (gp *GenericPool) choosePod(ctx context.Context, newLabels map[string]strin
 		}
 	}
 	expoDelay := 100 * time.Millisecond
+	// pods claimed by concurrent callers are dropped from the queue and the
+	// next one is tried sooner, it's likely free. Once all are gone, it
+	// waits until the pool makes another pod ready.
+	conflictDelay := 10 * time.Millisecond
 	logger := otelUtils.LoggerWithTraceID(ctx, gp.logger)
 	if !cache.WaitForCacheSync(ctx.Done(), gp.readyPodListerSynced) {
 		logger.Error("timed out waiting for ready pod lister synced")
 		return "", nil, errors.New("ready pod lister not synced")
 	}
+	if gp.readyPodQueue.Len() == 0 {
+		gp.starvedRequests.Add(1)
+		metrics.PoolStarvedRequests.WithLabelValues(gp.env.ObjectMeta.Name, gp.env.ObjectMeta.Namespace).Inc()
+	}
+	// keys already put back once in favour of pods on a preferred node
+	deferred := make(map[string]bool)
 	for {
 		// Retries took too long, error out.
 		if time.Now().After(podTimeout) {
 			logger.Error("timed out waiting for pod", zap.Any("labels", newLabels), zap.Duration("timeout", podTimeout.Sub(startTime)))
-			return "", nil, errors.New("timeout: waited too long to get a ready pod")
+			gp.recordEvent(apiv1.EventTypeWarning, "ReadyPodTimeout", "Timed out after %v waiting for a ready pod for function %s",
+				podTimeout.Sub(startTime), newLabels[fv1.FUNCTION_NAME])
+			return "", nil, ErrPodReadyTimeout
 		}
 		if ctx.Err() != nil {
 			logger.Error("context canceled while waiting for pod", zap.Any("labels", newLabels), zap.Duration("timeout", podTimeout.Sub(startTime)))
@@ -252,15 +615,23 @@ func (gp *GenericPool) choosePod(ctx context.Context, newLabels map[string]strin
 		}
 
 		var chosenPod *apiv1.Pod
-		var key string
 
 		otelUtils.SpanTrackEvent(ctx, "waitForPod", otelUtils.MapToAttributes(newLabels)...)
-		item, quit := gp.readyPodQueue.Get()
-		if quit {
+		key, err := gp.nextReadyPodKey(ctx, podTimeout)
+		switch {
+		case errors.Is(err, ErrPodReadyTimeout), ctx.Err() != nil:
+			// reported at the top of the loop
+			continue
+		case errors.Is(err, ErrNoReadyPods):
+			logger.Error("pool has no ready pods", zap.Any("labels", newLabels), zap.Error(err))
+			metrics.PoolNoReadyPods.WithLabelValues(gp.env.ObjectMeta.Name, gp.env.ObjectMeta.Namespace).Inc()
+			gp.recordEvent(apiv1.EventTypeWarning, "NoReadyPods", "No ready pod for function %s: %v, check that the environment image starts",
+				newLabels[fv1.FUNCTION_NAME], err)
+			return "", nil, err
+		case err != nil:
 			logger.Error("readypod controller is not running")
-			return "", nil, errors.New("readypod controller is not running")
+			return "", nil, err
 		}
-		key = item.(string)
 		logger.Debug("got key from the queue", zap.String("key", key))
 		namespace, name, err := cache.SplitMetaNamespaceKey(key)
 		if err != nil {
@@ -279,77 +650,248 @@ func (gp *GenericPool) choosePod(ctx context.Context, newLabels map[string]strin
 			gp.readyPodQueue.Done(key)
 			continue
 		}
+		if !gp.isServingGenerationPod(pod) {
+			logger.Info("pod is from a previous generation of the pool", zap.String("key", key),
+				zap.String("generation", pod.Labels[fv1.ENVIRONMENT_GENERATION]))
+			gp.readyPodQueue.Done(key)
+			continue
+		}
 		if !utils.IsReadyPod(pod) {
-			logger.Warn("pod not ready, pod will be checked again", zap.String("key", key), zap.Duration("delay", expoDelay))
+			delay := backoff(&expoDelay, podTimeout)
+			logger.Warn("pod not ready, pod will be checked again", zap.String("key", key), zap.Duration("delay", delay))
+			gp.readyPodQueue.Done(key)
+			gp.readyPodQueue.AddAfter(key, delay)
+			continue
+		}
+		// generic pods stay ready on a cordoned node until it's drained
+		if gp.onUnavailableNode(pod) {
+			delay := backoff(&expoDelay, podTimeout)
+			logger.Warn("pod is on an unavailable node, pod will be checked again", zap.String("key", key),
+				zap.String("node", pod.Spec.NodeName), zap.Duration("delay", delay))
+			gp.readyPodQueue.Done(key)
+			gp.readyPodQueue.AddAfter(key, delay)
+			continue
+		}
+		if !deferred[key] && gp.deferPod(pod) {
+			logger.Debug("pod is not on the preferred node, pod will be checked again", zap.String("key", key),
+				zap.String("node", pod.Spec.NodeName))
+			deferred[key] = true
 			gp.readyPodQueue.Done(key)
-			gp.readyPodQueue.AddAfter(key, expoDelay)
-			expoDelay *= 2
+			gp.readyPodQueue.Add(key)
 			continue
 		}
 		chosenPod = pod.DeepCopy()
 		otelUtils.SpanTrackEvent(ctx, "foundPod", otelUtils.GetAttributesForPod(chosenPod)...)
 
 		if gp.env.Spec.AllowedFunctionsPerContainer != fv1.AllowedFunctionsPerContainerInfinite {
-			// Relabel.  If the pod already got picked and
-			// modified, this should fail; in that case just
-			// retry.
-			labelPatch, _ := json.Marshal(newLabels)
-
-			// Append executor instance id to pod annotations to
-			// indicate this pod is managed by this executor.
-			annotations := gp.getDeployAnnotations(gp.env)
-			annotationPatch, _ := json.Marshal(annotations)
-
-			patch := fmt.Sprintf(`{"metadata":{"annotations":%v, "labels":%v}}`, string(annotationPatch), string(labelPatch))
-			logger.Info("relabel pod", zap.String("pod", patch))
-			newPod, err := gp.kubernetesClient.CoreV1().Pods(chosenPod.Namespace).Patch(ctx, chosenPod.Name, k8sTypes.StrategicMergePatchType, []byte(patch), metav1.PatchOptions{})
-			if err != nil && errors.Is(err, context.Canceled) {
+			claimedPod, err := gp.claimPod(ctx, chosenPod.Namespace, chosenPod.Name, newLabels)
+			if errors.Is(err, errPodAlreadyClaimed) {
+				logger.Warn("pod already claimed, trying another pod", zap.String("pod", chosenPod.Name))
+				gp.recordEvent(apiv1.EventTypeWarning, "PodClaimConflict", "Pod %s was already claimed, choosing another pod for function %s",
+					chosenPod.Name, newLabels[fv1.FUNCTION_NAME])
+				gp.readyPodQueue.Done(key)
+				select {
+				case <-ctx.Done():
+				case <-time.After(backoff(&conflictDelay, podTimeout)):
+				}
+				continue
+			} else if err != nil && errors.Is(err, context.Canceled) {
 				// ending retry loop when the request canceled
 				gp.readyPodQueue.Done(key)
 				gp.readyPodQueue.AddAfter(key, expoDelay)
 				return "", nil, errors.Errorf("failed to relabel pod: %s", err)
 			} else if err != nil {
-				logger.Error("failed to relabel pod", zap.Error(err), zap.String("pod", chosenPod.Name), zap.Duration("delay", expoDelay))
+				delay := backoff(&expoDelay, podTimeout)
+				logger.Error("failed to relabel pod", zap.Error(err), zap.String("pod", chosenPod.Name), zap.Duration("delay", delay))
+				metrics.PoolRelabelFailures.WithLabelValues(gp.env.ObjectMeta.Name, gp.env.ObjectMeta.Namespace).Inc()
+				gp.recordEvent(apiv1.EventTypeWarning, "PodRelabelFailed", "Failed to relabel pod %s for function %s: %v",
+					chosenPod.Name, newLabels[fv1.FUNCTION_NAME], err)
 				gp.readyPodQueue.Done(key)
-				gp.readyPodQueue.AddAfter(key, expoDelay)
-				expoDelay *= 2
+				gp.readyPodQueue.AddAfter(key, delay)
 				continue
 			}
+			chosenPod = claimedPod
 			otelUtils.SpanTrackEvent(ctx, "podRelabel", otelUtils.GetAttributesForPod(chosenPod)...)
-
-			// With StrategicMergePatchType, the client-go sometimes return
-			// nil error and the labels & annotations remain the same.
-			// So we have to check both of them to ensure the patch success.
-			for k, v := range newLabels {
-				if newPod.Labels[k] != v {
-					return "", nil, errors.Errorf("value of necessary labels '%v' mismatch: want '%v', get '%v'",
-						k, v, newPod.Labels[k])
-				}
-			}
-			for k, v := range annotations {
-				if newPod.Annotations[k] != v {
-					return "", nil, errors.Errorf("value of necessary annotations '%v' mismatch: want '%v', get '%v'",
-						k, v, newPod.Annotations[k])
-				}
-			}
 		}
 
 		logger.Info("chose pod", zap.Any("labels", newLabels),
 			zap.String("pod", chosenPod.Name), zap.Duration("elapsed_time", time.Since(startTime)))
 
+		gp.lookAheadScaleUp()
 		return key, chosenPod, nil
 	}
 }
 
+// deferPod returns true if the pod selector prefers a ready pod running on
+// another node than the given pod.
+func (gp *GenericPool) deferPod(pod *apiv1.Pod) bool {
+	if gp.podSelector == nil {
+		return false
+	}
+	pods, err := gp.readyPodLister.Pods(gp.fnNamespace).List(labels.SelectorFromSet(gp.getEnvironmentPoolLabels(gp.env)))
+	if err != nil {
+		return false
+	}
+	readyPods := make([]*apiv1.Pod, 0, len(pods))
+	for _, p := range pods {
+		if utils.IsReadyPod(p) && gp.isServingGenerationPod(p) {
+			readyPods = append(readyPods, p)
+		}
+	}
+	preferred := gp.podSelector.Choose(readyPods, gp.rand)
+	return preferred != nil && preferred.Spec.NodeName != pod.Spec.NodeName
+}
+
+// backoff returns the delay before the next attempt, jittered so concurrent
+// callers don't retry in lockstep and bounded by the deadline. The delay for
+// the attempt after is doubled up to maxChoosePodBackoff.
+func backoff(delay *time.Duration, deadline time.Time) time.Duration {
+	d := wait.Jitter(*delay, 0.5)
+	if *delay < maxChoosePodBackoff {
+		*delay *= 2
+		if *delay > maxChoosePodBackoff {
+			*delay = maxChoosePodBackoff
+		}
+	}
+	if remaining := time.Until(deadline); d > remaining {
+		d = remaining
+	}
+	if d < 0 {
+		d = 0
+	}
+	return d
+}
+
+// claimPod relabels a generic pod with the function labels, taking it out of
+// the pool. The pod is read fresh from the API server and updated with its
+// resourceVersion, so only one claimer can win; it returns errPodAlreadyClaimed
+// if the pod no longer belongs to the pool.
+func (gp *GenericPool) claimPod(ctx context.Context, namespace, name string, newLabels map[string]string) (*apiv1.Pod, error) {
+	var claimedPod *apiv1.Pod
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pod, err := gp.kubernetesClient.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		// a pod about to be evicted won't serve the function for long
+		if pod.Labels[fv1.MANAGED] != "true" || pod.DeletionTimestamp != nil || podDisrupted(pod) {
+			return errPodAlreadyClaimed
+		}
+
+		if pod.Labels == nil {
+			pod.Labels = make(map[string]string)
+		}
+		for k, v := range newLabels {
+			pod.Labels[k] = v
+		}
+		// Append executor instance id to pod annotations to
+		// indicate this pod is managed by this executor.
+		if pod.Annotations == nil {
+			pod.Annotations = make(map[string]string)
+		}
+		for k, v := range gp.getDeployAnnotations(gp.env) {
+			pod.Annotations[k] = v
+		}
+
+		gp.logger.Info("relabel pod", zap.String("pod", name), zap.Any("labels", newLabels))
+		claimedPod, err = gp.kubernetesClient.CoreV1().Pods(namespace).Update(ctx, pod, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claimedPod, nil
+}
+
 func (gp *GenericPool) labelsForFunction(metadata *metav1.ObjectMeta) map[string]string {
 	label := gp.getEnvironmentPoolLabels(gp.env)
 	label[fv1.FUNCTION_NAME] = metadata.Name
 	label[fv1.FUNCTION_UID] = string(metadata.UID)
 	label[fv1.FUNCTION_NAMESPACE] = metadata.Namespace // function CRD must stay within same namespace of environment CRD
-	label["managed"] = "false"                         // this allows us to easily find pods not managed by the deployment
+	label[fv1.MANAGED] = "false"                       // this allows us to easily find pods not managed by the deployment
 	return label
 }
 
+// validateFunctionLabels checks the function metadata makes valid label
+// values, so such a function fails with a clear error rather than the API
+// server rejecting the relabeling of the chosen pod.
+func validateFunctionLabels(metadata *metav1.ObjectMeta) error {
+	for _, field := range []struct {
+		name  string
+		value string
+	}{
+		{"name", metadata.Name},
+		{"namespace", metadata.Namespace},
+		{"uid", string(metadata.UID)},
+	} {
+		if errs := validation.IsValidLabelValue(field.value); len(errs) > 0 {
+			return errors.Errorf("function %s %q is not a valid label value: %s", field.name, field.value, strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
+
+// svcNameForFunction returns the name of the service created for the
+// specialized pod of the function.
+func svcNameForFunction(metadata *metav1.ObjectMeta) (string, error) {
+	name := fmt.Sprintf("svc-%v", metadata.Name)
+	if len(metadata.UID) > 0 {
+		name = fmt.Sprintf("%s-%v", name, metadata.UID)
+	}
+	if errs := validation.IsDNS1035Label(name); len(errs) > 0 {
+		return "", errors.Errorf("function name %q makes an invalid service name %q: %s", metadata.Name, name, strings.Join(errs, "; "))
+	}
+	return name, nil
+}
+
+// usesFunctionSvc returns true if the function is served through a service
+// of its own rather than at the address of its pod, either because the pool
+// creates a service for every function or because the function asks for one.
+func (gp *GenericPool) usesFunctionSvc(fn *metav1.ObjectMeta) bool {
+	if gp.useIstio {
+		return false
+	}
+	_, ok := fn.Annotations[fv1.ANNOTATION_SVC_TYPE]
+	return gp.useSvc || ok
+}
+
+// deleteSvc deletes a service created for a function, errors are only logged.
+func (gp *GenericPool) deleteSvc(ctx context.Context, name string) {
+	err := gp.kubernetesClient.CoreV1().Services(gp.fnNamespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !k8s_err.IsNotFound(err) {
+		gp.logger.Error("error deleting service",
+			zap.String("name", name),
+			zap.String("namespace", gp.fnNamespace),
+			zap.Error(err))
+	}
+}
+
+// waitForSvcEndpoints waits until the endpoints of the service include the
+// pod IP, so the first request routed through the service doesn't fail.
+func (gp *GenericPool) waitForSvcEndpoints(ctx context.Context, name string, podIP string) error {
+	err := wait.PollImmediateWithContext(ctx, svcEndpointsPollInterval, gp.svcEndpointsTimeout, func(ctx context.Context) (bool, error) {
+		endpoints, err := gp.kubernetesClient.CoreV1().Endpoints(gp.fnNamespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			if k8s_err.IsNotFound(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		for _, subset := range endpoints.Subsets {
+			for _, address := range subset.Addresses {
+				if address.IP == podIP {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	})
+	if errors.Is(err, wait.ErrWaitTimeout) {
+		return errors.Wrapf(ErrSvcEndpointsTimeout, "service %s in namespace %s", name, gp.fnNamespace)
+	}
+	return err
+}
+
 func (gp *GenericPool) scheduleDeletePod(ctx context.Context, name string) {
 	// The sleep allows debugging or collecting logs from the pod before it's
 	// cleaned up.  (We need a better solutions for both those things; log
@@ -385,24 +927,58 @@ func (gp *GenericPool) getFetcherURL(podIP string) string {
 	isv6 := IsIPv6(podIP)
 	var baseURL string
 
+	// Operators who don't want the fetcher reachable by the pod IP route
+	// specialize requests through a proxy, e.g. a sidecar of the pod
+	// listening on another port, which forwards them to the fetcher on
+	// localhost.
+	if len(gp.specializeURL) > 0 {
+		host := podIP
+		if isv6 {
+			host = "[" + podIP + "]"
+		}
+		return strings.NewReplacer("{host}", host, "{port}", strconv.Itoa(int(gp.fetcherPort))).Replace(gp.specializeURL)
+	}
+
 	if isv6 { // We use bracket if the IP is in IPv6.
-		baseURL = fmt.Sprintf("http://[%v]:8000/", podIP)
+		baseURL = fmt.Sprintf("http://[%v]:%d/", podIP, gp.fetcherPort)
 	} else {
-		baseURL = fmt.Sprintf("http://%v:8000/", podIP)
+		baseURL = fmt.Sprintf("http://%v:%d/", podIP, gp.fetcherPort)
 	}
 	return baseURL
 }
 
+// validateSpecializeURL checks a specialize URL template makes http URLs
+// which tell the pods apart by their {host}.
+func validateSpecializeURL(specializeURL string) error {
+	if !strings.Contains(specializeURL, "{host}") {
+		return errors.New("specialize url must contain {host}")
+	}
+	u, err := url.Parse(strings.NewReplacer("{host}", "10.0.0.1", "{port}", "8000").Replace(specializeURL))
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.Errorf("unsupported scheme %q of specialize url", u.Scheme)
+	}
+	return nil
+}
+
 // specializePod chooses a pod, copies the required user-defined function to that pod
 // (via fetcher), and calls the function-run container to load it, resulting in a
-// specialized pod.
-func (gp *GenericPool) specializePod(ctx context.Context, pod *apiv1.Pod, fn *fv1.Function) error {
-	logger := otelUtils.LoggerWithTraceID(ctx, gp.logger)
+// specialized pod. The fetch is recorded by the fetch breaker with the ticket
+// the breaker allowed the specialization with.
+func (gp *GenericPool) specializePod(ctx context.Context, pod *apiv1.Pod, fn *fv1.Function, ticket *fetchBreakerTicket) (err error) {
+	ctx, span := startSpan(ctx, "specializePod", append(otelUtils.GetAttributesForFunction(fn), otelUtils.GetAttributesForPod(pod)...)...)
+	defer func() {
+		endSpan(span, err)
+	}()
+	logger := otelUtils.LoggerWithTraceID(ctx, gp.logger).With(zap.String("function", fn.ObjectMeta.Name),
+		zap.String("namespace", fn.ObjectMeta.Namespace), zap.String("pod", pod.ObjectMeta.Name))
 
 	// for fetcher we don't need to create a service, just talk to the pod directly
 	podIP := pod.Status.PodIP
 	if len(podIP) == 0 {
-		return errors.Errorf("Pod %s in namespace %s has no IP", pod.ObjectMeta.Name, pod.ObjectMeta.Namespace)
+		return errors.Wrapf(ErrNoPodIP, "pod %s in namespace %s", pod.ObjectMeta.Name, pod.ObjectMeta.Namespace)
 	}
 	for _, cm := range fn.Spec.ConfigMaps {
 		_, err := gp.kubernetesClient.CoreV1().ConfigMaps(gp.fnNamespace).Get(ctx, cm.Name, metav1.GetOptions{})
@@ -443,23 +1019,139 @@ func (gp *GenericPool) specializePod(ctx context.Context, pod *apiv1.Pod, fn *fv
 
 	// tell fetcher to get the function.
 	fetcherURL := gp.getFetcherURL(podIP)
-	logger.Info("calling fetcher to copy function", zap.String("function", fn.ObjectMeta.Name), zap.String("url", fetcherURL))
+	logger.Info("calling fetcher to copy function", zap.String("url", fetcherURL))
 
 	specializeReq := gp.fetcherConfig.NewSpecializeRequest(fn, gp.env)
 
-	logger.Info("specializing pod", zap.String("function", fn.ObjectMeta.Name))
+	logger.Info("specializing pod")
 
 	// Fetcher will download user function to share volume of pod, and
-	// invoke environment specialize api for pod specialization.
-	err := fetcherClient.MakeClient(gp.logger, fetcherURL).Specialize(ctx, &specializeReq)
+	// invoke environment specialize api for pod specialization. This is
+	// bounded on its own, regardless of how long waiting for the pod took.
+	timeout := gp.getSpecializeTimeout(fn)
+	specializeCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	specializeResp, err := fetcherClient.MakeClient(logger, fetcherURL).
+		WithMaxRetries(gp.specializeMaxRetries).
+		WithAuthToken(gp.fetcherConfig.AuthToken()).
+		Specialize(specializeCtx, &specializeReq)
+	gp.fetchBreaker.record(ctx, ticket, err)
 	if err != nil {
-		return err
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			return errors.Wrapf(ErrSpecializeTimeout, "pod %s in namespace %s for function %s after %v",
+				pod.ObjectMeta.Name, pod.ObjectMeta.Namespace, fn.ObjectMeta.Name, timeout)
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = errors.Wrapf(err, "timed out specializing pod %s in namespace %s for function %s",
+				pod.ObjectMeta.Name, pod.ObjectMeta.Namespace, fn.ObjectMeta.Name)
+		}
+		specializeErr := makeSpecializeError(err)
+		metrics.SpecializeErrors.WithLabelValues(fn.ObjectMeta.Name, fn.ObjectMeta.Namespace, specializeErr.Stage).Inc()
+		return specializeErr
+	}
+	// fetchers of older releases don't report the function they wrote
+	if specializeResp != nil && specializeResp.Size == 0 {
+		metrics.SpecializeErrors.WithLabelValues(fn.ObjectMeta.Name, fn.ObjectMeta.Namespace, fetcher.SpecializeStageFetch).Inc()
+		return &SpecializeError{
+			Stage:      fetcher.SpecializeStageFetch,
+			StatusCode: http.StatusOK,
+			Err:        fmt.Errorf("fetcher reported empty function %q in pod %s", specializeResp.Filename, pod.ObjectMeta.Name),
+		}
+	}
+	if gp.env.ObjectMeta.Annotations[fv1.ANNOTATION_SPECIALIZE_PROBE] == fv1.SpecializeProbeGRPC {
+		address := net.JoinHostPort(podIP, fmt.Sprint(gp.runtimePort))
+		err = waitForGRPCServing(specializeCtx, address)
+		if err != nil {
+			metrics.SpecializeErrors.WithLabelValues(fn.ObjectMeta.Name, fn.ObjectMeta.Namespace, fetcher.SpecializeStageLoad).Inc()
+			if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+				return errors.Wrapf(ErrSpecializeTimeout, "waiting for the gRPC server of pod %s in namespace %s for function %s after %v: %v",
+					pod.ObjectMeta.Name, pod.ObjectMeta.Namespace, fn.ObjectMeta.Name, timeout, err)
+			}
+			return &SpecializeError{
+				Stage: fetcher.SpecializeStageLoad,
+				Err:   errors.Wrapf(err, "gRPC server of pod %s isn't serving", pod.ObjectMeta.Name),
+			}
+		}
+	} else if path, code := getHTTPProbe(gp.env); len(path) > 0 {
+		probeURL := "http://" + net.JoinHostPort(podIP, fmt.Sprint(gp.runtimePort)) + path
+		err = waitForHTTPStatus(specializeCtx, probeURL, code)
+		if err != nil {
+			metrics.SpecializeErrors.WithLabelValues(fn.ObjectMeta.Name, fn.ObjectMeta.Namespace, fetcher.SpecializeStageLoad).Inc()
+			if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+				return errors.Wrapf(ErrSpecializeTimeout, "waiting for %s of pod %s in namespace %s for function %s after %v: %v",
+					path, pod.ObjectMeta.Name, pod.ObjectMeta.Namespace, fn.ObjectMeta.Name, timeout, err)
+			}
+			return &SpecializeError{
+				Stage: fetcher.SpecializeStageLoad,
+				Err:   errors.Wrapf(err, "runtime of pod %s isn't serving %s", pod.ObjectMeta.Name, path),
+			}
+		}
 	}
 	otelUtils.SpanTrackEvent(ctx, "specializedPod", otelUtils.GetAttributesForPod(pod)...)
 	return nil
 }
 
-func (gp *GenericPool) createSvc(ctx context.Context, name string, labels map[string]string) (*apiv1.Service, error) {
+// getSpecializeTimeout returns the time allowed to fetch and load the function
+// into a pod.
+func (gp *GenericPool) getSpecializeTimeout(fn *fv1.Function) time.Duration {
+	if gp.specializeTimeout > 0 {
+		return gp.specializeTimeout
+	}
+	timeout := fn.Spec.InvokeStrategy.ExecutionStrategy.SpecializationTimeout
+	if timeout < fv1.DefaultSpecializationTimeOut {
+		timeout = fv1.DefaultSpecializationTimeOut
+	}
+	return time.Duration(timeout) * time.Second
+}
+
+// servicePorts returns the ports exposed by the service of a specialized
+// pod: the runtime port, followed by the ports declared on the environment
+// container for functions listening on additional ports.
+func (gp *GenericPool) servicePorts() []apiv1.ServicePort {
+	ports := []apiv1.ServicePort{
+		{
+			Name:       "http-env",
+			Protocol:   apiv1.ProtocolTCP,
+			Port:       gp.runtimePort,
+			TargetPort: intstr.FromInt(int(gp.runtimePort)),
+		},
+	}
+	if gp.env.Spec.Runtime.Container == nil {
+		return ports
+	}
+	for _, port := range gp.env.Spec.Runtime.Container.Ports {
+		if port.ContainerPort == gp.runtimePort || port.ContainerPort == gp.fetcherPort {
+			continue
+		}
+		name := port.Name
+		if len(name) == 0 {
+			name = fmt.Sprintf("port-%d", port.ContainerPort)
+		}
+		protocol := port.Protocol
+		if len(protocol) == 0 {
+			protocol = apiv1.ProtocolTCP
+		}
+		ports = append(ports, apiv1.ServicePort{
+			Name:       name,
+			Protocol:   protocol,
+			Port:       port.ContainerPort,
+			TargetPort: intstr.FromInt(int(port.ContainerPort)),
+		})
+	}
+	return ports
+}
+
+// createSvc creates a service selecting the pods with the given function
+// labels. A headless service resolves straight to the pod addresses instead
+// of a cluster IP, which gRPC streaming and client-side load balancing rely
+// on. Since poolmgr specializes a single pod per function, a headless
+// service resolves to exactly that pod; once the pod is reaped the name no
+// longer resolves, just like a ClusterIP service without endpoints.
+// svcSpec, if not nil, overrides the type and annotations of the service.
+// Endpoints only list ready pods unless svcSpec asks to publish not-ready
+// addresses too.
+func (gp *GenericPool) createSvc(ctx context.Context, name string, labels map[string]string, ports []apiv1.ServicePort,
+	headless bool, svcSpec *functionSvcSpec) (*apiv1.Service, error) {
 	otelUtils.SpanTrackEvent(ctx, "createSvc", otelUtils.MapToAttributes(map[string]string{
 		"name": name,
 	})...)
@@ -469,24 +1161,95 @@ func (gp *GenericPool) createSvc(ctx context.Context, name string, labels map[st
 			Labels: labels,
 		},
 		Spec: apiv1.ServiceSpec{
-			Type: apiv1.ServiceTypeClusterIP,
-			Ports: []apiv1.ServicePort{
-				{
-					Protocol:   apiv1.ProtocolTCP,
-					Port:       8888,
-					TargetPort: intstr.FromInt(8888),
-				},
-			},
+			Type:     apiv1.ServiceTypeClusterIP,
+			Ports:    ports,
 			Selector: labels,
 		},
 	}
+	if svcSpec != nil {
+		service.ObjectMeta.Annotations = svcSpec.annotations
+		service.Spec.Type = svcSpec.svcType
+		service.Spec.PublishNotReadyAddresses = svcSpec.publishNotReady
+		for i := range service.Spec.Ports {
+			if service.Spec.Ports[i].Port == gp.runtimePort {
+				service.Spec.Ports[i].NodePort = svcSpec.nodePort
+			}
+		}
+	}
+	// only ClusterIP services can do without a cluster IP
+	if headless && service.Spec.Type == apiv1.ServiceTypeClusterIP {
+		service.Spec.ClusterIP = apiv1.ClusterIPNone
+	}
 	svc, err := gp.kubernetesClient.CoreV1().Services(gp.fnNamespace).Create(ctx, &service, metav1.CreateOptions{})
 	return svc, err
 }
 
+// getFuncSvc specializes a pod for the function. With a service per function
+// only one pod can serve the function, so concurrent calls for the same
+// function share a single specialization rather than racing to create the
+// service. Otherwise every call specializes a pod, the function service
+// cache already limits those to the requests per pod of the function.
 func (gp *GenericPool) getFuncSvc(ctx context.Context, fn *fv1.Function) (*fscache.FuncSvc, error) {
-	logger := otelUtils.LoggerWithTraceID(ctx, gp.logger).With(zap.String("function", fn.ObjectMeta.Name), zap.String("namespace", fn.ObjectMeta.Namespace),
-		zap.String("env", fn.Spec.Environment.Name), zap.String("envNamespace", fn.Spec.Environment.Namespace))
+	if !gp.usesFunctionSvc(&fn.ObjectMeta) {
+		return gp.specializeFuncSvc(ctx, fn)
+	}
+
+	key := crd.CacheKey(&fn.ObjectMeta)
+	call := &funcSvcCall{}
+	call.wg.Add(1)
+	if existing, loaded := gp.funcSvcCalls.LoadOrStore(key, call); loaded {
+		call = existing.(*funcSvcCall)
+		call.wg.Wait()
+		if call.err == nil {
+			// each caller releases the function service once done
+			gp.fsCache.AddFunc(ctx, *call.fsvc, fn.GetRequestPerPod())
+		}
+		return call.fsvc, call.err
+	}
+
+	call.fsvc, call.err = gp.specializeFuncSvc(ctx, fn)
+	gp.funcSvcCalls.Delete(key)
+	call.wg.Done()
+	return call.fsvc, call.err
+}
+
+func (gp *GenericPool) specializeFuncSvc(ctx context.Context, fn *fv1.Function) (*fscache.FuncSvc, error) {
+	logger := otelUtils.LoggerWithTraceID(ctx, gp.logger).With(zap.String("function", fn.ObjectMeta.Name), zap.String("namespace", fn.ObjectMeta.Namespace))
+	coldStart := time.Now()
+
+	err := validateFunctionLabels(&fn.ObjectMeta)
+	if err != nil {
+		return nil, err
+	}
+	svcSpec, err := getFunctionSvcSpec(&fn.ObjectMeta, gp.svcPolicy)
+	if err != nil {
+		return nil, err
+	}
+	if svcSpec != nil && gp.useIstio {
+		return nil, errors.Errorf("function %s asks for a %s service, which istio doesn't support", fn.ObjectMeta.Name, svcSpec.svcType)
+	}
+	var svcName string
+	if gp.usesFunctionSvc(&fn.ObjectMeta) {
+		svcName, err = svcNameForFunction(&fn.ObjectMeta)
+		if err != nil {
+			return nil, err
+		}
+		// the service the pool creates for every function carries the
+		// annotations of the function too
+		annotations, err := getFunctionSvcAnnotations(&fn.ObjectMeta, gp.svcPolicy)
+		if err != nil {
+			return nil, err
+		}
+		if svcSpec == nil && annotations != nil {
+			svcSpec = &functionSvcSpec{svcType: apiv1.ServiceTypeClusterIP, annotations: annotations}
+		}
+	}
+	if svcSpec != nil && svcSpec.nodePort > 0 {
+		err = gp.checkNodePort(ctx, &fn.ObjectMeta, svcSpec.nodePort)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	logger.Info("choosing pod from pool")
 	funcLabels := gp.labelsForFunction(&fn.ObjectMeta)
@@ -515,8 +1278,8 @@ func (gp *GenericPool) getFuncSvc(ctx context.Context, fn *fv1.Function) (*fscac
 		// and make sure that there is only one pod behind the service
 
 		sel := map[string]string{
-			"functionName": fn.ObjectMeta.Name,
-			"functionUid":  string(fn.ObjectMeta.UID),
+			fv1.FUNCTION_NAME: fn.ObjectMeta.Name,
+			fv1.FUNCTION_UID:  string(fn.ObjectMeta.UID),
 		}
 		podList, err := gp.kubernetesClient.CoreV1().Pods(gp.fnNamespace).List(ctx, metav1.ListOptions{
 			LabelSelector: labels.Set(sel).AsSelector().String(),
@@ -532,43 +1295,56 @@ func (gp *GenericPool) getFuncSvc(ctx context.Context, fn *fv1.Function) (*fscac
 		}
 	}
 
-	key, pod, err := gp.choosePod(ctx, funcLabels)
+	pod, err := gp.chooseAndSpecializePod(ctx, fn, funcLabels)
 	if err != nil {
 		return nil, err
 	}
-	gp.readyPodQueue.Done(key)
-	err = gp.specializePod(ctx, pod, fn)
-	if err != nil {
-		go gp.scheduleDeletePod(context.Background(), pod.ObjectMeta.Name)
-		return nil, err
-	}
+	gp.lastColdStart.Store(time.Now().UnixNano())
+	gp.recordEvent(apiv1.EventTypeNormal, "PodSpecialized", "Specialized pod %s for function %s/%s",
+		pod.ObjectMeta.Name, fn.ObjectMeta.Namespace, fn.ObjectMeta.Name)
 	logger.Info("specialized pod", zap.String("pod", pod.ObjectMeta.Name), zap.String("podNamespace", pod.ObjectMeta.Namespace), zap.String("podIP", pod.Status.PodIP))
 
-	var svcHost string
-	if gp.useSvc && !gp.useIstio {
-		svcName := fmt.Sprintf("svc-%v", fn.ObjectMeta.Name)
-		if len(fn.ObjectMeta.UID) > 0 {
-			svcName = fmt.Sprintf("%s-%v", svcName, fn.ObjectMeta.UID)
-		}
-
-		svc, err := gp.createSvc(ctx, svcName, funcLabels)
+	var svcHost, externalAddress string
+	var nodePort int32
+	var svcRef *apiv1.ObjectReference
+	if len(svcName) > 0 {
+		svc, err := gp.createSvc(ctx, svcName, funcLabels, gp.servicePorts(), gp.usesHeadlessSvc(svcSpec), svcSpec)
 		if err != nil {
 			go gp.scheduleDeletePod(context.Background(), pod.ObjectMeta.Name)
 			return nil, err
 		}
 		if svc.ObjectMeta.Name != svcName {
+			// don't leak the service created for the pod we give up on
+			gp.deleteSvc(ctx, svc.ObjectMeta.Name)
 			go gp.scheduleDeletePod(context.Background(), pod.ObjectMeta.Name)
 			return nil, errors.Errorf("sanity check failed for svc %v", svc.ObjectMeta.Name)
 		}
+		// a new service routes nowhere until its endpoints list the pod
+		err = gp.waitForSvcEndpoints(ctx, svcName, pod.Status.PodIP)
+		if err != nil {
+			gp.deleteSvc(ctx, svcName)
+			go gp.scheduleDeletePod(context.Background(), pod.ObjectMeta.Name)
+			return nil, err
+		}
+
+		svcRef = &apiv1.ObjectReference{
+			Kind:            "service",
+			Name:            svc.ObjectMeta.Name,
+			APIVersion:      svc.TypeMeta.APIVersion,
+			Namespace:       svc.ObjectMeta.Namespace,
+			ResourceVersion: svc.ObjectMeta.ResourceVersion,
+			UID:             svc.ObjectMeta.UID,
+		}
 
 		// the fission router isn't in the same namespace, so return a
 		// namespace-qualified hostname
-		svcHost = fmt.Sprintf("%v.%v:8888", svcName, gp.fnNamespace)
+		svcHost = fmt.Sprintf("%v.%v:%d", svcName, gp.fnNamespace, gp.runtimePort)
+		nodePort, externalAddress = gp.externalAddress(svc)
 	} else if gp.useIstio {
 		svc := utils.GetFunctionIstioServiceName(fn.ObjectMeta.Name, fn.ObjectMeta.Namespace)
-		svcHost = fmt.Sprintf("%v.%v:8888", svc, gp.fnNamespace)
+		svcHost = fmt.Sprintf("%v.%v:%d", svc, gp.fnNamespace, gp.runtimePort)
 	} else {
-		svcHost = fmt.Sprintf("%v:8888", pod.Status.PodIP)
+		svcHost = fmt.Sprintf("%v:%d", pod.Status.PodIP, gp.runtimePort)
 	}
 
 	otelUtils.SpanTrackEvent(ctx, "addFunctionLabel", otelUtils.GetAttributesForPod(pod)...)
@@ -594,6 +1370,11 @@ func (gp *GenericPool) getFuncSvc(ctx context.Context, fn *fv1.Function) (*fscac
 			UID:             pod.ObjectMeta.UID,
 		},
 	}
+	// the idle object reaper cleans up every referenced object, so the
+	// service goes away together with the pod it selects
+	if svcRef != nil {
+		kubeObjRefs = append(kubeObjRefs, *svcRef)
+	}
 	cpuUsage := resource.MustParse("0m")
 	for _, container := range pod.Spec.Containers {
 		val := *container.Resources.Limits.Cpu()
@@ -614,16 +1395,24 @@ func (gp *GenericPool) getFuncSvc(ctx context.Context, fn *fv1.Function) (*fscac
 		Function:          &m,
 		Environment:       gp.env,
 		Address:           svcHost,
+		PodIP:             pod.Status.PodIP,
+		Port:              gp.runtimePort,
+		NodePort:          nodePort,
+		ExternalAddress:   externalAddress,
 		KubernetesObjects: kubeObjRefs,
 		Executor:          fv1.ExecutorTypePoolmgr,
 		CPULimit:          cpuLimit,
+		RequestTimeout:    fn.GetFunctionTimeout(),
+		ColdStartDuration: time.Since(coldStart),
 		Ctime:             time.Now(),
 		Atime:             time.Now(),
 	}
+	gp.lastColdStartDuration.Store(int64(fsvc.ColdStartDuration))
 
 	gp.fsCache.PodToFsvc.Store(pod.GetObjectMeta().GetName(), fsvc)
 	gp.podFSVCMap.Store(pod.ObjectMeta.Name, []interface{}{crd.CacheKey(fsvc.Function), fsvc.Address})
 	gp.fsCache.AddFunc(ctx, *fsvc, fn.GetRequestPerPod())
+	gp.PoolHooks.specialized(gp.logger, fsvc)
 
 	logger.Info("added function service",
 		zap.String("pod", pod.ObjectMeta.Name),
@@ -635,29 +1424,235 @@ func (gp *GenericPool) getFuncSvc(ctx context.Context, fn *fv1.Function) (*fscac
 	return fsvc, nil
 }
 
+// chooseAndSpecializePod claims a ready pod and specializes it for the
+// function. A pod going away while it's specialized is replaced by another
+// one, up to maxPodRechoices times.
+func (gp *GenericPool) chooseAndSpecializePod(ctx context.Context, fn *fv1.Function, funcLabels map[string]string) (*apiv1.Pod, error) {
+	logger := otelUtils.LoggerWithTraceID(ctx, gp.logger).With(zap.String("function", fn.ObjectMeta.Name), zap.String("namespace", fn.ObjectMeta.Namespace))
+	// don't claim a pod only to fail fetching the function into it
+	ticket, err := gp.fetchBreaker.allow()
+	if err != nil {
+		return nil, err
+	}
+	defer gp.fetchBreaker.release(ticket)
+	release, err := gp.acquireSpecializeSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if pod := gp.takePartialPod(ctx, fn); pod != nil {
+		logger.Info("re-specializing pod of a timed out attempt", zap.String("pod", pod.ObjectMeta.Name))
+		specializeStart := time.Now()
+		err = gp.specializePod(ctx, pod, fn, ticket)
+		metrics.PoolSpecializeDuration.WithLabelValues(gp.env.ObjectMeta.Name, gp.env.ObjectMeta.Namespace).Observe(time.Since(specializeStart).Seconds())
+		if err == nil {
+			return pod, nil
+		}
+		logger.Warn("error re-specializing pod of a timed out attempt, choosing another pod", zap.Error(err),
+			zap.String("pod", pod.ObjectMeta.Name))
+		go gp.scheduleDeletePod(context.Background(), pod.ObjectMeta.Name)
+	}
+
+	// re-specializing a partial pod above doesn't add a pod to the pool
+	err = gp.checkCapacity()
+	if err != nil {
+		return nil, err
+	}
+	for rechoices := 0; ; rechoices++ {
+		key, pod, err := gp.choosePod(ctx, funcLabels)
+		if err != nil {
+			return nil, err
+		}
+		gp.readyPodQueue.Done(key)
+		gp.PoolHooks.chosen(gp.logger, pod, fn)
+		specializeStart := time.Now()
+		err = gp.specializePod(ctx, pod, fn, ticket)
+		metrics.PoolSpecializeDuration.WithLabelValues(gp.env.ObjectMeta.Name, gp.env.ObjectMeta.Namespace).Observe(time.Since(specializeStart).Seconds())
+		if err == nil {
+			return pod, nil
+		}
+		if errors.Is(err, ErrSpecializeTimeout) && gp.keepPartialPod(ctx, fn, pod) {
+			return nil, err
+		}
+		go gp.scheduleDeletePod(context.Background(), pod.ObjectMeta.Name)
+		if rechoices < maxPodRechoices && gp.podGone(ctx, pod, err) {
+			logger.Warn("pod went away while specializing, choosing another pod", zap.Error(err),
+				zap.String("pod", pod.ObjectMeta.Name), zap.Int("rechoices", rechoices+1))
+			continue
+		}
+		return nil, err
+	}
+}
+
+// keepPartialPod keeps a pod whose specialization timed out for the next
+// attempt for the function, which re-issues the specialize request to the
+// pod rather than claiming another one: the fetcher may have been about to
+// finish. The pod is deleted if it isn't taken within partialPodTimeout.
+// It returns false if the pod is gone. Kept pods are only tracked in memory,
+// a restarted executor cleans them up with the other objects of the previous
+// instance, see CleanupOldExecutorObjects; their labels don't tell them from
+// the pods serving the function.
+func (gp *GenericPool) keepPartialPod(ctx context.Context, fn *fv1.Function, pod *apiv1.Pod) bool {
+	if gp.podGone(ctx, pod, nil) {
+		return false
+	}
+	key := crd.CacheKey(&fn.ObjectMeta)
+	if previous, loaded := gp.partialPods.Swap(key, pod.ObjectMeta.Name); loaded {
+		// only one pod is kept per function
+		go gp.scheduleDeletePod(context.Background(), previous.(string))
+	}
+	time.AfterFunc(partialPodTimeout, func() {
+		if gp.partialPods.CompareAndDelete(key, pod.ObjectMeta.Name) {
+			gp.scheduleDeletePod(context.Background(), pod.ObjectMeta.Name)
+		}
+	})
+	return true
+}
+
+// takePartialPod returns the pod kept by keepPartialPod for the function,
+// if it's still there and labeled for the function, or nil. A pod which
+// can't be reused is deleted.
+func (gp *GenericPool) takePartialPod(ctx context.Context, fn *fv1.Function) *apiv1.Pod {
+	name, ok := gp.partialPods.LoadAndDelete(crd.CacheKey(&fn.ObjectMeta))
+	if !ok {
+		return nil
+	}
+	pod, err := gp.kubernetesClient.CoreV1().Pods(gp.fnNamespace).Get(ctx, name.(string), metav1.GetOptions{})
+	if err != nil {
+		if !k8s_err.IsNotFound(err) {
+			gp.logger.Error("error getting pod of a timed out specialization", zap.Error(err), zap.String("pod", name.(string)))
+			go gp.scheduleDeletePod(context.Background(), name.(string))
+		}
+		return nil
+	}
+	if pod.ObjectMeta.DeletionTimestamp != nil || pod.Labels[fv1.FUNCTION_UID] != string(fn.ObjectMeta.UID) {
+		go gp.scheduleDeletePod(context.Background(), pod.ObjectMeta.Name)
+		return nil
+	}
+	return pod
+}
+
+// acquireSpecializeSlot waits until fewer pods than the configured limit are
+// being specialized, so that a burst of cold starts doesn't claim every pod
+// of the pool at once and starve it. Callers queue until a slot is free or
+// the context is done. It returns the function releasing the slot.
+func (gp *GenericPool) acquireSpecializeSlot(ctx context.Context) (func(), error) {
+	if gp.specializeSlots != nil {
+		select {
+		case gp.specializeSlots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("context canceled while waiting to specialize a pod: %w", ctx.Err())
+		}
+	}
+	gp.specializing.Add(1)
+	return func() {
+		gp.specializing.Add(-1)
+		if gp.specializeSlots != nil {
+			<-gp.specializeSlots
+		}
+	}, nil
+}
+
+// podGone reports whether specializing the pod failed because the pod was
+// deleted or evicted, in which case another pod can be specialized instead.
+func (gp *GenericPool) podGone(ctx context.Context, pod *apiv1.Pod, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	p, err := gp.kubernetesClient.CoreV1().Pods(pod.ObjectMeta.Namespace).Get(ctx, pod.ObjectMeta.Name, metav1.GetOptions{})
+	if err != nil {
+		return k8s_err.IsNotFound(err)
+	}
+	return p.ObjectMeta.DeletionTimestamp != nil
+}
+
+// recordEvent records an event on the pool deployment.
+func (gp *GenericPool) recordEvent(eventType, reason, messageFmt string, args ...interface{}) {
+	deployment := gp.deployment.Load()
+	if gp.recorder == nil || deployment == nil {
+		return
+	}
+	gp.recorder.Eventf(deployment, eventType, reason, messageFmt, args...)
+}
+
 // getPercent returns  x percent of the quantity i.e multiple it x/100
 func (gp *GenericPool) getPercent(cpuUsage resource.Quantity, percentage float64) (resource.Quantity, error) {
 	val := int64(math.Ceil(float64(cpuUsage.MilliValue()) * percentage))
 	return resource.ParseQuantity(fmt.Sprintf("%dm", val))
 }
 
-// destroys the pool -- the deployment, replicaset and pods
+// destroys the pool -- the deployment, replicaset, pods and function services
 func (gp *GenericPool) destroy(ctx context.Context) error {
 	close(gp.stopReadyPodControllerCh)
+	// unblock callers waiting in choosePod, they get an error
+	// saying the readypod controller is not running.
+	if gp.readyPodQueue != nil {
+		gp.readyPodQueue.ShutDown()
+	}
+	metrics.PoolReadyPods.DeleteLabelValues(gp.env.ObjectMeta.Name, gp.env.ObjectMeta.Namespace)
+	gp.fetchBreaker.deleteMetrics()
 
 	deletePropagation := metav1.DeletePropagationBackground
 	delOpt := metav1.DeleteOptions{
 		PropagationPolicy: &deletePropagation,
 	}
 
+	deployment := gp.deployment.Load()
 	err := gp.kubernetesClient.AppsV1().
-		Deployments(gp.fnNamespace).Delete(ctx, gp.deployment.ObjectMeta.Name, delOpt)
-	if err != nil {
+		Deployments(gp.fnNamespace).Delete(ctx, deployment.ObjectMeta.Name, delOpt)
+	if err != nil && !k8s_err.IsNotFound(err) {
 		gp.logger.Error("error destroying deployment",
 			zap.Error(err),
-			zap.String("deployment_name", gp.deployment.ObjectMeta.Name),
+			zap.String("deployment_name", deployment.ObjectMeta.Name),
 			zap.String("deployment_namespace", gp.fnNamespace))
 		return err
 	}
+	err = gp.deleteResourceQuota(ctx)
+	if err != nil {
+		gp.logger.Error("error deleting resource quota of pool", zap.Error(err))
+	}
+
+	return gp.deleteFunctionServices(ctx)
+}
+
+// deleteFunctionServices deletes the services created for specialized pods of this pool.
+func (gp *GenericPool) deleteFunctionServices(ctx context.Context) error {
+	sel := map[string]string{
+		fv1.EXECUTOR_TYPE:   string(fv1.ExecutorTypePoolmgr),
+		fv1.ENVIRONMENT_UID: string(gp.env.ObjectMeta.UID),
+	}
+	svcList, err := gp.kubernetesClient.CoreV1().Services(gp.fnNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.Set(sel).AsSelector().String(),
+	})
+	if err != nil {
+		gp.logger.Error("error listing function services", zap.Error(err))
+		return err
+	}
+	for _, svc := range svcList.Items {
+		err = gp.kubernetesClient.CoreV1().Services(gp.fnNamespace).Delete(ctx, svc.ObjectMeta.Name, metav1.DeleteOptions{})
+		if err != nil && !k8s_err.IsNotFound(err) {
+			gp.logger.Error("error deleting function service", zap.Error(err),
+				zap.String("service", svc.ObjectMeta.Name),
+				zap.String("namespace", gp.fnNamespace))
+			return err
+		}
+	}
 	return nil
 }
+
+// isCurrentGenerationPod returns false for generic pods created from a
+// previous spec of the environment. They stay ready and keep matching the
+// pool selector while the deployment rolls out the new spec, but run an
+// outdated runtime, unless the pool falls back to them, see
+// isServingGenerationPod.
+func (gp *GenericPool) isCurrentGenerationPod(pod *apiv1.Pod) bool {
+	deployment := gp.deployment.Load()
+	if deployment == nil {
+		return true
+	}
+	return pod.Labels[fv1.ENVIRONMENT_GENERATION] == deployment.Spec.Template.Labels[fv1.ENVIRONMENT_GENERATION]
+}
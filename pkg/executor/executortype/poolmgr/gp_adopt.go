@@ -0,0 +1,193 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"github.com/pkg/errors"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	k8sInformers "k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+// getAdoptSelector returns the selector of the pods the pool of env adopts
+// besides its own, nil if it adopts none, see fv1.ANNOTATION_ADOPT_POD_SELECTOR.
+// Like the pool's own pods, only pods labeled as managed can be claimed for
+// a function, which relabels them as unmanaged: the selector only matches
+// managed pods so that claimed pods leave the ready pods of the pool. Pods
+// of environment pools are never adopted, whatever the selector: the pods
+// of another environment run its runtime, and the pool lists its own pods
+// anyway.
+func getAdoptSelector(env *fv1.Environment) (labels.Selector, error) {
+	value := env.ObjectMeta.Annotations[fv1.ANNOTATION_ADOPT_POD_SELECTOR]
+	if len(value) == 0 {
+		return nil, nil
+	}
+	selector, err := labels.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	if selector.Empty() {
+		return nil, errors.New("selector matches every pod")
+	}
+	managed, err := labels.NewRequirement(fv1.MANAGED, selection.Equals, []string{"true"})
+	if err != nil {
+		return nil, err
+	}
+	noEnv, err := labels.NewRequirement(fv1.ENVIRONMENT_UID, selection.DoesNotExist, nil)
+	if err != nil {
+		return nil, err
+	}
+	return selector.Add(*managed, *noEnv), nil
+}
+
+// watchAdoptedPods adds the pods matching the adopt selector of the
+// environment to the ready pods of the pool: they're queued to be chosen
+// and listed by the ready pod lister. Choosing an adopted pod claims it
+// like the pool's own pods, so a pod is never specialized twice.
+func (gp *GenericPool) watchAdoptedPods() error {
+	selector, err := getAdoptSelector(gp.env)
+	if err != nil || selector == nil {
+		return err
+	}
+	informerFactory := k8sInformers.NewSharedInformerFactoryWithOptions(gp.kubernetesClient, 0,
+		k8sInformers.WithNamespace(gp.fnNamespace),
+		k8sInformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = selector.String()
+			options.FieldSelector = "status.phase=Running"
+		}))
+	podInformer := informerFactory.Core().V1().Pods()
+	gp.adoptSelector = selector
+	gp.readyPodLister = unionPodLister{gp.readyPodLister, podInformer.Lister()}
+	ownSynced := gp.readyPodListerSynced
+	gp.readyPodListerSynced = func() bool {
+		return ownSynced() && podInformer.Informer().HasSynced()
+	}
+	podInformer.Informer().AddEventHandler(gp.readyPodEventHandlers())
+	go podInformer.Informer().Run(gp.stopReadyPodControllerCh)
+	return nil
+}
+
+// isAdoptedPod returns true for pods the pool adopted rather than created.
+// They aren't created from the environment spec, so they have no
+// generation and serve whatever the current generation is.
+func (gp *GenericPool) isAdoptedPod(pod *apiv1.Pod) bool {
+	return gp.wasAdopted(pod) && gp.adoptSelector.Matches(labels.Set(pod.Labels))
+}
+
+// wasAdopted returns true for pods of the pool, generic or specialized,
+// which it adopted rather than created. Claiming an adopted pod labels it
+// with the environment, but not with a generation.
+func (gp *GenericPool) wasAdopted(pod *apiv1.Pod) bool {
+	if gp.adoptSelector == nil || gp.isOtherEnvironmentPod(pod) {
+		return false
+	}
+	_, ok := pod.Labels[fv1.ENVIRONMENT_GENERATION]
+	return !ok
+}
+
+// isOtherEnvironmentPod returns true for pods labeled with an environment
+// other than the pool's, e.g. pods of the pool of another environment.
+func (gp *GenericPool) isOtherEnvironmentPod(pod *apiv1.Pod) bool {
+	for label, value := range map[string]string{
+		fv1.ENVIRONMENT_UID:       string(gp.env.ObjectMeta.UID),
+		fv1.ENVIRONMENT_NAME:      gp.env.ObjectMeta.Name,
+		fv1.ENVIRONMENT_NAMESPACE: gp.env.ObjectMeta.Namespace,
+	} {
+		if v, ok := pod.Labels[label]; ok && v != value {
+			return true
+		}
+	}
+	return false
+}
+
+// unionPodLister lists the pods of several listers, e.g. the pool's own
+// pods and the pods it adopts. A pod listed by more than one is returned
+// once.
+type unionPodLister []corelisters.PodLister
+
+func (l unionPodLister) List(selector labels.Selector) ([]*apiv1.Pod, error) {
+	var lists [][]*apiv1.Pod
+	for _, lister := range l {
+		pods, err := lister.List(selector)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, pods)
+	}
+	return mergePods(lists), nil
+}
+
+func (l unionPodLister) Pods(namespace string) corelisters.PodNamespaceLister {
+	listers := make(unionPodNamespaceLister, 0, len(l))
+	for _, lister := range l {
+		listers = append(listers, lister.Pods(namespace))
+	}
+	return listers
+}
+
+type unionPodNamespaceLister []corelisters.PodNamespaceLister
+
+func (l unionPodNamespaceLister) List(selector labels.Selector) ([]*apiv1.Pod, error) {
+	var lists [][]*apiv1.Pod
+	for _, lister := range l {
+		pods, err := lister.List(selector)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, pods)
+	}
+	return mergePods(lists), nil
+}
+
+// Get returns the pod from the first lister that has it.
+func (l unionPodNamespaceLister) Get(name string) (*apiv1.Pod, error) {
+	var err error
+	for _, lister := range l {
+		var pod *apiv1.Pod
+		pod, err = lister.Get(name)
+		if err == nil {
+			return pod, nil
+		}
+	}
+	return nil, err
+}
+
+// mergePods concatenates lists of pods, skipping pods already listed.
+func mergePods(lists [][]*apiv1.Pod) []*apiv1.Pod {
+	seen := make(map[string]struct{})
+	var merged []*apiv1.Pod
+	for _, pods := range lists {
+		for _, pod := range pods {
+			key, err := k8sCache.MetaNamespaceKeyFunc(pod)
+			if err != nil {
+				continue
+			}
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			merged = append(merged, pod)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+func TestChoosePodAdoptedPod(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	gp := newTestPool(t)
+	defer gp.readyPodQueue.ShutDown()
+	defer close(gp.stopReadyPodControllerCh)
+	gp.env.ObjectMeta.Annotations = map[string]string{fv1.ANNOTATION_ADOPT_POD_SELECTOR: "warm=true"}
+	deployment := &appsv1.Deployment{}
+	deployment.Spec.Template.Labels = map[string]string{fv1.ENVIRONMENT_GENERATION: "2"}
+	gp.deployment.Store(deployment)
+	warm := &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "warm",
+			Namespace: metav1.NamespaceDefault,
+			Labels:    map[string]string{"warm": "true", fv1.MANAGED: "true"},
+		},
+		Status: apiv1.PodStatus{
+			Phase:             apiv1.PodRunning,
+			PodIP:             "10.0.0.1",
+			ContainerStatuses: []apiv1.ContainerStatus{{Name: "test", Ready: true}},
+		},
+	}
+	_, err := gp.kubernetesClient.CoreV1().Pods(warm.Namespace).Create(ctx, warm, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Error creating pod: %v", err)
+	}
+
+	err = gp.watchAdoptedPods()
+	if err != nil {
+		t.Fatalf("Error watching adopted pods: %v", err)
+	}
+	fn := &metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}
+	_, pod, err := gp.choosePod(ctx, gp.labelsForFunction(fn))
+	if err != nil {
+		t.Fatalf("Error choosing pod: %v", err)
+	}
+	if pod.Name != "warm" {
+		t.Fatalf("chose pod %q, want %q", pod.Name, "warm")
+	}
+	if pod.Labels[fv1.MANAGED] != "false" || pod.Labels[fv1.FUNCTION_UID] != "fn-uid" {
+		t.Errorf("adopted pod wasn't claimed, labels %v", pod.Labels)
+	}
+
+	// adopted pods aren't handed back to the pool
+	err = gp.releasePod(ctx, pod)
+	if err == nil {
+		t.Error("releasing an adopted pod didn't fail")
+	}
+	_, err = gp.kubernetesClient.CoreV1().Pods(warm.Namespace).Get(ctx, warm.Name, metav1.GetOptions{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("getting released adopted pod returned %v, want not found", err)
+	}
+}
+
+func TestChoosePodOtherEnvironmentPod(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the pool of another environment, at the same generation
+	other := newTestPod("other", "10.0.0.2", true)
+	other.Labels[fv1.ENVIRONMENT_NAME] = "other"
+	other.Labels[fv1.ENVIRONMENT_UID] = "other-uid"
+	other.Labels[fv1.ENVIRONMENT_GENERATION] = "2"
+	otherPool := newTestPool(t, other)
+	defer otherPool.readyPodQueue.ShutDown()
+
+	gp := newTestPool(t)
+	defer gp.readyPodQueue.ShutDown()
+	defer close(gp.stopReadyPodControllerCh)
+	gp.kubernetesClient = otherPool.kubernetesClient
+	gp.podReadyTimeout = 300 * time.Millisecond
+	gp.env.ObjectMeta.Annotations = map[string]string{fv1.ANNOTATION_ADOPT_POD_SELECTOR: "executorType=poolmgr"}
+	deployment := &appsv1.Deployment{}
+	deployment.Spec.Template.Labels = map[string]string{fv1.ENVIRONMENT_GENERATION: "2"}
+	gp.deployment.Store(deployment)
+	if gp.isServingGenerationPod(other) {
+		t.Error("pod of another environment is served by the pool")
+	}
+
+	err := gp.watchAdoptedPods()
+	if err != nil {
+		t.Fatalf("Error watching adopted pods: %v", err)
+	}
+	if !k8sCache.WaitForCacheSync(ctx.Done(), gp.readyPodListerSynced) {
+		t.Fatal("adopted pods didn't sync")
+	}
+	pods, err := gp.readyPodLister.List(labels.Everything())
+	if err != nil {
+		t.Fatalf("Error listing ready pods: %v", err)
+	}
+	if len(pods) != 0 {
+		t.Errorf("pool lists %d ready pods of another environment", len(pods))
+	}
+
+	fn := &metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}
+	_, pod, err := gp.choosePod(ctx, gp.labelsForFunction(fn))
+	if err == nil {
+		t.Fatalf("chose pod %q of another environment", pod.Name)
+	}
+	pod, err = gp.kubernetesClient.CoreV1().Pods(other.Namespace).Get(ctx, other.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting pod: %v", err)
+	}
+	if pod.Labels[fv1.MANAGED] != "true" {
+		t.Errorf("pod of another environment was claimed, labels %v", pod.Labels)
+	}
+}
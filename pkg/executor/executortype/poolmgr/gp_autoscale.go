@@ -0,0 +1,170 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sTypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+const (
+	defaultAutoscaleInterval = 10 * time.Second
+	defaultScaleDownDelay    = 5 * time.Minute
+	// early scale ups are delayed by up to twice this so that pods
+	// specialized together are accounted for in a single patch
+	lookAheadDelay = 100 * time.Millisecond
+)
+
+// adjustReplicas patches the replica count of the pool deployment, within
+// the max pods of the environment. It returns the replicas the deployment
+// has.
+func (gp *GenericPool) adjustReplicas(ctx context.Context, target int32) (int32, error) {
+	target = gp.replicaCeiling(target)
+	deployment := gp.deployment.Load()
+	if deployment.Spec.Replicas != nil && *deployment.Spec.Replicas == target {
+		return target, nil
+	}
+	patch := fmt.Sprintf(`{"spec":{"replicas":%d}}`, target)
+	depl, err := gp.kubernetesClient.AppsV1().Deployments(gp.fnNamespace).Patch(ctx, deployment.ObjectMeta.Name,
+		k8sTypes.MergePatchType, []byte(patch), metav1.PatchOptions{})
+	if err != nil {
+		return 0, err
+	}
+	gp.deployment.Store(depl)
+	return target, nil
+}
+
+// autoscaleEnabled returns true if the pool is allowed to grow beyond its
+// initial size.
+func (gp *GenericPool) autoscaleEnabled() bool {
+	if gp.env.Spec.AllowedFunctionsPerContainer == fv1.AllowedFunctionsPerContainerInfinite {
+		return false
+	}
+	return gp.maxReplicas > gp.minReplicas.Load()
+}
+
+// autoscalePool scales the pool deployment based on how often choosePod
+// finds no ready pod waiting in the queue, until the pool is destroyed.
+// Besides every autoscale interval, the pool is scaled as soon as
+// lookAheadScaleUp asks for replacement pods.
+func (gp *GenericPool) autoscalePool() {
+	ticker := time.NewTicker(gp.autoscaleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-gp.stopReadyPodControllerCh:
+			return
+		case <-ticker.C:
+		case <-gp.scaleUpCh:
+			select {
+			case <-gp.stopReadyPodControllerCh:
+				return
+			case <-time.After(wait.Jitter(lookAheadDelay, 1.0)):
+			}
+		}
+		gp.doAutoscale(context.Background())
+	}
+}
+
+// lookAheadScaleUp is called for every pod taken from the pool. Specialized
+// pods leave the deployment selector and the ReplicaSet replaces them, but
+// only after a while. If fewer than lookAhead ready pods are left waiting,
+// the missing pods are counted as starved requests and the autoscaler is
+// woken up, so that replacements are scheduled before the next cold start
+// needs them.
+func (gp *GenericPool) lookAheadScaleUp() {
+	if gp.lookAhead <= 0 || !gp.autoscaleEnabled() {
+		return
+	}
+	// pods being specialized have already been taken from the queue
+	effectiveReady := int32(gp.readyPodQueue.Len())
+	if effectiveReady >= gp.lookAhead {
+		return
+	}
+	gp.starvedRequests.Add(gp.lookAhead - effectiveReady)
+	select {
+	case gp.scaleUpCh <- struct{}{}:
+	default:
+		// a scale up is already pending
+	}
+}
+
+func (gp *GenericPool) doAutoscale(ctx context.Context) {
+	if !gp.autoscaleEnabled() || gp.deployment.Load() == nil {
+		return
+	}
+	gp.deploymentLock.Lock()
+	defer gp.deploymentLock.Unlock()
+	// scale from the replicas the deployment has, which may have been
+	// changed since the pool last patched them
+	err := gp.refreshDeployment(ctx)
+	if err != nil {
+		gp.logger.Error("error refreshing pool deployment", zap.Error(err),
+			zap.String("deployment", gp.deployment.Load().ObjectMeta.Name))
+		return
+	}
+	deployment := gp.deployment.Load()
+	if deployment.Spec.Replicas == nil {
+		return
+	}
+
+	current := *deployment.Spec.Replicas
+	target := current
+
+	starved := gp.starvedRequests.Swap(0)
+	if starved > 0 {
+		gp.lastStarved = time.Now()
+		target = current + starved
+		if target > gp.maxReplicas {
+			target = gp.maxReplicas
+		}
+	} else if current > gp.minReplicas.Load() && time.Since(gp.lastStarved) > gp.scaleDownDelay {
+		// Specialized pods are relabeled out of the deployment selector,
+		// so scaling down only removes generic pods and never touches
+		// pods serving functions.
+		target = current - 1
+	}
+
+	if target == current {
+		return
+	}
+
+	replicas, err := gp.adjustReplicas(ctx, target)
+	if err != nil {
+		gp.logger.Error("error adjusting pool replicas", zap.Error(err),
+			zap.String("deployment", deployment.ObjectMeta.Name),
+			zap.Int32("current", current), zap.Int32("target", target))
+		return
+	}
+	if replicas == current {
+		gp.logger.Debug("pool replicas capped by the max pods of the environment",
+			zap.String("deployment", deployment.ObjectMeta.Name),
+			zap.Int32("replicas", current), zap.Int32("target", target))
+		return
+	}
+	gp.logger.Info("adjusted pool replicas",
+		zap.String("deployment", deployment.ObjectMeta.Name),
+		zap.Int32("from", current), zap.Int32("to", replicas), zap.Int32("starved_requests", starved))
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/executor/fscache"
+	fetcherConfig "github.com/fission/fission/pkg/fetcher/config"
+)
+
+func TestChoosePodLookAhead(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	gp := newTestPool(t, newTestPod("p1", "10.0.0.1", true))
+	defer gp.readyPodQueue.ShutDown()
+	gp.minReplicas.Store(2)
+	gp.maxReplicas, gp.lookAhead = 5, 2
+	replicas := int32(2)
+	depl := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "pool", Namespace: metav1.NamespaceDefault},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+	}
+	depl, err := gp.kubernetesClient.AppsV1().Deployments(depl.Namespace).Create(ctx, depl, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Error creating deployment: %v", err)
+	}
+	gp.deployment.Store(depl)
+
+	fn := &metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}
+	_, _, err = gp.choosePod(ctx, gp.labelsForFunction(fn))
+	if err != nil {
+		t.Fatalf("Error choosing pod: %v", err)
+	}
+	select {
+	case <-gp.scaleUpCh:
+	default:
+		t.Fatal("choosing the last ready pod didn't wake up the autoscaler")
+	}
+
+	gp.doAutoscale(ctx)
+	got, err := gp.kubernetesClient.AppsV1().Deployments(depl.Namespace).Get(ctx, depl.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting deployment: %v", err)
+	}
+	if *got.Spec.Replicas != 4 {
+		t.Errorf("got %d replicas, want 4", *got.Spec.Replicas)
+	}
+}
+
+// TestAutoscaleConcurrentGetFuncSvc scales the pool while functions get
+// specialized and its status is read, for the race detector to check the
+// pool deployment is shared safely.
+func TestAutoscaleConcurrentGetFuncSvc(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ts, host, port := fakeFetcher(t)
+	defer ts.Close()
+	const functions = 4
+	var pods []*apiv1.Pod
+	for i := 0; i < functions; i++ {
+		pods = append(pods, newTestPod(fmt.Sprintf("p%d", i), host, true))
+	}
+	gp := newTestPool(t, pods...)
+	defer gp.readyPodQueue.ShutDown()
+	cfg, err := fetcherConfig.MakeFetcherConfig("/userfunc")
+	if err != nil {
+		t.Fatalf("Error creating fetcher config: %v", err)
+	}
+	gp.fetcherConfig, gp.fetcherPort = cfg, port
+	gp.fsCache = fscache.MakeFunctionServiceCache(gp.logger)
+	gp.recorder = record.NewFakeRecorder(100)
+	gp.minReplicas.Store(1)
+	gp.maxReplicas = 10
+	replicas := int32(1)
+	depl := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "pool", Namespace: metav1.NamespaceDefault},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+	}
+	depl, err = gp.kubernetesClient.AppsV1().Deployments(depl.Namespace).Create(ctx, depl, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Error creating deployment: %v", err)
+	}
+	gp.deployment.Store(depl)
+
+	done := make(chan struct{})
+	var autoscaled sync.WaitGroup
+	autoscaled.Add(1)
+	go func() {
+		defer autoscaled.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			gp.starvedRequests.Add(1)
+			gp.doAutoscale(ctx)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < functions; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fn := &fv1.Function{ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("fn%d", i),
+				Namespace: metav1.NamespaceDefault,
+				UID:       k8stypes.UID(fmt.Sprintf("fn%d-uid", i)),
+			}}
+			_, err := gp.getFuncSvc(ctx, fn)
+			if err != nil {
+				t.Errorf("Error getting function service: %v", err)
+			}
+			_, err = gp.Status(ctx)
+			if err != nil {
+				t.Errorf("Error getting pool status: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(done)
+	autoscaled.Wait()
+}
@@ -0,0 +1,203 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/executor/metrics"
+	"github.com/fission/fission/pkg/fetcher"
+	fetcherClient "github.com/fission/fission/pkg/fetcher/client"
+)
+
+const (
+	defaultFetchBreakerThreshold = 5
+	defaultFetchBreakerCooldown  = 30 * time.Second
+)
+
+// states of the fetch breaker, as reported by the metric
+const (
+	fetchBreakerClosed = iota
+	fetchBreakerOpen
+	fetchBreakerHalfOpen
+)
+
+// fetchBreaker stops specializing pods for a while once fetchers keep
+// failing to reach the source of the functions, e.g. while the storage the
+// archives come from is down. Every specialization would time out on its
+// own otherwise, adding load to the outage. Only failures of the source
+// count: connection errors and 502, 503 or 504 replies, which fetchers
+// report with a 502. Errors of a function's own package, e.g. a URL which
+// doesn't exist, and timeouts, which may be slow runtimes loading
+// functions, don't. Each pool has its own breaker, so functions of one
+// environment can't stop the specializations of others.
+//
+// After threshold fetch failures in a row the breaker opens and
+// specializations fail with ErrControllerUnavailable. Once the cooldown is
+// over, one trial specialization goes through: the breaker closes if it
+// fetches the function and opens again otherwise. Only the specialization
+// holding the trial ticket ends the trial, see fetchBreakerTicket.
+type fetchBreaker struct {
+	logger    *zap.Logger
+	threshold int
+	cooldown  time.Duration
+	labels    []string // metric labels of the pool
+
+	mu       sync.Mutex
+	failures int       // fetch failures in a row
+	openedAt time.Time // zero while the breaker is closed
+	trial    bool      // a trial specialization is running
+}
+
+// fetchBreakerTicket is handed to each specialization the breaker allows.
+// Specializations allowed before the breaker opened may finish while the
+// trial runs, so only the ticket of the trial can end it.
+type fetchBreakerTicket struct {
+	trial bool
+}
+
+// makeFetchBreaker returns the fetch breaker of the pool of env, configured
+// with POOLMGR_FETCH_BREAKER_THRESHOLD and POOLMGR_FETCH_BREAKER_COOLDOWN. A
+// threshold of 0 disables the breaker.
+func makeFetchBreaker(logger *zap.Logger, env *fv1.Environment, cfg *poolConfig) *fetchBreaker {
+	if cfg.fetchBreakerThreshold == 0 {
+		return nil
+	}
+	b := &fetchBreaker{
+		logger:    logger.Named("fetch_breaker"),
+		threshold: cfg.fetchBreakerThreshold,
+		cooldown:  cfg.fetchBreakerCooldown,
+		labels:    []string{env.ObjectMeta.Name, env.ObjectMeta.Namespace},
+	}
+	b.setState(fetchBreakerClosed)
+	return b
+}
+
+func (b *fetchBreaker) setState(state float64) {
+	metrics.FetchBreakerState.WithLabelValues(b.labels...).Set(state)
+}
+
+// deleteMetrics removes the metrics of the breaker once its pool is gone.
+func (b *fetchBreaker) deleteMetrics() {
+	if b == nil {
+		return
+	}
+	metrics.FetchBreakerState.DeleteLabelValues(b.labels...)
+	metrics.FetchBreakerTrips.DeleteLabelValues(b.labels...)
+}
+
+// allow returns the ticket of a specialization, or ErrControllerUnavailable
+// while the breaker is open or while the trial specialization after the
+// cooldown runs. A nil breaker allows every specialization.
+func (b *fetchBreaker) allow() (*fetchBreakerTicket, error) {
+	ticket := &fetchBreakerTicket{}
+	if b == nil {
+		return ticket, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openedAt.IsZero() {
+		return ticket, nil
+	}
+	if wait := b.cooldown - time.Since(b.openedAt); wait > 0 {
+		return nil, errors.Wrapf(ErrControllerUnavailable, "%d fetches failed in a row, retrying in %v",
+			b.failures, wait.Round(time.Second))
+	}
+	if b.trial {
+		return nil, errors.Wrap(ErrControllerUnavailable, "waiting for a trial fetch")
+	}
+	b.trial = true
+	ticket.trial = true
+	b.setState(fetchBreakerHalfOpen)
+	return ticket, nil
+}
+
+// release ends the trial if the ticket holds it and the specialization
+// didn't get to fetch, so another one can try.
+func (b *fetchBreaker) release(ticket *fetchBreakerTicket) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.endTrial(ticket)
+}
+
+// endTrial ends the trial if the ticket holds it. The caller holds b.mu.
+func (b *fetchBreaker) endTrial(ticket *fetchBreakerTicket) {
+	if ticket != nil && ticket.trial {
+		b.trial = false
+		ticket.trial = false
+	}
+}
+
+// record counts the outcome of a specialize request made with the ticket.
+// Requests which didn't reach a fetcher, timed out without telling which
+// stage took too long, failed on the function's own package, or whose
+// caller went away, say nothing about the source of the functions.
+func (b *fetchBreaker) record(ctx context.Context, ticket *fetchBreakerTicket, err error) {
+	if b == nil {
+		return
+	}
+	var httpErr *fetcherClient.HTTPError
+	failed := false
+	switch {
+	case err == nil:
+	case ctx.Err() != nil || !errors.As(err, &httpErr):
+		b.release(ticket)
+		return
+	case httpErr.Stage == fetcher.SpecializeStageLoad:
+		// the function was fetched if loading it failed
+	case httpErr.StatusCode == http.StatusBadGateway || httpErr.StatusCode == http.StatusServiceUnavailable ||
+		httpErr.StatusCode == http.StatusGatewayTimeout:
+		failed = true
+	default:
+		b.release(ticket)
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.endTrial(ticket)
+	if !failed {
+		if !b.openedAt.IsZero() {
+			b.logger.Info("fetching functions recovered, closing breaker")
+			b.setState(fetchBreakerClosed)
+		}
+		b.failures = 0
+		b.openedAt = time.Time{}
+		return
+	}
+	b.failures++
+	if b.failures < b.threshold {
+		return
+	}
+	if b.openedAt.IsZero() {
+		b.logger.Error("fetching functions keeps failing, opening breaker",
+			zap.Int("failures", b.failures), zap.Duration("cooldown", b.cooldown), zap.Error(err))
+		metrics.FetchBreakerTrips.WithLabelValues(b.labels...).Inc()
+	}
+	b.openedAt = time.Now()
+	b.setState(fetchBreakerOpen)
+}
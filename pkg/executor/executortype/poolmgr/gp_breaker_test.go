@@ -0,0 +1,188 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/fetcher"
+	fetcherClient "github.com/fission/fission/pkg/fetcher/client"
+	fetcherConfig "github.com/fission/fission/pkg/fetcher/config"
+)
+
+func TestFetchBreaker(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var fetchFails atomic.Bool
+	fetchFails.Store(true)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetchFails.Load() {
+			w.Header().Set(fetcher.SpecializeStageHeader, fetcher.SpecializeStageFetch)
+			http.Error(w, "failed to download url", http.StatusBadGateway)
+			return
+		}
+		_, err := w.Write([]byte(`{"filename":"deployarchive","size":1}`))
+		if err != nil {
+			t.Errorf("error writing response: %v", err)
+		}
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("Error parsing fetcher url: %v", err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatalf("Error parsing fetcher port: %v", err)
+	}
+
+	gp := newTestPool(t, newTestPod("ready-1", u.Hostname(), true), newTestPod("ready-2", u.Hostname(), true))
+	defer gp.readyPodQueue.ShutDown()
+	cfg, err := fetcherConfig.MakeFetcherConfig("/userfunc")
+	if err != nil {
+		t.Fatalf("Error creating fetcher config: %v", err)
+	}
+	gp.fetcherConfig, gp.fetcherPort = cfg, int32(port)
+	gp.specializeMaxRetries = 1
+	gp.fetchBreaker = makeFetchBreaker(gp.logger, gp.env, defaultPoolConfig())
+	gp.fetchBreaker.threshold, gp.fetchBreaker.cooldown = 1, time.Hour
+
+	fn := &fv1.Function{ObjectMeta: metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}}
+	_, err = gp.chooseAndSpecializePod(ctx, fn, gp.labelsForFunction(&fn.ObjectMeta))
+	if !errors.Is(err, ErrFetcherFailed) {
+		t.Fatalf("got error %v, want %v", err, ErrFetcherFailed)
+	}
+
+	_, err = gp.chooseAndSpecializePod(ctx, fn, gp.labelsForFunction(&fn.ObjectMeta))
+	if !errors.Is(err, ErrControllerUnavailable) {
+		t.Fatalf("got error %v with the breaker open, want %v", err, ErrControllerUnavailable)
+	}
+	if n := gp.readyPodQueue.Len(); n != 1 {
+		t.Errorf("%d pods left in the ready queue, want 1 as the open breaker claims none", n)
+	}
+
+	// the cooldown is over and the package storage is back
+	gp.fetchBreaker.openedAt = time.Now().Add(-2 * time.Hour)
+	fetchFails.Store(false)
+	_, err = gp.chooseAndSpecializePod(ctx, fn, gp.labelsForFunction(&fn.ObjectMeta))
+	if err != nil {
+		t.Fatalf("Error specializing pod on the trial after the cooldown: %v", err)
+	}
+	if _, err = gp.fetchBreaker.allow(); err != nil {
+		t.Errorf("breaker still open after the trial fetched the function: %v", err)
+	}
+}
+
+// TestFetchBreakerCounts checks that only failures to reach the source of
+// the functions open the breaker.
+func TestFetchBreakerCounts(t *testing.T) {
+	fetchErr := func(status int, stage string) error {
+		return &fetcherClient.HTTPError{StatusCode: status, Stage: stage, Err: errors.New("failed")}
+	}
+	tests := []struct {
+		name     string
+		err      error
+		wantOpen bool
+	}{
+		{"source unreachable", fetchErr(http.StatusBadGateway, fetcher.SpecializeStageFetch), true},
+		{"source unavailable", fetchErr(http.StatusServiceUnavailable, fetcher.SpecializeStageFetch), true},
+		{"source timed out", fetchErr(http.StatusGatewayTimeout, fetcher.SpecializeStageFetch), true},
+		{"package not found", fetchErr(http.StatusBadRequest, fetcher.SpecializeStageFetch), false},
+		{"fetcher internal error", fetchErr(http.StatusInternalServerError, fetcher.SpecializeStageFetch), false},
+		{"load failed", fetchErr(http.StatusBadGateway, fetcher.SpecializeStageLoad), false},
+		{"specialize timed out", errors.Wrap(context.DeadlineExceeded, "error specializing pod"), false},
+		{"fetcher unreachable", errors.New("connection refused"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gp := newTestPool(t)
+			defer gp.readyPodQueue.ShutDown()
+			b := makeFetchBreaker(gp.logger, gp.env, defaultPoolConfig())
+			b.threshold, b.cooldown = 1, time.Hour
+			defer b.deleteMetrics()
+
+			ticket, err := b.allow()
+			if err != nil {
+				t.Fatalf("breaker open before any request: %v", err)
+			}
+			b.record(context.Background(), ticket, tt.err)
+			b.release(ticket)
+			if _, err := b.allow(); (err != nil) != tt.wantOpen {
+				t.Errorf("breaker open = %v after %v, want %v", err != nil, tt.err, tt.wantOpen)
+			}
+		})
+	}
+}
+
+// TestFetchBreakerTrialOwner checks that specializations allowed before the
+// breaker opened don't end the trial when they finish while it runs.
+func TestFetchBreakerTrialOwner(t *testing.T) {
+	ctx := context.Background()
+	gp := newTestPool(t)
+	defer gp.readyPodQueue.ShutDown()
+	b := makeFetchBreaker(gp.logger, gp.env, defaultPoolConfig())
+	b.threshold, b.cooldown = 1, time.Hour
+	defer b.deleteMetrics()
+	unreachable := &fetcherClient.HTTPError{StatusCode: http.StatusBadGateway, Stage: fetcher.SpecializeStageFetch, Err: errors.New("failed")}
+
+	// allowed while the breaker is closed
+	early := make([]*fetchBreakerTicket, 3)
+	for i := range early {
+		ticket, err := b.allow()
+		if err != nil {
+			t.Fatalf("breaker open before any request: %v", err)
+		}
+		early[i] = ticket
+	}
+	b.record(ctx, early[0], unreachable)
+	b.release(early[0])
+
+	// the cooldown is over, one trial goes through
+	b.openedAt = time.Now().Add(-2 * time.Hour)
+	trial, err := b.allow()
+	if err != nil || !trial.trial {
+		t.Fatalf("got ticket %+v, %v after the cooldown, want the trial", trial, err)
+	}
+
+	// the early specializations finish without telling about the source
+	b.record(ctx, early[1], errors.New("connection refused"))
+	b.release(early[1])
+	b.release(early[2])
+	if _, err := b.allow(); !errors.Is(err, ErrControllerUnavailable) {
+		t.Fatalf("got %v while the trial runs, want %v", err, ErrControllerUnavailable)
+	}
+
+	// the trial ends without fetching, another one can try
+	b.record(ctx, trial, errors.New("connection refused"))
+	b.release(trial)
+	next, err := b.allow()
+	if err != nil || !next.trial {
+		t.Errorf("got ticket %+v, %v after the trial ended, want the next trial", next, err)
+	}
+}
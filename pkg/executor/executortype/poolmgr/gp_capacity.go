@@ -0,0 +1,178 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	apiv1 "k8s.io/api/core/v1"
+	k8s_err "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+// getEnvMaxPods returns the most pods the pool of env may have, generic and
+// specialized, 0 if the number isn't capped.
+func getEnvMaxPods(env *fv1.Environment) int32 {
+	value, err := strconv.ParseInt(env.ObjectMeta.Annotations[fv1.ANNOTATION_MAX_PODS], 10, 32)
+	if err != nil || value < 0 {
+		return 0
+	}
+	return int32(value)
+}
+
+// specializedPodCount returns the number of active specialized pods of the
+// pool, as seen by watchSpecializedPods.
+func (gp *GenericPool) specializedPodCount() int32 {
+	var count int32
+	for _, pod := range gp.listSpecializedPods() {
+		if IsPodActive(pod) {
+			count++
+		}
+	}
+	return count
+}
+
+// checkCapacity returns ErrPoolAtCapacity if specializing another pod would
+// take the pool past the max pods of its environment: the deployment
+// replaces the specialized pod with a new generic one. The pods being
+// specialized concurrently may not be counted yet, the resource quota of
+// the pool, if enabled, caps the pods strictly.
+func (gp *GenericPool) checkCapacity() error {
+	maxPods := getEnvMaxPods(gp.env)
+	if maxPods == 0 {
+		return nil
+	}
+	replicas := gp.minReplicas.Load()
+	if deployment := gp.deployment.Load(); deployment != nil && deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	specialized := gp.specializedPodCount()
+	if replicas+specialized >= maxPods {
+		return errors.Wrapf(ErrPoolAtCapacity, "%d specialized and %d generic pods of at most %d",
+			specialized, replicas, maxPods)
+	}
+	return nil
+}
+
+// replicaCeiling caps the replicas the pool scales to so that the generic
+// and specialized pods stay within the max pods of its environment. It
+// never goes below the pool size, new specializations fail instead.
+func (gp *GenericPool) replicaCeiling(target int32) int32 {
+	maxPods := getEnvMaxPods(gp.env)
+	if maxPods == 0 {
+		return target
+	}
+	ceiling := maxPods - gp.specializedPodCount()
+	if minReplicas := gp.minReplicas.Load(); ceiling < minReplicas {
+		ceiling = minReplicas
+	}
+	if target > ceiling {
+		return ceiling
+	}
+	return target
+}
+
+// applyResourceQuota caps the pods of the pool namespace at the max pods of
+// the environment with a resource quota named after the pool, if enabled
+// with POOLMGR_RESOURCE_QUOTA. The quota applies to every pod of the
+// namespace, so it's only applied to environments with a namespace of their
+// own, see ownsNamespace: in a shared namespace it would cap the pods of
+// other environments and functions too, only checkCapacity caps the pods
+// of the pool then. The quota is deleted once the environment no longer
+// caps its pods.
+func (gp *GenericPool) applyResourceQuota(ctx context.Context) error {
+	if !gp.resourceQuota {
+		return nil
+	}
+	maxPods := getEnvMaxPods(gp.env)
+	if maxPods == 0 {
+		return gp.deleteResourceQuota(ctx)
+	}
+	owns, err := gp.ownsNamespace(ctx)
+	if err != nil {
+		return err
+	}
+	if !owns {
+		gp.logger.Warn("not applying resource quota, the pool namespace is shared",
+			zap.String("env", gp.env.ObjectMeta.Name),
+			zap.String("namespace", gp.fnNamespace))
+		gp.recordEvent(apiv1.EventTypeWarning, "ResourceQuotaSkipped",
+			"Not applying resource quota: namespace %s is shared with other environments or functions", gp.fnNamespace)
+		return gp.deleteResourceQuota(ctx)
+	}
+	quotas := gp.kubernetesClient.CoreV1().ResourceQuotas(gp.fnNamespace)
+	name := gp.deployment.Load().ObjectMeta.Name
+
+	hard := apiv1.ResourceList{
+		apiv1.ResourcePods: *resource.NewQuantity(int64(maxPods), resource.DecimalSI),
+	}
+	quota, err := quotas.Get(ctx, name, metav1.GetOptions{})
+	if k8s_err.IsNotFound(err) {
+		_, err = quotas.Create(ctx, &apiv1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: gp.getEnvironmentPoolLabels(gp.env),
+			},
+			Spec: apiv1.ResourceQuotaSpec{Hard: hard},
+		}, metav1.CreateOptions{})
+		return errors.Wrapf(err, "error creating resource quota %s", name)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "error getting resource quota %s", name)
+	}
+	quota.Spec.Hard = hard
+	_, err = quotas.Update(ctx, quota, metav1.UpdateOptions{})
+	return errors.Wrapf(err, "error updating resource quota %s", name)
+}
+
+// ownsNamespace returns true if the pool namespace holds no environment
+// other than the pool's. Environments of the default namespace run their
+// pods in the shared function namespace.
+func (gp *GenericPool) ownsNamespace(ctx context.Context) (bool, error) {
+	if gp.fnNamespace != gp.env.ObjectMeta.Namespace {
+		return false, nil
+	}
+	envs, err := gp.fissionClient.CoreV1().Environments(gp.env.ObjectMeta.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return false, errors.Wrapf(err, "error listing environments of namespace %s", gp.env.ObjectMeta.Namespace)
+	}
+	for _, env := range envs.Items {
+		if env.ObjectMeta.UID != gp.env.ObjectMeta.UID {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// deleteResourceQuota deletes the resource quota of the pool, if any.
+func (gp *GenericPool) deleteResourceQuota(ctx context.Context) error {
+	if !gp.resourceQuota {
+		return nil
+	}
+	name := gp.deployment.Load().ObjectMeta.Name
+	err := gp.kubernetesClient.CoreV1().ResourceQuotas(gp.fnNamespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !k8s_err.IsNotFound(err) {
+		return errors.Wrapf(err, "error deleting resource quota %s", name)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	fClient "github.com/fission/fission/pkg/generated/clientset/versioned/fake"
+)
+
+func TestPoolCapacity(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	gp := newTestPool(t, newTestPod("ready", "10.0.0.1", true))
+	defer gp.readyPodQueue.ShutDown()
+	gp.env.ObjectMeta.Annotations = map[string]string{fv1.ANNOTATION_MAX_PODS: "5"}
+	gp.minReplicas.Store(2)
+	gp.maxReplicas = 10
+	replicas := int32(2)
+	depl := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "pool", Namespace: metav1.NamespaceDefault},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+	}
+	depl, err := gp.kubernetesClient.AppsV1().Deployments(depl.Namespace).Create(ctx, depl, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Error creating deployment: %v", err)
+	}
+	gp.deployment.Store(depl)
+	gp.specializedPods = newTestPodLister(t, newSpecializedTestPod("specialized-1", "10.0.0.2"),
+		newSpecializedTestPod("specialized-2", "10.0.0.2"))
+
+	// the autoscaler leaves room for the specialized pods
+	gp.starvedRequests.Add(5)
+	gp.doAutoscale(ctx)
+	if *gp.deployment.Load().Spec.Replicas != 3 {
+		t.Errorf("autoscaled to %d replicas, want 3", *gp.deployment.Load().Spec.Replicas)
+	}
+
+	fn := &fv1.Function{ObjectMeta: metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}}
+	_, err = gp.chooseAndSpecializePod(ctx, fn, gp.labelsForFunction(&fn.ObjectMeta))
+	if !errors.Is(err, ErrPoolAtCapacity) {
+		t.Errorf("specializing a pod at capacity returned %v, want %v", err, ErrPoolAtCapacity)
+	}
+
+	gp.resourceQuota = true
+	gp.fissionClient = fClient.NewSimpleClientset(gp.env)
+	err = gp.applyResourceQuota(ctx)
+	if err != nil {
+		t.Fatalf("Error applying resource quota: %v", err)
+	}
+	quota, err := gp.kubernetesClient.CoreV1().ResourceQuotas(gp.fnNamespace).Get(ctx, depl.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting resource quota: %v", err)
+	}
+	if pods := quota.Spec.Hard[apiv1.ResourcePods]; pods.Value() != 5 {
+		t.Errorf("resource quota allows %v pods, want 5", pods.String())
+	}
+
+	// the quota goes away once another environment shares the namespace
+	other := &fv1.Environment{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: metav1.NamespaceDefault, UID: "other-uid"}}
+	_, err = gp.fissionClient.CoreV1().Environments(other.Namespace).Create(ctx, other, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Error creating environment: %v", err)
+	}
+	err = gp.applyResourceQuota(ctx)
+	if err != nil {
+		t.Fatalf("Error applying resource quota: %v", err)
+	}
+	_, err = gp.kubernetesClient.CoreV1().ResourceQuotas(gp.fnNamespace).Get(ctx, depl.Name, metav1.GetOptions{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("getting resource quota of a shared namespace returned %v, want not found", err)
+	}
+	err = gp.fissionClient.CoreV1().Environments(other.Namespace).Delete(ctx, other.Name, metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatalf("Error deleting environment: %v", err)
+	}
+
+	// the pools of environments of the default namespace share the
+	// function namespace
+	fnNamespace := gp.fnNamespace
+	gp.fnNamespace = "fission-function"
+	err = gp.applyResourceQuota(ctx)
+	if err != nil {
+		t.Fatalf("Error applying resource quota: %v", err)
+	}
+	_, err = gp.kubernetesClient.CoreV1().ResourceQuotas(gp.fnNamespace).Get(ctx, depl.Name, metav1.GetOptions{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("getting resource quota of the function namespace returned %v, want not found", err)
+	}
+	gp.fnNamespace = fnNamespace
+	err = gp.applyResourceQuota(ctx)
+	if err != nil {
+		t.Fatalf("Error applying resource quota: %v", err)
+	}
+	_, err = gp.kubernetesClient.CoreV1().ResourceQuotas(gp.fnNamespace).Get(ctx, depl.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting resource quota: %v", err)
+	}
+
+	// the quota goes away with the max pods of the environment
+	gp.env.ObjectMeta.Annotations = nil
+	err = gp.applyResourceQuota(ctx)
+	if err != nil {
+		t.Fatalf("Error applying resource quota: %v", err)
+	}
+	_, err = gp.kubernetesClient.CoreV1().ResourceQuotas(gp.fnNamespace).Get(ctx, depl.Name, metav1.GetOptions{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("getting resource quota after removing max pods returned %v, want not found", err)
+	}
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+
+	fetcherClient "github.com/fission/fission/pkg/fetcher/client"
+	"github.com/fission/fission/pkg/utils"
+)
+
+// poolConfig is the configuration shared by the pools of the manager. It's
+// read from the POOLMGR_* environment variables once, by makePoolConfig.
+type poolConfig struct {
+	runtimeImagePullPolicy   apiv1.PullPolicy
+	maxReplicas              int32 // 0 disables autoscaling
+	lookAhead                int32
+	specializeMaxRetries     int
+	maxSpecializing          int // 0 doesn't bound concurrent specializations
+	deploymentStrategy       appsv1.DeploymentStrategy
+	scaleDownDelay           time.Duration
+	noReadyPodsThreshold     time.Duration
+	readyPodsPollInterval    time.Duration
+	generationHealthDeadline time.Duration
+	resourceQuota            bool
+	specializeURL            string
+	fetchBreakerThreshold    int // 0 disables the breaker
+	fetchBreakerCooldown     time.Duration
+	svcPolicy                functionSvcPolicy
+}
+
+// defaultPoolConfig returns the configuration of pools without any of the
+// environment variables set.
+func defaultPoolConfig() *poolConfig {
+	return &poolConfig{
+		runtimeImagePullPolicy:   utils.GetImagePullPolicy(""),
+		specializeMaxRetries:     fetcherClient.DefaultMaxRetries,
+		deploymentStrategy:       defaultDeploymentStrategy(),
+		scaleDownDelay:           defaultScaleDownDelay,
+		noReadyPodsThreshold:     defaultNoReadyPodsThreshold,
+		readyPodsPollInterval:    defaultReadyPodsPollInterval,
+		generationHealthDeadline: defaultGenerationHealthDeadline,
+		fetchBreakerThreshold:    defaultFetchBreakerThreshold,
+		fetchBreakerCooldown:     defaultFetchBreakerCooldown,
+		svcPolicy:                functionSvcPolicy{annotationPrefixes: defaultSvcAnnotationPrefixes},
+	}
+}
+
+// makePoolConfig reads the configuration of pools from the environment.
+// Invalid values are logged and replaced by the defaults.
+func makePoolConfig(logger *zap.Logger) *poolConfig {
+	cfg := defaultPoolConfig()
+	cfg.runtimeImagePullPolicy = utils.GetImagePullPolicy(os.Getenv("RUNTIME_IMAGE_PULL_POLICY"))
+
+	maxPoolsize, err := utils.GetUIntValueFromEnv("POOLMGR_MAX_POOLSIZE")
+	if err == nil {
+		cfg.maxReplicas = int32(maxPoolsize)
+	}
+	lookAhead, err := utils.GetUIntValueFromEnv("POOLMGR_LOOKAHEAD")
+	if err == nil {
+		cfg.lookAhead = int32(lookAhead)
+	}
+	specializeMaxRetries, err := utils.GetUIntValueFromEnv("POOLMGR_SPECIALIZE_MAX_RETRIES")
+	if err == nil && specializeMaxRetries > 0 {
+		cfg.specializeMaxRetries = int(specializeMaxRetries)
+	}
+	maxSpecializing, err := utils.GetUIntValueFromEnv("POOLMGR_MAX_CONCURRENT_SPECIALIZATIONS")
+	if err == nil {
+		cfg.maxSpecializing = int(maxSpecializing)
+	}
+	cfg.deploymentStrategy, err = getDeploymentStrategy()
+	if err != nil {
+		logger.Error("failed to parse the pool deployment strategy - set to the default value",
+			zap.Error(err))
+	}
+	cfg.scaleDownDelay = durationFromEnv(logger, "POOLMGR_SCALE_DOWN_DELAY", cfg.scaleDownDelay, nil)
+	cfg.noReadyPodsThreshold = durationFromEnv(logger, "POOLMGR_NO_READY_PODS_THRESHOLD", cfg.noReadyPodsThreshold, nil)
+	cfg.readyPodsPollInterval = durationFromEnv(logger, "POOLMGR_READY_PODS_POLL_INTERVAL", cfg.readyPodsPollInterval, positiveDuration)
+	cfg.generationHealthDeadline = durationFromEnv(logger, "POOLMGR_GENERATION_HEALTH_DEADLINE", cfg.generationHealthDeadline, nonNegativeDuration)
+	if resourceQuotaStr := os.Getenv("POOLMGR_RESOURCE_QUOTA"); len(resourceQuotaStr) > 0 {
+		resourceQuota, err := strconv.ParseBool(resourceQuotaStr)
+		if err != nil {
+			logger.Error("failed to parse 'POOLMGR_RESOURCE_QUOTA' - resource quotas are not created",
+				zap.Error(err),
+				zap.String("value", resourceQuotaStr))
+		}
+		cfg.resourceQuota = resourceQuota
+	}
+	if specializeURL := os.Getenv("POOLMGR_SPECIALIZE_URL"); len(specializeURL) > 0 {
+		err := validateSpecializeURL(specializeURL)
+		if err != nil {
+			logger.Error("failed to parse specialize url from 'POOLMGR_SPECIALIZE_URL' - sending specialize requests to the pod",
+				zap.Error(err),
+				zap.String("value", specializeURL))
+		} else {
+			cfg.specializeURL = specializeURL
+		}
+	}
+	if len(os.Getenv("POOLMGR_FETCH_BREAKER_THRESHOLD")) > 0 {
+		value, err := utils.GetUIntValueFromEnv("POOLMGR_FETCH_BREAKER_THRESHOLD")
+		if err != nil {
+			logger.Error("failed to parse 'POOLMGR_FETCH_BREAKER_THRESHOLD' - set to the default value",
+				zap.Error(err), zap.Int("default", cfg.fetchBreakerThreshold))
+		} else {
+			cfg.fetchBreakerThreshold = int(value)
+		}
+	}
+	cfg.fetchBreakerCooldown = durationFromEnv(logger, "POOLMGR_FETCH_BREAKER_COOLDOWN", cfg.fetchBreakerCooldown, positiveDuration)
+	cfg.svcPolicy = makeFunctionSvcPolicy(logger)
+	return cfg
+}
+
+// durationFromEnv returns the duration the environment variable is set to,
+// or def if it's unset. A value which doesn't parse or which validate, if
+// not nil, rejects is logged and def returned.
+func durationFromEnv(logger *zap.Logger, name string, def time.Duration, validate func(time.Duration) error) time.Duration {
+	value := os.Getenv(name)
+	if len(value) == 0 {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err == nil && validate != nil {
+		err = validate(d)
+	}
+	if err != nil {
+		logger.Error("failed to parse '"+name+"' - set to the default value",
+			zap.Error(err),
+			zap.String("value", value),
+			zap.Duration("default", def))
+		return def
+	}
+	return d
+}
+
+func positiveDuration(d time.Duration) error {
+	if d <= 0 {
+		return errors.New("must be positive")
+	}
+	return nil
+}
+
+func nonNegativeDuration(d time.Duration) error {
+	if d < 0 {
+		return errors.New("must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestDurationFromEnv(t *testing.T) {
+	for _, tt := range []struct {
+		value    string
+		validate func(time.Duration) error
+		want     time.Duration
+	}{
+		{"", nil, time.Minute},
+		{"1s", nil, time.Second},
+		{"0s", nil, 0},
+		{"0s", positiveDuration, time.Minute},
+		{"-1s", nonNegativeDuration, time.Minute},
+		{"soon", nil, time.Minute},
+	} {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("POOLMGR_TEST_DURATION", tt.value)
+			if got := durationFromEnv(zap.NewNop(), "POOLMGR_TEST_DURATION", time.Minute, tt.validate); got != tt.want {
+				t.Errorf("durationFromEnv() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestMakePoolConfig checks that pools get the configuration read by the
+// manager rather than the environment at the time they're created.
+func TestMakePoolConfig(t *testing.T) {
+	t.Setenv("POOLMGR_MAX_CONCURRENT_SPECIALIZATIONS", "2")
+	t.Setenv("POOLMGR_FETCH_BREAKER_THRESHOLD", "0")
+	t.Setenv("POOLMGR_SCALE_DOWN_DELAY", "1m")
+	cfg := makePoolConfig(zap.NewNop())
+	t.Setenv("POOLMGR_SCALE_DOWN_DELAY", "1h")
+
+	gp := newTestPool(t)
+	defer gp.readyPodQueue.ShutDown()
+	pool, err := MakeGenericPool(gp.logger, nil, gp.kubernetesClient, nil, gp.env,
+		gp.fnNamespace, nil, nil, "test", false, 0, nil, nil, cfg)
+	if err != nil {
+		t.Fatalf("Error creating pool: %v", err)
+	}
+	if pool.scaleDownDelay != time.Minute {
+		t.Errorf("scale down delay = %v, want %v", pool.scaleDownDelay, time.Minute)
+	}
+	if cap(pool.specializeSlots) != 2 {
+		t.Errorf("got %d specialize slots, want 2", cap(pool.specializeSlots))
+	}
+	if pool.fetchBreaker != nil {
+		t.Error("fetch breaker enabled with a threshold of 0")
+	}
+}
@@ -19,18 +19,106 @@ package poolmgr
 import (
 	"context"
 	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
 	"strings"
 
 	"go.uber.org/zap"
 	appsv1 "k8s.io/api/apps/v1"
 	apiv1 "k8s.io/api/core/v1"
 	k8sErrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
 
 	fv1 "github.com/fission/fission/pkg/apis/core/v1"
 	"github.com/fission/fission/pkg/executor/util"
+	"github.com/fission/fission/pkg/utils/maps"
 )
 
+// defaultRuntimeResources are requested for the runtime container of
+// environments without resources, so that generic pods don't get
+// BestEffort QoS and are not the first to be evicted under node pressure.
+var defaultRuntimeResources = apiv1.ResourceRequirements{
+	Requests: apiv1.ResourceList{
+		apiv1.ResourceCPU:    resource.MustParse("10m"),
+		apiv1.ResourceMemory: resource.MustParse("32Mi"),
+	},
+}
+
+// defaultDeploymentStrategy rolls out changes of the pool deployment without
+// taking a pod down before its replacement is ready, so that the pool
+// doesn't drop below its size while the runtime image is rolled.
+func defaultDeploymentStrategy() appsv1.DeploymentStrategy {
+	maxSurge := intstr.FromString("25%")
+	maxUnavailable := intstr.FromInt(0)
+	return appsv1.DeploymentStrategy{
+		Type: appsv1.RollingUpdateDeploymentStrategyType,
+		RollingUpdate: &appsv1.RollingUpdateDeployment{
+			MaxSurge:       &maxSurge,
+			MaxUnavailable: &maxUnavailable,
+		},
+	}
+}
+
+// getDeploymentStrategy returns the strategy of the pool deployment set with
+// POOLMGR_DEPLOYMENT_STRATEGY, POOLMGR_DEPLOYMENT_MAX_SURGE and
+// POOLMGR_DEPLOYMENT_MAX_UNAVAILABLE, see defaultDeploymentStrategy.
+func getDeploymentStrategy() (appsv1.DeploymentStrategy, error) {
+	strategy := defaultDeploymentStrategy()
+	switch strategyType := appsv1.DeploymentStrategyType(os.Getenv("POOLMGR_DEPLOYMENT_STRATEGY")); strategyType {
+	case appsv1.RecreateDeploymentStrategyType:
+		return appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType}, nil
+	case appsv1.RollingUpdateDeploymentStrategyType, "":
+	default:
+		return strategy, fmt.Errorf("unknown deployment strategy %q, must be RollingUpdate or Recreate", strategyType)
+	}
+
+	maxSurge, maxUnavailable := *strategy.RollingUpdate.MaxSurge, *strategy.RollingUpdate.MaxUnavailable
+	if value := os.Getenv("POOLMGR_DEPLOYMENT_MAX_SURGE"); len(value) > 0 {
+		maxSurge = intstr.Parse(value)
+	}
+	if value := os.Getenv("POOLMGR_DEPLOYMENT_MAX_UNAVAILABLE"); len(value) > 0 {
+		maxUnavailable = intstr.Parse(value)
+	}
+	if isZeroIntOrPercent(maxSurge) && isZeroIntOrPercent(maxUnavailable) {
+		return defaultDeploymentStrategy(), fmt.Errorf("max surge and max unavailable of the deployment strategy can't both be 0")
+	}
+	strategy.RollingUpdate.MaxSurge, strategy.RollingUpdate.MaxUnavailable = &maxSurge, &maxUnavailable
+	return strategy, nil
+}
+
+func isZeroIntOrPercent(value intstr.IntOrString) bool {
+	return value.String() == "0" || value.String() == "0%"
+}
+
+// getRuntimeResources returns the resources of the environment runtime
+// container, falling back to defaultRuntimeResources.
+func getRuntimeResources(env *fv1.Environment) apiv1.ResourceRequirements {
+	if len(env.Spec.Resources.Requests) == 0 && len(env.Spec.Resources.Limits) == 0 {
+		return *defaultRuntimeResources.DeepCopy()
+	}
+	return env.Spec.Resources
+}
+
+// runtimeProbe returns a probe checking the runtime server accepts
+// connections on the specialize port. Runtimes don't share a health
+// endpoint before specialization, so the probe doesn't use HTTP.
+func runtimeProbe(port int32, periodSeconds int32, failureThreshold int32) *apiv1.Probe {
+	return &apiv1.Probe{
+		InitialDelaySeconds: 1,
+		PeriodSeconds:       periodSeconds,
+		FailureThreshold:    failureThreshold,
+		ProbeHandler: apiv1.ProbeHandler{
+			TCPSocket: &apiv1.TCPSocketAction{
+				Port: intstr.FromInt(int(port)),
+			},
+		},
+	}
+}
+
 // getPoolName returns a unique name of an environment
 func getPoolName(env *fv1.Environment) string {
 	// TODO: get rid of resource version here
@@ -52,7 +140,18 @@ func getPoolName(env *fv1.Environment) string {
 		envPodName = env.ObjectMeta.Name[:nameLength] + "-" + env.ObjectMeta.Namespace[:namespaceLength]
 	}
 
-	return "poolmgr-" + strings.ToLower(fmt.Sprintf("%s-%s", envPodName, env.ResourceVersion))
+	name := "poolmgr-" + strings.ToLower(fmt.Sprintf("%s-%s", envPodName, env.ResourceVersion))
+	// The resource version keeps truncated names apart. Only resource
+	// versions of more than 17 characters make the name too long, it's then
+	// cut and told apart by a hash of the full name instead.
+	if len(name) > validation.DNS1123LabelMaxLength {
+		h := fnv.New32a()
+		h.Write([]byte(name))
+		suffix := fmt.Sprintf("-%08x", h.Sum32())
+		name = strings.TrimRight(name[:validation.DNS1123LabelMaxLength-len(suffix)], "-.") + suffix
+	}
+	// environments not read from the API server have no resource version
+	return strings.TrimRight(name, "-")
 }
 
 func (gp *GenericPool) genDeploymentMeta(env *fv1.Environment) metav1.ObjectMeta {
@@ -88,21 +187,22 @@ func (gp *GenericPool) genDeploymentSpec(env *fv1.Environment) (*appsv1.Deployme
 		podAnnotations["sidecar.istio.io/inject"] = "false"
 	}
 
-	podLabels := env.ObjectMeta.Labels
-	if podLabels == nil {
-		podLabels = make(map[string]string)
-	}
-
+	podLabels := maps.CopyStringMap(env.ObjectMeta.Labels)
 	for k, v := range deployLabels {
 		podLabels[k] = v
 	}
+	// Not part of the selector: pods of the previous environment spec keep
+	// matching it while the deployment rolls, see isCurrentGenerationPod.
+	podLabels[fv1.ENVIRONMENT_GENERATION] = strconv.FormatInt(env.ObjectMeta.Generation, 10)
 
+	// Command and Args are left to the environment container, the image
+	// entrypoint runs unless it sets them.
 	container, err := util.MergeContainer(&apiv1.Container{
 		Name:                   env.ObjectMeta.Name,
 		Image:                  env.Spec.Runtime.Image,
 		ImagePullPolicy:        gp.runtimeImagePullPolicy,
 		TerminationMessagePath: "/dev/termination-log",
-		Resources:              env.Spec.Resources,
+		Resources:              getRuntimeResources(env),
 		// Pod is removed from endpoints list for service when it's
 		// state became "Termination". We used preStop hook as the
 		// workaround for connection draining since pod maybe shutdown
@@ -123,17 +223,25 @@ func (gp *GenericPool) genDeploymentSpec(env *fv1.Environment) (*appsv1.Deployme
 		Ports: []apiv1.ContainerPort{
 			{
 				Name:          "http-fetcher",
-				ContainerPort: int32(8000),
+				ContainerPort: gp.fetcherPort,
 			},
 			{
 				Name:          "http-env",
-				ContainerPort: int32(8888),
+				ContainerPort: gp.runtimePort,
 			},
 		},
 	}, env.Spec.Runtime.Container)
 	if err != nil {
 		return nil, err
 	}
+	// Probes set in the environment container take precedence, merging
+	// them would leave the probe with more than one handler.
+	if container.ReadinessProbe == nil {
+		container.ReadinessProbe = runtimeProbe(gp.runtimePort, 1, 30)
+	}
+	if container.LivenessProbe == nil {
+		container.LivenessProbe = runtimeProbe(gp.runtimePort, 10, 6)
+	}
 
 	pod := apiv1.PodTemplateSpec{
 		ObjectMeta: metav1.ObjectMeta{
@@ -141,7 +249,10 @@ func (gp *GenericPool) genDeploymentSpec(env *fv1.Environment) (*appsv1.Deployme
 			Annotations: podAnnotations,
 		},
 		Spec: apiv1.PodSpec{
-			Containers:         []apiv1.Container{*container},
+			Containers: []apiv1.Container{*container},
+			// Environments may run their pool with another service account
+			// through Runtime.PodSpec, it must let the fetcher read the
+			// secrets and configmaps of functions.
 			ServiceAccountName: fv1.FissionFetcherSA,
 			// TerminationGracePeriodSeconds should be equal to the
 			// sleep time of preStop to make sure that SIGTERM is sent
@@ -175,6 +286,7 @@ func (gp *GenericPool) genDeploymentSpec(env *fv1.Environment) (*appsv1.Deployme
 			MatchLabels: deployLabels,
 		},
 		Template: pod,
+		Strategy: *gp.deploymentStrategy.DeepCopy(),
 	}
 
 	// Order of merging is important here - first fetcher, then containers and lastly pod spec
@@ -205,6 +317,7 @@ func (gp *GenericPool) createPoolDeployment(ctx context.Context, env *fv1.Enviro
 		ObjectMeta: deploymentMeta,
 		Spec:       *deploymentSpec,
 	}
+	gp.minReplicas.Store(*deploymentSpec.Replicas)
 	depl, err := gp.kubernetesClient.AppsV1().Deployments(gp.fnNamespace).Get(ctx, deployment.Name, metav1.GetOptions{})
 	if err == nil {
 		if depl.Annotations[fv1.EXECUTOR_INSTANCEID_LABEL] != gp.instanceID {
@@ -213,7 +326,7 @@ func (gp *GenericPool) createPoolDeployment(ctx context.Context, env *fv1.Enviro
 			// rolling update if spec is different from the one in the cluster.
 			depl, err = gp.kubernetesClient.AppsV1().Deployments(gp.fnNamespace).Update(ctx, deployment, metav1.UpdateOptions{})
 		}
-		gp.deployment = depl
+		gp.deployment.Store(depl)
 		return err
 	} else if !k8sErrs.IsNotFound(err) {
 		gp.logger.Error("error getting deployment in kubernetes", zap.Error(err), zap.String("deployment", deployment.Name))
@@ -221,24 +334,67 @@ func (gp *GenericPool) createPoolDeployment(ctx context.Context, env *fv1.Enviro
 	}
 
 	depl, err = gp.kubernetesClient.AppsV1().Deployments(gp.fnNamespace).Create(ctx, deployment, metav1.CreateOptions{})
+	if k8sErrs.IsAlreadyExists(err) {
+		// The deployment was created since we looked it up, e.g. by another
+		// executor instance. It's usable as is, so adopt it.
+		depl, err = gp.kubernetesClient.AppsV1().Deployments(gp.fnNamespace).Get(ctx, deployment.Name, metav1.GetOptions{})
+		if err != nil {
+			gp.logger.Error("error getting existing deployment in kubernetes", zap.Error(err), zap.String("deployment", deployment.Name))
+			return err
+		}
+		gp.deployment.Store(depl)
+		gp.logger.Info("adopted existing deployment", zap.String("deployment", depl.Name), zap.String("ns", depl.Namespace))
+		return nil
+	}
 	if err != nil {
 		gp.logger.Error("error creating deployment in kubernetes", zap.Error(err), zap.String("deployment", deployment.Name))
 		return err
 	}
 
-	gp.deployment = depl
+	gp.deployment.Store(depl)
 	gp.logger.Info("deployment created", zap.String("deployment", depl.Name), zap.String("ns", depl.Namespace), zap.Any("environment", env))
 
 	return nil
 }
 
+// refreshDeployment replaces the cached pool deployment with the one in the
+// cluster, so that changes made behind the pool's back, e.g. scaling it with
+// kubectl, are taken into account.
+func (gp *GenericPool) refreshDeployment(ctx context.Context) error {
+	depl, err := gp.kubernetesClient.AppsV1().Deployments(gp.fnNamespace).Get(ctx, gp.deployment.Load().ObjectMeta.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	gp.deployment.Store(depl)
+	return nil
+}
+
+// updatePoolDeployment rolls the pool to the new environment spec. The
+// deployment is updated in place and Kubernetes replaces the generic pods
+// with a rolling update. Specialized pods were relabeled out of the
+// deployment selector when chosen, so they are not part of the rollout:
+// they keep serving their functions on the previous spec and drain away as
+// the idle pod reaper deletes them, while new specializations use pods of
+// the new spec. If the new spec doesn't turn ready, the pool falls back to
+// the generic pods of the previous one, see trackRollout. The replicas the
+// autoscaler set are kept as long as they're within the new pool size and
+// the max replicas.
+//
+// The pool keeps a single deployment for all generations of its pods, told
+// apart by their ENVIRONMENT_GENERATION label, rather than a deployment per
+// generation. The rolling update already runs both generations side by side
+// and deletes the drained generic pods, and the ready pod informer, the
+// autoscaler and the adopted pod selector all follow a single deployment.
 func (gp *GenericPool) updatePoolDeployment(ctx context.Context, env *fv1.Environment) error {
 	logger := gp.logger.With(zap.String("env", env.Name), zap.String("namespace", env.Namespace))
 	if gp.env.ObjectMeta.ResourceVersion == env.ObjectMeta.ResourceVersion {
 		logger.Debug("env resource version matching with pool env")
 		return nil
 	}
-	newDeployment := gp.deployment.DeepCopy()
+	gp.deploymentLock.Lock()
+	defer gp.deploymentLock.Unlock()
+	deployment := gp.deployment.Load()
+	newDeployment := deployment.DeepCopy()
 	spec, err := gp.genDeploymentSpec(env)
 	if err != nil {
 		logger.Error("error generating deployment spec", zap.Error(err))
@@ -246,7 +402,7 @@ func (gp *GenericPool) updatePoolDeployment(ctx context.Context, env *fv1.Enviro
 	}
 	newDeployment.Spec = *spec
 	deployMeta := gp.genDeploymentMeta(env)
-	deployMeta.Name = gp.deployment.Name
+	deployMeta.Name = deployment.Name
 	newDeployment.ObjectMeta = deployMeta
 
 	poolsize := getEnvPoolSize(env)
@@ -254,18 +410,30 @@ func (gp *GenericPool) updatePoolDeployment(ctx context.Context, env *fv1.Enviro
 	case fv1.AllowedFunctionsPerContainerInfinite:
 		poolsize = 1
 	}
-	newDeployment.Spec.Replicas = &poolsize
+	replicas := poolsize
+	if current := deployment.Spec.Replicas; current != nil && *current > poolsize && *current <= gp.maxReplicas &&
+		env.Spec.AllowedFunctionsPerContainer != fv1.AllowedFunctionsPerContainerInfinite {
+		replicas = *current
+	}
+	newDeployment.Spec.Replicas = &replicas
 
 	depl, err := gp.kubernetesClient.AppsV1().Deployments(gp.fnNamespace).Update(ctx, newDeployment, metav1.UpdateOptions{})
 	if err != nil {
-		logger.Error("error updating deployment in kubernetes", zap.Error(err), zap.String("deployment", depl.Name))
+		logger.Error("error updating deployment in kubernetes", zap.Error(err), zap.String("deployment", newDeployment.Name))
 		return err
 	}
 	// possible concurrency issue here as
-	// gp.env and gp.deployment referenced at few places
+	// gp.env referenced at few places
 	// we can move update pool to gpm.service if required
+	previous := deployment.Spec.Template.Labels[fv1.ENVIRONMENT_GENERATION]
 	gp.env = env
-	gp.deployment = depl
+	gp.deployment.Store(depl)
+	gp.minReplicas.Store(poolsize)
+	gp.trackRollout(previous, depl.Spec.Template.Labels[fv1.ENVIRONMENT_GENERATION])
+	err = gp.applyResourceQuota(ctx)
+	if err != nil {
+		logger.Error("error applying resource quota of pool", zap.Error(err))
+	}
 	logger.Info("Updated deployment for pool", zap.String("deployment", depl.Name))
 	return nil
 }
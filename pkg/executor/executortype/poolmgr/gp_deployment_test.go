@@ -17,12 +17,27 @@ limitations under the License.
 package poolmgr
 
 import (
+	"context"
 	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	k8sErrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 
 	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	fetcherConfig "github.com/fission/fission/pkg/fetcher/config"
+	"github.com/fission/fission/pkg/utils/loggerfactory"
 )
 
 func TestGetPoolName(t *testing.T) {
@@ -72,3 +87,814 @@ func TestGetPoolName(t *testing.T) {
 		})
 	}
 }
+
+func TestGetPoolNameValid(t *testing.T) {
+	env := func(name, namespace, resourceVersion string) *fv1.Environment {
+		return &fv1.Environment{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, ResourceVersion: resourceVersion}}
+	}
+	long := strings.Repeat("environment-", 22)[:253]
+	longVersion := strings.Repeat("9", 40)
+	tests := []struct {
+		name string
+		env  *fv1.Environment
+	}{
+		{"long names", env(long, long, "2518")},
+		{"long resource version", env(long, long, longVersion)},
+		{"no resource version", env("test", "testns", "")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := getPoolName(tt.env)
+			if errs := validation.IsDNS1123Label(got); len(errs) > 0 {
+				t.Errorf("getPoolName() = %s, not a valid name: %v", got, errs)
+			}
+			if again := getPoolName(tt.env); again != got {
+				t.Errorf("getPoolName() = %s then %s, want the same name", got, again)
+			}
+		})
+	}
+
+	// names cut within the resource version are told apart by a hash
+	a := getPoolName(env(long, long, longVersion))
+	b := getPoolName(env(long, long, longVersion+"1"))
+	if a == b {
+		t.Errorf("getPoolName() = %s for different environments, want different names", a)
+	}
+}
+
+// newDeploymentTestPool returns a pool able to generate deployment specs for env.
+func newDeploymentTestPool(t *testing.T, env *fv1.Environment) *GenericPool {
+	t.Helper()
+	fetcherCfg, err := fetcherConfig.MakeFetcherConfig("/userfunc")
+	if err != nil {
+		t.Fatalf("Error creating fetcher config: %v", err)
+	}
+	gp, err := MakeGenericPool(loggerfactory.GetLogger(), nil, fake.NewSimpleClientset(), nil, env,
+		metav1.NamespaceDefault, nil, fetcherCfg, "test", false, 0, nil, nil, makePoolConfig(loggerfactory.GetLogger()))
+	if err != nil {
+		t.Fatalf("Error creating pool: %v", err)
+	}
+	return gp
+}
+
+func newDeploymentTestEnv(resources apiv1.ResourceRequirements) *fv1.Environment {
+	return &fv1.Environment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: metav1.NamespaceDefault,
+			UID:       "env-uid",
+		},
+		Spec: fv1.EnvironmentSpec{
+			Version:   2,
+			Runtime:   fv1.Runtime{Image: "fission/test-env"},
+			Resources: resources,
+		},
+	}
+}
+
+func TestGenDeploymentSpecResources(t *testing.T) {
+	custom := apiv1.ResourceRequirements{
+		Requests: apiv1.ResourceList{
+			apiv1.ResourceCPU:    resource.MustParse("100m"),
+			apiv1.ResourceMemory: resource.MustParse("128Mi"),
+		},
+		Limits: apiv1.ResourceList{
+			apiv1.ResourceCPU:    resource.MustParse("500m"),
+			apiv1.ResourceMemory: resource.MustParse("256Mi"),
+		},
+	}
+
+	tests := []struct {
+		name      string
+		resources apiv1.ResourceRequirements
+		want      apiv1.ResourceRequirements
+	}{
+		{"defaults without environment resources", apiv1.ResourceRequirements{}, defaultRuntimeResources},
+		{"environment resources", custom, custom},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newDeploymentTestEnv(tt.resources)
+			spec, err := newDeploymentTestPool(t, env).genDeploymentSpec(env)
+			if err != nil {
+				t.Fatalf("Error generating deployment spec: %v", err)
+			}
+			var container *apiv1.Container
+			for i := range spec.Template.Spec.Containers {
+				if spec.Template.Spec.Containers[i].Name == env.ObjectMeta.Name {
+					container = &spec.Template.Spec.Containers[i]
+				}
+			}
+			if container == nil {
+				t.Fatalf("runtime container %q not found", env.ObjectMeta.Name)
+			}
+			for name, want := range tt.want.Requests {
+				if got := container.Resources.Requests[name]; got.Cmp(want) != 0 {
+					t.Errorf("request %s = %s, want %s", name, got.String(), want.String())
+				}
+			}
+			for name, want := range tt.want.Limits {
+				if got := container.Resources.Limits[name]; got.Cmp(want) != 0 {
+					t.Errorf("limit %s = %s, want %s", name, got.String(), want.String())
+				}
+			}
+		})
+	}
+}
+
+func TestGenDeploymentSpecProbes(t *testing.T) {
+	userProbe := &apiv1.Probe{
+		ProbeHandler: apiv1.ProbeHandler{
+			HTTPGet: &apiv1.HTTPGetAction{Path: "/healthz", Port: intstr.FromInt(8888)},
+		},
+	}
+
+	env := newDeploymentTestEnv(apiv1.ResourceRequirements{})
+	env.Spec.Runtime.Container = &apiv1.Container{ReadinessProbe: userProbe}
+	spec, err := newDeploymentTestPool(t, env).genDeploymentSpec(env)
+	if err != nil {
+		t.Fatalf("Error generating deployment spec: %v", err)
+	}
+	container := spec.Template.Spec.Containers[0]
+	if container.Name != env.ObjectMeta.Name {
+		t.Fatalf("first container = %q, want runtime container %q", container.Name, env.ObjectMeta.Name)
+	}
+
+	if container.ReadinessProbe == nil || container.ReadinessProbe.HTTPGet == nil || container.ReadinessProbe.TCPSocket != nil {
+		t.Errorf("readiness probe = %+v, want the environment probe", container.ReadinessProbe)
+	}
+	if container.LivenessProbe == nil || container.LivenessProbe.TCPSocket == nil ||
+		container.LivenessProbe.TCPSocket.Port.IntValue() != 8888 {
+		t.Errorf("liveness probe = %+v, want tcp probe on port 8888", container.LivenessProbe)
+	}
+}
+
+func TestGenDeploymentSpecFetcherImageAndPorts(t *testing.T) {
+	t.Setenv("FETCHER_IMAGE", "registry.local/fission/fetcher")
+	t.Setenv("FETCHER_PORT", "9000")
+	t.Setenv("RUNTIME_PORT", "9999")
+
+	env := newDeploymentTestEnv(apiv1.ResourceRequirements{})
+	gp := newDeploymentTestPool(t, env)
+	spec, err := gp.genDeploymentSpec(env)
+	if err != nil {
+		t.Fatalf("Error generating deployment spec: %v", err)
+	}
+
+	containers := make(map[string]apiv1.Container)
+	for _, c := range spec.Template.Spec.Containers {
+		containers[c.Name] = c
+	}
+	fetcher, ok := containers["fetcher"]
+	if !ok {
+		t.Fatal("fetcher container not found")
+	}
+	if fetcher.Image != "registry.local/fission/fetcher" {
+		t.Errorf("fetcher image = %q, want %q", fetcher.Image, "registry.local/fission/fetcher")
+	}
+	if fetcher.ReadinessProbe.HTTPGet.Port.IntValue() != 9000 {
+		t.Errorf("fetcher readiness probe port = %v, want 9000", fetcher.ReadinessProbe.HTTPGet.Port.IntValue())
+	}
+	command := strings.Join(fetcher.Command, " ")
+	if !strings.Contains(command, "-port 9000") || !strings.Contains(command, "-runtime-port 9999") {
+		t.Errorf("fetcher command = %q, want custom ports", command)
+	}
+
+	runtime := containers[env.ObjectMeta.Name]
+	ports := make(map[string]int32)
+	for _, p := range runtime.Ports {
+		ports[p.Name] = p.ContainerPort
+	}
+	if ports["http-fetcher"] != 9000 || ports["http-env"] != 9999 {
+		t.Errorf("runtime container ports = %v, want fetcher 9000 and env 9999", ports)
+	}
+	if runtime.ReadinessProbe.TCPSocket.Port.IntValue() != 9999 {
+		t.Errorf("runtime readiness probe port = %v, want 9999", runtime.ReadinessProbe.TCPSocket.Port.IntValue())
+	}
+
+	if got, want := gp.getFetcherURL("10.0.0.1"), "http://10.0.0.1:9000/"; got != want {
+		t.Errorf("fetcher url = %q, want %q", got, want)
+	}
+	if got, want := gp.getFetcherURL("fd00::1"), "http://[fd00::1]:9000/"; got != want {
+		t.Errorf("fetcher url = %q, want %q", got, want)
+	}
+}
+
+func TestGenDeploymentSpecScheduling(t *testing.T) {
+	nodeSelector := map[string]string{"accelerator": "nvidia"}
+	affinity := &apiv1.Affinity{
+		NodeAffinity: &apiv1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &apiv1.NodeSelector{
+				NodeSelectorTerms: []apiv1.NodeSelectorTerm{{
+					MatchExpressions: []apiv1.NodeSelectorRequirement{{
+						Key:      "topology.kubernetes.io/region",
+						Operator: apiv1.NodeSelectorOpIn,
+						Values:   []string{"eu-west-1"},
+					}},
+				}},
+			},
+		},
+	}
+	tolerations := []apiv1.Toleration{{
+		Key:      "nvidia.com/gpu",
+		Operator: apiv1.TolerationOpExists,
+		Effect:   apiv1.TaintEffectNoSchedule,
+	}}
+
+	env := newDeploymentTestEnv(apiv1.ResourceRequirements{})
+	env.Spec.Runtime.PodSpec = &apiv1.PodSpec{
+		NodeSelector: nodeSelector,
+		Affinity:     affinity,
+		Tolerations:  tolerations,
+	}
+	spec, err := newDeploymentTestPool(t, env).genDeploymentSpec(env)
+	if err != nil {
+		t.Fatalf("Error generating deployment spec: %v", err)
+	}
+
+	podSpec := spec.Template.Spec
+	if !reflect.DeepEqual(podSpec.NodeSelector, nodeSelector) {
+		t.Errorf("node selector = %v, want %v", podSpec.NodeSelector, nodeSelector)
+	}
+	if !reflect.DeepEqual(podSpec.Affinity, affinity) {
+		t.Errorf("affinity = %+v, want %+v", podSpec.Affinity, affinity)
+	}
+	if !reflect.DeepEqual(podSpec.Tolerations, tolerations) {
+		t.Errorf("tolerations = %+v, want %+v", podSpec.Tolerations, tolerations)
+	}
+
+	env.Spec.Runtime.PodSpec = nil
+	spec, err = newDeploymentTestPool(t, env).genDeploymentSpec(env)
+	if err != nil {
+		t.Fatalf("Error generating deployment spec: %v", err)
+	}
+	if spec.Template.Spec.NodeSelector != nil || spec.Template.Spec.Affinity != nil || spec.Template.Spec.Tolerations != nil {
+		t.Errorf("pod spec carries scheduling constraints without environment pod spec: %+v", spec.Template.Spec)
+	}
+}
+
+// TestGenDeploymentSpecPodTemplate inspects the pool deployment generated for
+// an environment, no API server is involved until createPoolDeployment.
+func TestGenDeploymentSpecPodTemplate(t *testing.T) {
+	env := newDeploymentTestEnv(apiv1.ResourceRequirements{})
+	gp := newDeploymentTestPool(t, env)
+	meta := gp.genDeploymentMeta(env)
+	spec, err := gp.genDeploymentSpec(env)
+	if err != nil {
+		t.Fatalf("Error generating deployment spec: %v", err)
+	}
+
+	poolLabels := gp.getEnvironmentPoolLabels(env)
+	if !reflect.DeepEqual(meta.Labels, poolLabels) {
+		t.Errorf("deployment labels = %v, want %v", meta.Labels, poolLabels)
+	}
+	if !reflect.DeepEqual(spec.Selector.MatchLabels, poolLabels) {
+		t.Errorf("deployment selector = %v, want %v", spec.Selector.MatchLabels, poolLabels)
+	}
+	for k, v := range poolLabels {
+		if spec.Template.ObjectMeta.Labels[k] != v {
+			t.Errorf("pod template label %q = %q, want %q", k, spec.Template.ObjectMeta.Labels[k], v)
+		}
+	}
+
+	var volumes []string
+	for _, v := range spec.Template.Spec.Volumes {
+		volumes = append(volumes, v.Name)
+	}
+	wantVolumes := []string{fv1.SharedVolumeUserfunc, fv1.SharedVolumeSecrets, fv1.SharedVolumeConfigmaps, fv1.PodInfoVolume}
+	if !reflect.DeepEqual(volumes, wantVolumes) {
+		t.Errorf("pod volumes = %v, want %v", volumes, wantVolumes)
+	}
+
+	var containers []string
+	for _, c := range spec.Template.Spec.Containers {
+		containers = append(containers, c.Name)
+		mounted := false
+		for _, m := range c.VolumeMounts {
+			if m.Name == fv1.SharedVolumeUserfunc && m.MountPath == "/userfunc" {
+				mounted = true
+			}
+		}
+		if !mounted {
+			t.Errorf("container %q doesn't mount the shared volume at /userfunc", c.Name)
+		}
+	}
+	if want := []string{env.ObjectMeta.Name, "fetcher"}; !reflect.DeepEqual(containers, want) {
+		t.Errorf("pod containers = %v, want %v", containers, want)
+	}
+}
+
+func TestGenDeploymentSpecVolumes(t *testing.T) {
+	fetcherCfg, err := fetcherConfig.MakeFetcherConfig("/code")
+	if err != nil {
+		t.Fatalf("Error creating fetcher config: %v", err)
+	}
+	fetcherCfg.AddVolumes([]apiv1.Volume{
+		{Name: "scratch", VolumeSource: apiv1.VolumeSource{EmptyDir: &apiv1.EmptyDirVolumeSource{}}},
+	}, []apiv1.VolumeMount{
+		{Name: "scratch", MountPath: "/scratch"},
+	})
+	env := newDeploymentTestEnv(apiv1.ResourceRequirements{})
+	gp, err := MakeGenericPool(loggerfactory.GetLogger(), nil, fake.NewSimpleClientset(), nil, env,
+		metav1.NamespaceDefault, nil, fetcherCfg, "test", false, 0, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Error creating pool: %v", err)
+	}
+	spec, err := gp.genDeploymentSpec(env)
+	if err != nil {
+		t.Fatalf("Error generating deployment spec: %v", err)
+	}
+
+	found := false
+	for _, v := range spec.Template.Spec.Volumes {
+		if v.Name == "scratch" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("scratch volume not found in the pod")
+	}
+	for _, c := range spec.Template.Spec.Containers {
+		mounts := make(map[string]string)
+		for _, m := range c.VolumeMounts {
+			mounts[m.Name] = m.MountPath
+		}
+		if mounts[fv1.SharedVolumeUserfunc] != "/code" {
+			t.Errorf("container %q mounts the shared volume at %q, want /code", c.Name, mounts[fv1.SharedVolumeUserfunc])
+		}
+		if mounts["scratch"] != "/scratch" {
+			t.Errorf("container %q mounts the scratch volume at %q, want /scratch", c.Name, mounts["scratch"])
+		}
+	}
+}
+
+func TestGenDeploymentSpecImagePullSecrets(t *testing.T) {
+	env := newDeploymentTestEnv(apiv1.ResourceRequirements{})
+	env.Spec.ImagePullSecret = "registry-creds"
+	env.Spec.Runtime.PodSpec = &apiv1.PodSpec{
+		ImagePullSecrets: []apiv1.LocalObjectReference{{Name: "env-extra"}},
+	}
+	gp := newDeploymentTestPool(t, env)
+	gp.podSpecPatch = &apiv1.PodSpec{
+		ImagePullSecrets: []apiv1.LocalObjectReference{{Name: "cluster-creds"}},
+	}
+	spec, err := gp.genDeploymentSpec(env)
+	if err != nil {
+		t.Fatalf("Error generating deployment spec: %v", err)
+	}
+
+	var secrets []string
+	for _, s := range spec.Template.Spec.ImagePullSecrets {
+		secrets = append(secrets, s.Name)
+	}
+	want := []string{"cluster-creds", "registry-creds", "env-extra"}
+	if !reflect.DeepEqual(secrets, want) {
+		t.Errorf("image pull secrets = %v, want %v", secrets, want)
+	}
+}
+
+func TestGenDeploymentSpecTopologySpread(t *testing.T) {
+	env := newDeploymentTestEnv(apiv1.ResourceRequirements{})
+	gp := newDeploymentTestPool(t, env)
+	spec, err := gp.genDeploymentSpec(env)
+	if err != nil {
+		t.Fatalf("Error generating deployment spec: %v", err)
+	}
+	if len(spec.Template.Spec.TopologySpreadConstraints) != 0 {
+		t.Errorf("topology spread constraints = %v, want none by default", spec.Template.Spec.TopologySpreadConstraints)
+	}
+
+	poolSelector := &metav1.LabelSelector{MatchLabels: map[string]string{fv1.ENVIRONMENT_UID: string(env.ObjectMeta.UID)}}
+	zones := apiv1.TopologySpreadConstraint{
+		MaxSkew:           1,
+		TopologyKey:       apiv1.LabelTopologyZone,
+		WhenUnsatisfiable: apiv1.ScheduleAnyway,
+		LabelSelector:     poolSelector,
+	}
+	nodes := apiv1.TopologySpreadConstraint{
+		MaxSkew:           2,
+		TopologyKey:       apiv1.LabelHostname,
+		WhenUnsatisfiable: apiv1.DoNotSchedule,
+		LabelSelector:     poolSelector,
+	}
+	gp.podSpecPatch = &apiv1.PodSpec{TopologySpreadConstraints: []apiv1.TopologySpreadConstraint{zones}}
+	env.Spec.Runtime.PodSpec = &apiv1.PodSpec{TopologySpreadConstraints: []apiv1.TopologySpreadConstraint{nodes}}
+	spec, err = gp.genDeploymentSpec(env)
+	if err != nil {
+		t.Fatalf("Error generating deployment spec: %v", err)
+	}
+	want := []apiv1.TopologySpreadConstraint{zones, nodes}
+	if !reflect.DeepEqual(spec.Template.Spec.TopologySpreadConstraints, want) {
+		t.Errorf("topology spread constraints = %v, want %v", spec.Template.Spec.TopologySpreadConstraints, want)
+	}
+}
+
+func TestGenDeploymentSpecEnvVars(t *testing.T) {
+	env := newDeploymentTestEnv(apiv1.ResourceRequirements{})
+	want := []apiv1.EnvVar{
+		{Name: "LOG_LEVEL", Value: "debug"},
+		{Name: "REGION", ValueFrom: &apiv1.EnvVarSource{
+			ConfigMapKeyRef: &apiv1.ConfigMapKeySelector{
+				LocalObjectReference: apiv1.LocalObjectReference{Name: "settings"},
+				Key:                  "region",
+			},
+		}},
+		{Name: "API_TOKEN", ValueFrom: &apiv1.EnvVarSource{
+			SecretKeyRef: &apiv1.SecretKeySelector{
+				LocalObjectReference: apiv1.LocalObjectReference{Name: "credentials"},
+				Key:                  "token",
+			},
+		}},
+	}
+	env.Spec.Runtime.Container = &apiv1.Container{Env: want}
+	gp := newDeploymentTestPool(t, env)
+	spec, err := gp.genDeploymentSpec(env)
+	if err != nil {
+		t.Fatalf("Error generating deployment spec: %v", err)
+	}
+	container := spec.Template.Spec.Containers[0]
+	if container.Name != env.ObjectMeta.Name {
+		t.Fatalf("first container = %q, want the runtime container", container.Name)
+	}
+	if !reflect.DeepEqual(container.Env, want) {
+		t.Errorf("runtime container env = %v, want %v", container.Env, want)
+	}
+
+	env.Spec.Runtime.Container = nil
+	spec, err = gp.genDeploymentSpec(env)
+	if err != nil {
+		t.Fatalf("Error generating deployment spec: %v", err)
+	}
+	if len(spec.Template.Spec.Containers[0].Env) != 0 {
+		t.Errorf("runtime container env = %v, want none by default", spec.Template.Spec.Containers[0].Env)
+	}
+}
+
+func TestGenDeploymentSpecCommand(t *testing.T) {
+	command := []string{"/usr/local/bin/server"}
+	args := []string{"--port", "8888"}
+	for _, test := range []struct {
+		name      string
+		container *apiv1.Container
+		podSpec   func(env *fv1.Environment) *apiv1.PodSpec
+	}{
+		{
+			name:      "container",
+			container: &apiv1.Container{Command: command, Args: args},
+		},
+		{
+			name: "pod spec",
+			podSpec: func(env *fv1.Environment) *apiv1.PodSpec {
+				return &apiv1.PodSpec{Containers: []apiv1.Container{
+					{Name: env.ObjectMeta.Name, Command: command, Args: args},
+				}}
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			env := newDeploymentTestEnv(apiv1.ResourceRequirements{})
+			env.Spec.Runtime.Container = test.container
+			if test.podSpec != nil {
+				env.Spec.Runtime.PodSpec = test.podSpec(env)
+			}
+			spec, err := newDeploymentTestPool(t, env).genDeploymentSpec(env)
+			if err != nil {
+				t.Fatalf("Error generating deployment spec: %v", err)
+			}
+			container := spec.Template.Spec.Containers[0]
+			if container.Name != env.ObjectMeta.Name {
+				t.Fatalf("first container = %q, want the runtime container", container.Name)
+			}
+			if !reflect.DeepEqual(container.Command, command) || !reflect.DeepEqual(container.Args, args) {
+				t.Errorf("runtime container command = %v, args = %v, want %v and %v",
+					container.Command, container.Args, command, args)
+			}
+		})
+	}
+
+	env := newDeploymentTestEnv(apiv1.ResourceRequirements{})
+	spec, err := newDeploymentTestPool(t, env).genDeploymentSpec(env)
+	if err != nil {
+		t.Fatalf("Error generating deployment spec: %v", err)
+	}
+	if container := spec.Template.Spec.Containers[0]; len(container.Command) != 0 || len(container.Args) != 0 {
+		t.Errorf("runtime container command = %v, args = %v, want the image entrypoint by default",
+			container.Command, container.Args)
+	}
+}
+
+func TestGenDeploymentSpecServiceAccount(t *testing.T) {
+	env := newDeploymentTestEnv(apiv1.ResourceRequirements{})
+	gp := newDeploymentTestPool(t, env)
+	spec, err := gp.genDeploymentSpec(env)
+	if err != nil {
+		t.Fatalf("Error generating deployment spec: %v", err)
+	}
+	if spec.Template.Spec.ServiceAccountName != fv1.FissionFetcherSA || spec.Template.Spec.AutomountServiceAccountToken != nil {
+		t.Errorf("service account = %q, automount = %v, want %q and the default automount",
+			spec.Template.Spec.ServiceAccountName, spec.Template.Spec.AutomountServiceAccountToken, fv1.FissionFetcherSA)
+	}
+
+	automount := false
+	env.Spec.Runtime.PodSpec = &apiv1.PodSpec{ServiceAccountName: "restricted", AutomountServiceAccountToken: &automount}
+	spec, err = gp.genDeploymentSpec(env)
+	if err != nil {
+		t.Fatalf("Error generating deployment spec: %v", err)
+	}
+	if spec.Template.Spec.ServiceAccountName != "restricted" {
+		t.Errorf("service account = %q, want the environment's %q", spec.Template.Spec.ServiceAccountName, "restricted")
+	}
+	if got := spec.Template.Spec.AutomountServiceAccountToken; got == nil || *got {
+		t.Errorf("automount service account token = %v, want false", got)
+	}
+}
+
+func TestGenDeploymentSpecDNS(t *testing.T) {
+	env := newDeploymentTestEnv(apiv1.ResourceRequirements{})
+	gp := newDeploymentTestPool(t, env)
+	spec, err := gp.genDeploymentSpec(env)
+	if err != nil {
+		t.Fatalf("Error generating deployment spec: %v", err)
+	}
+	if spec.Template.Spec.DNSPolicy != "" || spec.Template.Spec.DNSConfig != nil {
+		t.Errorf("dns policy = %q, dns config = %v, want the kubernetes defaults",
+			spec.Template.Spec.DNSPolicy, spec.Template.Spec.DNSConfig)
+	}
+
+	ndots := "2"
+	dnsConfig := &apiv1.PodDNSConfig{
+		Nameservers: []string{"10.0.0.10"},
+		Searches:    []string{"internal.example.com"},
+		Options:     []apiv1.PodDNSConfigOption{{Name: "ndots", Value: &ndots}},
+	}
+	env.Spec.Runtime.PodSpec = &apiv1.PodSpec{DNSPolicy: apiv1.DNSClusterFirstWithHostNet, DNSConfig: dnsConfig}
+	spec, err = gp.genDeploymentSpec(env)
+	if err != nil {
+		t.Fatalf("Error generating deployment spec: %v", err)
+	}
+	if spec.Template.Spec.DNSPolicy != apiv1.DNSClusterFirstWithHostNet {
+		t.Errorf("dns policy = %q, want %q", spec.Template.Spec.DNSPolicy, apiv1.DNSClusterFirstWithHostNet)
+	}
+	if !reflect.DeepEqual(spec.Template.Spec.DNSConfig, dnsConfig) {
+		t.Errorf("dns config = %v, want %v", spec.Template.Spec.DNSConfig, dnsConfig)
+	}
+}
+
+func TestGenDeploymentSpecInitContainers(t *testing.T) {
+	env := newDeploymentTestEnv(apiv1.ResourceRequirements{})
+	initContainers := []apiv1.Container{
+		{Name: "warm-cache", Image: "busybox", Command: []string{"sh", "-c", "cp -r /cache/. /warm"}},
+	}
+	env.Spec.Runtime.PodSpec = &apiv1.PodSpec{InitContainers: initContainers}
+	gp := newDeploymentTestPool(t, env)
+	spec, err := gp.genDeploymentSpec(env)
+	if err != nil {
+		t.Fatalf("Error generating deployment spec: %v", err)
+	}
+	if !reflect.DeepEqual(spec.Template.Spec.InitContainers, initContainers) {
+		t.Errorf("init containers = %v, want %v", spec.Template.Spec.InitContainers, initContainers)
+	}
+	var names []string
+	for _, c := range spec.Template.Spec.Containers {
+		names = append(names, c.Name)
+	}
+	if want := []string{env.ObjectMeta.Name, "fetcher"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("containers = %v, want %v", names, want)
+	}
+}
+
+func TestGenDeploymentSpecTerminationGracePeriod(t *testing.T) {
+	tests := []struct {
+		name        string
+		gracePeriod int64
+		want        int64
+	}{
+		{"negative uses default", -1, 360},
+		{"immediate", 0, 0},
+		{"custom", 30, 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newDeploymentTestEnv(apiv1.ResourceRequirements{})
+			env.Spec.TerminationGracePeriod = tt.gracePeriod
+			gp := newDeploymentTestPool(t, env)
+			spec, err := gp.genDeploymentSpec(env)
+			if err != nil {
+				t.Fatalf("Error generating deployment spec: %v", err)
+			}
+			podSpec := spec.Template.Spec
+			if podSpec.TerminationGracePeriodSeconds == nil || *podSpec.TerminationGracePeriodSeconds != tt.want {
+				t.Fatalf("terminationGracePeriodSeconds = %v, want %d", podSpec.TerminationGracePeriodSeconds, tt.want)
+			}
+			// both containers drain connections for the whole grace period
+			wantPreStop := []string{"/bin/sleep", strconv.FormatInt(tt.want, 10)}
+			for _, c := range podSpec.Containers {
+				if c.Lifecycle == nil || c.Lifecycle.PreStop == nil || c.Lifecycle.PreStop.Exec == nil {
+					t.Fatalf("container %q has no preStop hook", c.Name)
+				}
+				if got := c.Lifecycle.PreStop.Exec.Command; !reflect.DeepEqual(got, wantPreStop) {
+					t.Errorf("container %q preStop = %v, want %v", c.Name, got, wantPreStop)
+				}
+			}
+		})
+	}
+}
+
+func TestCreatePoolDeploymentAlreadyExists(t *testing.T) {
+	env := newDeploymentTestEnv(apiv1.ResourceRequirements{})
+	gp := newDeploymentTestPool(t, env)
+	existing := &appsv1.Deployment{ObjectMeta: gp.genDeploymentMeta(env)}
+	existing.Namespace = gp.fnNamespace
+	existing.Annotations[fv1.EXECUTOR_INSTANCEID_LABEL] = "other-executor"
+	kubernetesClient := fake.NewSimpleClientset(existing)
+	gp.kubernetesClient = kubernetesClient
+
+	// the deployment is created by someone else between the lookup and
+	// the creation of the pool deployment
+	lookedUp := false
+	kubernetesClient.PrependReactor("get", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if lookedUp {
+			return false, nil, nil
+		}
+		lookedUp = true
+		return true, nil, k8sErrs.NewNotFound(appsv1.Resource("deployments"), existing.Name)
+	})
+
+	err := gp.createPoolDeployment(context.Background(), env)
+	if err != nil {
+		t.Fatalf("Error creating pool deployment: %v", err)
+	}
+	if gp.deployment.Load() == nil || gp.deployment.Load().Annotations[fv1.EXECUTOR_INSTANCEID_LABEL] != "other-executor" {
+		t.Errorf("pool deployment = %v, want the existing deployment adopted", gp.deployment.Load())
+	}
+}
+
+func TestRefreshDeployment(t *testing.T) {
+	ctx := context.Background()
+	env := newDeploymentTestEnv(apiv1.ResourceRequirements{})
+	gp := newDeploymentTestPool(t, env)
+	err := gp.createPoolDeployment(ctx, env)
+	if err != nil {
+		t.Fatalf("Error creating pool deployment: %v", err)
+	}
+
+	// someone scales the pool deployment with kubectl
+	scaled := gp.deployment.Load().DeepCopy()
+	replicas := int32(6)
+	scaled.Spec.Replicas = &replicas
+	_, err = gp.kubernetesClient.AppsV1().Deployments(gp.fnNamespace).Update(ctx, scaled, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("Error scaling deployment: %v", err)
+	}
+
+	err = gp.refreshDeployment(ctx)
+	if err != nil {
+		t.Fatalf("Error refreshing deployment: %v", err)
+	}
+	if *gp.deployment.Load().Spec.Replicas != replicas {
+		t.Fatalf("cached deployment has %d replicas, want %d", *gp.deployment.Load().Spec.Replicas, replicas)
+	}
+
+	// the autoscaler scales from the replicas set externally
+	gp.maxReplicas = 10
+	replicas = 8
+	scaled.Spec.Replicas = &replicas
+	_, err = gp.kubernetesClient.AppsV1().Deployments(gp.fnNamespace).Update(ctx, scaled, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("Error scaling deployment: %v", err)
+	}
+	gp.starvedRequests.Add(1)
+	gp.doAutoscale(ctx)
+	if *gp.deployment.Load().Spec.Replicas != 9 {
+		t.Errorf("autoscaled to %d replicas, want 9", *gp.deployment.Load().Spec.Replicas)
+	}
+}
+
+func TestUpdatePoolDeploymentKeepsAutoscaledReplicas(t *testing.T) {
+	ctx := context.Background()
+	env := newDeploymentTestEnv(apiv1.ResourceRequirements{})
+	env.Spec.Version = 3
+	env.Spec.Poolsize = 2
+	env.ResourceVersion = "1"
+	gp := newDeploymentTestPool(t, env)
+	err := gp.createPoolDeployment(ctx, env)
+	if err != nil {
+		t.Fatalf("Error creating pool deployment: %v", err)
+	}
+	gp.maxReplicas = 10
+	gp.starvedRequests.Add(3)
+	gp.doAutoscale(ctx)
+	if *gp.deployment.Load().Spec.Replicas != 5 {
+		t.Fatalf("autoscaled to %d replicas, want 5", *gp.deployment.Load().Spec.Replicas)
+	}
+
+	tests := []struct {
+		name     string
+		poolsize int
+		want     int32
+	}{
+		{"autoscaled replicas within the new pool size", 4, 5},
+		{"pool size above the autoscaled replicas", 6, 6},
+		{"pool size below the autoscaled replicas", 2, 6},
+		{"pool size above the max replicas", 12, 12},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env = env.DeepCopy()
+			env.Spec.Poolsize = tt.poolsize
+			env.ResourceVersion = strconv.Itoa(i + 2)
+			err := gp.updatePoolDeployment(ctx, env)
+			if err != nil {
+				t.Fatalf("Error updating pool deployment: %v", err)
+			}
+			got, err := gp.kubernetesClient.AppsV1().Deployments(gp.fnNamespace).Get(ctx, gp.deployment.Load().Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("Error getting deployment: %v", err)
+			}
+			if *got.Spec.Replicas != tt.want {
+				t.Errorf("updated deployment has %d replicas, want %d", *got.Spec.Replicas, tt.want)
+			}
+			if gp.minReplicas.Load() != int32(tt.poolsize) {
+				t.Errorf("pool size = %d, want %d", gp.minReplicas.Load(), tt.poolsize)
+			}
+		})
+	}
+}
+
+func TestGenDeploymentSpecReplicas(t *testing.T) {
+	tests := []struct {
+		name         string
+		version      int
+		poolsize     int
+		allowedFuncs fv1.AllowedFunctionsPerContainer
+		want         int32
+	}{
+		{"pool size from the spec", 3, 5, "", 5},
+		{"no pool", 3, 0, "", 0},
+		{"default for older environments", 2, 5, "", defaultPoolSize},
+		{"single pod for infinite functions per container", 3, 5, fv1.AllowedFunctionsPerContainerInfinite, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newDeploymentTestEnv(apiv1.ResourceRequirements{})
+			env.Spec.Version = tt.version
+			env.Spec.Poolsize = tt.poolsize
+			env.Spec.AllowedFunctionsPerContainer = tt.allowedFuncs
+			spec, err := newDeploymentTestPool(t, env).genDeploymentSpec(env)
+			if err != nil {
+				t.Fatalf("Error generating deployment spec: %v", err)
+			}
+			if *spec.Replicas != tt.want {
+				t.Errorf("replicas = %d, want %d", *spec.Replicas, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenDeploymentSpecStrategy(t *testing.T) {
+	tests := []struct {
+		name           string
+		env            map[string]string
+		wantType       appsv1.DeploymentStrategyType
+		maxSurge       string
+		maxUnavailable string
+	}{
+		{"keeps pods available by default", nil, appsv1.RollingUpdateDeploymentStrategyType, "25%", "0"},
+		{"rolling update", map[string]string{
+			"POOLMGR_DEPLOYMENT_MAX_SURGE":       "2",
+			"POOLMGR_DEPLOYMENT_MAX_UNAVAILABLE": "10%",
+		}, appsv1.RollingUpdateDeploymentStrategyType, "2", "10%"},
+		{"recreate", map[string]string{"POOLMGR_DEPLOYMENT_STRATEGY": "Recreate"}, appsv1.RecreateDeploymentStrategyType, "", ""},
+		{"unknown strategy", map[string]string{"POOLMGR_DEPLOYMENT_STRATEGY": "BlueGreen"}, appsv1.RollingUpdateDeploymentStrategyType, "25%", "0"},
+		{"no progress", map[string]string{
+			"POOLMGR_DEPLOYMENT_MAX_SURGE":       "0",
+			"POOLMGR_DEPLOYMENT_MAX_UNAVAILABLE": "0%",
+		}, appsv1.RollingUpdateDeploymentStrategyType, "25%", "0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			env := newDeploymentTestEnv(apiv1.ResourceRequirements{})
+			spec, err := newDeploymentTestPool(t, env).genDeploymentSpec(env)
+			if err != nil {
+				t.Fatalf("Error generating deployment spec: %v", err)
+			}
+			if spec.Strategy.Type != tt.wantType {
+				t.Fatalf("strategy = %q, want %q", spec.Strategy.Type, tt.wantType)
+			}
+			if tt.wantType == appsv1.RecreateDeploymentStrategyType {
+				if spec.Strategy.RollingUpdate != nil {
+					t.Errorf("got rolling update %v for the recreate strategy", spec.Strategy.RollingUpdate)
+				}
+				return
+			}
+			rollingUpdate := spec.Strategy.RollingUpdate
+			if rollingUpdate.MaxSurge.String() != tt.maxSurge || rollingUpdate.MaxUnavailable.String() != tt.maxUnavailable {
+				t.Errorf("max surge %s and max unavailable %s, want %s and %s",
+					rollingUpdate.MaxSurge.String(), rollingUpdate.MaxUnavailable.String(), tt.maxSurge, tt.maxUnavailable)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/utils"
+)
+
+// defaultGenerationHealthDeadline is how long a new generation of the pool
+// pods gets to turn ready before the pool falls back to the previous one,
+// see GenericPool.generationHealthDeadline.
+const defaultGenerationHealthDeadline = 2 * time.Minute
+
+// generationRollout tracks the rollout of a new generation of the pool
+// pods. The deployment keeps the pods of the previous generation while the
+// new ones don't turn ready, but the pool doesn't choose them since they
+// run an outdated spec. If the new generation is broken, e.g. its image
+// doesn't start, the pool would starve until the environment is fixed.
+// Once the health deadline passes without a ready pod of the new
+// generation, the pool falls back to the ready pods of the previous one
+// until the new generation recovers.
+type generationRollout struct {
+	previous string    // generation the pool falls back to
+	current  string    // generation being rolled out
+	since    time.Time // when the rollout started
+	fallback atomic.Bool
+}
+
+// trackRollout starts tracking the rollout from the previous generation to
+// the current one. A rollout replacing a generation that never turned
+// ready keeps falling back to the last generation known to be good.
+func (gp *GenericPool) trackRollout(previous, current string) {
+	if gp.generationHealthDeadline <= 0 || previous == current {
+		return
+	}
+	if r := gp.rollout.Load(); r != nil && r.current == previous && !gp.generationReady(previous) {
+		previous = r.previous
+	}
+	r := &generationRollout{
+		previous: previous,
+		current:  current,
+		since:    time.Now(),
+	}
+	gp.rollout.Store(r)
+	time.AfterFunc(gp.generationHealthDeadline, func() {
+		gp.checkRollout(r)
+	})
+}
+
+// checkRollout falls back to the previous generation if the rollout is
+// still the pool's latest and its generation has no ready pods.
+func (gp *GenericPool) checkRollout(r *generationRollout) {
+	select {
+	case <-gp.stopReadyPodControllerCh:
+		return
+	default:
+	}
+	if gp.rollout.Load() != r {
+		return
+	}
+	if gp.generationReady(r.current) {
+		gp.rollout.CompareAndSwap(r, nil)
+		return
+	}
+	r.fallback.Store(true)
+	gp.logger.Warn("no pod of the new generation turned ready, falling back to the previous generation",
+		zap.String("env", gp.env.ObjectMeta.Name),
+		zap.String("namespace", gp.env.ObjectMeta.Namespace),
+		zap.String("generation", r.current),
+		zap.String("fallbackGeneration", r.previous),
+		zap.Duration("deadline", gp.generationHealthDeadline))
+	gp.recordEvent(apiv1.EventTypeWarning, "GenerationFallback", "No pod of generation %s turned ready in %v, using pods of generation %s until it recovers",
+		r.current, gp.generationHealthDeadline, r.previous)
+
+	// choosePod dropped the keys of the previous generation pods, queue
+	// them again so they can be chosen
+	pods, err := gp.generationPods(r.previous)
+	if err != nil {
+		gp.logger.Error("error listing pods of the fallback generation", zap.Error(err), zap.String("generation", r.previous))
+		return
+	}
+	for _, pod := range pods {
+		if !utils.IsReadyPod(pod) {
+			continue
+		}
+		key, err := k8sCache.MetaNamespaceKeyFunc(pod)
+		if err != nil {
+			gp.logger.Error("error getting key of pod", zap.Error(err), zap.String("pod", pod.Name))
+			continue
+		}
+		gp.readyPodQueue.Add(key)
+	}
+}
+
+// fallbackGeneration returns the generation whose pods the pool chooses
+// besides the current one, empty unless the current generation failed to
+// turn ready. The fallback ends as soon as a pod of the current generation
+// is ready.
+func (gp *GenericPool) fallbackGeneration() string {
+	r := gp.rollout.Load()
+	if r == nil || !r.fallback.Load() {
+		return ""
+	}
+	if gp.generationReady(r.current) {
+		if gp.rollout.CompareAndSwap(r, nil) {
+			gp.logger.Info("new generation recovered, ending fallback to the previous generation",
+				zap.String("env", gp.env.ObjectMeta.Name),
+				zap.String("namespace", gp.env.ObjectMeta.Namespace),
+				zap.String("generation", r.current),
+				zap.Duration("after", time.Since(r.since)))
+		}
+		return ""
+	}
+	return r.previous
+}
+
+// generationPods lists the generic pods of a generation.
+func (gp *GenericPool) generationPods(generation string) ([]*apiv1.Pod, error) {
+	return gp.readyPodLister.Pods(gp.fnNamespace).List(labels.SelectorFromSet(map[string]string{
+		fv1.ENVIRONMENT_GENERATION: generation,
+	}))
+}
+
+// generationReady returns whether a generic pod of the generation is
+// ready.
+func (gp *GenericPool) generationReady(generation string) bool {
+	if gp.readyPodLister == nil {
+		return true
+	}
+	pods, err := gp.generationPods(generation)
+	if err != nil {
+		gp.logger.Error("error listing pods of generation", zap.Error(err), zap.String("generation", generation))
+		return true
+	}
+	for _, pod := range pods {
+		if utils.IsReadyPod(pod) {
+			return true
+		}
+	}
+	return false
+}
+
+// isServingGenerationPod returns true for generic pods the pool chooses:
+// pods of the current generation, adopted pods, and pods of the previous
+// generation while the pool falls back to it. Pods of other environments
+// are never chosen, whatever their generation.
+func (gp *GenericPool) isServingGenerationPod(pod *apiv1.Pod) bool {
+	if gp.isOtherEnvironmentPod(pod) {
+		return false
+	}
+	if gp.isCurrentGenerationPod(pod) || gp.isAdoptedPod(pod) {
+		return true
+	}
+	fallback := gp.fallbackGeneration()
+	return len(fallback) > 0 && pod.Labels[fv1.ENVIRONMENT_GENERATION] == fallback
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+func TestGenerationFallback(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	t.Setenv("POOLMGR_GENERATION_HEALTH_DEADLINE", "10ms")
+
+	previous := newTestPod("previous", "10.0.0.1", true)
+	previous.Labels[fv1.ENVIRONMENT_GENERATION] = "1"
+	current := newTestPod("current", "10.0.0.2", false)
+	current.Labels[fv1.ENVIRONMENT_GENERATION] = "2"
+	gp := newTestPool(t, previous, current)
+	defer gp.readyPodQueue.ShutDown()
+	if gp.generationHealthDeadline != 10*time.Millisecond {
+		t.Fatalf("got generation health deadline %v, want %v", gp.generationHealthDeadline, 10*time.Millisecond)
+	}
+	deployment := &appsv1.Deployment{}
+	deployment.Spec.Template.Labels = map[string]string{fv1.ENVIRONMENT_GENERATION: "2"}
+	gp.deployment.Store(deployment)
+
+	if gen := gp.fallbackGeneration(); gen != "" {
+		t.Fatalf("got fallback generation %q before the rollout", gen)
+	}
+	gp.trackRollout("1", "2")
+	err := wait.PollImmediateWithContext(ctx, 10*time.Millisecond, 2*time.Second, func(context.Context) (bool, error) {
+		return gp.fallbackGeneration() == "1", nil
+	})
+	if err != nil {
+		t.Fatalf("pool didn't fall back to the previous generation: %v", err)
+	}
+	ready, err := gp.readyPodCount()
+	if err != nil {
+		t.Fatalf("Error counting ready pods: %v", err)
+	}
+	if ready != 1 {
+		t.Errorf("got %d ready pods, want the previous generation one", ready)
+	}
+
+	fn := &metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}
+	_, pod, err := gp.choosePod(ctx, gp.labelsForFunction(fn))
+	if err != nil {
+		t.Fatalf("Error choosing pod: %v", err)
+	}
+	if pod.Name != "previous" {
+		t.Fatalf("chose pod %q, want %q", pod.Name, "previous")
+	}
+
+	// the fallback ends once the current generation recovers
+	current.Status.ContainerStatuses[0].Ready = true
+	if gen := gp.fallbackGeneration(); gen != "" {
+		t.Errorf("got fallback generation %q after the current generation recovered", gen)
+	}
+	if gp.rollout.Load() != nil {
+		t.Error("rollout still tracked after the current generation recovered")
+	}
+}
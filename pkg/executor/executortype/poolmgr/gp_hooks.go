@@ -0,0 +1,81 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"go.uber.org/zap"
+	apiv1 "k8s.io/api/core/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/executor/fscache"
+)
+
+// PoolHooks are optional callbacks run on the lifecycle events of the pods
+// of a pool, e.g. for audit logging, registering pods with external
+// systems or tracing. Hooks run in their own goroutine, with copies of the
+// objects, so they don't slow down requests or the reaper. Nil hooks are
+// skipped.
+type PoolHooks struct {
+	// OnChoose is called with the generic pod chosen for a function,
+	// before it's specialized.
+	OnChoose func(pod *apiv1.Pod, fn *fv1.Function)
+	// OnSpecialize is called with the function service of a pod once it
+	// was specialized and cached.
+	OnSpecialize func(fsvc *fscache.FuncSvc)
+	// OnReap is called with the function service of an idle pod the
+	// reaper removed from the cache, before the pod is released or
+	// deleted.
+	OnReap func(fsvc *fscache.FuncSvc)
+}
+
+// chosen runs the OnChoose hook.
+func (h *PoolHooks) chosen(logger *zap.Logger, pod *apiv1.Pod, fn *fv1.Function) {
+	if h.OnChoose == nil {
+		return
+	}
+	pod, fn = pod.DeepCopy(), fn.DeepCopy()
+	go runHook(logger, "OnChoose", func() { h.OnChoose(pod, fn) })
+}
+
+// specialized runs the OnSpecialize hook.
+func (h *PoolHooks) specialized(logger *zap.Logger, fsvc *fscache.FuncSvc) {
+	if h.OnSpecialize == nil {
+		return
+	}
+	fsvcCopy := *fsvc
+	go runHook(logger, "OnSpecialize", func() { h.OnSpecialize(&fsvcCopy) })
+}
+
+// reaped runs the OnReap hook.
+func (h *PoolHooks) reaped(logger *zap.Logger, fsvc *fscache.FuncSvc) {
+	if h.OnReap == nil {
+		return
+	}
+	fsvcCopy := *fsvc
+	go runHook(logger, "OnReap", func() { h.OnReap(&fsvcCopy) })
+}
+
+// runHook runs a hook, logging rather than crashing the executor if it
+// panics.
+func runHook(logger *zap.Logger, name string, hook func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("pool hook panicked", zap.String("hook", name), zap.Any("panic", r))
+		}
+	}()
+	hook()
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/executor/fscache"
+	fetcherConfig "github.com/fission/fission/pkg/fetcher/config"
+)
+
+func TestGetFuncSvcRunsHooks(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ts, host, port := fakeFetcher(t)
+	defer ts.Close()
+	gp := newTestPool(t, newTestPod("ready", host, true))
+	defer gp.readyPodQueue.ShutDown()
+	cfg, err := fetcherConfig.MakeFetcherConfig("/userfunc")
+	if err != nil {
+		t.Fatalf("Error creating fetcher config: %v", err)
+	}
+	gp.fetcherConfig, gp.fetcherPort = cfg, port
+	gp.fsCache = fscache.MakeFunctionServiceCache(gp.logger)
+
+	chosen := make(chan string, 1)
+	specialized := make(chan string, 1)
+	block := make(chan struct{})
+	defer close(block)
+	gp.OnChoose = func(pod *apiv1.Pod, fn *fv1.Function) {
+		chosen <- pod.Name + "/" + fn.Name
+		// hooks don't hold up the specialization
+		<-block
+	}
+	gp.OnSpecialize = func(fsvc *fscache.FuncSvc) {
+		specialized <- fsvc.Name + "/" + fsvc.Function.Name
+		panic("hooks panicking don't crash the executor")
+	}
+
+	fn := &fv1.Function{ObjectMeta: metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}}
+	_, err = gp.getFuncSvc(ctx, fn)
+	if err != nil {
+		t.Fatalf("Error getting function service: %v", err)
+	}
+	for name, ch := range map[string]chan string{"OnChoose": chosen, "OnSpecialize": specialized} {
+		select {
+		case got := <-ch:
+			if got != "ready/fn" {
+				t.Errorf("%s hook got %q, want %q", name, got, "ready/fn")
+			}
+		case <-ctx.Done():
+			t.Errorf("%s hook not run", name)
+		}
+	}
+}
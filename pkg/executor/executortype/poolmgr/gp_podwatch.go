@@ -0,0 +1,284 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	k8sInformers "k8s.io/client-go/informers"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/crd"
+	"github.com/fission/fission/pkg/executor/fscache"
+	"github.com/fission/fission/pkg/executor/metrics"
+	"github.com/fission/fission/pkg/executor/reaper"
+)
+
+// crashLoopBackOff is the waiting reason of containers restarted too often.
+const crashLoopBackOff = "CrashLoopBackOff"
+
+// podFailureHandler is notified of specialized pods that failed, after their
+// function service was evicted from the cache. It lets the pool manager
+// react to functions whose pods fail.
+type podFailureHandler func(ctx context.Context, pod *apiv1.Pod, fsvc *fscache.FuncSvc)
+
+// podFailed returns true if a specialized pod can't serve its function any
+// longer because the pod failed or one of its containers is crash looping.
+func podFailed(pod *apiv1.Pod) bool {
+	if pod.Status.Phase == apiv1.PodFailed {
+		return true
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Waiting != nil && status.State.Waiting.Reason == crashLoopBackOff {
+			return true
+		}
+	}
+	return false
+}
+
+// podDisrupted returns true if a pod is about to be evicted, e.g. because
+// its node is drained for maintenance or went NotReady and the taint manager
+// evicts its pods. Pods on nodes cordoned or NotReady before the eviction
+// starts are found by the node watch of the pool manager, see
+// nodeUnavailable.
+func podDisrupted(pod *apiv1.Pod) bool {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == apiv1.AlphaNoCompatGuaranteeDisruptionTarget && cond.Status == apiv1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeUnavailable returns true if the pods of a node are about to go away:
+// the node is cordoned, usually ahead of a drain, or isn't ready.
+func nodeUnavailable(node *apiv1.Node) bool {
+	if node.Spec.Unschedulable {
+		return true
+	}
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == apiv1.NodeReady {
+			return cond.Status != apiv1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// specializedPodSelector selects the specialized pods of the pool.
+func (gp *GenericPool) specializedPodSelector() labels.Selector {
+	return labels.SelectorFromSet(map[string]string{
+		fv1.EXECUTOR_TYPE:   string(fv1.ExecutorTypePoolmgr),
+		fv1.ENVIRONMENT_UID: string(gp.env.ObjectMeta.UID),
+		fv1.MANAGED:         "false",
+	})
+}
+
+// listSpecializedPods returns the specialized pods of the pool, as seen by
+// watchSpecializedPods.
+func (gp *GenericPool) listSpecializedPods() []*apiv1.Pod {
+	if gp.specializedPods == nil {
+		return nil
+	}
+	pods, err := gp.specializedPods.Pods(gp.fnNamespace).List(gp.specializedPodSelector())
+	if err != nil {
+		gp.logger.Error("error listing specialized pods", zap.Error(err))
+		return nil
+	}
+	return pods
+}
+
+// watchSpecializedPods watches the specialized pods of the pool until the
+// pool is destroyed, so that a pod failing after specialization or about to
+// be evicted is noticed right away rather than by the next request routed
+// to it. Pools in the namespaces the pool manager watches share its pod
+// informer, which hands the pod updates to handleSpecializedPod. The others
+// watch their namespace on their own.
+func (gp *GenericPool) watchSpecializedPods() {
+	if gp.specializedPods != nil {
+		return
+	}
+	informerFactory := k8sInformers.NewSharedInformerFactoryWithOptions(gp.kubernetesClient, 0,
+		k8sInformers.WithNamespace(gp.fnNamespace),
+		k8sInformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = gp.specializedPodSelector().String()
+		}))
+	podInformer := informerFactory.Core().V1().Pods()
+	podInformer.Informer().AddEventHandler(k8sCache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if pod, ok := newObj.(*apiv1.Pod); ok {
+				gp.handleSpecializedPod(context.Background(), pod)
+			}
+		},
+	})
+	gp.specializedPods = podInformer.Lister()
+	go podInformer.Informer().Run(gp.stopReadyPodControllerCh)
+}
+
+// handleSpecializedPod reacts to an update of a specialized pod of the pool
+// which failed or is about to go away.
+func (gp *GenericPool) handleSpecializedPod(ctx context.Context, pod *apiv1.Pod) {
+	switch {
+	case podFailed(pod):
+		gp.handleFailedPod(ctx, pod)
+	case podDisrupted(pod) || gp.onUnavailableNode(pod):
+		gp.handleDisruptedPod(ctx, pod)
+	}
+}
+
+// onUnavailableNode returns true if the pod runs on a node known to be
+// cordoned or NotReady, see nodeUnavailable. Nodes are only known if the
+// pool manager watches them.
+func (gp *GenericPool) onUnavailableNode(pod *apiv1.Pod) bool {
+	if gp.nodeLister == nil || len(pod.Spec.NodeName) == 0 {
+		return false
+	}
+	node, err := gp.nodeLister.Get(pod.Spec.NodeName)
+	if err != nil {
+		return false
+	}
+	return nodeUnavailable(node)
+}
+
+// handleUnavailableNode moves the functions of the specialized pods of the
+// pool on a node which became unavailable to other pods, see
+// handleDisruptedPod.
+func (gp *GenericPool) handleUnavailableNode(ctx context.Context, node *apiv1.Node) {
+	for _, pod := range gp.listSpecializedPods() {
+		if pod.Spec.NodeName == node.ObjectMeta.Name && IsPodActive(pod) {
+			gp.handleDisruptedPod(ctx, pod)
+		}
+	}
+}
+
+// handleFailedPod evicts the function service of a failed specialized pod
+// from the cache, so that the next request for the function specializes
+// another pod, and deletes the failed pod along with its service, if any.
+func (gp *GenericPool) handleFailedPod(ctx context.Context, pod *apiv1.Pod) {
+	if gp.fsCache == nil {
+		return
+	}
+	// the pod keeps failing until it's deleted, only handle it once
+	obj, ok := gp.fsCache.PodToFsvc.LoadAndDelete(pod.ObjectMeta.Name)
+	if !ok {
+		return
+	}
+	fsvc, ok := obj.(*fscache.FuncSvc)
+	if !ok {
+		gp.logger.Error("could not convert item from PodToFsvc", zap.String("pod", pod.ObjectMeta.Name))
+		return
+	}
+	gp.fsCache.DeleteFunctionSvc(ctx, fsvc)
+	gp.fsCache.DeleteEntry(fsvc)
+	gp.podFSVCMap.Delete(pod.ObjectMeta.Name)
+
+	gp.logger.Warn("specialized pod failed, evicted its function service",
+		zap.String("pod", pod.ObjectMeta.Name),
+		zap.String("function", fsvc.Function.Name),
+		zap.String("functionNamespace", fsvc.Function.Namespace),
+		zap.String("phase", string(pod.Status.Phase)))
+	metrics.PoolSpecializedPodFailures.WithLabelValues(gp.env.ObjectMeta.Name, gp.env.ObjectMeta.Namespace).Inc()
+	gp.recordEvent(apiv1.EventTypeWarning, "SpecializedPodFailed", "Specialized pod %s of function %s/%s failed",
+		pod.ObjectMeta.Name, fsvc.Function.Namespace, fsvc.Function.Name)
+	if gp.onPodFailure != nil {
+		gp.onPodFailure(ctx, pod, fsvc)
+	}
+	go func() {
+		for i := range fsvc.KubernetesObjects {
+			reaper.CleanupKubeObject(context.Background(), gp.logger, gp.kubernetesClient, &fsvc.KubernetesObjects[i])
+		}
+	}()
+}
+
+// handleDisruptedPod evicts the function service of a specialized pod about
+// to be evicted from the cache and specializes another pod for the function
+// in the background, so that requests don't follow the pod to its end or
+// wait for a cold start afterwards. Its service is deleted for the new pod
+// to get one of its own. Once the function moved, the pod, which nothing
+// routes requests to any longer, is deleted as well: its node may recover
+// or never be drained, and neither the pool nor the idle reaper would
+// delete it then.
+func (gp *GenericPool) handleDisruptedPod(ctx context.Context, pod *apiv1.Pod) {
+	if gp.fsCache == nil {
+		return
+	}
+	// the pod stays a disruption target until it's deleted, only handle it once
+	obj, ok := gp.fsCache.PodToFsvc.LoadAndDelete(pod.ObjectMeta.Name)
+	if !ok {
+		return
+	}
+	fsvc, ok := obj.(*fscache.FuncSvc)
+	if !ok {
+		gp.logger.Error("could not convert item from PodToFsvc", zap.String("pod", pod.ObjectMeta.Name))
+		return
+	}
+	gp.fsCache.DeleteFunctionSvc(ctx, fsvc)
+	gp.fsCache.DeleteEntry(fsvc)
+	gp.podFSVCMap.Delete(pod.ObjectMeta.Name)
+
+	gp.logger.Info("specialized pod about to be evicted, re-specializing its function",
+		zap.String("pod", pod.ObjectMeta.Name),
+		zap.String("node", pod.Spec.NodeName),
+		zap.String("function", fsvc.Function.Name),
+		zap.String("functionNamespace", fsvc.Function.Namespace))
+	metrics.PoolRespecializations.WithLabelValues(gp.env.ObjectMeta.Name, gp.env.ObjectMeta.Namespace).Inc()
+	gp.recordEvent(apiv1.EventTypeNormal, "SpecializedPodDisrupted", "Specialized pod %s of function %s/%s is about to be evicted",
+		pod.ObjectMeta.Name, fsvc.Function.Namespace, fsvc.Function.Name)
+	go func() {
+		for i := range fsvc.KubernetesObjects {
+			if fsvc.KubernetesObjects[i].Kind == "service" {
+				reaper.CleanupKubeObject(context.Background(), gp.logger, gp.kubernetesClient, &fsvc.KubernetesObjects[i])
+			}
+		}
+		gp.respecialize(context.Background(), fsvc.Function)
+		for i := range fsvc.KubernetesObjects {
+			if fsvc.KubernetesObjects[i].Kind == "pod" {
+				reaper.CleanupKubeObject(context.Background(), gp.logger, gp.kubernetesClient, &fsvc.KubernetesObjects[i])
+			}
+		}
+	}()
+}
+
+// respecialize specializes a pod for the function of a disrupted pod. The
+// function service is cached as available, like a pre-warmed one.
+func (gp *GenericPool) respecialize(ctx context.Context, fnMeta *metav1.ObjectMeta) {
+	if gp.fissionClient == nil {
+		return
+	}
+	logger := gp.logger.With(zap.String("function", fnMeta.Name), zap.String("functionNamespace", fnMeta.Namespace))
+	fn, err := gp.fissionClient.CoreV1().Functions(fnMeta.Namespace).Get(ctx, fnMeta.Name, metav1.GetOptions{})
+	if err != nil {
+		logger.Error("error getting function to re-specialize", zap.Error(err))
+		return
+	}
+	if fn.ObjectMeta.UID != fnMeta.UID {
+		// the function was recreated since
+		return
+	}
+	fsvc, err := gp.getFuncSvc(ctx, fn)
+	if err != nil {
+		logger.Error("error re-specializing function", zap.Error(err))
+		return
+	}
+	// getFuncSvc counts its caller as an active request of the pod
+	gp.fsCache.MarkAvailable(crd.CacheKey(fsvc.Function), fsvc.Address)
+	logger.Info("re-specialized function", zap.String("pod", fsvc.Name))
+}
@@ -0,0 +1,343 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/crd"
+	"github.com/fission/fission/pkg/executor/fscache"
+	fetcherConfig "github.com/fission/fission/pkg/fetcher/config"
+	fClient "github.com/fission/fission/pkg/generated/clientset/versioned/fake"
+)
+
+func TestPodFailed(t *testing.T) {
+	tests := []struct {
+		name   string
+		status apiv1.PodStatus
+		want   bool
+	}{
+		{"running", apiv1.PodStatus{Phase: apiv1.PodRunning}, false},
+		{"failed", apiv1.PodStatus{Phase: apiv1.PodFailed}, true},
+		{"crash looping", apiv1.PodStatus{
+			Phase: apiv1.PodRunning,
+			ContainerStatuses: []apiv1.ContainerStatus{{
+				Name:  "test",
+				State: apiv1.ContainerState{Waiting: &apiv1.ContainerStateWaiting{Reason: crashLoopBackOff}},
+			}},
+		}, true},
+		{"container creating", apiv1.PodStatus{
+			Phase: apiv1.PodPending,
+			ContainerStatuses: []apiv1.ContainerStatus{{
+				Name:  "test",
+				State: apiv1.ContainerState{Waiting: &apiv1.ContainerStateWaiting{Reason: "ContainerCreating"}},
+			}},
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := podFailed(&apiv1.Pod{Status: tt.status}); got != tt.want {
+				t.Errorf("podFailed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleFailedPod(t *testing.T) {
+	ctx := context.Background()
+	gp := newTestPool(t)
+	defer gp.readyPodQueue.ShutDown()
+	gp.fsCache = fscache.MakeFunctionServiceCache(gp.logger)
+	var failures int
+	gp.onPodFailure = func(ctx context.Context, pod *apiv1.Pod, fsvc *fscache.FuncSvc) {
+		failures++
+	}
+
+	fn := &fv1.Function{ObjectMeta: metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}}
+	pod := newTestPod("specialized", "10.0.0.1", true)
+	for k, v := range gp.labelsForFunction(&fn.ObjectMeta) {
+		pod.Labels[k] = v
+	}
+	pod, err := gp.kubernetesClient.CoreV1().Pods(pod.Namespace).Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Error creating pod: %v", err)
+	}
+	fsvc := &fscache.FuncSvc{
+		Name:     pod.Name,
+		Function: &fn.ObjectMeta,
+		Address:  "10.0.0.1:8888",
+		KubernetesObjects: []apiv1.ObjectReference{{
+			Kind:      "pod",
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+		}},
+		Executor: fv1.ExecutorTypePoolmgr,
+	}
+	gp.fsCache.PodToFsvc.Store(pod.Name, fsvc)
+	gp.fsCache.AddFunc(ctx, *fsvc, fn.GetRequestPerPod())
+
+	pod.Status.Phase = apiv1.PodFailed
+	gp.handleFailedPod(ctx, pod)
+	// the pod keeps failing until it's gone, later updates are no-ops
+	gp.handleFailedPod(ctx, pod)
+
+	if failures != 1 {
+		t.Errorf("failure handler called %d times, want once", failures)
+	}
+	_, err = gp.fsCache.GetFuncSvc(ctx, &fn.ObjectMeta, fn.GetRequestPerPod(), 0)
+	if err == nil {
+		t.Error("got function service of the failed pod, want a cache miss")
+	}
+	err = wait.PollImmediateWithContext(ctx, 10*time.Millisecond, 5*time.Second, func(ctx context.Context) (bool, error) {
+		_, err := gp.kubernetesClient.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		return k8serrors.IsNotFound(err), nil
+	})
+	if err != nil {
+		t.Errorf("failed pod wasn't deleted: %v", err)
+	}
+}
+
+func TestHandleDisruptedPod(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ts, host, port := fakeFetcher(t)
+	defer ts.Close()
+	gp := newTestPool(t, newTestPod("ready", host, true))
+	defer gp.readyPodQueue.ShutDown()
+	cfg, err := fetcherConfig.MakeFetcherConfig("/userfunc")
+	if err != nil {
+		t.Fatalf("Error creating fetcher config: %v", err)
+	}
+	gp.fetcherConfig, gp.fetcherPort = cfg, port
+	fn, pod := newDisruptedTestPod(ctx, t, gp)
+
+	// the node of the pod is drained
+	pod.Status.Conditions = append(pod.Status.Conditions, apiv1.PodCondition{
+		Type:   apiv1.AlphaNoCompatGuaranteeDisruptionTarget,
+		Status: apiv1.ConditionTrue,
+		Reason: "EvictionByEvictionAPI",
+	})
+	if !podDisrupted(pod) || podFailed(pod) {
+		t.Fatal("pod isn't considered disrupted")
+	}
+	gp.handleDisruptedPod(ctx, pod)
+	checkRespecialized(ctx, t, gp, fn, pod)
+}
+
+// newDisruptedTestPod returns a pod of the pool specialized for a function
+// which is cached as available, like a pod serving its function when it's
+// about to be evicted.
+func newDisruptedTestPod(ctx context.Context, t *testing.T, gp *GenericPool) (*fv1.Function, *apiv1.Pod) {
+	t.Helper()
+	gp.fsCache = fscache.MakeFunctionServiceCache(gp.logger)
+	fn := &fv1.Function{ObjectMeta: metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}}
+	gp.fissionClient = fClient.NewSimpleClientset(fn)
+
+	pod := newSpecializedTestPod("draining", "10.0.0.1")
+	pod.Spec.NodeName = "node-1"
+	for k, v := range gp.labelsForFunction(&fn.ObjectMeta) {
+		pod.Labels[k] = v
+	}
+	pod, err := gp.kubernetesClient.CoreV1().Pods(pod.Namespace).Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Error creating pod: %v", err)
+	}
+	fsvc := &fscache.FuncSvc{
+		Name:     pod.Name,
+		Function: &fn.ObjectMeta,
+		Address:  "10.0.0.1:8888",
+		KubernetesObjects: []apiv1.ObjectReference{{
+			Kind:      "pod",
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+		}},
+		Executor: fv1.ExecutorTypePoolmgr,
+	}
+	gp.fsCache.PodToFsvc.Store(pod.Name, fsvc)
+	gp.fsCache.AddFunc(ctx, *fsvc, fn.GetRequestPerPod())
+	gp.fsCache.MarkAvailable(crd.CacheKey(&fn.ObjectMeta), fsvc.Address)
+	return fn, pod
+}
+
+// checkRespecialized checks that the function of a disrupted pod is served
+// by another pod, the disrupted one being deleted afterwards.
+func checkRespecialized(ctx context.Context, t *testing.T, gp *GenericPool, fn *fv1.Function, pod *apiv1.Pod) {
+	t.Helper()
+	var got *fscache.FuncSvc
+	var err error
+	err = wait.PollImmediateWithContext(ctx, 10*time.Millisecond, 5*time.Second, func(ctx context.Context) (bool, error) {
+		got, err = gp.fsCache.GetFuncSvc(ctx, &fn.ObjectMeta, fn.GetRequestPerPod(), 0)
+		return err == nil && got.Name != pod.Name, nil
+	})
+	if err != nil {
+		t.Fatalf("function wasn't re-specialized: %v, got %v", err, got)
+	}
+	if got.Name != "ready" {
+		t.Errorf("function re-specialized on pod %q, want %q", got.Name, "ready")
+	}
+	err = wait.PollImmediateWithContext(ctx, 10*time.Millisecond, 5*time.Second, func(ctx context.Context) (bool, error) {
+		_, err := gp.kubernetesClient.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		return k8serrors.IsNotFound(err), nil
+	})
+	if err != nil {
+		t.Errorf("disrupted pod wasn't deleted: %v", err)
+	}
+}
+
+func TestNodeUnavailable(t *testing.T) {
+	tests := []struct {
+		name string
+		node apiv1.Node
+		want bool
+	}{
+		{"ready", apiv1.Node{Status: apiv1.NodeStatus{Conditions: []apiv1.NodeCondition{
+			{Type: apiv1.NodeReady, Status: apiv1.ConditionTrue},
+		}}}, false},
+		{"no conditions", apiv1.Node{}, false},
+		{"cordoned", apiv1.Node{
+			Spec: apiv1.NodeSpec{Unschedulable: true},
+			Status: apiv1.NodeStatus{Conditions: []apiv1.NodeCondition{
+				{Type: apiv1.NodeReady, Status: apiv1.ConditionTrue},
+			}},
+		}, true},
+		{"not ready", apiv1.Node{Status: apiv1.NodeStatus{Conditions: []apiv1.NodeCondition{
+			{Type: apiv1.NodeMemoryPressure, Status: apiv1.ConditionFalse},
+			{Type: apiv1.NodeReady, Status: apiv1.ConditionFalse},
+		}}}, true},
+		{"unreachable", apiv1.Node{Status: apiv1.NodeStatus{Conditions: []apiv1.NodeCondition{
+			{Type: apiv1.NodeReady, Status: apiv1.ConditionUnknown},
+		}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nodeUnavailable(&tt.node); got != tt.want {
+				t.Errorf("nodeUnavailable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestHandleUnavailableNode cordons the node of a specialized pod, whose
+// function moves to another pod before the node is drained.
+func TestHandleUnavailableNode(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ts, host, port := fakeFetcher(t)
+	defer ts.Close()
+	gp := newTestPool(t, newTestPod("ready", host, true))
+	defer gp.readyPodQueue.ShutDown()
+	cfg, err := fetcherConfig.MakeFetcherConfig("/userfunc")
+	if err != nil {
+		t.Fatalf("Error creating fetcher config: %v", err)
+	}
+	gp.fetcherConfig, gp.fetcherPort = cfg, port
+	fn, pod := newDisruptedTestPod(ctx, t, gp)
+	other := newSpecializedTestPod("elsewhere", "10.0.0.3")
+	other.Spec.NodeName = "node-2"
+	gp.specializedPods = newTestPodLister(t, pod, other)
+
+	node := &apiv1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}, Spec: apiv1.NodeSpec{Unschedulable: true}}
+	gp.handleUnavailableNode(ctx, node)
+	checkRespecialized(ctx, t, gp, fn, pod)
+}
+
+// TestHandleSpecializedPodOnUnavailableNode updates a specialized pod on a
+// node which is known to be NotReady, e.g. a pod which got ready after its
+// node went away.
+func TestHandleSpecializedPodOnUnavailableNode(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ts, host, port := fakeFetcher(t)
+	defer ts.Close()
+	gp := newTestPool(t, newTestPod("ready", host, true))
+	defer gp.readyPodQueue.ShutDown()
+	cfg, err := fetcherConfig.MakeFetcherConfig("/userfunc")
+	if err != nil {
+		t.Fatalf("Error creating fetcher config: %v", err)
+	}
+	gp.fetcherConfig, gp.fetcherPort = cfg, port
+	fn, pod := newDisruptedTestPod(ctx, t, gp)
+
+	// without a node watch, the pod is left alone
+	gp.handleSpecializedPod(ctx, pod)
+	if _, ok := gp.fsCache.PodToFsvc.Load(pod.Name); !ok {
+		t.Fatal("function service of a pod on an unknown node was evicted")
+	}
+
+	nodes := k8sCache.NewIndexer(k8sCache.MetaNamespaceKeyFunc, k8sCache.Indexers{})
+	err = nodes.Add(&apiv1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status: apiv1.NodeStatus{Conditions: []apiv1.NodeCondition{
+			{Type: apiv1.NodeReady, Status: apiv1.ConditionFalse},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Error adding node: %v", err)
+	}
+	gp.nodeLister = corelisters.NewNodeLister(nodes)
+	gp.handleSpecializedPod(ctx, pod)
+	checkRespecialized(ctx, t, gp, fn, pod)
+}
+
+func TestChoosePodSkipsUnavailableNode(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cordoned := newTestPod("cordoned", "10.0.0.1", true)
+	cordoned.Spec.NodeName = "node-1"
+	gp := newTestPool(t, cordoned, newTestPod("ready", "10.0.0.2", true))
+	defer gp.readyPodQueue.ShutDown()
+	nodes := k8sCache.NewIndexer(k8sCache.MetaNamespaceKeyFunc, k8sCache.Indexers{})
+	err := nodes.Add(&apiv1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Spec:       apiv1.NodeSpec{Unschedulable: true},
+	})
+	if err != nil {
+		t.Fatalf("Error adding node: %v", err)
+	}
+	gp.nodeLister = corelisters.NewNodeLister(nodes)
+
+	fn := &metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}
+	_, pod, err := gp.choosePod(ctx, gp.labelsForFunction(fn))
+	if err != nil {
+		t.Fatalf("Error choosing pod: %v", err)
+	}
+	if pod.Name != "ready" {
+		t.Fatalf("chose pod %q, want %q", pod.Name, "ready")
+	}
+	// the pod is kept in the pool for its node to recover
+	got, err := gp.kubernetesClient.CoreV1().Pods(cordoned.Namespace).Get(ctx, cordoned.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting pod: %v", err)
+	}
+	if got.Labels[fv1.MANAGED] != "true" {
+		t.Errorf("pod on an unavailable node was claimed")
+	}
+}
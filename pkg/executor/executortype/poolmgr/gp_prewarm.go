@@ -0,0 +1,138 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/crd"
+)
+
+// PreWarm specializes count pods for the function ahead of its requests.
+// The function services are cached as available, so requests for the
+// function are served by the pre-warmed pods without a cold start. The
+// idle object reaper keeps count idle pods of the function and reclaims
+// any idle pods above it. Functions with a service of their own get a
+// single pod, see preWarmPods.
+func (gp *GenericPool) PreWarm(ctx context.Context, fn *fv1.Function, count int) error {
+	if count <= 0 {
+		gp.prewarmed.Delete(crd.CacheKeyUID(&fn.ObjectMeta))
+		gp.preWarmedFunctions.Delete(crd.CacheKeyUID(&fn.ObjectMeta))
+		return nil
+	}
+	count = gp.preWarmPods(&fn.ObjectMeta, count)
+	gp.prewarmed.Store(crd.CacheKeyUID(&fn.ObjectMeta), count)
+	// wait for the pool to have the pods ready rather than specializing
+	// them one by one as they become ready
+	minReady := int32(count)
+	if poolsize := getEnvPoolSize(gp.env); poolsize < minReady {
+		minReady = poolsize
+	}
+	err := gp.waitForReadyPods(ctx, minReady)
+	if err != nil {
+		return errors.Wrapf(err, "error pre-warming function %s", fn.ObjectMeta.Name)
+	}
+	for i := 0; i < count; i++ {
+		fsvc, err := gp.getFuncSvc(ctx, fn)
+		if err != nil {
+			return errors.Wrapf(err, "error pre-warming pod %d of %d for function %s", i+1, count, fn.ObjectMeta.Name)
+		}
+		// getFuncSvc counts its caller as an active request of the pod
+		gp.fsCache.MarkAvailable(crd.CacheKey(fsvc.Function), fsvc.Address)
+	}
+	gp.preWarmedFunctions.Store(crd.CacheKeyUID(&fn.ObjectMeta), metav1.ObjectMeta{
+		Name:      fn.ObjectMeta.Name,
+		Namespace: fn.ObjectMeta.Namespace,
+		UID:       fn.ObjectMeta.UID,
+	})
+	return nil
+}
+
+// preWarmPods returns how many of count pods the pool can pre-warm for the
+// function. A function with a service of its own is served by the one pod
+// the service selects, specializing another would fail to create the
+// service again, so it's pre-warmed with a single pod.
+func (gp *GenericPool) preWarmPods(fn *metav1.ObjectMeta, count int) int {
+	if count > 1 && gp.usesFunctionSvc(fn) {
+		return 1
+	}
+	return count
+}
+
+// getPreWarmCount returns the number of pods to pre-warm for a function
+// annotated with fv1.ANNOTATION_PREWARM, 0 if it isn't annotated.
+func getPreWarmCount(fn *metav1.ObjectMeta) (int, error) {
+	value, ok := fn.Annotations[fv1.ANNOTATION_PREWARM]
+	if !ok {
+		return 0, nil
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 0 {
+		return 0, fv1.MakeValidationErr(fv1.ErrorInvalidValue, fv1.ANNOTATION_PREWARM, value,
+			"must be a non-negative number of pods")
+	}
+	return count, nil
+}
+
+// preWarmFunctions pre-warms the functions annotated with
+// fv1.ANNOTATION_PREWARM once the pool has enough pods ready for all of
+// them. It's run in the background after the pool is created, so failures
+// are logged and the function is cold started by its first request instead.
+func (gp *GenericPool) preWarmFunctions(ctx context.Context, fns []*fv1.Function) {
+	counts := make([]int, len(fns))
+	var total int32
+	for i, fn := range fns {
+		count, err := getPreWarmCount(&fn.ObjectMeta)
+		if err != nil {
+			gp.logger.Error("invalid pre-warm annotation", zap.String("function", fn.ObjectMeta.Name),
+				zap.String("namespace", fn.ObjectMeta.Namespace), zap.Error(err))
+			continue
+		}
+		counts[i] = gp.preWarmPods(&fn.ObjectMeta, count)
+		total += int32(counts[i])
+	}
+	if total == 0 {
+		return
+	}
+	minReady := total
+	if poolsize := getEnvPoolSize(gp.env); poolsize < minReady {
+		minReady = poolsize
+	}
+	err := gp.waitForReadyPods(ctx, minReady)
+	if err != nil {
+		gp.logger.Warn("pool has fewer pods ready than functions to pre-warm", zap.Int32("pods", minReady), zap.Error(err))
+	}
+	for i, fn := range fns {
+		if counts[i] == 0 {
+			continue
+		}
+		err := gp.PreWarm(ctx, fn, counts[i])
+		if err != nil {
+			gp.logger.Error("failed to pre-warm function", zap.String("function", fn.ObjectMeta.Name),
+				zap.String("namespace", fn.ObjectMeta.Namespace), zap.Error(err))
+			continue
+		}
+		gp.logger.Info("pre-warmed function", zap.String("function", fn.ObjectMeta.Name),
+			zap.String("namespace", fn.ObjectMeta.Namespace), zap.Int("pods", counts[i]))
+	}
+}
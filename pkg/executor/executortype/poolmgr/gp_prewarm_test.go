@@ -0,0 +1,191 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/executor/fscache"
+	fetcherConfig "github.com/fission/fission/pkg/fetcher/config"
+)
+
+func TestPreWarm(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ts, _, port := fakeFetcher(t)
+	defer ts.Close()
+	// pods need distinct addresses to be cached as distinct function services
+	gp := newTestPool(t, newTestPod("warm-1", "127.0.0.1", true), newTestPod("warm-2", "localhost", true))
+	defer gp.readyPodQueue.ShutDown()
+	cfg, err := fetcherConfig.MakeFetcherConfig("/userfunc")
+	if err != nil {
+		t.Fatalf("Error creating fetcher config: %v", err)
+	}
+	gp.fetcherConfig, gp.fetcherPort = cfg, port
+	gp.fsCache = fscache.MakeFunctionServiceCache(gp.logger)
+
+	fn := &fv1.Function{ObjectMeta: metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}}
+	err = gp.PreWarm(ctx, fn, 2)
+	if err != nil {
+		t.Fatalf("Error pre-warming function: %v", err)
+	}
+	if keep, ok := gp.prewarmed.Load("fn-uid"); !ok || keep.(int) != 2 {
+		t.Errorf("pods kept warm = %v, want 2", keep)
+	}
+	if gp.readyPodQueue.Len() != 0 {
+		t.Errorf("found %d generic pods left in the queue, want none", gp.readyPodQueue.Len())
+	}
+
+	// both pre-warmed pods serve requests without specializing another pod
+	for i := 0; i < 2; i++ {
+		fsvc, err := gp.fsCache.GetFuncSvc(ctx, &fn.ObjectMeta, 1, 0)
+		if err != nil {
+			t.Fatalf("Error getting pre-warmed function service: %v", err)
+		}
+		if fsvc.Name != "warm-1" && fsvc.Name != "warm-2" {
+			t.Errorf("function service uses pod %q, want a pre-warmed pod", fsvc.Name)
+		}
+	}
+}
+
+func TestPreWarmFunctionSvc(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ts, host, port := fakeFetcher(t)
+	defer ts.Close()
+	gp := newTestPool(t, newTestPod("warm-1", host, true), newTestPod("warm-2", host, true))
+	defer gp.readyPodQueue.ShutDown()
+	cfg, err := fetcherConfig.MakeFetcherConfig("/userfunc")
+	if err != nil {
+		t.Fatalf("Error creating fetcher config: %v", err)
+	}
+	gp.fetcherConfig, gp.fetcherPort = cfg, port
+	gp.fsCache = fscache.MakeFunctionServiceCache(gp.logger)
+	gp.useSvc = true
+	createTestEndpoints(t, gp, "svc-fn-fn-uid", host)
+
+	// the service of the function selects a single pod, a second one
+	// would fail to create it again
+	fn := &fv1.Function{ObjectMeta: metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}}
+	err = gp.PreWarm(ctx, fn, 2)
+	if err != nil {
+		t.Fatalf("Error pre-warming function: %v", err)
+	}
+	if keep, ok := gp.prewarmed.Load("fn-uid"); !ok || keep.(int) != 1 {
+		t.Errorf("pods kept warm = %v, want 1", keep)
+	}
+	if n := gp.readyPodQueue.Len(); n != 1 {
+		t.Errorf("found %d generic pods left in the queue, want 1", n)
+	}
+	svcs, err := gp.kubernetesClient.CoreV1().Services(gp.fnNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Error listing services: %v", err)
+	}
+	if len(svcs.Items) != 1 {
+		t.Errorf("found %d services, want 1", len(svcs.Items))
+	}
+}
+
+func TestPreWarmFunctions(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ts, _, port := fakeFetcher(t)
+	defer ts.Close()
+	gp := newTestPool(t, newTestPod("warm-1", "127.0.0.1", true), newTestPod("warm-2", "localhost", true))
+	defer gp.readyPodQueue.ShutDown()
+	cfg, err := fetcherConfig.MakeFetcherConfig("/userfunc")
+	if err != nil {
+		t.Fatalf("Error creating fetcher config: %v", err)
+	}
+	gp.fetcherConfig, gp.fetcherPort = cfg, port
+	gp.fsCache = fscache.MakeFunctionServiceCache(gp.logger)
+
+	fn := func(name string, prewarm string) *fv1.Function {
+		f := &fv1.Function{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: metav1.NamespaceDefault, UID: k8stypes.UID(name + "-uid")}}
+		if len(prewarm) > 0 {
+			f.ObjectMeta.Annotations = map[string]string{fv1.ANNOTATION_PREWARM: prewarm}
+		}
+		return f
+	}
+	gp.preWarmFunctions(ctx, []*fv1.Function{fn("warm", "1"), fn("cold", ""), fn("invalid", "many"), fn("other", "1")})
+
+	status, err := gp.Status(ctx)
+	if err != nil {
+		t.Fatalf("Error getting pool status: %v", err)
+	}
+	var got []string
+	for _, f := range status.PreWarmedFunctions {
+		got = append(got, f.Name)
+	}
+	sort.Strings(got)
+	if want := []string{"other", "warm"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pre-warmed functions = %v, want %v", got, want)
+	}
+}
+
+func TestPreWarmFunctionsFunctionSvc(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ts, host, port := fakeFetcher(t)
+	defer ts.Close()
+	gp := newTestPool(t, newTestPod("warm-1", host, true), newTestPod("warm-2", host, true))
+	defer gp.readyPodQueue.ShutDown()
+	cfg, err := fetcherConfig.MakeFetcherConfig("/userfunc")
+	if err != nil {
+		t.Fatalf("Error creating fetcher config: %v", err)
+	}
+	gp.fetcherConfig, gp.fetcherPort = cfg, port
+	gp.fsCache = fscache.MakeFunctionServiceCache(gp.logger)
+	gp.useSvc = true
+	createTestEndpoints(t, gp, "svc-a-a-uid", host)
+	createTestEndpoints(t, gp, "svc-b-b-uid", host)
+
+	// each function gets one of the two pods, the pool doesn't wait for
+	// the four pods the annotations ask for
+	fns := []*fv1.Function{
+		{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: metav1.NamespaceDefault, UID: "a-uid",
+			Annotations: map[string]string{fv1.ANNOTATION_PREWARM: "2"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "b", Namespace: metav1.NamespaceDefault, UID: "b-uid",
+			Annotations: map[string]string{fv1.ANNOTATION_PREWARM: "2"}}},
+	}
+	gp.preWarmFunctions(ctx, fns)
+
+	status, err := gp.Status(ctx)
+	if err != nil {
+		t.Fatalf("Error getting pool status: %v", err)
+	}
+	var got []string
+	for _, f := range status.PreWarmedFunctions {
+		got = append(got, f.Name)
+	}
+	sort.Strings(got)
+	if want := []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pre-warmed functions = %v, want %v", got, want)
+	}
+}
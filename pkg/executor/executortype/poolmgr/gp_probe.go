@@ -0,0 +1,119 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+// specializeProbePollInterval is the interval the runtime of a specialized
+// pod is checked at until it's able to serve the function.
+const specializeProbePollInterval = 100 * time.Millisecond
+
+// waitForGRPCServing waits until the gRPC server at address reports the
+// overall health of the server as SERVING, or ctx is done. A server that
+// isn't up yet or doesn't report SERVING is checked again, one that doesn't
+// implement the health protocol fails right away.
+func waitForGRPCServing(ctx context.Context, address string) error {
+	conn, err := grpc.DialContext(ctx, address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	client := healthpb.NewHealthClient(conn)
+	var lastErr error
+	err = wait.PollImmediateUntilWithContext(ctx, specializeProbePollInterval, func(ctx context.Context) (bool, error) {
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+		if status.Code(err) == codes.Unimplemented {
+			return false, err
+		}
+		if err != nil {
+			lastErr = err
+			return false, nil
+		}
+		if resp.Status != healthpb.HealthCheckResponse_SERVING {
+			lastErr = errors.Errorf("gRPC server reports %v", resp.Status)
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil && ctx.Err() != nil {
+		// report the timeout rather than the polling error
+		err = ctx.Err()
+	}
+	if err != nil && lastErr != nil {
+		return errors.Wrap(err, lastErr.Error())
+	}
+	return err
+}
+
+// getHTTPProbe returns the path and status of the HTTP probe of the
+// runtimes of the environment, an empty path if there's none. The
+// annotations are validated when the pool is created.
+func getHTTPProbe(env *fv1.Environment) (string, int) {
+	path := env.ObjectMeta.Annotations[fv1.ANNOTATION_SPECIALIZE_PROBE_PATH]
+	code, err := strconv.Atoi(env.ObjectMeta.Annotations[fv1.ANNOTATION_SPECIALIZE_PROBE_STATUS])
+	if err != nil {
+		code = http.StatusOK
+	}
+	return path, code
+}
+
+// waitForHTTPStatus waits until GET requests of url return code, or ctx is
+// done. Connection errors and other statuses are retried.
+func waitForHTTPStatus(ctx context.Context, url string, code int) error {
+	var lastErr error
+	err := wait.PollImmediateUntilWithContext(ctx, specializeProbePollInterval, func(ctx context.Context) (bool, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return false, err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			lastErr = err
+			return false, nil
+		}
+		resp.Body.Close()
+		if resp.StatusCode != code {
+			lastErr = errors.Errorf("runtime returned status %d, want %d", resp.StatusCode, code)
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil && ctx.Err() != nil {
+		// report the timeout rather than the polling error
+		err = ctx.Err()
+	}
+	if err != nil && lastErr != nil {
+		return errors.Wrap(err, lastErr.Error())
+	}
+	return err
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	fetcherConfig "github.com/fission/fission/pkg/fetcher/config"
+)
+
+func TestWaitForGRPCServing(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	// a server which doesn't become ready times out
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	err = waitForGRPCServing(ctx, lis.Addr().String())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	time.AfterFunc(200*time.Millisecond, func() {
+		healthServer.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	})
+	err = waitForGRPCServing(ctx, lis.Addr().String())
+	if err != nil {
+		t.Fatalf("Error waiting for gRPC server: %v", err)
+	}
+}
+
+func TestSpecializePodHTTPProbe(t *testing.T) {
+	ts, host, port := fakeFetcher(t)
+	defer ts.Close()
+	var probes atomic.Int32
+	runtime := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			http.NotFound(w, r)
+			return
+		}
+		// the function can't serve the first requests after specializing
+		if probes.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer runtime.Close()
+	u, err := url.Parse(runtime.URL)
+	if err != nil {
+		t.Fatalf("Error parsing runtime url: %v", err)
+	}
+	runtimePort, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatalf("Error parsing runtime port: %v", err)
+	}
+
+	pod := newTestPod("ready", host, true)
+	gp := newTestPool(t, pod)
+	defer gp.readyPodQueue.ShutDown()
+	cfg, err := fetcherConfig.MakeFetcherConfig("/userfunc")
+	if err != nil {
+		t.Fatalf("Error creating fetcher config: %v", err)
+	}
+	gp.fetcherConfig, gp.fetcherPort, gp.runtimePort = cfg, port, int32(runtimePort)
+	gp.env.ObjectMeta.Annotations = map[string]string{
+		fv1.ANNOTATION_SPECIALIZE_PROBE_PATH:   "/healthz",
+		fv1.ANNOTATION_SPECIALIZE_PROBE_STATUS: "204",
+	}
+	fn := &fv1.Function{ObjectMeta: metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = gp.specializePod(ctx, pod, fn, nil)
+	if err != nil {
+		t.Fatalf("Error specializing pod: %v", err)
+	}
+	if n := probes.Load(); n != 3 {
+		t.Errorf("runtime probed %d times, want 3", n)
+	}
+
+	// a runtime which never returns the status fails the load stage
+	gp.env.ObjectMeta.Annotations[fv1.ANNOTATION_SPECIALIZE_PROBE_PATH] = "/missing"
+	gp.specializeTimeout = 300 * time.Millisecond
+	err = gp.specializePod(ctx, pod, fn, nil)
+	if !errors.Is(err, ErrSpecializeTimeout) {
+		t.Fatalf("got error %v, want %v", err, ErrSpecializeTimeout)
+	}
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	apiv1 "k8s.io/api/core/v1"
+	k8s_err "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	fetcherClient "github.com/fission/fission/pkg/fetcher/client"
+)
+
+const podResetTimeout = 10 * time.Second
+
+// releasePod un-specializes a pod and hands it back to the pool instead of
+// deleting it. The fetcher is asked to wipe the function, secrets and config
+// maps it fetched on /reset, since it wouldn't fetch the next function
+// written under the same name and the next function mustn't read them. The
+// runtime is asked to unload the function on /reset too, then the function
+// labels are removed so that the pool deployment selects the pod again and
+// it can be chosen for another function. If any step fails, the pod is
+// deleted and the deployment replaces it with a clean one.
+// Adopted pods are deleted too, the deployment could otherwise take them
+// over once they carry the pool labels.
+func (gp *GenericPool) releasePod(ctx context.Context, pod *apiv1.Pod) error {
+	logger := gp.logger.With(zap.String("pod", pod.ObjectMeta.Name), zap.String("podNamespace", pod.ObjectMeta.Namespace))
+	defer func() {
+		gp.podFSVCMap.Delete(pod.ObjectMeta.Name)
+		if gp.fsCache != nil {
+			gp.fsCache.PodToFsvc.Delete(pod.ObjectMeta.Name)
+		}
+	}()
+
+	var err error
+	if gp.wasAdopted(pod) {
+		err = errors.New("adopted pods are not released")
+	} else {
+		err = gp.wipePod(ctx, pod)
+		if err == nil {
+			err = gp.resetPod(ctx, pod)
+		}
+	}
+	if err == nil {
+		err = gp.unclaimPod(ctx, pod.ObjectMeta.Namespace, pod.ObjectMeta.Name)
+	}
+	if err == nil {
+		logger.Info("released pod back to the pool")
+		return nil
+	}
+
+	logger.Warn("failed to release pod, deleting it", zap.Error(err))
+	delErr := gp.kubernetesClient.CoreV1().Pods(pod.ObjectMeta.Namespace).Delete(ctx, pod.ObjectMeta.Name, metav1.DeleteOptions{})
+	if delErr != nil && !k8s_err.IsNotFound(delErr) {
+		return fmt.Errorf("%w: %w", err, delErr)
+	}
+	return err
+}
+
+// wipePod asks the fetcher of a specialized pod to remove everything it
+// fetched for the function.
+func (gp *GenericPool) wipePod(ctx context.Context, pod *apiv1.Pod) error {
+	ctx, cancel := context.WithTimeout(ctx, podResetTimeout)
+	defer cancel()
+	err := fetcherClient.MakeClient(gp.logger, gp.getFetcherURL(pod.Status.PodIP)).
+		WithAuthToken(gp.fetcherConfig.AuthToken()).
+		Reset(ctx)
+	return errors.Wrapf(err, "error wiping pod %s", pod.ObjectMeta.Name)
+}
+
+// resetPod asks the runtime of a specialized pod to unload its function.
+func (gp *GenericPool) resetPod(ctx context.Context, pod *apiv1.Pod) error {
+	ctx, cancel := context.WithTimeout(ctx, podResetTimeout)
+	defer cancel()
+	resetURL := fmt.Sprintf("http://%s:%d/reset", pod.Status.PodIP, gp.runtimePort)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, resetURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "error resetting pod %s", pod.ObjectMeta.Name)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return errors.Errorf("error resetting pod %s: runtime replied %s", pod.ObjectMeta.Name, resp.Status)
+	}
+	return nil
+}
+
+// unclaimPod is the reverse of claimPod: it removes the function labels and
+// annotations from a pod and labels it managed by the pool deployment again.
+func (gp *GenericPool) unclaimPod(ctx context.Context, namespace, name string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pod, err := gp.kubernetesClient.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if pod.DeletionTimestamp != nil {
+			return errors.Errorf("pod %s is being deleted", name)
+		}
+		for _, label := range []string{fv1.FUNCTION_NAME, fv1.FUNCTION_UID, fv1.FUNCTION_NAMESPACE} {
+			delete(pod.Labels, label)
+		}
+		for k, v := range gp.getEnvironmentPoolLabels(gp.env) {
+			pod.Labels[k] = v
+		}
+		delete(pod.Annotations, fv1.ANNOTATION_SVC_HOST)
+		delete(pod.Annotations, fv1.FUNCTION_RESOURCE_VERSION)
+		_, err = gp.kubernetesClient.CoreV1().Pods(namespace).Update(ctx, pod, metav1.UpdateOptions{})
+		return err
+	})
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	fetcherConfig "github.com/fission/fission/pkg/fetcher/config"
+)
+
+func TestReleasePod(t *testing.T) {
+	tests := []struct {
+		name        string
+		wipeStatus  int
+		resetStatus int
+		wantErr     bool
+	}{
+		{"pod reset", http.StatusOK, http.StatusOK, false},
+		{"runtime can't reset", http.StatusOK, http.StatusNotFound, true},
+		{"fetcher can't wipe", http.StatusNotFound, http.StatusOK, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			var wiped, reset bool
+			fetcherHost, fetcherPort := resetServer(t, "fetcher", &wiped, tt.wipeStatus)
+			runtimeHost, runtimePort := resetServer(t, "runtime", &reset, tt.resetStatus)
+			if fetcherHost != runtimeHost {
+				t.Fatalf("fetcher and runtime served on %s and %s", fetcherHost, runtimeHost)
+			}
+
+			gp := newTestPool(t)
+			defer gp.readyPodQueue.ShutDown()
+			cfg, err := fetcherConfig.MakeFetcherConfig("/userfunc")
+			if err != nil {
+				t.Fatalf("Error creating fetcher config: %v", err)
+			}
+			gp.fetcherConfig = cfg
+			gp.fetcherPort, gp.runtimePort = fetcherPort, runtimePort
+			fn := &metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}
+			pod := newTestPod("specialized", fetcherHost, true)
+			for k, v := range gp.labelsForFunction(fn) {
+				pod.Labels[k] = v
+			}
+			pod.Annotations = map[string]string{fv1.ANNOTATION_SVC_HOST: "10.0.0.1:8888"}
+			pod, err = gp.kubernetesClient.CoreV1().Pods(pod.Namespace).Create(ctx, pod, metav1.CreateOptions{})
+			if err != nil {
+				t.Fatalf("Error creating pod: %v", err)
+			}
+
+			err = gp.releasePod(ctx, pod)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("releasePod() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !wiped {
+				t.Error("fetcher wasn't asked to wipe the pod")
+			}
+
+			got, err := gp.kubernetesClient.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+			if tt.wantErr {
+				if !k8serrors.IsNotFound(err) {
+					t.Errorf("got pod %v, error %v, want the pod deleted", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Error getting pod: %v", err)
+			}
+			if !reset {
+				t.Error("runtime wasn't asked to reset the pod")
+			}
+			if got.Labels[fv1.MANAGED] != "true" || got.Labels[fv1.FUNCTION_NAME] != "" || got.Labels[fv1.FUNCTION_UID] != "" {
+				t.Errorf("pod labels = %v, want the pool labels only", got.Labels)
+			}
+			if _, ok := got.Annotations[fv1.ANNOTATION_SVC_HOST]; ok {
+				t.Errorf("pod annotations = %v, want the service host removed", got.Annotations)
+			}
+		})
+	}
+}
+
+// resetServer serves /reset with the given status for the fetcher or the
+// runtime of a pod, setting called once it's requested.
+func resetServer(t *testing.T, container string, called *bool, status int) (string, int32) {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/reset" {
+			t.Errorf("got %s request to %s, want /reset", container, r.URL.Path)
+		}
+		*called = true
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(ts.Close)
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("Error parsing %s url: %v", container, err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatalf("Error parsing %s port: %v", container, err)
+	}
+	return u.Hostname(), int32(port)
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/executor/fscache"
+	"github.com/fission/fission/pkg/utils"
+)
+
+// defaultReadyPodsPollInterval is how often the ready pods of a pool are
+// checked while waiting for them, see GenericPool.readyPodsPollInterval.
+const defaultReadyPodsPollInterval = 100 * time.Millisecond
+
+type (
+	// PoolStatus is a snapshot of the health of a pool
+	PoolStatus struct {
+		DesiredReplicas int32 `json:"desiredReplicas"`
+		ReadyReplicas   int32 `json:"readyReplicas"`
+		SpecializedPods int32 `json:"specializedPods"`
+		ActiveRequests  int32 `json:"activeRequests"`
+		// LastColdStart is when a request last had a pod specialized for
+		// it, unset before the first cold start.
+		LastColdStart *metav1.Time `json:"lastColdStart,omitempty"`
+		// NoReadyPodsSince is when the pool ran out of ready pods, unset
+		// while it has some.
+		NoReadyPodsSince *metav1.Time `json:"noReadyPodsSince,omitempty"`
+		// LastColdStartDuration is how long getting the pod of the last
+		// cold start specialized took.
+		LastColdStartDuration *metav1.Duration `json:"lastColdStartDuration,omitempty"`
+		// PreWarmedFunctions are the functions pods were specialized for
+		// ahead of their requests.
+		PreWarmedFunctions []metav1.ObjectMeta `json:"preWarmedFunctions,omitempty"`
+		// Specializing is the number of pods being specialized, at most
+		// MaxSpecializing unless it's 0.
+		Specializing    int32 `json:"specializing"`
+		MaxSpecializing int32 `json:"maxSpecializing,omitempty"`
+		// SpecializedPodMaxAge is how long a specialized pod serves its
+		// function before it's recycled, unset if pods aren't recycled.
+		SpecializedPodMaxAge *metav1.Duration `json:"specializedPodMaxAge,omitempty"`
+		// FallbackGeneration is the previous generation of pods the pool
+		// uses while none of the current generation turned ready.
+		FallbackGeneration string `json:"fallbackGeneration,omitempty"`
+	}
+
+	// SpecializedPod is a pod of the pool specialized for a function
+	SpecializedPod struct {
+		Name     string            `json:"name"`
+		Function metav1.ObjectMeta `json:"function"`
+		// SpecializedAt is unset for pods specialized before the executor
+		// restarted.
+		SpecializedAt *metav1.Time `json:"specializedAt,omitempty"`
+		// ColdStartDuration is how long getting the pod specialized
+		// took, unset for pods specialized before the executor restarted.
+		ColdStartDuration *metav1.Duration `json:"coldStartDuration,omitempty"`
+	}
+)
+
+// Status returns the desired and ready generic pods of the pool and since
+// when it has none, the specialized pods of the environment, the requests in
+// flight to them, when a pod was last specialized and how long it took, the
+// pre-warmed functions, the pods being specialized and how long specialized
+// pods live.
+func (gp *GenericPool) Status(ctx context.Context) (*PoolStatus, error) {
+	status := &PoolStatus{}
+	if deployment := gp.deployment.Load(); deployment != nil && deployment.Spec.Replicas != nil {
+		status.DesiredReplicas = *deployment.Spec.Replicas
+	}
+
+	if gp.readyPodLister != nil {
+		readyPods, err := gp.readyPodCount()
+		if err != nil {
+			return nil, err
+		}
+		status.ReadyReplicas = readyPods
+	}
+	if since := gp.noReadyPodsSince.Load(); since > 0 {
+		status.NoReadyPodsSince = &metav1.Time{Time: time.Unix(0, since)}
+	}
+
+	specializedPods, err := gp.ListSpecializedPods(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status.SpecializedPods = int32(len(specializedPods))
+
+	if gp.fsCache != nil {
+		status.ActiveRequests = int32(gp.fsCache.ActiveRequests(gp.env.ObjectMeta.UID))
+	}
+
+	if lastColdStart := gp.lastColdStart.Load(); lastColdStart > 0 {
+		status.LastColdStart = &metav1.Time{Time: time.Unix(0, lastColdStart)}
+		status.LastColdStartDuration = &metav1.Duration{Duration: time.Duration(gp.lastColdStartDuration.Load())}
+	}
+
+	status.Specializing = gp.specializing.Load()
+	status.MaxSpecializing = int32(cap(gp.specializeSlots))
+	if gp.specializedPodMaxAge > 0 {
+		status.SpecializedPodMaxAge = &metav1.Duration{Duration: gp.specializedPodMaxAge}
+	}
+	status.FallbackGeneration = gp.fallbackGeneration()
+
+	gp.preWarmedFunctions.Range(func(_, fn interface{}) bool {
+		status.PreWarmedFunctions = append(status.PreWarmedFunctions, fn.(metav1.ObjectMeta))
+		return true
+	})
+	return status, nil
+}
+
+// ListSpecializedPods returns the active pods of the pool specialized for a
+// function, along with the function, when the pod was specialized and how
+// long that took.
+func (gp *GenericPool) ListSpecializedPods(ctx context.Context) ([]SpecializedPod, error) {
+	selector := labels.SelectorFromSet(map[string]string{
+		fv1.EXECUTOR_TYPE:   string(fv1.ExecutorTypePoolmgr),
+		fv1.ENVIRONMENT_UID: string(gp.env.ObjectMeta.UID),
+		fv1.MANAGED:         "false",
+	})
+	pods, err := gp.kubernetesClient.CoreV1().Pods(gp.fnNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var specializedPods []SpecializedPod
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if !IsPodActive(pod) {
+			continue
+		}
+		specializedPod := SpecializedPod{
+			Name: pod.ObjectMeta.Name,
+			Function: metav1.ObjectMeta{
+				Name:      pod.Labels[fv1.FUNCTION_NAME],
+				Namespace: pod.Labels[fv1.FUNCTION_NAMESPACE],
+				UID:       types.UID(pod.Labels[fv1.FUNCTION_UID]),
+			},
+		}
+		if gp.fsCache != nil {
+			if fsvc, ok := gp.fsCache.PodToFsvc.Load(pod.ObjectMeta.Name); ok {
+				if fsvc, ok := fsvc.(*fscache.FuncSvc); ok {
+					if !fsvc.Ctime.IsZero() {
+						specializedPod.SpecializedAt = &metav1.Time{Time: fsvc.Ctime}
+					}
+					if fsvc.ColdStartDuration > 0 {
+						specializedPod.ColdStartDuration = &metav1.Duration{Duration: fsvc.ColdStartDuration}
+					}
+				}
+			}
+		}
+		specializedPods = append(specializedPods, specializedPod)
+	}
+	return specializedPods, nil
+}
+
+// readyPodCount returns the number of generic pods ready to be specialized.
+func (gp *GenericPool) readyPodCount() (int32, error) {
+	pods, err := gp.readyPodLister.Pods(gp.fnNamespace).List(labels.Everything())
+	if err != nil {
+		return 0, err
+	}
+	var ready int32
+	for _, pod := range pods {
+		if utils.IsReadyPod(pod) && gp.isServingGenerationPod(pod) {
+			ready++
+		}
+	}
+	return ready, nil
+}
+
+// waitForReadyPods blocks until the pool has at least minReady generic pods
+// ready to be specialized, or the pod ready timeout expires.
+func (gp *GenericPool) waitForReadyPods(ctx context.Context, minReady int32) error {
+	err := wait.PollImmediateWithContext(ctx, gp.readyPodsPollInterval, gp.podReadyTimeout, func(ctx context.Context) (bool, error) {
+		ready, err := gp.readyPodCount()
+		if err != nil {
+			return false, err
+		}
+		return ready >= minReady, nil
+	})
+	if errors.Is(err, wait.ErrWaitTimeout) {
+		return errors.Wrapf(ErrPodReadyTimeout, "waiting for %d ready pods", minReady)
+	}
+	return err
+}
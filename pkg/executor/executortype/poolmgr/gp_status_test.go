@@ -0,0 +1,178 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/executor/fscache"
+)
+
+func TestPoolStatus(t *testing.T) {
+	ctx := context.Background()
+	gp := newTestPool(t,
+		newTestPod("ready-1", "10.0.0.1", true),
+		newTestPod("ready-2", "10.0.0.2", true),
+		newTestPod("not-ready", "10.0.0.3", false),
+	)
+	defer gp.readyPodQueue.ShutDown()
+	replicas := int32(3)
+	gp.deployment.Store(&appsv1.Deployment{Spec: appsv1.DeploymentSpec{Replicas: &replicas}})
+
+	fn := &metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}
+	specialized := newTestPod("specialized", "10.0.0.4", true)
+	specialized.Labels = gp.labelsForFunction(fn)
+	_, err := gp.kubernetesClient.CoreV1().Pods(specialized.Namespace).Create(ctx, specialized, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Error creating pod: %v", err)
+	}
+
+	gp.fsCache = fscache.MakeFunctionServiceCache(gp.logger)
+	fsvc := fscache.FuncSvc{Name: specialized.Name, Function: fn, Environment: gp.env, Address: "10.0.0.4:8888"}
+	gp.fsCache.AddFunc(ctx, fsvc, 2)
+	gp.fsCache.AddFunc(ctx, fsvc, 2)
+	other := &fv1.Environment{ObjectMeta: metav1.ObjectMeta{Name: "other", UID: "other-uid"}}
+	gp.fsCache.AddFunc(ctx, fscache.FuncSvc{Name: "other", Function: fn, Environment: other, Address: "10.0.0.5:8888"}, 2)
+
+	status, err := gp.Status(ctx)
+	if err != nil {
+		t.Fatalf("Error getting pool status: %v", err)
+	}
+	if status.DesiredReplicas != 3 || status.ReadyReplicas != 2 || status.SpecializedPods != 1 || status.ActiveRequests != 2 {
+		t.Errorf("status = %+v, want 3 desired, 2 ready, 1 specialized and 2 active requests", status)
+	}
+	if status.LastColdStart != nil || status.LastColdStartDuration != nil {
+		t.Errorf("last cold start = %v taking %v, want none before any specialization", status.LastColdStart, status.LastColdStartDuration)
+	}
+	data, err := json.Marshal(status)
+	if err != nil {
+		t.Fatalf("Error encoding pool status: %v", err)
+	}
+	if strings.Contains(string(data), "lastColdStart") {
+		t.Errorf("encoded status %s has a last cold start before any specialization", data)
+	}
+	if status.SpecializedPodMaxAge != nil {
+		t.Errorf("specialized pod max age = %v, want unset by default", status.SpecializedPodMaxAge)
+	}
+
+	gp.specializedPodMaxAge = time.Hour
+	status, err = gp.Status(ctx)
+	if err != nil {
+		t.Fatalf("Error getting pool status: %v", err)
+	}
+	if status.SpecializedPodMaxAge == nil || status.SpecializedPodMaxAge.Duration != time.Hour {
+		t.Errorf("specialized pod max age = %v, want 1h", status.SpecializedPodMaxAge)
+	}
+	if status.NoReadyPodsSince != nil {
+		t.Errorf("no ready pods since %v, want unset while the pool has ready pods", status.NoReadyPodsSince)
+	}
+
+	noReadyPodsSince := time.Now().Add(-time.Minute)
+	gp.noReadyPodsSince.Store(noReadyPodsSince.UnixNano())
+	status, err = gp.Status(ctx)
+	if err != nil {
+		t.Fatalf("Error getting pool status: %v", err)
+	}
+	if status.NoReadyPodsSince == nil || !status.NoReadyPodsSince.Time.Equal(noReadyPodsSince) {
+		t.Errorf("no ready pods since %v, want %v", status.NoReadyPodsSince, noReadyPodsSince)
+	}
+}
+
+func TestListSpecializedPods(t *testing.T) {
+	ctx := context.Background()
+	gp := newTestPool(t, newTestPod("ready", "10.0.0.1", true))
+	defer gp.readyPodQueue.ShutDown()
+	gp.fsCache = fscache.MakeFunctionServiceCache(gp.logger)
+
+	fn := &metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}
+	specialized := newTestPod("specialized", "10.0.0.2", true)
+	specialized.Labels = gp.labelsForFunction(fn)
+	_, err := gp.kubernetesClient.CoreV1().Pods(specialized.Namespace).Create(ctx, specialized, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Error creating pod: %v", err)
+	}
+	specializedAt := time.Now().Add(-time.Minute)
+	gp.fsCache.PodToFsvc.Store(specialized.Name, &fscache.FuncSvc{Name: specialized.Name, Function: fn, Ctime: specializedAt,
+		ColdStartDuration: 1500 * time.Millisecond})
+
+	pods, err := gp.ListSpecializedPods(ctx)
+	if err != nil {
+		t.Fatalf("Error listing specialized pods: %v", err)
+	}
+	if len(pods) != 1 {
+		t.Fatalf("got %d specialized pods, want 1", len(pods))
+	}
+	got := pods[0]
+	if got.Name != specialized.Name || got.Function.Name != fn.Name || got.Function.Namespace != fn.Namespace || got.Function.UID != fn.UID {
+		t.Errorf("got specialized pod %+v, want pod %q for function %s/%s", got, specialized.Name, fn.Namespace, fn.Name)
+	}
+	if got.SpecializedAt == nil || !got.SpecializedAt.Time.Equal(specializedAt) {
+		t.Errorf("specialized at %v, want %v", got.SpecializedAt, specializedAt)
+	}
+	if got.ColdStartDuration == nil || got.ColdStartDuration.Duration != 1500*time.Millisecond {
+		t.Errorf("cold start duration = %v, want 1.5s", got.ColdStartDuration)
+	}
+}
+
+func TestWaitForReadyPods(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	gp := newTestPool(t, newTestPod("ready", "10.0.0.1", true), newTestPod("not-ready", "10.0.0.2", false))
+	defer gp.readyPodQueue.ShutDown()
+	gp.podReadyTimeout = 300 * time.Millisecond
+
+	err := gp.waitForReadyPods(ctx, 1)
+	if err != nil {
+		t.Errorf("Error waiting for 1 ready pod: %v", err)
+	}
+	err = gp.waitForReadyPods(ctx, 2)
+	if !errors.Is(err, ErrPodReadyTimeout) {
+		t.Errorf("waiting for 2 ready pods returned %v, want %v", err, ErrPodReadyTimeout)
+	}
+}
+
+func TestReadyPodsPollInterval(t *testing.T) {
+	for _, tt := range []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", defaultReadyPodsPollInterval},
+		{"1s", time.Second},
+		{"10ms", 10 * time.Millisecond},
+		{"0s", defaultReadyPodsPollInterval},
+		{"fast", defaultReadyPodsPollInterval},
+	} {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("POOLMGR_READY_PODS_POLL_INTERVAL", tt.value)
+			gp := newTestPool(t)
+			defer gp.readyPodQueue.ShutDown()
+			if gp.readyPodsPollInterval != tt.want {
+				t.Errorf("poll interval = %v, want %v", gp.readyPodsPollInterval, tt.want)
+			}
+		})
+	}
+}
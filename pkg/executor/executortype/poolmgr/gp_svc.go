@@ -0,0 +1,322 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+const (
+	// staleSvcCleanupInterval is how often the pool looks for function
+	// services left without pods, see cleanupStaleServices
+	staleSvcCleanupInterval = 5 * time.Minute
+	// staleSvcGracePeriod spares services created moments ago, their pod
+	// may still be getting relabeled or replaced
+	staleSvcGracePeriod = time.Minute
+)
+
+// defaultSvcAnnotationPrefixes are the prefixes of the annotations
+// functions may set on their services unless the operator configures others
+// with POOLMGR_FUNCTION_SVC_ANNOTATION_PREFIXES: those of cloud load
+// balancers.
+var defaultSvcAnnotationPrefixes = []string{"service.beta.kubernetes.io/", "service.kubernetes.io/"}
+
+// functionSvcPolicy is what the operator allows functions to ask for on
+// their services, see getFunctionSvcSpec.
+type functionSvcPolicy struct {
+	// exposure allows NodePort and LoadBalancer services, which make
+	// functions reachable from outside of the cluster, and annotations on
+	// the services, which may change how the cluster routes to them
+	exposure           bool
+	annotationPrefixes []string // prefixes of the annotations functions may set when exposure is allowed
+}
+
+// makeFunctionSvcPolicy returns the policy configured with
+// POOLMGR_ALLOW_FUNCTION_SVC_EXPOSURE, off by default, and
+// POOLMGR_FUNCTION_SVC_ANNOTATION_PREFIXES, a comma separated list.
+func makeFunctionSvcPolicy(logger *zap.Logger) functionSvcPolicy {
+	policy := functionSvcPolicy{annotationPrefixes: defaultSvcAnnotationPrefixes}
+	if exposureStr := os.Getenv("POOLMGR_ALLOW_FUNCTION_SVC_EXPOSURE"); len(exposureStr) > 0 {
+		exposure, err := strconv.ParseBool(exposureStr)
+		if err != nil {
+			logger.Error("failed to parse 'POOLMGR_ALLOW_FUNCTION_SVC_EXPOSURE' - function services are not exposed",
+				zap.Error(err),
+				zap.String("value", exposureStr))
+		}
+		policy.exposure = exposure
+	}
+	if prefixesStr, ok := os.LookupEnv("POOLMGR_FUNCTION_SVC_ANNOTATION_PREFIXES"); ok {
+		policy.annotationPrefixes = nil
+		for _, prefix := range strings.Split(prefixesStr, ",") {
+			if prefix = strings.TrimSpace(prefix); len(prefix) > 0 {
+				policy.annotationPrefixes = append(policy.annotationPrefixes, prefix)
+			}
+		}
+	}
+	return policy
+}
+
+// allowsAnnotation returns whether functions may set the annotation on
+// their services.
+func (p functionSvcPolicy) allowsAnnotation(name string) bool {
+	if !p.exposure {
+		return false
+	}
+	for _, prefix := range p.annotationPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// functionSvcSpec is the service a function asks for with its annotations,
+// see getFunctionSvcSpec.
+type functionSvcSpec struct {
+	svcType     apiv1.ServiceType
+	headless    bool              // create the ClusterIP service without a cluster IP, see createSvc
+	nodePort    int32             // node port of the runtime port, 0 lets Kubernetes allocate one
+	annotations map[string]string // annotations of the service, e.g. to configure a cloud load balancer
+	// publishNotReady keeps routing to the pod while it fails its readiness
+	// probe, e.g. during a GC pause, rather than taking the function down
+	publishNotReady bool
+}
+
+// getFunctionSvcSpec returns the service requested by the annotations of a
+// function, or nil if the function doesn't ask for a service of its own.
+// NodePort and LoadBalancer services expose the function outside of the
+// cluster without an ingress, so functions only get them if the policy
+// allows it.
+func getFunctionSvcSpec(fn *metav1.ObjectMeta, policy functionSvcPolicy) (*functionSvcSpec, error) {
+	svcType, ok := fn.Annotations[fv1.ANNOTATION_SVC_TYPE]
+	if !ok {
+		return nil, nil
+	}
+	spec := &functionSvcSpec{svcType: apiv1.ServiceType(svcType)}
+	switch spec.svcType {
+	case apiv1.ServiceTypeClusterIP, apiv1.ServiceTypeNodePort, apiv1.ServiceTypeLoadBalancer:
+	case fv1.SvcTypeHeadless:
+		spec.svcType, spec.headless = apiv1.ServiceTypeClusterIP, true
+	default:
+		return nil, fv1.MakeValidationErr(fv1.ErrorUnsupportedType, fv1.ANNOTATION_SVC_TYPE, svcType,
+			"must be ClusterIP, Headless, NodePort or LoadBalancer")
+	}
+	if spec.svcType != apiv1.ServiceTypeClusterIP && !policy.exposure {
+		return nil, fv1.MakeValidationErr(fv1.ErrorUnsupportedType, fv1.ANNOTATION_SVC_TYPE, svcType,
+			"NodePort and LoadBalancer services are disabled by the operator")
+	}
+
+	if nodePort, ok := fn.Annotations[fv1.ANNOTATION_SVC_NODE_PORT]; ok {
+		if spec.svcType == apiv1.ServiceTypeClusterIP {
+			return nil, fv1.MakeValidationErr(fv1.ErrorInvalidValue, fv1.ANNOTATION_SVC_NODE_PORT, nodePort,
+				"only NodePort and LoadBalancer services have a node port")
+		}
+		port, err := strconv.ParseInt(nodePort, 10, 32)
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, fv1.MakeValidationErr(fv1.ErrorInvalidValue, fv1.ANNOTATION_SVC_NODE_PORT, nodePort,
+				"not a valid port number")
+		}
+		spec.nodePort = int32(port)
+	}
+
+	if publishNotReady, ok := fn.Annotations[fv1.ANNOTATION_SVC_PUBLISH_NOT_READY]; ok {
+		publish, err := strconv.ParseBool(publishNotReady)
+		if err != nil {
+			return nil, fv1.MakeValidationErr(fv1.ErrorInvalidValue, fv1.ANNOTATION_SVC_PUBLISH_NOT_READY, publishNotReady,
+				"must be true or false")
+		}
+		spec.publishNotReady = publish
+	}
+
+	annotations, err := getFunctionSvcAnnotations(fn, policy)
+	if err != nil {
+		return nil, err
+	}
+	spec.annotations = annotations
+	return spec, nil
+}
+
+// getFunctionSvcAnnotations returns the annotations a function asks for on
+// its service with fv1.ANNOTATION_SVC_ANNOTATION_PREFIX, e.g. for a cloud
+// load balancer, or nil if there are none. It fails on annotations the
+// policy doesn't allow rather than creating the service without them.
+func getFunctionSvcAnnotations(fn *metav1.ObjectMeta, policy functionSvcPolicy) (map[string]string, error) {
+	var annotations map[string]string
+	for k, v := range fn.Annotations {
+		if name, ok := strings.CutPrefix(k, fv1.ANNOTATION_SVC_ANNOTATION_PREFIX); ok && len(name) > 0 {
+			if !policy.allowsAnnotation(name) {
+				return nil, fv1.MakeValidationErr(fv1.ErrorInvalidValue, k, v,
+					"the operator doesn't allow this annotation on function services")
+			}
+			if annotations == nil {
+				annotations = make(map[string]string)
+			}
+			annotations[name] = v
+		}
+	}
+	return annotations, nil
+}
+
+// usesHeadlessSvc returns whether the service of a function is created
+// without a cluster IP, see createSvc: if the environment asks for headless
+// function services with fv1.ANNOTATION_HEADLESS_SVC or the function asks
+// for a headless service.
+func (gp *GenericPool) usesHeadlessSvc(svcSpec *functionSvcSpec) bool {
+	if svcSpec != nil && svcSpec.headless {
+		return true
+	}
+	headless, _ := strconv.ParseBool(gp.env.ObjectMeta.Annotations[fv1.ANNOTATION_HEADLESS_SVC])
+	return headless
+}
+
+// checkNodePort returns ErrNodePortConflict if the node port is used by the
+// service of another function of the pool namespace. The executor can't list
+// services of other namespaces; the API server still rejects a node port
+// allocated there when the service is created.
+func (gp *GenericPool) checkNodePort(ctx context.Context, fn *metav1.ObjectMeta, nodePort int32) error {
+	selector := labels.SelectorFromSet(map[string]string{
+		fv1.EXECUTOR_TYPE: string(fv1.ExecutorTypePoolmgr),
+	})
+	svcs, err := gp.kubernetesClient.CoreV1().Services(gp.fnNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return err
+	}
+	for _, svc := range svcs.Items {
+		if svc.Labels[fv1.FUNCTION_UID] == string(fn.UID) {
+			continue
+		}
+		for _, port := range svc.Spec.Ports {
+			if port.NodePort == nodePort {
+				return errors.Wrapf(ErrNodePortConflict, "node port %d is used by function %s/%s",
+					nodePort, svc.Labels[fv1.FUNCTION_NAMESPACE], svc.Labels[fv1.FUNCTION_NAME])
+			}
+		}
+	}
+	return nil
+}
+
+// externalAddress returns the address a NodePort or LoadBalancer service
+// exposes the runtime port at outside of the cluster, as far as it is
+// allocated yet.
+func (gp *GenericPool) externalAddress(svc *apiv1.Service) (nodePort int32, address string) {
+	for _, port := range svc.Spec.Ports {
+		if port.Port == gp.runtimePort {
+			nodePort = port.NodePort
+		}
+	}
+	if svc.Spec.Type != apiv1.ServiceTypeLoadBalancer {
+		return nodePort, ""
+	}
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		host := ingress.IP
+		if len(host) == 0 {
+			host = ingress.Hostname
+		}
+		if len(host) > 0 {
+			return nodePort, fmt.Sprintf("%s:%d", host, gp.runtimePort)
+		}
+	}
+	return nodePort, ""
+}
+
+// cleanupStaleServices deletes the function services of the environment of
+// the pool which select no active pod anymore, e.g. services an executor
+// didn't get to delete before it restarted or services of a previous
+// environment of the same name. They would conflict with the services
+// created for new pods of the function. It returns the number of deleted
+// services.
+func (gp *GenericPool) cleanupStaleServices(ctx context.Context) (int, error) {
+	sel := map[string]string{
+		fv1.EXECUTOR_TYPE:         string(fv1.ExecutorTypePoolmgr),
+		fv1.ENVIRONMENT_NAME:      gp.env.ObjectMeta.Name,
+		fv1.ENVIRONMENT_NAMESPACE: gp.env.ObjectMeta.Namespace,
+	}
+	svcList, err := gp.kubernetesClient.CoreV1().Services(gp.fnNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.Set(sel).AsSelector().String(),
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "error listing function services")
+	}
+
+	deleted := 0
+	for i := range svcList.Items {
+		svc := &svcList.Items[i]
+		if len(svc.Spec.Selector) == 0 || time.Since(svc.ObjectMeta.CreationTimestamp.Time) < staleSvcGracePeriod {
+			continue
+		}
+		pods, err := gp.kubernetesClient.CoreV1().Pods(gp.fnNamespace).List(ctx, metav1.ListOptions{
+			LabelSelector: labels.Set(svc.Spec.Selector).AsSelector().String(),
+		})
+		if err != nil {
+			return deleted, errors.Wrapf(err, "error listing pods of service %s", svc.ObjectMeta.Name)
+		}
+		active := false
+		for j := range pods.Items {
+			if IsPodActive(&pods.Items[j]) {
+				active = true
+				break
+			}
+		}
+		if active {
+			continue
+		}
+
+		gp.logger.Info("deleting stale function service",
+			zap.String("service", svc.ObjectMeta.Name),
+			zap.String("function", svc.Labels[fv1.FUNCTION_NAME]),
+			zap.String("environmentUID", svc.Labels[fv1.ENVIRONMENT_UID]))
+		err = gp.kubernetesClient.CoreV1().Services(gp.fnNamespace).Delete(ctx, svc.ObjectMeta.Name, metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return deleted, errors.Wrapf(err, "error deleting stale service %s", svc.ObjectMeta.Name)
+		}
+		deleted++
+		if gp.fsCache != nil {
+			if fsvc, ok := gp.fsCache.GetByServiceName(svc.ObjectMeta.Name); ok {
+				gp.fsCache.DeleteFunctionSvc(ctx, fsvc)
+			}
+		}
+	}
+	return deleted, nil
+}
+
+// cleanupStaleServicesPeriodically runs cleanupStaleServices right away and
+// then every staleSvcCleanupInterval until the pool is destroyed.
+func (gp *GenericPool) cleanupStaleServicesPeriodically() {
+	wait.Until(func() {
+		_, err := gp.cleanupStaleServices(context.Background())
+		if err != nil {
+			gp.logger.Error("error cleaning up stale function services", zap.Error(err))
+		}
+	}, staleSvcCleanupInterval, gp.stopReadyPodControllerCh)
+}
@@ -0,0 +1,338 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/executor/fscache"
+	fetcherConfig "github.com/fission/fission/pkg/fetcher/config"
+)
+
+func TestCleanupStaleServices(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	gp := newTestPool(t)
+	defer gp.readyPodQueue.ShutDown()
+	gp.fsCache = fscache.MakeFunctionServiceCache(gp.logger)
+
+	old := metav1.NewTime(time.Now().Add(-time.Hour))
+	createSvc := func(name string, funcLabels map[string]string, created metav1.Time) {
+		t.Helper()
+		svc := &apiv1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: gp.fnNamespace, Labels: funcLabels, CreationTimestamp: created},
+			Spec:       apiv1.ServiceSpec{Selector: funcLabels, Ports: gp.servicePorts()},
+		}
+		_, err := gp.kubernetesClient.CoreV1().Services(gp.fnNamespace).Create(ctx, svc, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatalf("Error creating service: %v", err)
+		}
+	}
+
+	orphan := &metav1.ObjectMeta{Name: "orphan", Namespace: metav1.NamespaceDefault, UID: "orphan-uid"}
+	createSvc("svc-orphan", gp.labelsForFunction(orphan), old)
+	gp.fsCache.AddFunc(ctx, fscache.FuncSvc{
+		Name:     "gone",
+		Function: orphan,
+		Address:  "svc-orphan.default:8888",
+		KubernetesObjects: []apiv1.ObjectReference{
+			{Kind: "service", Name: "svc-orphan", Namespace: gp.fnNamespace},
+		},
+	}, 1)
+
+	live := &metav1.ObjectMeta{Name: "live", Namespace: metav1.NamespaceDefault, UID: "live-uid"}
+	createSvc("svc-live", gp.labelsForFunction(live), old)
+	pod := newTestPod("specialized", "10.0.0.1", true)
+	pod.Labels = gp.labelsForFunction(live)
+	_, err := gp.kubernetesClient.CoreV1().Pods(pod.Namespace).Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Error creating pod: %v", err)
+	}
+
+	fresh := &metav1.ObjectMeta{Name: "fresh", Namespace: metav1.NamespaceDefault, UID: "fresh-uid"}
+	createSvc("svc-fresh", gp.labelsForFunction(fresh), metav1.Now())
+
+	otherEnv := gp.labelsForFunction(orphan)
+	otherEnv[fv1.ENVIRONMENT_NAME] = "other"
+	createSvc("svc-other-env", otherEnv, old)
+
+	deleted, err := gp.cleanupStaleServices(ctx)
+	if err != nil {
+		t.Fatalf("Error cleaning up stale services: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("deleted %d services, want 1", deleted)
+	}
+	svcs, err := gp.kubernetesClient.CoreV1().Services(gp.fnNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Error listing services: %v", err)
+	}
+	var names []string
+	for _, svc := range svcs.Items {
+		names = append(names, svc.ObjectMeta.Name)
+	}
+	sort.Strings(names)
+	if want := []string{"svc-fresh", "svc-live", "svc-other-env"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("services left = %v, want %v", names, want)
+	}
+	if _, ok := gp.fsCache.GetByServiceName("svc-orphan"); ok {
+		t.Error("function service of the stale service is still cached")
+	}
+}
+
+func TestGetFunctionSvcSpec(t *testing.T) {
+	exposed := functionSvcPolicy{exposure: true, annotationPrefixes: defaultSvcAnnotationPrefixes}
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		policy      functionSvcPolicy
+		want        *functionSvcSpec
+		wantErr     bool
+	}{
+		{"no service asked for", nil, functionSvcPolicy{}, nil, false},
+		{"cluster IP", map[string]string{fv1.ANNOTATION_SVC_TYPE: "ClusterIP"}, functionSvcPolicy{},
+			&functionSvcSpec{svcType: apiv1.ServiceTypeClusterIP}, false},
+		{"headless", map[string]string{fv1.ANNOTATION_SVC_TYPE: "Headless"}, functionSvcPolicy{},
+			&functionSvcSpec{svcType: apiv1.ServiceTypeClusterIP, headless: true}, false},
+		{"node port of a headless service", map[string]string{
+			fv1.ANNOTATION_SVC_TYPE:      "Headless",
+			fv1.ANNOTATION_SVC_NODE_PORT: "30080",
+		}, exposed, nil, true},
+		{"node port", map[string]string{
+			fv1.ANNOTATION_SVC_TYPE:      "NodePort",
+			fv1.ANNOTATION_SVC_NODE_PORT: "30080",
+		}, exposed, &functionSvcSpec{svcType: apiv1.ServiceTypeNodePort, nodePort: 30080}, false},
+		{"load balancer annotations", map[string]string{
+			fv1.ANNOTATION_SVC_TYPE: "LoadBalancer",
+			fv1.ANNOTATION_SVC_ANNOTATION_PREFIX + "service.beta.kubernetes.io/aws-load-balancer-internal": "true",
+			"unrelated": "annotation",
+		}, exposed, &functionSvcSpec{
+			svcType:     apiv1.ServiceTypeLoadBalancer,
+			annotations: map[string]string{"service.beta.kubernetes.io/aws-load-balancer-internal": "true"},
+		}, false},
+		{"publish not ready addresses", map[string]string{
+			fv1.ANNOTATION_SVC_TYPE:              "ClusterIP",
+			fv1.ANNOTATION_SVC_PUBLISH_NOT_READY: "true",
+		}, functionSvcPolicy{}, &functionSvcSpec{svcType: apiv1.ServiceTypeClusterIP, publishNotReady: true}, false},
+		{"node port not allowed", map[string]string{fv1.ANNOTATION_SVC_TYPE: "NodePort"}, functionSvcPolicy{}, nil, true},
+		{"load balancer not allowed", map[string]string{fv1.ANNOTATION_SVC_TYPE: "LoadBalancer"}, functionSvcPolicy{}, nil, true},
+		{"annotations not allowed", map[string]string{
+			fv1.ANNOTATION_SVC_TYPE: "ClusterIP",
+			fv1.ANNOTATION_SVC_ANNOTATION_PREFIX + "service.beta.kubernetes.io/aws-load-balancer-internal": "true",
+		}, functionSvcPolicy{annotationPrefixes: defaultSvcAnnotationPrefixes}, nil, true},
+		{"annotation outside of the allowed prefixes", map[string]string{
+			fv1.ANNOTATION_SVC_TYPE: "LoadBalancer",
+			fv1.ANNOTATION_SVC_ANNOTATION_PREFIX + "external-dns.alpha.kubernetes.io/hostname": "fn.example.com",
+		}, exposed, nil, true},
+		{"unknown type", map[string]string{fv1.ANNOTATION_SVC_TYPE: "ExternalName"}, exposed, nil, true},
+		{"node port of a cluster IP", map[string]string{
+			fv1.ANNOTATION_SVC_TYPE:      "ClusterIP",
+			fv1.ANNOTATION_SVC_NODE_PORT: "30080",
+		}, exposed, nil, true},
+		{"invalid node port", map[string]string{
+			fv1.ANNOTATION_SVC_TYPE:      "NodePort",
+			fv1.ANNOTATION_SVC_NODE_PORT: "http",
+		}, exposed, nil, true},
+		{"invalid publish not ready addresses", map[string]string{
+			fv1.ANNOTATION_SVC_TYPE:              "ClusterIP",
+			fv1.ANNOTATION_SVC_PUBLISH_NOT_READY: "sometimes",
+		}, exposed, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getFunctionSvcSpec(&metav1.ObjectMeta{Name: "fn", Annotations: tt.annotations}, tt.policy)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getFunctionSvcSpec() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getFunctionSvcSpec() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetFuncSvcAnnotations(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ts, host, port := fakeFetcher(t)
+	defer ts.Close()
+	gp := newTestPool(t, newTestPod("ready", host, true))
+	defer gp.readyPodQueue.ShutDown()
+	cfg, err := fetcherConfig.MakeFetcherConfig("/userfunc")
+	if err != nil {
+		t.Fatalf("Error creating fetcher config: %v", err)
+	}
+	gp.fetcherConfig, gp.fetcherPort = cfg, port
+	gp.fsCache = fscache.MakeFunctionServiceCache(gp.logger)
+	gp.useSvc = true
+	createTestEndpoints(t, gp, "svc-fn-fn-uid", host)
+
+	// the pool creates a service for every function, the function only
+	// asks for annotations on it
+	annotations := map[string]string{
+		fv1.ANNOTATION_SVC_ANNOTATION_PREFIX + "linkerd.io/inject": "enabled",
+	}
+	fn := &fv1.Function{ObjectMeta: metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid", Annotations: annotations}}
+	_, err = gp.getFuncSvc(ctx, fn)
+	if err == nil {
+		t.Fatal("function got annotations on its service the operator doesn't allow")
+	}
+	if n := gp.readyPodQueue.Len(); n != 1 {
+		t.Errorf("%d pods left in the ready queue, want 1 as the function is rejected before choosing one", n)
+	}
+
+	gp.svcPolicy = functionSvcPolicy{exposure: true, annotationPrefixes: []string{"linkerd.io/"}}
+	_, err = gp.getFuncSvc(ctx, fn)
+	if err != nil {
+		t.Fatalf("Error getting function service: %v", err)
+	}
+	svc, err := gp.kubernetesClient.CoreV1().Services(gp.fnNamespace).Get(ctx, "svc-fn-fn-uid", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting service: %v", err)
+	}
+	want := map[string]string{"linkerd.io/inject": "enabled"}
+	if !reflect.DeepEqual(svc.Annotations, want) {
+		t.Errorf("service annotations = %v, want %v", svc.Annotations, want)
+	}
+	if svc.Spec.Type != apiv1.ServiceTypeClusterIP {
+		t.Errorf("service type = %q, want %q", svc.Spec.Type, apiv1.ServiceTypeClusterIP)
+	}
+}
+
+func TestGetFuncSvcHeadless(t *testing.T) {
+	tests := []struct {
+		name           string
+		envAnnotations map[string]string
+		svcType        string
+		wantClusterIP  string
+	}{
+		{"function asks for a headless service", nil, fv1.SvcTypeHeadless, apiv1.ClusterIPNone},
+		{"environment makes function services headless", map[string]string{fv1.ANNOTATION_HEADLESS_SVC: "true"},
+			string(apiv1.ServiceTypeClusterIP), apiv1.ClusterIPNone},
+		{"cluster IP service", map[string]string{fv1.ANNOTATION_HEADLESS_SVC: "false"},
+			string(apiv1.ServiceTypeClusterIP), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			ts, host, port := fakeFetcher(t)
+			defer ts.Close()
+			gp := newTestPool(t, newTestPod("ready", host, true))
+			defer gp.readyPodQueue.ShutDown()
+			cfg, err := fetcherConfig.MakeFetcherConfig("/userfunc")
+			if err != nil {
+				t.Fatalf("Error creating fetcher config: %v", err)
+			}
+			gp.fetcherConfig, gp.fetcherPort = cfg, port
+			gp.fsCache = fscache.MakeFunctionServiceCache(gp.logger)
+			gp.env.ObjectMeta.Annotations = tt.envAnnotations
+			createTestEndpoints(t, gp, "svc-fn-fn-uid", host)
+
+			fn := &fv1.Function{ObjectMeta: metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid",
+				Annotations: map[string]string{fv1.ANNOTATION_SVC_TYPE: tt.svcType}}}
+			_, err = gp.getFuncSvc(ctx, fn)
+			if err != nil {
+				t.Fatalf("Error getting function service: %v", err)
+			}
+			svc, err := gp.kubernetesClient.CoreV1().Services(gp.fnNamespace).Get(ctx, "svc-fn-fn-uid", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("Error getting service: %v", err)
+			}
+			if svc.Spec.Type != apiv1.ServiceTypeClusterIP || svc.Spec.ClusterIP != tt.wantClusterIP {
+				t.Errorf("service %s with cluster IP %q, want ClusterIP with cluster IP %q",
+					svc.Spec.Type, svc.Spec.ClusterIP, tt.wantClusterIP)
+			}
+		})
+	}
+}
+
+func TestGetFuncSvcNodePort(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ts, host, port := fakeFetcher(t)
+	defer ts.Close()
+	gp := newTestPool(t, newTestPod("ready", host, true))
+	defer gp.readyPodQueue.ShutDown()
+	cfg, err := fetcherConfig.MakeFetcherConfig("/userfunc")
+	if err != nil {
+		t.Fatalf("Error creating fetcher config: %v", err)
+	}
+	gp.fetcherConfig, gp.fetcherPort = cfg, port
+	gp.fsCache = fscache.MakeFunctionServiceCache(gp.logger)
+	gp.svcPolicy = functionSvcPolicy{exposure: true}
+	createTestEndpoints(t, gp, "svc-fn-fn-uid", host)
+
+	// the pool doesn't create services, the function asks for one
+	annotations := map[string]string{
+		fv1.ANNOTATION_SVC_TYPE:      "NodePort",
+		fv1.ANNOTATION_SVC_NODE_PORT: "30080",
+	}
+	fn := &fv1.Function{ObjectMeta: metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid", Annotations: annotations}}
+	fsvc, err := gp.getFuncSvc(ctx, fn)
+	if err != nil {
+		t.Fatalf("Error getting function service: %v", err)
+	}
+	if fsvc.NodePort != 30080 {
+		t.Errorf("function service node port = %d, want 30080", fsvc.NodePort)
+	}
+	svc, err := gp.kubernetesClient.CoreV1().Services(gp.fnNamespace).Get(ctx, "svc-fn-fn-uid", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error getting service: %v", err)
+	}
+	if svc.Spec.Type != apiv1.ServiceTypeNodePort {
+		t.Errorf("service type = %q, want %q", svc.Spec.Type, apiv1.ServiceTypeNodePort)
+	}
+
+	other := &fv1.Function{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: metav1.NamespaceDefault, UID: "other-uid", Annotations: annotations}}
+	_, err = gp.getFuncSvc(ctx, other)
+	if !errors.Is(err, ErrNodePortConflict) {
+		t.Fatalf("got error %v, want %v", err, ErrNodePortConflict)
+	}
+}
+
+func TestMakeFunctionSvcPolicy(t *testing.T) {
+	policy := makeFunctionSvcPolicy(zap.NewNop())
+	if policy.exposure {
+		t.Error("function services are exposed by default")
+	}
+
+	t.Setenv("POOLMGR_ALLOW_FUNCTION_SVC_EXPOSURE", "true")
+	t.Setenv("POOLMGR_FUNCTION_SVC_ANNOTATION_PREFIXES", "linkerd.io/, cloud.google.com/")
+	policy = makeFunctionSvcPolicy(zap.NewNop())
+	want := functionSvcPolicy{exposure: true, annotationPrefixes: []string{"linkerd.io/", "cloud.google.com/"}}
+	if !reflect.DeepEqual(policy, want) {
+		t.Errorf("policy = %+v, want %+v", policy, want)
+	}
+	if policy.allowsAnnotation("service.beta.kubernetes.io/aws-load-balancer-internal") {
+		t.Error("annotation outside of the configured prefixes allowed")
+	}
+}
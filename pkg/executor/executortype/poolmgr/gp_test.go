@@ -0,0 +1,1532 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	goruntime "runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	k8stesting "k8s.io/client-go/testing"
+	k8sCache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/crd"
+	"github.com/fission/fission/pkg/executor/fscache"
+	"github.com/fission/fission/pkg/executor/reaper"
+	"github.com/fission/fission/pkg/fetcher"
+	fetcherConfig "github.com/fission/fission/pkg/fetcher/config"
+	"github.com/fission/fission/pkg/utils/loggerfactory"
+)
+
+// newTestPool returns a pool whose ready pod lister and queue are fed
+// directly from the given pods, without running an informer.
+func newTestPool(t *testing.T, pods ...*apiv1.Pod) *GenericPool {
+	t.Helper()
+	ctx := context.Background()
+	kubernetesClient := fake.NewSimpleClientset()
+	indexer := k8sCache.NewIndexer(k8sCache.MetaNamespaceKeyFunc, k8sCache.Indexers{k8sCache.NamespaceIndex: k8sCache.MetaNamespaceIndexFunc})
+	queue := workqueue.NewDelayingQueue()
+	for _, pod := range pods {
+		_, err := kubernetesClient.CoreV1().Pods(pod.Namespace).Create(ctx, pod, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatalf("Error creating pod: %v", err)
+		}
+		err = indexer.Add(pod)
+		if err != nil {
+			t.Fatalf("Error adding pod to indexer: %v", err)
+		}
+		key, err := k8sCache.MetaNamespaceKeyFunc(pod)
+		if err != nil {
+			t.Fatalf("Error creating key: %v", err)
+		}
+		queue.Add(key)
+	}
+
+	env := &fv1.Environment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: metav1.NamespaceDefault,
+			UID:       "env-uid",
+		},
+		Spec: fv1.EnvironmentSpec{
+			Runtime: fv1.Runtime{Image: "fission/test-env"},
+		},
+	}
+	gp, err := MakeGenericPool(loggerfactory.GetLogger(), nil, kubernetesClient, nil, env,
+		metav1.NamespaceDefault, nil, nil, "test", false, 5*time.Second, nil, nil, makePoolConfig(loggerfactory.GetLogger()))
+	if err != nil {
+		t.Fatalf("Error creating pool: %v", err)
+	}
+	gp.readyPodLister = corelisters.NewPodLister(indexer)
+	gp.readyPodListerSynced = func() bool { return true }
+	gp.readyPodQueue = queue
+	return gp
+}
+
+func newTestPod(name string, podIP string, ready bool) *apiv1.Pod {
+	return &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: metav1.NamespaceDefault,
+			Labels: map[string]string{
+				fv1.EXECUTOR_TYPE:         string(fv1.ExecutorTypePoolmgr),
+				fv1.ENVIRONMENT_NAME:      "test",
+				fv1.ENVIRONMENT_NAMESPACE: metav1.NamespaceDefault,
+				fv1.ENVIRONMENT_UID:       "env-uid",
+				"managed":                 "true",
+			},
+		},
+		Status: apiv1.PodStatus{
+			Phase: apiv1.PodRunning,
+			PodIP: podIP,
+			ContainerStatuses: []apiv1.ContainerStatus{
+				{Name: "test", Ready: ready},
+			},
+		},
+	}
+}
+
+// newSpecializedTestPod returns a pod of the test pool specialized for a
+// function.
+func newSpecializedTestPod(name string, podIP string) *apiv1.Pod {
+	pod := newTestPod(name, podIP, true)
+	pod.Labels[fv1.MANAGED] = "false"
+	return pod
+}
+
+// newTestPodLister returns a pod lister holding the pods, as set up by the
+// pod informers.
+func newTestPodLister(t *testing.T, pods ...*apiv1.Pod) corelisters.PodLister {
+	t.Helper()
+	indexer := k8sCache.NewIndexer(k8sCache.MetaNamespaceKeyFunc, k8sCache.Indexers{k8sCache.NamespaceIndex: k8sCache.MetaNamespaceIndexFunc})
+	for _, pod := range pods {
+		err := indexer.Add(pod)
+		if err != nil {
+			t.Fatalf("Error adding pod: %v", err)
+		}
+	}
+	return corelisters.NewPodLister(indexer)
+}
+
+func TestMakeGenericPoolPodReadyTimeout(t *testing.T) {
+	logger := loggerfactory.GetLogger()
+	env := &fv1.Environment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: metav1.NamespaceDefault,
+		},
+		Spec: fv1.EnvironmentSpec{
+			Runtime: fv1.Runtime{Image: "fission/test-env"},
+		},
+	}
+
+	envTimeout := env.DeepCopy()
+	envTimeout.ObjectMeta.Annotations = map[string]string{fv1.ANNOTATION_POD_READY_TIMEOUT: "15m"}
+
+	tests := []struct {
+		name    string
+		env     *fv1.Environment
+		timeout time.Duration
+		want    time.Duration
+	}{
+		{"zero falls back to default", env, 0, defaultPodReadyTimeout},
+		{"negative falls back to default", env, -1 * time.Second, defaultPodReadyTimeout},
+		{"custom timeout is kept", env, 30 * time.Second, 30 * time.Second},
+		{"environment overrides the default", envTimeout, 0, 15 * time.Minute},
+		{"environment overrides the custom timeout", envTimeout, 30 * time.Second, 15 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gp, err := MakeGenericPool(logger, nil, fake.NewSimpleClientset(), nil, tt.env,
+				metav1.NamespaceDefault, nil, nil, "test", false, tt.timeout, nil, nil, nil)
+			if err != nil {
+				t.Fatalf("Error creating pool: %v", err)
+			}
+			if gp.podReadyTimeout != tt.want {
+				t.Errorf("podReadyTimeout = %v, want %v", gp.podReadyTimeout, tt.want)
+			}
+		})
+	}
+}
+
+func TestMakeGenericPoolMissingDependency(t *testing.T) {
+	env := &fv1.Environment{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: metav1.NamespaceDefault},
+		Spec:       fv1.EnvironmentSpec{Runtime: fv1.Runtime{Image: "fission/test-env"}},
+	}
+	tests := []struct {
+		name             string
+		logger           *zap.Logger
+		kubernetesClient *fake.Clientset
+		env              *fv1.Environment
+	}{
+		{"no logger", nil, fake.NewSimpleClientset(), env},
+		{"no kubernetes client", loggerfactory.GetLogger(), nil, env},
+		{"no environment", loggerfactory.GetLogger(), fake.NewSimpleClientset(), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var kubernetesClient kubernetes.Interface
+			if tt.kubernetesClient != nil {
+				kubernetesClient = tt.kubernetesClient
+			}
+			gp, err := MakeGenericPool(tt.logger, nil, kubernetesClient, nil, tt.env,
+				metav1.NamespaceDefault, nil, nil, "test", false, 0, nil, nil, nil)
+			if gp != nil || !errors.Is(err, ErrMissingDependency) {
+				t.Fatalf("MakeGenericPool() = %v, %v, want %v", gp, err, ErrMissingDependency)
+			}
+		})
+	}
+}
+
+func TestPoolSetupAuthTokenSecret(t *testing.T) {
+	t.Setenv(fetcher.AuthTokenEnv, "secret-token")
+	t.Setenv("FETCHER_AUTH_TOKEN_SECRET", "fetcher-auth")
+	cfg, err := fetcherConfig.MakeFetcherConfig("/userfunc")
+	if err != nil {
+		t.Fatalf("Error creating fetcher config: %v", err)
+	}
+	tests := []struct {
+		name   string
+		secret *apiv1.Secret
+	}{
+		{"missing secret", nil},
+		{"missing key", &apiv1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "fetcher-auth", Namespace: metav1.NamespaceDefault},
+			Data:       map[string][]byte{"other": []byte("secret-token")},
+		}},
+		{"other token", &apiv1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "fetcher-auth", Namespace: metav1.NamespaceDefault},
+			Data:       map[string][]byte{"token": []byte("other-token")},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kubernetesClient := fake.NewSimpleClientset()
+			if tt.secret != nil {
+				kubernetesClient = fake.NewSimpleClientset(tt.secret)
+			}
+			env := &fv1.Environment{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: metav1.NamespaceDefault, UID: "env-uid"},
+				Spec:       fv1.EnvironmentSpec{Runtime: fv1.Runtime{Image: "fission/test-env"}},
+			}
+			gp, err := MakeGenericPool(loggerfactory.GetLogger(), nil, kubernetesClient, nil, env,
+				metav1.NamespaceDefault, nil, cfg, "test", false, 0, nil, nil, nil)
+			if err != nil {
+				t.Fatalf("Error creating pool: %v", err)
+			}
+			err = gp.setup(context.Background())
+			if err == nil {
+				t.Fatal("pool set up without a usable fetcher auth token secret")
+			}
+			deployments, err := kubernetesClient.AppsV1().Deployments(metav1.NamespaceDefault).List(context.Background(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatalf("Error listing deployments: %v", err)
+			}
+			if len(deployments.Items) != 0 {
+				t.Errorf("created %d deployments, want none", len(deployments.Items))
+			}
+		})
+	}
+
+	secret := &apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "fetcher-auth", Namespace: metav1.NamespaceDefault},
+		Data:       map[string][]byte{"token": []byte("secret-token")},
+	}
+	err = cfg.CheckAuthTokenSecret(context.Background(), fake.NewSimpleClientset(secret), metav1.NamespaceDefault)
+	if err != nil {
+		t.Errorf("Error checking fetcher auth token secret: %v", err)
+	}
+}
+
+func TestMakeGenericPoolInvalidEnvironment(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   metav1.ObjectMeta
+		image string
+		field string
+	}{
+		{"empty image", metav1.ObjectMeta{Name: "test", Namespace: metav1.NamespaceDefault}, "", "EnvironmentSpec.Runtime.Image"},
+		{"invalid name", metav1.ObjectMeta{Name: "Test_Env", Namespace: metav1.NamespaceDefault}, "fission/test-env", "Environment.Name"},
+		{"unknown specialize probe", metav1.ObjectMeta{Name: "test", Namespace: metav1.NamespaceDefault,
+			Annotations: map[string]string{fv1.ANNOTATION_SPECIALIZE_PROBE: "tcp"}}, "fission/test-env", fv1.ANNOTATION_SPECIALIZE_PROBE},
+		{"fetcher filename outside the shared volume", metav1.ObjectMeta{Name: "test", Namespace: metav1.NamespaceDefault,
+			Annotations: map[string]string{fv1.ANNOTATION_FETCHER_FILENAME: "../handler.py"}}, "fission/test-env", fv1.ANNOTATION_FETCHER_FILENAME},
+		{"relative specialize probe path", metav1.ObjectMeta{Name: "test", Namespace: metav1.NamespaceDefault,
+			Annotations: map[string]string{fv1.ANNOTATION_SPECIALIZE_PROBE_PATH: "healthz"}}, "fission/test-env", fv1.ANNOTATION_SPECIALIZE_PROBE_PATH},
+		{"invalid specialize probe status", metav1.ObjectMeta{Name: "test", Namespace: metav1.NamespaceDefault,
+			Annotations: map[string]string{fv1.ANNOTATION_SPECIALIZE_PROBE_PATH: "/healthz", fv1.ANNOTATION_SPECIALIZE_PROBE_STATUS: "ok"}}, "fission/test-env", fv1.ANNOTATION_SPECIALIZE_PROBE_STATUS},
+		{"max pods within the pool size", metav1.ObjectMeta{Name: "test", Namespace: metav1.NamespaceDefault,
+			Annotations: map[string]string{fv1.ANNOTATION_MAX_PODS: "2"}}, "fission/test-env", fv1.ANNOTATION_MAX_PODS},
+		{"invalid adopt pod selector", metav1.ObjectMeta{Name: "test", Namespace: metav1.NamespaceDefault,
+			Annotations: map[string]string{fv1.ANNOTATION_ADOPT_POD_SELECTOR: "warm in ("}}, "fission/test-env", fv1.ANNOTATION_ADOPT_POD_SELECTOR},
+		{"invalid pod ready timeout", metav1.ObjectMeta{Name: "test", Namespace: metav1.NamespaceDefault,
+			Annotations: map[string]string{fv1.ANNOTATION_POD_READY_TIMEOUT: "300"}}, "fission/test-env", fv1.ANNOTATION_POD_READY_TIMEOUT},
+		{"negative pod ready timeout", metav1.ObjectMeta{Name: "test", Namespace: metav1.NamespaceDefault,
+			Annotations: map[string]string{fv1.ANNOTATION_POD_READY_TIMEOUT: "-1m"}}, "fission/test-env", fv1.ANNOTATION_POD_READY_TIMEOUT},
+		{"invalid headless service", metav1.ObjectMeta{Name: "test", Namespace: metav1.NamespaceDefault,
+			Annotations: map[string]string{fv1.ANNOTATION_HEADLESS_SVC: "yes please"}}, "fission/test-env", fv1.ANNOTATION_HEADLESS_SVC},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := &fv1.Environment{
+				ObjectMeta: tt.env,
+				Spec: fv1.EnvironmentSpec{
+					Runtime: fv1.Runtime{Image: tt.image},
+				},
+			}
+			gp, err := MakeGenericPool(loggerfactory.GetLogger(), nil, fake.NewSimpleClientset(), nil, env,
+				metav1.NamespaceDefault, nil, nil, "test", false, 0, nil, nil, nil)
+			if gp != nil || !errors.Is(err, ErrInvalidEnvironment) {
+				t.Fatalf("MakeGenericPool() = %v, %v, want %v", gp, err, ErrInvalidEnvironment)
+			}
+			var validationErr fv1.ValidationError
+			if !errors.As(err, &validationErr) || validationErr.Field != tt.field {
+				t.Errorf("got error %v, want a validation error of %s", err, tt.field)
+			}
+		})
+	}
+}
+
+func TestChoosePodOnlyReadyPods(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	terminating := newTestPod("terminating", "10.0.0.4", true)
+	now := metav1.Now()
+	terminating.DeletionTimestamp = &now
+	gp := newTestPool(t,
+		newTestPod("not-ready", "10.0.0.1", false),
+		newTestPod("no-ip", "", true),
+		terminating,
+		newTestPod("ready", "10.0.0.3", true),
+	)
+	defer gp.readyPodQueue.ShutDown()
+
+	fn := &metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}
+	_, pod, err := gp.choosePod(ctx, gp.labelsForFunction(fn))
+	if err != nil {
+		t.Fatalf("Error choosing pod: %v", err)
+	}
+	if pod.Name != "ready" {
+		t.Fatalf("chose pod %q, want %q", pod.Name, "ready")
+	}
+	if len(pod.Status.PodIP) == 0 {
+		t.Fatalf("chosen pod %q has no IP", pod.Name)
+	}
+}
+
+func TestChoosePodInitContainers(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	withInit := func(name, ip string, initDone bool) *apiv1.Pod {
+		pod := newTestPod(name, ip, initDone)
+		pod.Spec.InitContainers = []apiv1.Container{{Name: "warm-cache"}}
+		pod.Spec.Containers = []apiv1.Container{{Name: "test"}}
+		state := apiv1.ContainerState{Running: &apiv1.ContainerStateRunning{}}
+		if initDone {
+			state = apiv1.ContainerState{Terminated: &apiv1.ContainerStateTerminated{Reason: "Completed"}}
+		} else {
+			pod.Status.Phase = apiv1.PodPending
+		}
+		pod.Status.InitContainerStatuses = []apiv1.ContainerStatus{{Name: "warm-cache", State: state}}
+		return pod
+	}
+	gp := newTestPool(t,
+		withInit("initializing", "10.0.0.1", false),
+		withInit("initialized", "10.0.0.2", true),
+	)
+	defer gp.readyPodQueue.ShutDown()
+
+	fn := &metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}
+	_, pod, err := gp.choosePod(ctx, gp.labelsForFunction(fn))
+	if err != nil {
+		t.Fatalf("Error choosing pod: %v", err)
+	}
+	if pod.Name != "initialized" {
+		t.Fatalf("chose pod %q, want %q", pod.Name, "initialized")
+	}
+}
+
+func TestChoosePodCurrentGeneration(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	generation := func(name, ip, gen string) *apiv1.Pod {
+		pod := newTestPod(name, ip, true)
+		pod.Labels[fv1.ENVIRONMENT_GENERATION] = gen
+		return pod
+	}
+	gp := newTestPool(t,
+		generation("old-1", "10.0.0.1", "1"),
+		generation("old-2", "10.0.0.2", "1"),
+		generation("current", "10.0.0.3", "2"),
+	)
+	defer gp.readyPodQueue.ShutDown()
+	env := gp.env.DeepCopy()
+	env.Spec.Runtime.Image = "fission/test-env"
+	env.ObjectMeta.Generation = 2
+	var err error
+	gp.fetcherConfig, err = fetcherConfig.MakeFetcherConfig("/userfunc")
+	if err != nil {
+		t.Fatalf("Error creating fetcher config: %v", err)
+	}
+	spec, err := gp.genDeploymentSpec(env)
+	if err != nil {
+		t.Fatalf("Error generating deployment spec: %v", err)
+	}
+	gp.deployment.Store(&appsv1.Deployment{Spec: *spec})
+
+	ready, err := gp.readyPodCount()
+	if err != nil {
+		t.Fatalf("Error counting ready pods: %v", err)
+	}
+	if ready != 1 {
+		t.Errorf("got %d ready pods, want only the current generation one", ready)
+	}
+
+	fn := &metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}
+	_, pod, err := gp.choosePod(ctx, gp.labelsForFunction(fn))
+	if err != nil {
+		t.Fatalf("Error choosing pod: %v", err)
+	}
+	if pod.Name != "current" {
+		t.Fatalf("chose pod %q, want %q", pod.Name, "current")
+	}
+}
+
+// conflictingPodUpdates makes the fake clientset reject pod updates carrying
+// a stale resourceVersion, like the API server does.
+func conflictingPodUpdates(kubernetesClient *fake.Clientset) {
+	var mu sync.Mutex
+	versions := make(map[string]int)
+	kubernetesClient.PrependReactor("update", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pod := action.(k8stesting.UpdateAction).GetObject().(*apiv1.Pod)
+		mu.Lock()
+		defer mu.Unlock()
+		current := strconv.Itoa(versions[pod.Name])
+		if pod.ResourceVersion != current {
+			return true, nil, k8serrors.NewConflict(apiv1.Resource("pods"), pod.Name, errors.New("stale resourceVersion"))
+		}
+		versions[pod.Name]++
+		pod.ResourceVersion = strconv.Itoa(versions[pod.Name])
+		return false, nil, nil
+	})
+}
+
+func TestClaimPodConcurrent(t *testing.T) {
+	ctx := context.Background()
+	podNames := []string{"pod-1", "pod-2", "pod-3"}
+	var pods []*apiv1.Pod
+	for i, name := range podNames {
+		pod := newTestPod(name, fmt.Sprintf("10.0.0.%d", i+1), true)
+		pod.ResourceVersion = "0"
+		pods = append(pods, pod)
+	}
+	gp := newTestPool(t, pods...)
+	defer gp.readyPodQueue.ShutDown()
+	conflictingPodUpdates(gp.kubernetesClient.(*fake.Clientset))
+
+	const claimers = 20
+	var wg sync.WaitGroup
+	claimed := make(chan string, claimers)
+	for i := 0; i < claimers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fn := &metav1.ObjectMeta{Name: fmt.Sprintf("fn-%d", i), Namespace: metav1.NamespaceDefault, UID: k8stypes.UID(fmt.Sprintf("fn-uid-%d", i))}
+			for _, name := range podNames {
+				_, err := gp.claimPod(ctx, metav1.NamespaceDefault, name, gp.labelsForFunction(fn))
+				if err == nil {
+					claimed <- name
+					return
+				}
+				if !errors.Is(err, errPodAlreadyClaimed) {
+					t.Errorf("unexpected error claiming pod %s: %v", name, err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(claimed)
+
+	claims := make(map[string]int)
+	for name := range claimed {
+		claims[name]++
+	}
+	for _, name := range podNames {
+		if claims[name] != 1 {
+			t.Errorf("pod %s claimed %d times, want 1", name, claims[name])
+		}
+	}
+}
+
+func TestChoosePodContention(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	const free, claimedElsewhere = 5, 15
+	var pods []*apiv1.Pod
+	for i := 0; i < free+claimedElsewhere; i++ {
+		pods = append(pods, newTestPod(fmt.Sprintf("pod-%d", i), fmt.Sprintf("10.0.0.%d", i+1), true))
+	}
+	gp := newTestPool(t, pods...)
+	defer gp.readyPodQueue.ShutDown()
+	// the pods first in the queue are still listed as generic while
+	// they've already been claimed on the API server
+	for _, pod := range pods[:claimedElsewhere] {
+		claimed := pod.DeepCopy()
+		claimed.Labels[fv1.MANAGED] = "false"
+		_, err := gp.kubernetesClient.CoreV1().Pods(claimed.Namespace).Update(ctx, claimed, metav1.UpdateOptions{})
+		if err != nil {
+			t.Fatalf("Error updating pod: %v", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	chosen := make(chan string, free)
+	for i := 0; i < free; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fn := &metav1.ObjectMeta{Name: fmt.Sprintf("fn-%d", i), Namespace: metav1.NamespaceDefault, UID: k8stypes.UID(fmt.Sprintf("fn-uid-%d", i))}
+			_, pod, err := gp.choosePod(ctx, gp.labelsForFunction(fn))
+			if err != nil {
+				t.Errorf("Error choosing pod: %v", err)
+				return
+			}
+			chosen <- pod.Name
+		}(i)
+	}
+	wg.Wait()
+	close(chosen)
+
+	seen := make(map[string]bool)
+	for name := range chosen {
+		if seen[name] {
+			t.Errorf("pod %s chosen twice", name)
+		}
+		seen[name] = true
+	}
+	if len(seen) != free {
+		t.Errorf("chose %d pods, want %d", len(seen), free)
+	}
+	// pods lost to another claimer are dropped rather than retried
+	if n := gp.readyPodQueue.Len(); n != 0 {
+		t.Errorf("%d pods left in the queue, want none", n)
+	}
+}
+
+func TestChoosePodSkipsPodsLeftThePool(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	gp := newTestPool(t, newTestPod("ready", "10.0.0.1", true))
+	// the key of a pod relabeled away from the pool stays queued until the
+	// informer delete event, choosePod must skip it since the lister
+	// doesn't know the pod anymore
+	queue := workqueue.NewDelayingQueue()
+	queue.Add(metav1.NamespaceDefault + "/relabeled")
+	queue.Add(metav1.NamespaceDefault + "/ready")
+	defer queue.ShutDown()
+	gp.readyPodQueue.ShutDown()
+	gp.readyPodQueue = queue
+
+	fn := &metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}
+	_, pod, err := gp.choosePod(ctx, gp.labelsForFunction(fn))
+	if err != nil {
+		t.Fatalf("Error choosing pod: %v", err)
+	}
+	if pod.Name != "ready" {
+		t.Fatalf("chose pod %q, want %q", pod.Name, "ready")
+	}
+}
+
+func TestChoosePodEmptyQueueTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	gp := newTestPool(t, newTestPod("ready", "10.0.0.1", true))
+	// the pod isn't queued yet, e.g. its add event is still delayed
+	queue := workqueue.NewDelayingQueue()
+	defer queue.ShutDown()
+	gp.readyPodQueue.ShutDown()
+	gp.readyPodQueue = queue
+	gp.podReadyTimeout = 300 * time.Millisecond
+
+	fn := &metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}
+	start := time.Now()
+	_, _, err := gp.choosePod(ctx, gp.labelsForFunction(fn))
+	if !errors.Is(err, ErrPodReadyTimeout) {
+		t.Fatalf("got error %v, want %v", err, ErrPodReadyTimeout)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("choosePod returned after %v, want it bounded by the pod ready timeout", elapsed)
+	}
+
+	// the timed out call must not have left anything taking keys
+	queue.Add(metav1.NamespaceDefault + "/ready")
+	_, pod, err := gp.choosePod(ctx, gp.labelsForFunction(fn))
+	if err != nil {
+		t.Fatalf("Error choosing pod: %v", err)
+	}
+	if pod.Name != "ready" {
+		t.Fatalf("chose pod %q, want %q", pod.Name, "ready")
+	}
+}
+
+func TestNextReadyPodKeyTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	gp := newTestPool(t)
+	defer gp.readyPodQueue.ShutDown()
+	before := goruntime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		_, err := gp.nextReadyPodKey(ctx, time.Now().Add(10*time.Millisecond))
+		if !errors.Is(err, ErrPodReadyTimeout) {
+			t.Fatalf("got error %v, want %v", err, ErrPodReadyTimeout)
+		}
+	}
+	if after := goruntime.NumGoroutine(); after > before {
+		t.Errorf("%d goroutines left behind by timed out calls", after-before)
+	}
+
+	gp.readyPodQueue.Add(metav1.NamespaceDefault + "/ready")
+	key, err := gp.nextReadyPodKey(ctx, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatalf("Error getting key: %v", err)
+	}
+	if key != metav1.NamespaceDefault+"/ready" {
+		t.Errorf("got key %q, want %q", key, metav1.NamespaceDefault+"/ready")
+	}
+	gp.readyPodQueue.Done(key)
+
+	gp.readyPodQueue.ShutDown()
+	if _, err := gp.nextReadyPodKey(ctx, time.Now().Add(time.Second)); err == nil {
+		t.Error("got a key from a queue shut down")
+	}
+}
+
+func TestChoosePodNoReadyPods(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	gp := newTestPool(t, newTestPod("not-ready", "10.0.0.1", false))
+	defer gp.readyPodQueue.ShutDown()
+	gp.noReadyPodsThreshold = time.Minute
+	gp.noReadyPodsSince.Store(time.Now().Add(-2 * time.Minute).UnixNano())
+
+	fn := &metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}
+	_, _, err := gp.choosePod(ctx, gp.labelsForFunction(fn))
+	if !errors.Is(err, ErrNoReadyPods) {
+		t.Fatalf("got error %v, want %v", err, ErrNoReadyPods)
+	}
+
+	// a pod turning ready resets the clock
+	gp.noReadyPodsSince.Store(time.Now().Add(-2 * time.Minute).UnixNano())
+	gp.trackReadyPods(1)
+	if noReady := gp.noReadyPodsFor(); noReady != 0 {
+		t.Errorf("no ready pods for %v after a pod turned ready, want 0", noReady)
+	}
+	gp.trackReadyPods(0)
+	if noReady := gp.noReadyPodsFor(); noReady <= 0 || noReady > time.Second {
+		t.Errorf("no ready pods for %v right after the last ready pod left, want just started", noReady)
+	}
+}
+
+// fakeFetcher serves successful specialize requests, it returns the
+// server with its host and port.
+func fakeFetcher(t *testing.T) (*httptest.Server, string, int32) {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write([]byte(`{"filename":"deployarchive","size":1}`))
+		if err != nil {
+			t.Errorf("error writing response: %v", err)
+		}
+	}))
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("Error parsing fetcher url: %v", err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatalf("Error parsing fetcher port: %v", err)
+	}
+	return ts, u.Hostname(), int32(port)
+}
+
+// createTestEndpoints creates the endpoints the endpoints controller would
+// create for a service selecting the pod with the given IP.
+func createTestEndpoints(t *testing.T, gp *GenericPool, name string, podIP string) {
+	t.Helper()
+	endpoints := &apiv1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: gp.fnNamespace},
+		Subsets: []apiv1.EndpointSubset{
+			{Addresses: []apiv1.EndpointAddress{{IP: podIP}}},
+		},
+	}
+	_, err := gp.kubernetesClient.CoreV1().Endpoints(gp.fnNamespace).Create(context.Background(), endpoints, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Error creating endpoints: %v", err)
+	}
+}
+
+func TestGetFuncSvcDeletesServiceOnFailure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ts, host, port := fakeFetcher(t)
+	defer ts.Close()
+	gp := newTestPool(t, newTestPod("ready", host, true))
+	defer gp.readyPodQueue.ShutDown()
+	cfg, err := fetcherConfig.MakeFetcherConfig("/userfunc")
+	if err != nil {
+		t.Fatalf("Error creating fetcher config: %v", err)
+	}
+	gp.fetcherConfig, gp.fetcherPort = cfg, port
+	gp.useSvc = true
+
+	// make the API server return a service with an unexpected name, so
+	// getFuncSvc fails after the service got created
+	kubernetesClient := gp.kubernetesClient.(*fake.Clientset)
+	kubernetesClient.PrependReactor("create", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		svc := action.(k8stesting.CreateAction).GetObject().(*apiv1.Service)
+		svc.Name = "unexpected-" + svc.Name
+		return false, nil, nil
+	})
+
+	fn := &fv1.Function{ObjectMeta: metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}}
+	_, err = gp.getFuncSvc(ctx, fn)
+	if err == nil {
+		t.Fatal("getFuncSvc succeeded, want sanity check error")
+	}
+
+	svcs, err := kubernetesClient.CoreV1().Services(metav1.NamespaceDefault).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Error listing services: %v", err)
+	}
+	if len(svcs.Items) != 0 {
+		t.Errorf("found %d dangling services, want none", len(svcs.Items))
+	}
+}
+
+func TestGetFuncSvcRequestTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ts, host, port := fakeFetcher(t)
+	defer ts.Close()
+	gp := newTestPool(t, newTestPod("ready-1", host, true), newTestPod("ready-2", host, true))
+	defer gp.readyPodQueue.ShutDown()
+	cfg, err := fetcherConfig.MakeFetcherConfig("/userfunc")
+	if err != nil {
+		t.Fatalf("Error creating fetcher config: %v", err)
+	}
+	gp.fetcherConfig, gp.fetcherPort = cfg, port
+	gp.fsCache = fscache.MakeFunctionServiceCache(gp.logger)
+
+	for _, tt := range []struct {
+		timeout int
+		want    time.Duration
+	}{
+		{0, time.Duration(fv1.DEFAULT_FUNCTION_TIMEOUT) * time.Second},
+		{5, 5 * time.Second},
+	} {
+		fn := &fv1.Function{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("fn-%d", tt.timeout), Namespace: metav1.NamespaceDefault,
+				UID: k8stypes.UID(fmt.Sprintf("fn-uid-%d", tt.timeout))},
+			Spec: fv1.FunctionSpec{FunctionTimeout: tt.timeout},
+		}
+		fsvc, err := gp.getFuncSvc(ctx, fn)
+		if err != nil {
+			t.Fatalf("Error getting function service: %v", err)
+		}
+		if fsvc.RequestTimeout != tt.want {
+			t.Errorf("request timeout of function with timeout %d = %v, want %v", tt.timeout, fsvc.RequestTimeout, tt.want)
+		}
+		if fsvc.ColdStartDuration <= 0 {
+			t.Errorf("cold start duration = %v, want the time taken to specialize", fsvc.ColdStartDuration)
+		}
+	}
+
+	status, err := gp.Status(ctx)
+	if err != nil {
+		t.Fatalf("Error getting pool status: %v", err)
+	}
+	if status.LastColdStart == nil || status.LastColdStart.IsZero() {
+		t.Errorf("last cold start = %v, want the time of the cold start", status.LastColdStart)
+	}
+	if status.LastColdStartDuration == nil || status.LastColdStartDuration.Duration <= 0 {
+		t.Errorf("last cold start duration = %v, want the time taken to specialize", status.LastColdStartDuration)
+	}
+}
+
+func TestGetFuncSvcReferencesService(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ts, host, port := fakeFetcher(t)
+	defer ts.Close()
+	gp := newTestPool(t, newTestPod("ready", host, true))
+	defer gp.readyPodQueue.ShutDown()
+	cfg, err := fetcherConfig.MakeFetcherConfig("/userfunc")
+	if err != nil {
+		t.Fatalf("Error creating fetcher config: %v", err)
+	}
+	gp.fetcherConfig, gp.fetcherPort = cfg, port
+	gp.fsCache = fscache.MakeFunctionServiceCache(gp.logger)
+	gp.useSvc = true
+	createTestEndpoints(t, gp, "svc-fn-fn-uid", host)
+
+	fn := &fv1.Function{ObjectMeta: metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}}
+	fsvc, err := gp.getFuncSvc(ctx, fn)
+	if err != nil {
+		t.Fatalf("Error getting function service: %v", err)
+	}
+	// the pod can still be reached directly, bypassing the service
+	if fsvc.PodIP != host || fsvc.Port != gp.runtimePort {
+		t.Errorf("function service pod address = %s:%d, want %s:%d", fsvc.PodIP, fsvc.Port, host, gp.runtimePort)
+	}
+
+	// the idle reaper only deletes what the function service references
+	for _, obj := range fsvc.KubernetesObjects {
+		reaper.CleanupKubeObject(ctx, gp.logger, gp.kubernetesClient, &obj)
+	}
+	svcs, err := gp.kubernetesClient.CoreV1().Services(metav1.NamespaceDefault).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Error listing services: %v", err)
+	}
+	if len(svcs.Items) != 0 {
+		t.Errorf("found %d services left after cleanup, want none", len(svcs.Items))
+	}
+}
+
+func TestCreateSvcPorts(t *testing.T) {
+	ctx := context.Background()
+	gp := newTestPool(t)
+	defer gp.readyPodQueue.ShutDown()
+	gp.env.Spec.Runtime.Container = &apiv1.Container{
+		Ports: []apiv1.ContainerPort{
+			{Name: "grpc", ContainerPort: 9090},
+			{ContainerPort: 9091, Protocol: apiv1.ProtocolUDP},
+		},
+	}
+
+	svc, err := gp.createSvc(ctx, "svc-fn", map[string]string{"functionName": "fn"}, gp.servicePorts(), false, nil)
+	if err != nil {
+		t.Fatalf("Error creating service: %v", err)
+	}
+	want := []apiv1.ServicePort{
+		{Name: "http-env", Protocol: apiv1.ProtocolTCP, Port: 8888, TargetPort: intstr.FromInt(8888)},
+		{Name: "grpc", Protocol: apiv1.ProtocolTCP, Port: 9090, TargetPort: intstr.FromInt(9090)},
+		{Name: "port-9091", Protocol: apiv1.ProtocolUDP, Port: 9091, TargetPort: intstr.FromInt(9091)},
+	}
+	if !reflect.DeepEqual(svc.Spec.Ports, want) {
+		t.Errorf("service ports = %v, want %v", svc.Spec.Ports, want)
+	}
+}
+
+func TestCreateSvcHeadless(t *testing.T) {
+	ctx := context.Background()
+	gp := newTestPool(t)
+	defer gp.readyPodQueue.ShutDown()
+
+	fn := &metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}
+	funcLabels := gp.labelsForFunction(fn)
+	svc, err := gp.createSvc(ctx, "svc-fn", funcLabels, gp.servicePorts(), true, nil)
+	if err != nil {
+		t.Fatalf("Error creating service: %v", err)
+	}
+	if svc.Spec.ClusterIP != apiv1.ClusterIPNone {
+		t.Errorf("service cluster IP = %q, want %q", svc.Spec.ClusterIP, apiv1.ClusterIPNone)
+	}
+	if !reflect.DeepEqual(svc.Spec.Selector, funcLabels) {
+		t.Errorf("service selector = %v, want function labels %v", svc.Spec.Selector, funcLabels)
+	}
+}
+
+func TestCreateSvcPublishNotReady(t *testing.T) {
+	ctx := context.Background()
+	gp := newTestPool(t)
+	defer gp.readyPodQueue.ShutDown()
+
+	fn := &metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}
+	funcLabels := gp.labelsForFunction(fn)
+	svc, err := gp.createSvc(ctx, "svc-fn", funcLabels, gp.servicePorts(), false, nil)
+	if err != nil {
+		t.Fatalf("Error creating service: %v", err)
+	}
+	if svc.Spec.PublishNotReadyAddresses {
+		t.Error("service publishes not ready addresses by default")
+	}
+
+	svcSpec := &functionSvcSpec{svcType: apiv1.ServiceTypeClusterIP, publishNotReady: true}
+	svc, err = gp.createSvc(ctx, "svc-fn-2", funcLabels, gp.servicePorts(), false, svcSpec)
+	if err != nil {
+		t.Fatalf("Error creating service: %v", err)
+	}
+	if !svc.Spec.PublishNotReadyAddresses {
+		t.Error("service doesn't publish not ready addresses")
+	}
+}
+
+func TestSpecializePodLogContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ts, host, port := fakeFetcher(t)
+	defer ts.Close()
+	pod := newTestPod("ready", host, true)
+	gp := newTestPool(t, pod)
+	defer gp.readyPodQueue.ShutDown()
+	cfg, err := fetcherConfig.MakeFetcherConfig("/userfunc")
+	if err != nil {
+		t.Fatalf("Error creating fetcher config: %v", err)
+	}
+	gp.fetcherConfig, gp.fetcherPort = cfg, port
+	core, logs := observer.New(zap.InfoLevel)
+	logPool, err := MakeGenericPool(zap.New(core), nil, gp.kubernetesClient, nil, gp.env,
+		metav1.NamespaceDefault, nil, nil, "test", false, 5*time.Second, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Error creating pool: %v", err)
+	}
+	gp.logger = logPool.logger
+
+	fn := &fv1.Function{ObjectMeta: metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}}
+	err = gp.specializePod(ctx, pod, fn, nil)
+	if err != nil {
+		t.Fatalf("Error specializing pod: %v", err)
+	}
+
+	entries := logs.FilterMessage("specializing pod").All()
+	if len(entries) != 1 {
+		t.Fatalf("found %d specializing pod log entries, want 1", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	for key, want := range map[string]string{"env": "test", "envNamespace": metav1.NamespaceDefault, "function": "fn", "pod": "ready"} {
+		if fields[key] != want {
+			t.Errorf("log field %q = %v, want %q", key, fields[key], want)
+		}
+	}
+}
+
+func TestSpecializePodFetcherFilename(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	requests := make(chan fetcher.FunctionSpecializeRequest, 2)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req fetcher.FunctionSpecializeRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			t.Errorf("error decoding specialize request: %v", err)
+		}
+		requests <- req
+		_, err = w.Write([]byte(`{"filename":"` + req.FetchReq.Filename + `","size":1}`))
+		if err != nil {
+			t.Errorf("error writing response: %v", err)
+		}
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("Error parsing fetcher url: %v", err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatalf("Error parsing fetcher port: %v", err)
+	}
+	pod := newTestPod("ready", u.Hostname(), true)
+	gp := newTestPool(t, pod)
+	defer gp.readyPodQueue.ShutDown()
+	cfg, err := fetcherConfig.MakeFetcherConfig("/userfunc")
+	if err != nil {
+		t.Fatalf("Error creating fetcher config: %v", err)
+	}
+	gp.fetcherConfig, gp.fetcherPort = cfg, int32(port)
+
+	fn := &fv1.Function{ObjectMeta: metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}}
+	for _, tt := range []struct {
+		annotations map[string]string
+		want        string
+	}{
+		{nil, "user"},
+		{map[string]string{fv1.ANNOTATION_FETCHER_FILENAME: "handler.py"}, "handler.py"},
+	} {
+		gp.env.ObjectMeta.Annotations = tt.annotations
+		err = gp.specializePod(ctx, pod, fn, nil)
+		if err != nil {
+			t.Fatalf("Error specializing pod: %v", err)
+		}
+		req := <-requests
+		if req.FetchReq.Filename != tt.want || req.LoadReq.FilePath != filepath.Join("/userfunc", tt.want) {
+			t.Errorf("fetcher got filename %q and load path %q, want %q", req.FetchReq.Filename, req.LoadReq.FilePath, tt.want)
+		}
+	}
+}
+
+func TestGetFuncSvcRechoosesDeletedPod(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ts, host, port := fakeFetcher(t)
+	defer ts.Close()
+	// nothing listens on 127.0.0.2, like a pod evicted after being chosen
+	gone := newTestPod("gone", "127.0.0.2", true)
+	gp := newTestPool(t, gone, newTestPod("ready", host, true))
+	defer gp.readyPodQueue.ShutDown()
+	cfg, err := fetcherConfig.MakeFetcherConfig("/userfunc")
+	if err != nil {
+		t.Fatalf("Error creating fetcher config: %v", err)
+	}
+	gp.fetcherConfig, gp.fetcherPort = cfg, port
+	gp.fsCache = fscache.MakeFunctionServiceCache(gp.logger)
+	gp.specializeMaxRetries = 1
+
+	fn := &fv1.Function{ObjectMeta: metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}}
+	fsvc, err := gp.getFuncSvc(ctx, fn)
+	if err != nil {
+		t.Fatalf("Error getting function service: %v", err)
+	}
+	if fsvc.Name != "ready" {
+		t.Errorf("function service uses pod %q, want %q", fsvc.Name, "ready")
+	}
+}
+
+func TestGetFuncSvcWaitsForSvcEndpoints(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ts, host, port := fakeFetcher(t)
+	defer ts.Close()
+	gp := newTestPool(t, newTestPod("ready", host, true))
+	defer gp.readyPodQueue.ShutDown()
+	cfg, err := fetcherConfig.MakeFetcherConfig("/userfunc")
+	if err != nil {
+		t.Fatalf("Error creating fetcher config: %v", err)
+	}
+	gp.fetcherConfig, gp.fetcherPort = cfg, port
+	gp.useSvc = true
+	gp.svcEndpointsTimeout = 300 * time.Millisecond
+	// the endpoints don't list the specialized pod yet
+	createTestEndpoints(t, gp, "svc-fn-fn-uid", "10.0.0.9")
+
+	fn := &fv1.Function{ObjectMeta: metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}}
+	_, err = gp.getFuncSvc(ctx, fn)
+	if !errors.Is(err, ErrSvcEndpointsTimeout) {
+		t.Fatalf("getFuncSvc returned %v, want %v", err, ErrSvcEndpointsTimeout)
+	}
+
+	svcs, err := gp.kubernetesClient.CoreV1().Services(metav1.NamespaceDefault).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Error listing services: %v", err)
+	}
+	if len(svcs.Items) != 0 {
+		t.Errorf("found %d dangling services, want none", len(svcs.Items))
+	}
+}
+
+func TestValidateFunctionLabels(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata metav1.ObjectMeta
+		wantErr  bool
+	}{
+		{"valid", metav1.ObjectMeta{Name: "hello", Namespace: "default", UID: "fn-uid"}, false},
+		{"name too long", metav1.ObjectMeta{Name: strings.Repeat("a", 64), Namespace: "default", UID: "fn-uid"}, true},
+		{"invalid uid", metav1.ObjectMeta{Name: "hello", Namespace: "default", UID: "fn uid"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateFunctionLabels(&tt.metadata)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateFunctionLabels() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSvcNameForFunctionVersions(t *testing.T) {
+	gp := newTestPool(t)
+	defer gp.readyPodQueue.ShutDown()
+
+	v1 := &metav1.ObjectMeta{Name: "hello", Namespace: metav1.NamespaceDefault, UID: "uid-1"}
+	v2 := &metav1.ObjectMeta{Name: "hello", Namespace: metav1.NamespaceDefault, UID: "uid-2"}
+	name1, err := svcNameForFunction(v1)
+	if err != nil {
+		t.Fatalf("Error getting service name: %v", err)
+	}
+	name2, err := svcNameForFunction(v2)
+	if err != nil {
+		t.Fatalf("Error getting service name: %v", err)
+	}
+	if name1 == name2 {
+		t.Errorf("versions of the function share service %q", name1)
+	}
+	// the service of a version must not select the pods of the other
+	selector1 := labels.SelectorFromSet(gp.labelsForFunction(v1))
+	if selector1.Matches(labels.Set(gp.labelsForFunction(v2))) {
+		t.Errorf("service selector %v of %s selects the pods of %s", selector1, v1.UID, v2.UID)
+	}
+}
+
+func TestGetFuncSvcInvalidServiceName(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	gp := newTestPool(t, newTestPod("ready", "10.0.0.1", true))
+	defer gp.readyPodQueue.ShutDown()
+	gp.useSvc = true
+
+	// a valid label value, but too long for the service name
+	fn := &fv1.Function{ObjectMeta: metav1.ObjectMeta{Name: strings.Repeat("a", 60), Namespace: metav1.NamespaceDefault, UID: "fn-uid"}}
+	_, err := gp.getFuncSvc(ctx, fn)
+	if err == nil || !strings.Contains(err.Error(), "invalid service name") {
+		t.Fatalf("getFuncSvc returned %v, want invalid service name error", err)
+	}
+	if gp.readyPodQueue.Len() != 1 {
+		t.Errorf("found %d pods in the queue, want the ready pod left unclaimed", gp.readyPodQueue.Len())
+	}
+}
+
+func TestGetFuncSvcConcurrentCallsShareSpecialization(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var specializations atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		specializations.Add(1)
+		// keep the specialization in flight while the other calls come in
+		time.Sleep(100 * time.Millisecond)
+		_, err := w.Write([]byte(`{"filename":"deployarchive","size":1}`))
+		if err != nil {
+			t.Errorf("error writing response: %v", err)
+		}
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("Error parsing fetcher url: %v", err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatalf("Error parsing fetcher port: %v", err)
+	}
+
+	const calls = 5
+	var pods []*apiv1.Pod
+	for i := 0; i < calls; i++ {
+		pods = append(pods, newTestPod(fmt.Sprintf("ready-%d", i), u.Hostname(), true))
+	}
+	gp := newTestPool(t, pods...)
+	defer gp.readyPodQueue.ShutDown()
+	cfg, err := fetcherConfig.MakeFetcherConfig("/userfunc")
+	if err != nil {
+		t.Fatalf("Error creating fetcher config: %v", err)
+	}
+	gp.fetcherConfig, gp.fetcherPort = cfg, int32(port)
+	gp.fsCache = fscache.MakeFunctionServiceCache(gp.logger)
+	gp.useSvc = true
+	createTestEndpoints(t, gp, "svc-fn-fn-uid", u.Hostname())
+
+	fn := &fv1.Function{ObjectMeta: metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}}
+	fsvcs := make([]*fscache.FuncSvc, calls)
+	var wg sync.WaitGroup
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fsvc, err := gp.getFuncSvc(ctx, fn)
+			if err != nil {
+				t.Errorf("Error getting function service: %v", err)
+				return
+			}
+			fsvcs[i] = fsvc
+		}(i)
+	}
+	wg.Wait()
+
+	if n := specializations.Load(); n != 1 {
+		t.Errorf("specialized %d pods, want 1", n)
+	}
+	for i, fsvc := range fsvcs {
+		if fsvc != nil && fsvc.Name != fsvcs[0].Name {
+			t.Errorf("call %d got pod %q, want %q", i, fsvc.Name, fsvcs[0].Name)
+		}
+	}
+}
+
+func TestGetFuncSvcRecordsEvent(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ts, host, port := fakeFetcher(t)
+	defer ts.Close()
+	gp := newTestPool(t, newTestPod("ready", host, true))
+	defer gp.readyPodQueue.ShutDown()
+	cfg, err := fetcherConfig.MakeFetcherConfig("/userfunc")
+	if err != nil {
+		t.Fatalf("Error creating fetcher config: %v", err)
+	}
+	gp.fetcherConfig, gp.fetcherPort = cfg, port
+	gp.fsCache = fscache.MakeFunctionServiceCache(gp.logger)
+	recorder := record.NewFakeRecorder(10)
+	gp.recorder = recorder
+	gp.deployment.Store(&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "poolmgr-test", Namespace: metav1.NamespaceDefault}})
+
+	fn := &fv1.Function{ObjectMeta: metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}}
+	_, err = gp.getFuncSvc(ctx, fn)
+	if err != nil {
+		t.Fatalf("Error getting function service: %v", err)
+	}
+
+	select {
+	case event := <-recorder.Events:
+		want := "Normal PodSpecialized Specialized pod ready for function default/fn"
+		if event != want {
+			t.Errorf("recorded event %q, want %q", event, want)
+		}
+	default:
+		t.Error("no event recorded for the specialized pod")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	deadline := time.Now().Add(time.Minute)
+	delay := 100 * time.Millisecond
+	for _, want := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond} {
+		d := backoff(&delay, deadline)
+		if d < want || d > want*3/2 {
+			t.Errorf("backoff = %v, want jittered %v", d, want)
+		}
+	}
+
+	delay = 4 * time.Second
+	backoff(&delay, deadline)
+	if delay != maxChoosePodBackoff {
+		t.Errorf("next delay = %v, want it capped at %v", delay, maxChoosePodBackoff)
+	}
+
+	if d := backoff(&delay, time.Now().Add(time.Second)); d > time.Second {
+		t.Errorf("backoff = %v, want at most the time left until the deadline", d)
+	}
+	if d := backoff(&delay, time.Now().Add(-time.Second)); d != 0 {
+		t.Errorf("backoff = %v past the deadline, want 0", d)
+	}
+}
+
+func TestSpecializeURL(t *testing.T) {
+	for _, tt := range []struct {
+		value string
+		podIP string
+		want  string
+	}{
+		{"", "10.0.0.1", "http://10.0.0.1:8000/"},
+		{"https://{host}:8443/", "10.0.0.1", "https://10.0.0.1:8443/"},
+		{"https://{host}:8443/", "fd00::1", "https://[fd00::1]:8443/"},
+		{"http://specialize-proxy.fission/{host}/{port}/", "10.0.0.1", "http://specialize-proxy.fission/10.0.0.1/8000/"},
+		{"http://specialize-proxy.fission/", "10.0.0.1", "http://10.0.0.1:8000/"},
+		{"unix://{host}", "10.0.0.1", "http://10.0.0.1:8000/"},
+	} {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("POOLMGR_SPECIALIZE_URL", tt.value)
+			gp := newTestPool(t)
+			defer gp.readyPodQueue.ShutDown()
+			gp.fetcherPort = 8000
+			if got := gp.getFetcherURL(tt.podIP); got != tt.want {
+				t.Errorf("fetcher url = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetFuncSvcSpecializeTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("Error parsing fetcher url: %v", err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatalf("Error parsing fetcher port: %v", err)
+	}
+
+	gp := newTestPool(t, newTestPod("ready", u.Hostname(), true))
+	defer gp.readyPodQueue.ShutDown()
+	cfg, err := fetcherConfig.MakeFetcherConfig("/userfunc")
+	if err != nil {
+		t.Fatalf("Error creating fetcher config: %v", err)
+	}
+	gp.fetcherConfig, gp.fetcherPort = cfg, int32(port)
+	gp.fsCache = fscache.MakeFunctionServiceCache(gp.logger)
+	gp.specializeMaxRetries = 1
+	gp.specializeTimeout = 100 * time.Millisecond
+
+	fn := &fv1.Function{ObjectMeta: metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}}
+	_, err = gp.getFuncSvc(ctx, fn)
+	if !errors.Is(err, ErrSpecializeTimeout) {
+		t.Fatalf("got error %v, want %v", err, ErrSpecializeTimeout)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("specialization was not bounded by the specialize timeout")
+	}
+}
+
+func TestGetFuncSvcReusesTimedOutPod(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the first specialize request times out, the retry succeeds
+	var requests atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		_, err := w.Write([]byte(`{"filename":"deployarchive","size":1}`))
+		if err != nil {
+			t.Errorf("error writing response: %v", err)
+		}
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("Error parsing fetcher url: %v", err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatalf("Error parsing fetcher port: %v", err)
+	}
+
+	gp := newTestPool(t, newTestPod("first", u.Hostname(), true), newTestPod("second", u.Hostname(), true))
+	defer gp.readyPodQueue.ShutDown()
+	cfg, err := fetcherConfig.MakeFetcherConfig("/userfunc")
+	if err != nil {
+		t.Fatalf("Error creating fetcher config: %v", err)
+	}
+	gp.fetcherConfig, gp.fetcherPort = cfg, int32(port)
+	gp.fsCache = fscache.MakeFunctionServiceCache(gp.logger)
+	gp.specializeMaxRetries = 1
+	gp.specializeTimeout = 100 * time.Millisecond
+
+	fn := &fv1.Function{ObjectMeta: metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}}
+	_, err = gp.getFuncSvc(ctx, fn)
+	if !errors.Is(err, ErrSpecializeTimeout) {
+		t.Fatalf("got error %v, want %v", err, ErrSpecializeTimeout)
+	}
+	partial, ok := gp.partialPods.Load(crd.CacheKey(&fn.ObjectMeta))
+	if !ok {
+		t.Fatal("pod of the timed out attempt wasn't kept")
+	}
+
+	gp.specializeTimeout = time.Second
+	fsvc, err := gp.getFuncSvc(ctx, fn)
+	if err != nil {
+		t.Fatalf("Error getting function service: %v", err)
+	}
+	if fsvc.Name != partial.(string) {
+		t.Errorf("function specialized on pod %q, want the pod of the timed out attempt %q", fsvc.Name, partial)
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("fetcher got %d specialize requests, want 2", got)
+	}
+	// the other pod is still ready for the next function
+	if gp.readyPodQueue.Len() != 1 {
+		t.Errorf("%d pods left in the ready pod queue, want 1", gp.readyPodQueue.Len())
+	}
+}
+
+func TestTakePartialPodDeletesStalePod(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	gp := newTestPool(t)
+	defer gp.readyPodQueue.ShutDown()
+	fn := &fv1.Function{ObjectMeta: metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}}
+	// the kept pod was relabeled for another function
+	pod := newSpecializedTestPod("partial", "10.0.0.1")
+	pod.Labels[fv1.FUNCTION_UID] = "other-fn-uid"
+	_, err := gp.kubernetesClient.CoreV1().Pods(pod.Namespace).Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Error creating pod: %v", err)
+	}
+	gp.partialPods.Store(crd.CacheKey(&fn.ObjectMeta), pod.Name)
+
+	if got := gp.takePartialPod(ctx, fn); got != nil {
+		t.Fatalf("took pod %q labeled for another function", got.Name)
+	}
+	err = wait.PollImmediateWithContext(ctx, 10*time.Millisecond, 2*time.Second, func(ctx context.Context) (bool, error) {
+		_, err := gp.kubernetesClient.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		return k8serrors.IsNotFound(err), nil
+	})
+	if err != nil {
+		t.Errorf("stale partial pod wasn't deleted: %v", err)
+	}
+}
+
+func TestGetFuncSvcSpecializeStage(t *testing.T) {
+	tests := []struct {
+		name      string
+		stage     string
+		status    int
+		wantStage string
+		wantErr   error
+	}{
+		{"fetch failed", fetcher.SpecializeStageFetch, http.StatusInternalServerError, fetcher.SpecializeStageFetch, ErrFetcherFailed},
+		{"load failed", fetcher.SpecializeStageLoad, http.StatusInternalServerError, fetcher.SpecializeStageLoad, ErrRuntimeLoadFailed},
+		{"fetcher without stage", "", http.StatusInternalServerError, fetcher.SpecializeStageFetch, ErrFetcherFailed},
+		{"package not found", fetcher.SpecializeStageFetch, http.StatusNotFound, fetcher.SpecializeStageFetch, ErrFetcherFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if len(tt.stage) > 0 {
+					w.Header().Set(fetcher.SpecializeStageHeader, tt.stage)
+				}
+				http.Error(w, "specialize failed", tt.status)
+			}))
+			defer ts.Close()
+			u, err := url.Parse(ts.URL)
+			if err != nil {
+				t.Fatalf("Error parsing fetcher url: %v", err)
+			}
+			port, err := strconv.Atoi(u.Port())
+			if err != nil {
+				t.Fatalf("Error parsing fetcher port: %v", err)
+			}
+
+			gp := newTestPool(t, newTestPod("ready", u.Hostname(), true))
+			defer gp.readyPodQueue.ShutDown()
+			cfg, err := fetcherConfig.MakeFetcherConfig("/userfunc")
+			if err != nil {
+				t.Fatalf("Error creating fetcher config: %v", err)
+			}
+			gp.fetcherConfig, gp.fetcherPort = cfg, int32(port)
+			gp.fsCache = fscache.MakeFunctionServiceCache(gp.logger)
+			gp.specializeMaxRetries = 1
+
+			fn := &fv1.Function{ObjectMeta: metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}}
+			_, err = gp.getFuncSvc(ctx, fn)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			var specializeErr *SpecializeError
+			if !errors.As(err, &specializeErr) {
+				t.Fatalf("got error %v, want a SpecializeError", err)
+			}
+			if specializeErr.Stage != tt.wantStage || specializeErr.StatusCode != tt.status {
+				t.Errorf("got stage %q and status %d, want %q and %d",
+					specializeErr.Stage, specializeErr.StatusCode, tt.wantStage, tt.status)
+			}
+		})
+	}
+}
+
+func TestAcquireSpecializeSlot(t *testing.T) {
+	ctx := context.Background()
+	gp := newTestPool(t)
+	defer gp.readyPodQueue.ShutDown()
+	gp.specializeSlots = make(chan struct{}, 1)
+
+	release, err := gp.acquireSpecializeSlot(ctx)
+	if err != nil {
+		t.Fatalf("Error acquiring specialize slot: %v", err)
+	}
+	status, err := gp.Status(ctx)
+	if err != nil {
+		t.Fatalf("Error getting pool status: %v", err)
+	}
+	if status.Specializing != 1 || status.MaxSpecializing != 1 {
+		t.Errorf("got %d of %d specializations, want 1 of 1", status.Specializing, status.MaxSpecializing)
+	}
+
+	// the next specialization waits for the slot until its context is done
+	waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = gp.acquireSpecializeSlot(waitCtx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		release, err := gp.acquireSpecializeSlot(ctx)
+		if err != nil {
+			t.Errorf("Error acquiring specialize slot: %v", err)
+			return
+		}
+		release()
+		close(acquired)
+	}()
+	release()
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("releasing the slot didn't let the waiting specialization proceed")
+	}
+	if n := gp.specializing.Load(); n != 0 {
+		t.Errorf("got %d specializations in progress, want none", n)
+	}
+}
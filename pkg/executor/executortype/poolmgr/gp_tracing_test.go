@@ -0,0 +1,91 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	fetcherConfig "github.com/fission/fission/pkg/fetcher/config"
+)
+
+func TestChooseAndSpecializePodSpans(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	ts, host, port := fakeFetcher(t)
+	defer ts.Close()
+	gp := newTestPool(t, newTestPod("ready", host, true))
+	defer gp.readyPodQueue.ShutDown()
+	cfg, err := fetcherConfig.MakeFetcherConfig("/userfunc")
+	if err != nil {
+		t.Fatalf("Error creating fetcher config: %v", err)
+	}
+	gp.fetcherConfig, gp.fetcherPort = cfg, port
+
+	ctx, parent := provider.Tracer("test").Start(ctx, "request")
+	fn := &fv1.Function{ObjectMeta: metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}}
+	_, err = gp.chooseAndSpecializePod(ctx, fn, gp.labelsForFunction(&fn.ObjectMeta))
+	parent.End()
+	if err != nil {
+		t.Fatalf("Error specializing pod: %v", err)
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	for _, name := range []string{"poolmgr/choosePod", "poolmgr/specializePod"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Fatalf("no %s span recorded", name)
+		}
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("%s span isn't a child of the request span", name)
+		}
+		hasPod := false
+		for _, attr := range span.Attributes() {
+			hasPod = hasPod || (attr.Key == "pod-name" && attr.Value.AsString() == "ready")
+		}
+		if !hasPod {
+			t.Errorf("%s span has attributes %v, want the pod", name, span.Attributes())
+		}
+	}
+	// the specialize request to the fetcher is traced within the span of
+	// the specialization
+	specializeID := spans["poolmgr/specializePod"].SpanContext().SpanID()
+	nested := false
+	for _, span := range recorder.Ended() {
+		nested = nested || span.Parent().SpanID() == specializeID
+	}
+	if !nested {
+		t.Error("no span of the specialize request nested in the specializePod span")
+	}
+}
@@ -38,8 +38,12 @@ import (
 	"k8s.io/apimachinery/pkg/util/wait"
 	k8sInformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	corelisters "k8s.io/client-go/listers/core/v1"
 	k8sCache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/flowcontrol"
 	metricsclient "k8s.io/metrics/pkg/client/clientset/versioned"
 
 	fv1 "github.com/fission/fission/pkg/apis/core/v1"
@@ -67,6 +71,17 @@ const (
 	CLEANUP_POOL
 )
 
+// pools are created at up to defaultPoolCreationQPS per second after an
+// initial burst of defaultPoolCreationBurst, see makePoolCreationLimiter
+const (
+	defaultPoolCreationQPS   = 2
+	defaultPoolCreationBurst = 5
+)
+
+// idleWindow is how long a function service must have served no request
+// before the idle object reaper considers it
+const idleWindow = 5 * time.Second
+
 type (
 	GenericPoolManager struct {
 		logger *zap.Logger
@@ -97,12 +112,47 @@ type (
 
 		podSpecPatch               *apiv1.PodSpec
 		objectReaperIntervalSecond time.Duration
+		podReadyTimeout            time.Duration
+		podSelector                string
+		// releaseIdlePods hands idle specialized pods back to their pool
+		// instead of deleting them, see GenericPool.releasePod
+		releaseIdlePods bool
+		// specializedPodMaxAge is how long a pod may serve its function
+		// before the reaper recycles it in an idle window, 0 for no limit
+		specializedPodMaxAge time.Duration
+
+		// poolConfig is handed to every pool, see makePoolConfig
+		poolConfig *poolConfig
+
+		// pods to keep warm by function UID, see GenericPool.PreWarm
+		prewarmed sync.Map
+
+		// recorder records pool lifecycle events on the pool deployments
+		recorder record.EventRecorder
+
+		// poolCreationLimiter spreads out the creation of pools, e.g. of
+		// all environments at startup, nil if creations aren't limited
+		poolCreationLimiter flowcontrol.RateLimiter
+
+		// hooks are run by the pools, see SetPoolHooks
+		hooks PoolHooks
+
+		// poolsByEnvUID holds the pools for the pod and node event
+		// handlers, which run outside of the service loop owning pools
+		poolsByEnvUID sync.Map
+		// nodeInformer watches the nodes of the cluster, nil unless
+		// POOLMGR_WATCH_NODES is set, see watchNodes
+		nodeInformer k8sCache.SharedIndexInformer
+		nodeLister   corelisters.NodeLister
 	}
 	request struct {
 		requestType
 		ctx             context.Context
 		env             *fv1.Environment
 		responseChannel chan *response
+		// admitted is set once the request took a token of the pool
+		// creation limiter
+		admitted bool
 	}
 	response struct {
 		error
@@ -134,8 +184,30 @@ func MakeGenericPoolManager(ctx context.Context,
 		enableIstio = istio
 	}
 
+	podReadyTimeoutStr := os.Getenv("POD_READY_TIMEOUT")
+	podReadyTimeout, err := time.ParseDuration(podReadyTimeoutStr)
+	if err != nil {
+		podReadyTimeout = defaultPodReadyTimeout
+		gpmLogger.Error("failed to parse pod ready timeout duration from 'POD_READY_TIMEOUT' - set to the default value",
+			zap.Error(err),
+			zap.String("value", podReadyTimeoutStr),
+			zap.Duration("default", podReadyTimeout))
+	}
+
+	releaseIdlePods := false
+	if len(os.Getenv("POOLMGR_RELEASE_IDLE_PODS")) > 0 {
+		releaseIdlePods, err = strconv.ParseBool(os.Getenv("POOLMGR_RELEASE_IDLE_PODS"))
+		if err != nil {
+			gpmLogger.Error("failed to parse 'POOLMGR_RELEASE_IDLE_PODS', set to false", zap.Error(err))
+		}
+	}
+
+	// 0 doesn't recycle specialized pods
+	specializedPodMaxAge := durationFromEnv(gpmLogger, "POOLMGR_SPECIALIZED_POD_MAX_AGE", 0, nonNegativeDuration)
+	idleServiceTTL := durationFromEnv(gpmLogger, "POOLMGR_IDLE_SERVICE_TTL", 2*time.Minute, positiveDuration)
+
 	poolPodC := NewPoolPodController(ctx, gpmLogger, kubernetesClient,
-		enableIstio, finformerFactory, gpmInformerFactory)
+		enableIstio, fetcherConfig, finformerFactory, gpmInformerFactory)
 
 	gpm := &GenericPoolManager{
 		logger:                     gpmLogger,
@@ -148,18 +220,35 @@ func MakeGenericPoolManager(ctx context.Context,
 		fsCache:                    fscache.MakeFunctionServiceCache(gpmLogger),
 		instanceID:                 instanceID,
 		requestChannel:             make(chan *request),
-		defaultIdlePodReapTime:     2 * time.Minute,
+		defaultIdlePodReapTime:     idleServiceTTL,
 		fetcherConfig:              fetcherConfig,
 		enableIstio:                enableIstio,
 		poolPodC:                   poolPodC,
 		podSpecPatch:               podSpecPatch,
 		objectReaperIntervalSecond: time.Duration(executorUtils.GetObjectReaperInterval(logger, fv1.ExecutorTypePoolmgr, 5)) * time.Second,
+		podReadyTimeout:            podReadyTimeout,
+		podSelector:                os.Getenv("POOLMGR_POD_SELECTOR"),
+		releaseIdlePods:            releaseIdlePods,
+		specializedPodMaxAge:       specializedPodMaxAge,
+		poolConfig:                 makePoolConfig(gpmLogger),
+		recorder:                   makeEventRecorder(kubernetesClient),
+		poolCreationLimiter:        makePoolCreationLimiter(gpmLogger),
 		podLister:                  make(map[string]corelisters.PodLister),
 		podListerSynced:            make(map[string]k8sCache.InformerSynced),
 	}
 	for ns, informerFactory := range gpmInformerFactory {
 		gpm.podLister[ns] = informerFactory.Core().V1().Pods().Lister()
 		gpm.podListerSynced[ns] = informerFactory.Core().V1().Pods().Informer().HasSynced
+		informerFactory.Core().V1().Pods().Informer().AddEventHandler(gpm.specializedPodEventHandlers())
+	}
+	if watchNodes := os.Getenv("POOLMGR_WATCH_NODES"); len(watchNodes) > 0 {
+		watch, err := strconv.ParseBool(watchNodes)
+		if err != nil {
+			gpmLogger.Error("failed to parse 'POOLMGR_WATCH_NODES', nodes are not watched", zap.Error(err))
+		}
+		if watch {
+			gpm.watchNodes()
+		}
 	}
 
 	gpm.logger.Debug("inside MakeGenericPoolManager")
@@ -167,11 +256,52 @@ func MakeGenericPoolManager(ctx context.Context,
 	return gpm, nil
 }
 
+// makePoolCreationLimiter returns the token bucket pool creations are
+// limited by, configured with POOLMGR_POOL_CREATION_QPS and
+// POOLMGR_POOL_CREATION_BURST. A rate of 0 disables the limit.
+func makePoolCreationLimiter(logger *zap.Logger) flowcontrol.RateLimiter {
+	qps := float64(defaultPoolCreationQPS)
+	if qpsStr := os.Getenv("POOLMGR_POOL_CREATION_QPS"); len(qpsStr) > 0 {
+		value, err := strconv.ParseFloat(qpsStr, 32)
+		if err != nil || value < 0 {
+			logger.Error("failed to parse 'POOLMGR_POOL_CREATION_QPS' - set to the default value",
+				zap.Error(err), zap.String("value", qpsStr), zap.Float64("default", qps))
+		} else {
+			qps = value
+		}
+	}
+	if qps == 0 {
+		return nil
+	}
+	burst := defaultPoolCreationBurst
+	if len(os.Getenv("POOLMGR_POOL_CREATION_BURST")) > 0 {
+		value, err := utils.GetUIntValueFromEnv("POOLMGR_POOL_CREATION_BURST")
+		if err != nil || value == 0 {
+			logger.Error("failed to parse 'POOLMGR_POOL_CREATION_BURST' - set to the default value",
+				zap.Error(err), zap.Int("default", burst))
+		} else {
+			burst = int(value)
+		}
+	}
+	return flowcontrol.NewTokenBucketRateLimiter(float32(qps), burst)
+}
+
+// SetPoolHooks sets the hooks the pools run on the lifecycle events of
+// their pods. Pools get the hooks when they're created, so set them before
+// Run.
+func (gpm *GenericPoolManager) SetPoolHooks(hooks PoolHooks) {
+	gpm.hooks = hooks
+}
+
 func (gpm *GenericPoolManager) Run(ctx context.Context) {
 	waitSynced := make([]k8sCache.InformerSynced, 0)
 	for _, podListerSynced := range gpm.podListerSynced {
 		waitSynced = append(waitSynced, podListerSynced)
 	}
+	if gpm.nodeInformer != nil {
+		go gpm.nodeInformer.Run(ctx.Done())
+		waitSynced = append(waitSynced, gpm.nodeInformer.HasSynced)
+	}
 	if ok := k8sCache.WaitForCacheSync(ctx.Done(), waitSynced...); !ok {
 		gpm.logger.Fatal("failed to wait for caches to sync")
 	}
@@ -188,7 +318,12 @@ func (gpm *GenericPoolManager) GetTypeName(ctx context.Context) fv1.ExecutorType
 }
 
 func (gpm *GenericPoolManager) GetFuncSvc(ctx context.Context, fn *fv1.Function) (fnSvc *fscache.FuncSvc, fErr error) {
+	ctx, span := startSpan(ctx, "GetFuncSvc", otelUtils.GetAttributesForFunction(fn)...)
 	defer func() {
+		if fnSvc != nil {
+			span.SetAttributes(attribute.String("pod-name", fnSvc.Name))
+		}
+		endSpan(span, fErr)
 		if fErr != nil {
 			metrics.ColdStartsError.WithLabelValues(fn.ObjectMeta.Name, fn.ObjectMeta.Namespace).Inc()
 			return
@@ -230,6 +365,22 @@ func (gpm *GenericPoolManager) GetFuncSvcFromCache(ctx context.Context, fn *fv1.
 	return gpm.fsCache.GetFuncSvc(ctx, &fn.ObjectMeta, fn.GetRequestPerPod(), fn.GetConcurrency())
 }
 
+// GetFuncSvcVersionFromCache returns a cached function service of the
+// version of the function with the given UID rather than of fn itself, e.g.
+// to route part of the requests of a function to another version of it.
+func (gpm *GenericPoolManager) GetFuncSvcVersionFromCache(ctx context.Context, fn *fv1.Function, uid k8sTypes.UID) (*fscache.FuncSvc, error) {
+	otelUtils.SpanTrackEvent(ctx, "GetFuncSvcVersionFromCache", otelUtils.GetAttributesForFunction(fn)...)
+	return gpm.fsCache.GetFuncSvcVersion(ctx, fn.ObjectMeta.Namespace, fn.ObjectMeta.Name, uid,
+		fn.GetRequestPerPod(), fn.GetConcurrency())
+}
+
+// GetFuncSvcByServiceName returns the cached function service the named
+// Kubernetes service was created for, so that a service can be resolved back
+// to its function.
+func (gpm *GenericPoolManager) GetFuncSvcByServiceName(name string) (*fscache.FuncSvc, bool) {
+	return gpm.fsCache.GetByServiceName(name)
+}
+
 func (gpm *GenericPoolManager) DeleteFuncSvcFromCache(ctx context.Context, fsvc *fscache.FuncSvc) {
 	otelUtils.SpanTrackEvent(ctx, "DeleteFuncSvcFromCache", fscache.GetAttributesForFuncSvc(fsvc)...)
 	gpm.fsCache.DeleteFunctionSvc(ctx, fsvc)
@@ -371,6 +522,7 @@ func (gpm *GenericPoolManager) AdoptExistingResources(ctx context.Context) {
 		fv1.EXECUTOR_TYPE: string(fv1.ExecutorTypePoolmgr),
 	}
 
+	_, runtimePort := getPorts(gpm.fetcherConfig)
 	for _, namespace := range utils.DefaultNSResolver().FissionResourceNS {
 		podList, err := gpm.kubernetesClient.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
 			LabelSelector: labels.Set(l).AsSelector().String(),
@@ -404,7 +556,7 @@ func (gpm *GenericPoolManager) AdoptExistingResources(ctx context.Context) {
 				}
 
 				// for unspecialized pod, we only update its annotations
-				if pod.Labels["managed"] == "true" {
+				if pod.Labels[fv1.MANAGED] == "true" {
 					return
 				}
 
@@ -434,6 +586,8 @@ func (gpm *GenericPoolManager) AdoptExistingResources(ctx context.Context) {
 					},
 					Environment: &env,
 					Address:     svcHost,
+					PodIP:       pod.Status.PodIP,
+					Port:        runtimePort,
 					KubernetesObjects: []apiv1.ObjectReference{
 						{
 							Kind:            "pod",
@@ -449,6 +603,12 @@ func (gpm *GenericPoolManager) AdoptExistingResources(ctx context.Context) {
 					Atime:    time.Now(),
 				}
 
+				// the service created for the function, if any, has to be
+				// referenced too for the idle object reaper to clean it up
+				if svcRef := gpm.functionServiceRef(ctx, fsvc.Function, pod.Namespace, svcHost); svcRef != nil {
+					fsvc.KubernetesObjects = append(fsvc.KubernetesObjects, *svcRef)
+				}
+
 				_, err = gpm.fsCache.Add(fsvc)
 				if err != nil {
 					// If fsvc already exists we just skip the duplicate one. And let reaper to recycle the duplicate pods.
@@ -469,6 +629,31 @@ func (gpm *GenericPoolManager) AdoptExistingResources(ctx context.Context) {
 	wg.Wait()
 }
 
+// functionServiceRef returns a reference to the service a pool created for
+// the function when svcHost routes through it, nil otherwise.
+func (gpm *GenericPoolManager) functionServiceRef(ctx context.Context, fn *metav1.ObjectMeta, namespace string, svcHost string) *apiv1.ObjectReference {
+	svcName, err := svcNameForFunction(fn)
+	if err != nil || !strings.HasPrefix(svcHost, svcName+".") {
+		return nil
+	}
+	svc, err := gpm.kubernetesClient.CoreV1().Services(namespace).Get(ctx, svcName, metav1.GetOptions{})
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			gpm.logger.Warn("error getting service of function", zap.Error(err),
+				zap.String("service", svcName), zap.String("ns", namespace))
+		}
+		return nil
+	}
+	return &apiv1.ObjectReference{
+		Kind:            "service",
+		Name:            svc.ObjectMeta.Name,
+		APIVersion:      svc.TypeMeta.APIVersion,
+		Namespace:       svc.ObjectMeta.Namespace,
+		ResourceVersion: svc.ObjectMeta.ResourceVersion,
+		UID:             svc.ObjectMeta.UID,
+	}
+}
+
 func (gpm *GenericPoolManager) CleanupOldExecutorObjects(ctx context.Context) {
 	gpm.logger.Info("Poolmanager starts to clean orphaned resources", zap.String("instanceID", gpm.instanceID))
 
@@ -496,51 +681,135 @@ func (gpm *GenericPoolManager) CleanupOldExecutorObjects(ctx context.Context) {
 func (gpm *GenericPoolManager) service() {
 	for {
 		req := <-gpm.requestChannel
-		switch req.requestType {
-		case GET_POOL:
-			// just because they are missing in the cache, we end up creating another duplicate pool.
-			var err error
-			created := false
-			pool, ok := gpm.pools[crd.CacheKeyUID(&req.env.ObjectMeta)]
-			if !ok {
-				// To support backward compatibility, if envs are created in default ns, we go ahead
-				// and create pools in fission-function ns as earlier.
-				ns := gpm.nsResolver.GetFunctionNS(req.env.ObjectMeta.Namespace)
-				pool = MakeGenericPool(gpm.logger, gpm.fissionClient, gpm.kubernetesClient,
-					gpm.metricsClient, req.env, ns, gpm.fsCache,
-					gpm.fetcherConfig, gpm.instanceID, gpm.enableIstio, gpm.podSpecPatch)
-				err = pool.setup(req.ctx)
-				if err != nil {
-					req.responseChannel <- &response{error: err}
-					continue
-				}
-				gpm.pools[crd.CacheKeyUID(&req.env.ObjectMeta)] = pool
-				created = true
+		gpm.serveRequest(req)
+	}
+}
+
+// serveRequest serves a request of the service loop. A panic serving it,
+// e.g. on a malformed environment, fails the request rather than killing the
+// loop, which would leave every later request hanging.
+func (gpm *GenericPoolManager) serveRequest(req *request) {
+	responded := false
+	respond := func(resp *response) {
+		responded = true
+		req.responseChannel <- resp
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			gpm.logger.Error("panic serving pool manager request", zap.Any("panic", e), zap.Stack("stack"))
+			if req.requestType == GET_POOL && !responded {
+				respond(&response{error: fmt.Errorf("error getting pool: %v", e)})
 			}
-			req.responseChannel <- &response{pool: pool, created: created}
-		case CLEANUP_POOL:
-			env := *req.env
-			gpm.logger.Info("destroying pool",
-				zap.String("environment", env.ObjectMeta.Name),
-				zap.String("namespace", env.ObjectMeta.Namespace))
+		}
+	}()
 
-			key := crd.CacheKeyUID(&req.env.ObjectMeta)
-			pool, ok := gpm.pools[key]
-			if !ok {
-				gpm.logger.Error("Could not find pool", zap.String("environment", env.ObjectMeta.Name), zap.String("namespace", env.ObjectMeta.Namespace))
+	switch req.requestType {
+	case GET_POOL:
+		// just because they are missing in the cache, we end up creating another duplicate pool.
+		var err error
+		created := false
+		pool, ok := gpm.pools[crd.CacheKeyUID(&req.env.ObjectMeta)]
+		if !ok && gpm.poolCreationLimiter != nil && !req.admitted && !gpm.poolCreationLimiter.TryAccept() {
+			// wait for a token without holding up requests for
+			// existing pools
+			go gpm.admitPoolCreation(req)
+			return
+		}
+		if !ok {
+			// To support backward compatibility, if envs are created in default ns, we go ahead
+			// and create pools in fission-function ns as earlier. Any other environment gets its
+			// pool, services and pods in its own namespace, which is how tenants are isolated
+			// with network policies and quotas.
+			ns := gpm.nsResolver.GetFunctionNS(req.env.ObjectMeta.Namespace)
+			pool, err = MakeGenericPool(gpm.logger, gpm.fissionClient, gpm.kubernetesClient,
+				gpm.metricsClient, req.env, ns, gpm.fsCache,
+				gpm.fetcherConfig, gpm.instanceID, gpm.enableIstio, gpm.podReadyTimeout, gpm.podSpecPatch,
+				makePodSelector(gpm.podSelector, gpm.podLister[ns], ns), gpm.poolConfig)
+			if err != nil {
+				respond(&response{error: err})
 				return
 			}
-			delete(gpm.pools, key)
-			err := pool.destroy(req.ctx)
+			pool.prewarmed = &gpm.prewarmed
+			pool.recorder = gpm.recorder
+			pool.specializedPodMaxAge = gpm.specializedPodMaxAge
+			pool.PoolHooks = gpm.hooks
+			// the pods of the namespaces the manager watches are handed to
+			// the pool by specializedPodEventHandlers
+			pool.specializedPods = gpm.podLister[ns]
+			pool.nodeLister = gpm.nodeLister
+
+			err = pool.setup(req.ctx)
 			if err != nil {
-				gpm.logger.Error("failed to destroy pool",
-					zap.String("environment", env.ObjectMeta.Name),
-					zap.String("namespace", env.ObjectMeta.Namespace),
-					zap.Error(err))
+				respond(&response{error: err})
+				return
+			}
+			gpm.pools[crd.CacheKeyUID(&req.env.ObjectMeta)] = pool
+			gpm.poolsByEnvUID.Store(req.env.ObjectMeta.UID, pool)
+			created = true
+			// the request context ends before the pods are ready
+			go gpm.preWarmFunctions(context.Background(), pool, req.env)
+		}
+		respond(&response{pool: pool, created: created})
+	case CLEANUP_POOL:
+		env := *req.env
+		gpm.logger.Info("destroying pool",
+			zap.String("environment", env.ObjectMeta.Name),
+			zap.String("namespace", env.ObjectMeta.Namespace))
+
+		key := crd.CacheKeyUID(&req.env.ObjectMeta)
+		pool, ok := gpm.pools[key]
+		if !ok {
+			gpm.logger.Error("Could not find pool", zap.String("environment", env.ObjectMeta.Name), zap.String("namespace", env.ObjectMeta.Namespace))
+			return
+		}
+		delete(gpm.pools, key)
+		gpm.poolsByEnvUID.Delete(req.env.ObjectMeta.UID)
+		err := pool.destroy(req.ctx)
+		if err != nil {
+			gpm.logger.Error("failed to destroy pool",
+				zap.String("environment", env.ObjectMeta.Name),
+				zap.String("namespace", env.ObjectMeta.Namespace),
+				zap.Error(err))
+		}
+		// no response, caller doesn't wait
+	}
+}
+
+// admitPoolCreation waits for a token of the pool creation limiter and
+// hands the request back to the service loop to create the pool.
+func (gpm *GenericPoolManager) admitPoolCreation(req *request) {
+	err := gpm.poolCreationLimiter.Wait(req.ctx)
+	if err != nil {
+		req.responseChannel <- &response{error: fmt.Errorf("waiting to create pool for environment %s/%s: %w",
+			req.env.ObjectMeta.Namespace, req.env.ObjectMeta.Name, err)}
+		return
+	}
+	req.admitted = true
+	gpm.requestChannel <- req
+}
+
+// preWarmFunctions pre-warms the poolmgr functions of the environment
+// annotated with fv1.ANNOTATION_PREWARM in its newly created pool.
+func (gpm *GenericPoolManager) preWarmFunctions(ctx context.Context, pool *GenericPool, env *fv1.Environment) {
+	var fns []*fv1.Function
+	for _, namespace := range utils.DefaultNSResolver().FissionResourceNS {
+		list, err := gpm.fissionClient.CoreV1().Functions(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			gpm.logger.Error("failed to get function list to pre-warm", zap.Error(err), zap.String("namespace", namespace))
+			return
+		}
+		for i := range list.Items {
+			fn := &list.Items[i]
+			if _, ok := fn.ObjectMeta.Annotations[fv1.ANNOTATION_PREWARM]; !ok ||
+				fn.Spec.InvokeStrategy.ExecutionStrategy.ExecutorType != fv1.ExecutorTypePoolmgr ||
+				fn.Spec.Environment.Name != env.ObjectMeta.Name ||
+				fn.Spec.Environment.Namespace != env.ObjectMeta.Namespace {
+				continue
 			}
-			// no response, caller doesn't wait
+			fns = append(fns, fn)
 		}
 	}
+	pool.preWarmFunctions(ctx, fns)
 }
 
 func (gpm *GenericPoolManager) getPool(ctx context.Context, env *fv1.Environment) (*GenericPool, bool, error) {
@@ -565,6 +834,74 @@ func (gpm *GenericPoolManager) cleanupPool(ctx context.Context, env *fv1.Environ
 	}
 }
 
+// ReapPool removes the pool of the environment from the cluster: its
+// deployment, function services and specialized pods. It goes by labels
+// rather than by the pool in memory, so it also cleans up after the executor
+// restarted. The pool in memory, if any, is destroyed as well.
+func (gpm *GenericPoolManager) ReapPool(ctx context.Context, env *fv1.Environment) error {
+	gpm.cleanupPool(ctx, env)
+
+	ns := gpm.nsResolver.GetFunctionNS(env.ObjectMeta.Namespace)
+	listOpts := metav1.ListOptions{
+		LabelSelector: labels.Set(map[string]string{
+			fv1.EXECUTOR_TYPE:         string(fv1.ExecutorTypePoolmgr),
+			fv1.ENVIRONMENT_NAME:      env.ObjectMeta.Name,
+			fv1.ENVIRONMENT_NAMESPACE: env.ObjectMeta.Namespace,
+		}).AsSelector().String(),
+	}
+	deletePropagation := metav1.DeletePropagationBackground
+	delOpt := metav1.DeleteOptions{
+		PropagationPolicy: &deletePropagation,
+	}
+	errs := &multierror.Error{}
+
+	deployList, err := gpm.kubernetesClient.AppsV1().Deployments(ns).List(ctx, listOpts)
+	if err != nil {
+		errs = multierror.Append(errs, fmt.Errorf("error listing deployments: %w", err))
+	} else {
+		for _, deploy := range deployList.Items {
+			err = gpm.kubernetesClient.AppsV1().Deployments(ns).Delete(ctx, deploy.ObjectMeta.Name, delOpt)
+			if err != nil && !k8serrors.IsNotFound(err) {
+				errs = multierror.Append(errs, fmt.Errorf("error deleting deployment %s: %w", deploy.ObjectMeta.Name, err))
+			}
+		}
+	}
+
+	svcList, err := gpm.kubernetesClient.CoreV1().Services(ns).List(ctx, listOpts)
+	if err != nil {
+		errs = multierror.Append(errs, fmt.Errorf("error listing function services: %w", err))
+	} else {
+		for _, svc := range svcList.Items {
+			err = gpm.kubernetesClient.CoreV1().Services(ns).Delete(ctx, svc.ObjectMeta.Name, metav1.DeleteOptions{})
+			if err != nil && !k8serrors.IsNotFound(err) {
+				errs = multierror.Append(errs, fmt.Errorf("error deleting function service %s: %w", svc.ObjectMeta.Name, err))
+				continue
+			}
+			if fsvc, ok := gpm.fsCache.GetByServiceName(svc.ObjectMeta.Name); ok {
+				gpm.fsCache.DeleteFunctionSvc(ctx, fsvc)
+			}
+		}
+	}
+
+	// generic pods go with their deployment, specialized pods don't
+	podList, err := gpm.kubernetesClient.CoreV1().Pods(ns).List(ctx, listOpts)
+	if err != nil {
+		errs = multierror.Append(errs, fmt.Errorf("error listing pods: %w", err))
+	} else {
+		for _, pod := range podList.Items {
+			err = gpm.kubernetesClient.CoreV1().Pods(ns).Delete(ctx, pod.ObjectMeta.Name, metav1.DeleteOptions{})
+			if err != nil && !k8serrors.IsNotFound(err) {
+				errs = multierror.Append(errs, fmt.Errorf("error deleting pod %s: %w", pod.ObjectMeta.Name, err))
+			}
+		}
+	}
+
+	gpm.logger.Info("reaped pool",
+		zap.String("environment", env.ObjectMeta.Name),
+		zap.String("namespace", env.ObjectMeta.Namespace))
+	return errs.ErrorOrNil()
+}
+
 func (gpm *GenericPoolManager) getFunctionEnv(ctx context.Context, fn *fv1.Function) (*fv1.Environment, error) {
 	var env *fv1.Environment
 	otelUtils.SpanTrackEvent(ctx, "getFunctionEnv", otelUtils.GetAttributesForFunction(fn)...)
@@ -600,13 +937,35 @@ func (gpm *GenericPoolManager) getFunctionEnv(ctx context.Context, fn *fv1.Funct
 	return env, nil
 }
 
+// makeEventRecorder returns a recorder sending the events of the pools to
+// the API server, so they show up in kubectl describe.
+func makeEventRecorder(kubernetesClient kubernetes.Interface) record.EventRecorder {
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: kubernetesClient.CoreV1().Events(""),
+	})
+	return eventBroadcaster.NewRecorder(scheme.Scheme, apiv1.EventSource{Component: "executor"})
+}
+
 // idleObjectReaper reaps objects after certain idle time
 func (gpm *GenericPoolManager) idleObjectReaper(ctx context.Context) {
-	// calling function doIdleObjectReaper() repeatedly at given interval of time
-	wait.UntilWithContext(ctx, gpm.doIdleObjectReaper, gpm.objectReaperIntervalSecond)
+	// calling function reapIdleServices() repeatedly at given interval of time
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		gpm.reapIdleServices(ctx, gpm.defaultIdlePodReapTime)
+	}, gpm.objectReaperIntervalSecond)
 }
 
-func (gpm *GenericPoolManager) doIdleObjectReaper(ctx context.Context) {
+// reapIdleServices deletes the objects of function services which weren't
+// accessed for longer than ttl, or the idle timeout of their function if it
+// has one, and serve no request. The access time of a function service is
+// refreshed by each request for its function, see FuncSvc.UpdateAccessTime.
+// The function service, if any, is deleted. Specialized pods are deleted
+// and the pool deployment replaces them with fresh generic pods, unless
+// releaseIdlePods is set for runtimes able to unload their function.
+// Pods specialized longer than specializedPodMaxAge ago are recycled as soon
+// as they're idle, i.e. once they served no request for idleWindow, so that
+// a busy pod is never deleted mid-request.
+func (gpm *GenericPoolManager) reapIdleServices(ctx context.Context, ttl time.Duration) {
 	envList := make(map[k8sTypes.UID]struct{})
 	for _, namespace := range utils.DefaultNSResolver().FissionResourceNS {
 		envs, err := gpm.fissionClient.CoreV1().Environments(namespace).List(ctx, metav1.ListOptions{})
@@ -632,11 +991,13 @@ func (gpm *GenericPoolManager) doIdleObjectReaper(ctx context.Context) {
 		}
 	}
 
-	funcSvcs, err := gpm.fsCache.ListOldForPool(time.Second * 5)
+	funcSvcs, err := gpm.fsCache.ListOldForPool(idleWindow)
 	if err != nil {
 		gpm.logger.Error("error reaping idle pods", zap.Error(err))
 		return
 	}
+	// idle pods spared per function to keep its pre-warmed pods
+	spared := make(map[string]int)
 
 	for i := range funcSvcs {
 		fsvc := funcSvcs[i]
@@ -650,7 +1011,8 @@ func (gpm *GenericPoolManager) doIdleObjectReaper(ctx context.Context) {
 		}
 		// For function with the environment that no longer exists, executor
 		// cleanups the idle pod as usual and prints log to notify user.
-		if _, ok := envList[fsvc.Environment.ObjectMeta.UID]; !ok {
+		_, envExists := envList[fsvc.Environment.ObjectMeta.UID]
+		if !envExists {
 			gpm.logger.Warn("function environment no longer exists",
 				zap.String("environment", fsvc.Environment.ObjectMeta.Name),
 				zap.String("function", fsvc.Name))
@@ -660,17 +1022,29 @@ func (gpm *GenericPoolManager) doIdleObjectReaper(ctx context.Context) {
 			continue
 		}
 
-		idlePodReapTime := gpm.defaultIdlePodReapTime
-		if fn, ok := fnList[fsvc.Function.UID]; ok {
-			if fn.Spec.IdleTimeout != nil {
-				idlePodReapTime = time.Duration(*fn.Spec.IdleTimeout) * time.Second
-			}
+		idlePodReapTime := ttl
+		fn, fnExists := fnList[fsvc.Function.UID]
+		if fnExists && fn.Spec.IdleTimeout != nil {
+			idlePodReapTime = time.Duration(*fn.Spec.IdleTimeout) * time.Second
 		}
 
-		if time.Since(fsvc.Atime) < idlePodReapTime {
+		expired := gpm.specializedPodExpired(fsvc)
+		if expired {
+			idlePodReapTime = idleWindow
+		} else if time.Since(fsvc.Atime) < idlePodReapTime {
 			continue
 		}
 
+		fnKey := crd.CacheKeyUID(fsvc.Function)
+		if keep, ok := gpm.prewarmed.Load(fnKey); ok {
+			if !fnExists {
+				gpm.prewarmed.Delete(fnKey)
+			} else if !expired && spared[fnKey] < keep.(int) {
+				spared[fnKey]++
+				continue
+			}
+		}
+
 		go func() {
 			deleted, err := gpm.fsCache.DeleteOldPoolCache(ctx, fsvc, idlePodReapTime)
 			if err != nil {
@@ -679,14 +1053,22 @@ func (gpm *GenericPoolManager) doIdleObjectReaper(ctx context.Context) {
 					zap.Any("service", fsvc))
 			}
 			if deleted {
+				gpm.hooks.reaped(gpm.logger, fsvc)
 				for i := range fsvc.KubernetesObjects {
 					gpm.logger.Info("release idle function resources",
 						zap.String("function", fsvc.Function.Name),
 						zap.String("address", fsvc.Address),
 						zap.String("executor", string(fsvc.Executor)),
 						zap.String("pod", fsvc.Name),
+						zap.Bool("expired", expired),
 					)
-					reaper.CleanupKubeObject(ctx, gpm.logger, gpm.kubernetesClient, &fsvc.KubernetesObjects[i])
+					obj := &fsvc.KubernetesObjects[i]
+					// expired pods are replaced rather than reused
+					if gpm.releaseIdlePods && !expired && envExists && obj.Kind == "pod" {
+						gpm.releaseIdlePod(ctx, fsvc.Environment, obj)
+					} else {
+						reaper.CleanupKubeObject(ctx, gpm.logger, gpm.kubernetesClient, obj)
+					}
 					time.Sleep(50 * time.Millisecond)
 				}
 			}
@@ -694,6 +1076,37 @@ func (gpm *GenericPoolManager) doIdleObjectReaper(ctx context.Context) {
 	}
 }
 
+// specializedPodExpired returns whether the pod of fsvc was specialized for
+// longer than the specialized pod max age.
+func (gpm *GenericPoolManager) specializedPodExpired(fsvc *fscache.FuncSvc) bool {
+	return gpm.specializedPodMaxAge > 0 && !fsvc.Ctime.IsZero() &&
+		time.Since(fsvc.Ctime) >= gpm.specializedPodMaxAge
+}
+
+// releaseIdlePod hands an idle specialized pod back to the pool of its
+// environment, falling back to deleting it.
+func (gpm *GenericPoolManager) releaseIdlePod(ctx context.Context, env *fv1.Environment, obj *apiv1.ObjectReference) {
+	pod, err := gpm.kubernetesClient.CoreV1().Pods(obj.Namespace).Get(ctx, obj.Name, metav1.GetOptions{})
+	if err != nil {
+		if !k8serrors.IsNotFound(err) {
+			gpm.logger.Error("error getting idle pod", zap.Error(err), zap.String("pod", obj.Name))
+			reaper.CleanupKubeObject(ctx, gpm.logger, gpm.kubernetesClient, obj)
+		}
+		return
+	}
+	pool, _, err := gpm.getPool(ctx, env)
+	if err != nil {
+		gpm.logger.Error("error getting pool to release idle pod", zap.Error(err), zap.String("pod", obj.Name))
+		reaper.CleanupKubeObject(ctx, gpm.logger, gpm.kubernetesClient, obj)
+		return
+	}
+	// releasePod deletes the pod itself when it can't be released
+	err = pool.releasePod(ctx, pod)
+	if err != nil {
+		gpm.logger.Warn("error releasing idle pod", zap.Error(err), zap.String("pod", obj.Name))
+	}
+}
+
 // WebsocketStartEventChecker checks if the pod has emitted a websocket connection start event
 func (gpm *GenericPoolManager) WebsocketStartEventChecker(ctx context.Context, kubeClient kubernetes.Interface) {
 	stopper := make(chan struct{})
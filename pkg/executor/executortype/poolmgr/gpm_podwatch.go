@@ -0,0 +1,96 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	apiv1 "k8s.io/api/core/v1"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	k8sInformers "k8s.io/client-go/informers"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+// poolForPod returns the pool a specialized pod belongs to, nil if the pod
+// isn't a specialized pod of one of the pools.
+func (gpm *GenericPoolManager) poolForPod(pod *apiv1.Pod) *GenericPool {
+	if pod.Labels[fv1.EXECUTOR_TYPE] != string(fv1.ExecutorTypePoolmgr) || pod.Labels[fv1.MANAGED] != "false" {
+		return nil
+	}
+	obj, ok := gpm.poolsByEnvUID.Load(k8stypes.UID(pod.Labels[fv1.ENVIRONMENT_UID]))
+	if !ok {
+		return nil
+	}
+	return obj.(*GenericPool)
+}
+
+// specializedPodEventHandlers hands the updates of the specialized pods
+// seen by the pod informers of the manager to their pools, see
+// GenericPool.watchSpecializedPods.
+func (gpm *GenericPoolManager) specializedPodEventHandlers() k8sCache.ResourceEventHandlerFuncs {
+	return k8sCache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			pod, ok := newObj.(*apiv1.Pod)
+			if !ok {
+				return
+			}
+			if pool := gpm.poolForPod(pod); pool != nil {
+				pool.handleSpecializedPod(context.Background(), pod)
+			}
+		},
+	}
+}
+
+// watchNodes watches the nodes of the cluster, so that the functions of
+// specialized pods on a node which gets cordoned or goes NotReady move to
+// other pods before the pods are evicted, see nodeUnavailable. It needs
+// the executor to be allowed to list and watch nodes.
+func (gpm *GenericPoolManager) watchNodes() {
+	factory := k8sInformers.NewSharedInformerFactory(gpm.kubernetesClient, 30*time.Minute)
+	nodes := factory.Core().V1().Nodes()
+	nodes.Informer().AddEventHandler(k8sCache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldNode, ok := oldObj.(*apiv1.Node)
+			if !ok {
+				return
+			}
+			node, ok := newObj.(*apiv1.Node)
+			if !ok || nodeUnavailable(oldNode) || !nodeUnavailable(node) {
+				return
+			}
+			gpm.handleUnavailableNode(context.Background(), node)
+		},
+	})
+	gpm.nodeInformer = nodes.Informer()
+	gpm.nodeLister = nodes.Lister()
+}
+
+// handleUnavailableNode lets every pool move its functions off a node which
+// became unavailable.
+func (gpm *GenericPoolManager) handleUnavailableNode(ctx context.Context, node *apiv1.Node) {
+	gpm.logger.Info("node unavailable, moving specialized pods off it",
+		zap.String("node", node.ObjectMeta.Name),
+		zap.Bool("unschedulable", node.Spec.Unschedulable))
+	gpm.poolsByEnvUID.Range(func(_, obj interface{}) bool {
+		obj.(*GenericPool).handleUnavailableNode(ctx, node)
+		return true
+	})
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"testing"
+
+	apiv1 "k8s.io/api/core/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/utils/loggerfactory"
+)
+
+func TestPoolForPod(t *testing.T) {
+	gp := newTestPool(t)
+	defer gp.readyPodQueue.ShutDown()
+	gpm := &GenericPoolManager{logger: loggerfactory.GetLogger()}
+	gpm.poolsByEnvUID.Store(gp.env.ObjectMeta.UID, gp)
+
+	otherEnv := newSpecializedTestPod("other-env", "10.0.0.2")
+	otherEnv.Labels[fv1.ENVIRONMENT_UID] = "other-uid"
+	builder := newSpecializedTestPod("builder", "10.0.0.3")
+	builder.Labels[fv1.EXECUTOR_TYPE] = ""
+
+	tests := []struct {
+		name string
+		pod  *apiv1.Pod
+		want *GenericPool
+	}{
+		{"specialized pod", newSpecializedTestPod("specialized", "10.0.0.1"), gp},
+		{"pod of the pool", newTestPod("generic", "10.0.0.1", true), nil},
+		{"pod of another environment", otherEnv, nil},
+		{"pod of another executor", builder, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := gpm.poolForPod(tt.pod); got != tt.want {
+				t.Errorf("poolForPod() = %p, want %p", got, tt.want)
+			}
+		})
+	}
+
+	gpm.poolsByEnvUID.Delete(gp.env.ObjectMeta.UID)
+	if got := gpm.poolForPod(newSpecializedTestPod("specialized", "10.0.0.1")); got != nil {
+		t.Errorf("poolForPod() = %p after the pool is cleaned up, want none", got)
+	}
+}
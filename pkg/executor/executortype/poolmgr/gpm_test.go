@@ -0,0 +1,408 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/util/flowcontrol"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/crd"
+	"github.com/fission/fission/pkg/executor/fscache"
+	fClient "github.com/fission/fission/pkg/generated/clientset/versioned/fake"
+	"github.com/fission/fission/pkg/utils"
+	"github.com/fission/fission/pkg/utils/loggerfactory"
+)
+
+func TestFunctionServiceRef(t *testing.T) {
+	fn := &metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}
+	svc := &apiv1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc-fn-fn-uid", Namespace: metav1.NamespaceDefault, UID: "svc-uid"}}
+	gpm := &GenericPoolManager{
+		logger:           loggerfactory.GetLogger(),
+		kubernetesClient: fake.NewSimpleClientset(svc),
+	}
+
+	tests := []struct {
+		name    string
+		svcHost string
+		want    bool
+	}{
+		{"pod behind the function service", "svc-fn-fn-uid.default:8888", true},
+		{"pod addressed directly", "10.0.0.1:8888", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ref := gpm.functionServiceRef(context.Background(), fn, metav1.NamespaceDefault, tt.svcHost)
+			if !tt.want {
+				if ref != nil {
+					t.Fatalf("got reference to %v, want none", ref)
+				}
+				return
+			}
+			if ref == nil || ref.Kind != "service" || ref.Name != svc.Name || ref.UID != svc.UID {
+				t.Fatalf("got reference %v, want service %q", ref, svc.Name)
+			}
+		})
+	}
+
+	other := &metav1.ObjectMeta{Name: "other", Namespace: metav1.NamespaceDefault, UID: "other-uid"}
+	if ref := gpm.functionServiceRef(context.Background(), other, metav1.NamespaceDefault, "svc-other-other-uid.default:8888"); ref != nil {
+		t.Errorf("got reference to %v for a function without service, want none", ref)
+	}
+}
+
+func TestCleanupUnknownPoolKeepsServing(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	env := &fv1.Environment{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: metav1.NamespaceDefault, UID: "env-uid"}}
+	pool := &GenericPool{env: env}
+	gpm := &GenericPoolManager{
+		logger:         loggerfactory.GetLogger(),
+		pools:          map[string]*GenericPool{crd.CacheKeyUID(&env.ObjectMeta): pool},
+		requestChannel: make(chan *request),
+	}
+	go gpm.service()
+
+	unknown := &fv1.Environment{ObjectMeta: metav1.ObjectMeta{Name: "unknown", Namespace: metav1.NamespaceDefault, UID: "unknown-uid"}}
+	gpm.cleanupPool(ctx, unknown)
+
+	got := make(chan *GenericPool)
+	go func() {
+		p, _, err := gpm.getPool(ctx, env)
+		if err != nil {
+			t.Errorf("Error getting pool: %v", err)
+		}
+		got <- p
+	}()
+	select {
+	case p := <-got:
+		if p != pool {
+			t.Errorf("got pool %p, want the cached pool %p", p, pool)
+		}
+	case <-ctx.Done():
+		t.Fatal("pool manager stopped serving after cleaning up an unknown pool")
+	}
+}
+
+func TestServicePanicKeepsServing(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	env := &fv1.Environment{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: metav1.NamespaceDefault, UID: "env-uid"}}
+	pool := &GenericPool{env: env}
+	gpm := &GenericPoolManager{
+		logger:         loggerfactory.GetLogger(),
+		pools:          map[string]*GenericPool{crd.CacheKeyUID(&env.ObjectMeta): pool},
+		requestChannel: make(chan *request),
+	}
+	go gpm.service()
+
+	// a request without an environment panics the service loop
+	respCh := make(chan *response)
+	gpm.requestChannel <- &request{ctx: ctx, requestType: GET_POOL, responseChannel: respCh}
+	select {
+	case resp := <-respCh:
+		if resp.error == nil {
+			t.Error("got no error for a request which panicked")
+		}
+	case <-ctx.Done():
+		t.Fatal("no response to a request which panicked")
+	}
+
+	got := make(chan *GenericPool)
+	go func() {
+		p, _, err := gpm.getPool(ctx, env)
+		if err != nil {
+			t.Errorf("Error getting pool: %v", err)
+		}
+		got <- p
+	}()
+	select {
+	case p := <-got:
+		if p != pool {
+			t.Errorf("got pool %p, want the cached pool %p", p, pool)
+		}
+	case <-ctx.Done():
+		t.Fatal("pool manager stopped serving after a panic")
+	}
+}
+
+func TestGetFuncSvcFromCache(t *testing.T) {
+	ctx := context.Background()
+	logger := loggerfactory.GetLogger()
+	gpm := &GenericPoolManager{
+		logger:  logger,
+		fsCache: fscache.MakeFunctionServiceCache(logger),
+	}
+	fn := &fv1.Function{ObjectMeta: metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}}
+	fsvc := fscache.FuncSvc{
+		Name:     "pod-1",
+		Function: &fn.ObjectMeta,
+		Address:  "10.0.0.1:8888",
+	}
+
+	_, err := gpm.GetFuncSvcFromCache(ctx, fn)
+	if err == nil {
+		t.Fatal("got function service before specializing a pod, want a cache miss")
+	}
+
+	gpm.fsCache.AddFunc(ctx, fsvc, fn.GetRequestPerPod())
+	// the request that specialized the pod is done with it
+	gpm.UnTapService(ctx, crd.CacheKey(&fn.ObjectMeta), fsvc.Address)
+	before := time.Now()
+	got, err := gpm.GetFuncSvcFromCache(ctx, fn)
+	if err != nil {
+		t.Fatalf("Error getting function service from cache: %v", err)
+	}
+	if got.Address != fsvc.Address {
+		t.Errorf("got address %q, want %q", got.Address, fsvc.Address)
+	}
+	if got.Atime.Before(before) {
+		t.Errorf("got atime %v, want it updated on the cache hit after %v", got.Atime, before)
+	}
+
+	gpm.DeleteFuncSvcFromCache(ctx, got)
+	_, err = gpm.GetFuncSvcFromCache(ctx, fn)
+	if err == nil {
+		t.Error("got function service after deleting it, want a cache miss")
+	}
+}
+
+func TestSpecializedPodExpired(t *testing.T) {
+	gpm := &GenericPoolManager{}
+	old := &fscache.FuncSvc{Ctime: time.Now().Add(-2 * time.Hour)}
+	young := &fscache.FuncSvc{Ctime: time.Now().Add(-time.Minute)}
+	if gpm.specializedPodExpired(old) {
+		t.Error("pod expired without a max age")
+	}
+
+	gpm.specializedPodMaxAge = time.Hour
+	if !gpm.specializedPodExpired(old) {
+		t.Error("pod specialized 2h ago didn't expire after 1h")
+	}
+	if gpm.specializedPodExpired(young) {
+		t.Error("pod specialized 1m ago expired after 1h")
+	}
+	if gpm.specializedPodExpired(&fscache.FuncSvc{}) {
+		t.Error("pod with an unknown specialization time expired")
+	}
+}
+
+func TestAdmitPoolCreation(t *testing.T) {
+	gpm := &GenericPoolManager{
+		requestChannel:      make(chan *request),
+		poolCreationLimiter: flowcontrol.NewTokenBucketRateLimiter(10, 1),
+	}
+	env := &fv1.Environment{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: metav1.NamespaceDefault}}
+	if !gpm.poolCreationLimiter.TryAccept() {
+		t.Fatal("limiter has no token for the first pool")
+	}
+
+	// the next pool is created once the limiter has a token again
+	start := time.Now()
+	req := &request{ctx: context.Background(), requestType: GET_POOL, env: env, responseChannel: make(chan *response)}
+	go gpm.admitPoolCreation(req)
+	select {
+	case got := <-gpm.requestChannel:
+		if got != req || !got.admitted {
+			t.Fatalf("got request %+v, want the admitted request", got)
+		}
+		if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+			t.Errorf("request admitted after %v, want it delayed by the limiter", elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request wasn't admitted")
+	}
+
+	// requests giving up before a token is available fail
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req = &request{ctx: ctx, requestType: GET_POOL, env: env, responseChannel: make(chan *response)}
+	go gpm.admitPoolCreation(req)
+	select {
+	case resp := <-req.responseChannel:
+		if resp.error == nil {
+			t.Fatal("got no error for a canceled request")
+		}
+	case <-gpm.requestChannel:
+		t.Fatal("canceled request was admitted")
+	case <-time.After(5 * time.Second):
+		t.Fatal("canceled request got no response")
+	}
+}
+
+func TestReapPool(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	env := &fv1.Environment{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: metav1.NamespaceDefault, UID: "env-uid"}}
+	envLabels := func(envName string) map[string]string {
+		return map[string]string{
+			fv1.EXECUTOR_TYPE:         string(fv1.ExecutorTypePoolmgr),
+			fv1.ENVIRONMENT_NAME:      envName,
+			fv1.ENVIRONMENT_NAMESPACE: metav1.NamespaceDefault,
+		}
+	}
+	meta := func(name, envName string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Name: name, Namespace: metav1.NamespaceDefault, Labels: envLabels(envName)}
+	}
+	logger := loggerfactory.GetLogger()
+	// no pool in memory, as after a restart of the executor
+	gpm := &GenericPoolManager{
+		logger: logger,
+		kubernetesClient: fake.NewSimpleClientset(
+			&appsv1.Deployment{ObjectMeta: meta("poolmgr-test", "test")},
+			&apiv1.Service{ObjectMeta: meta("svc-fn", "test")},
+			&apiv1.Pod{ObjectMeta: meta("specialized", "test")},
+			&apiv1.Pod{ObjectMeta: meta("other-env", "other")},
+		),
+		fsCache:        fscache.MakeFunctionServiceCache(logger),
+		nsResolver:     &utils.NamespaceResolver{},
+		pools:          map[string]*GenericPool{},
+		requestChannel: make(chan *request),
+	}
+	go gpm.service()
+
+	fn := &metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}
+	gpm.fsCache.AddFunc(ctx, fscache.FuncSvc{
+		Name:     "specialized",
+		Function: fn,
+		Address:  "svc-fn.default:8888",
+		KubernetesObjects: []apiv1.ObjectReference{
+			{Kind: "service", Name: "svc-fn", Namespace: metav1.NamespaceDefault},
+		},
+	}, 1)
+
+	err := gpm.ReapPool(ctx, env)
+	if err != nil {
+		t.Fatalf("Error reaping pool: %v", err)
+	}
+
+	deploys, err := gpm.kubernetesClient.AppsV1().Deployments(metav1.NamespaceDefault).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Error listing deployments: %v", err)
+	}
+	if len(deploys.Items) != 0 {
+		t.Errorf("got %d deployments, want the pool deployment deleted", len(deploys.Items))
+	}
+	svcs, err := gpm.kubernetesClient.CoreV1().Services(metav1.NamespaceDefault).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Error listing services: %v", err)
+	}
+	if len(svcs.Items) != 0 {
+		t.Errorf("got %d services, want the function service deleted", len(svcs.Items))
+	}
+	pods, err := gpm.kubernetesClient.CoreV1().Pods(metav1.NamespaceDefault).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Error listing pods: %v", err)
+	}
+	if len(pods.Items) != 1 || pods.Items[0].Name != "other-env" {
+		t.Errorf("got pods %v, want only the pod of the other environment", pods.Items)
+	}
+	if _, ok := gpm.GetFuncSvcByServiceName("svc-fn"); ok {
+		t.Error("function service of the reaped pool still cached")
+	}
+}
+
+// TestReapIdleServices reaps the function services not accessed for longer
+// than the ttl, or the idle timeout of their function.
+func TestReapIdleServices(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	env := &fv1.Environment{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: metav1.NamespaceDefault, UID: "env-uid"}}
+	idleTimeout := 10
+	fns := map[string]*fv1.Function{
+		"idle":   {ObjectMeta: metav1.ObjectMeta{Name: "idle", Namespace: metav1.NamespaceDefault, UID: "idle-uid"}},
+		"recent": {ObjectMeta: metav1.ObjectMeta{Name: "recent", Namespace: metav1.NamespaceDefault, UID: "recent-uid"}},
+		"short": {
+			ObjectMeta: metav1.ObjectMeta{Name: "short", Namespace: metav1.NamespaceDefault, UID: "short-uid"},
+			Spec:       fv1.FunctionSpec{IdleTimeout: &idleTimeout},
+		},
+	}
+	accessed := map[string]time.Duration{"idle": 10 * time.Minute, "recent": 30 * time.Second, "short": 30 * time.Second}
+
+	logger := loggerfactory.GetLogger()
+	gpm := &GenericPoolManager{
+		logger:           logger,
+		kubernetesClient: fake.NewSimpleClientset(),
+		fissionClient:    fClient.NewSimpleClientset(env, fns["idle"], fns["recent"], fns["short"]),
+		fsCache:          fscache.MakeFunctionServiceCache(logger),
+	}
+	for name, fn := range fns {
+		svc := &apiv1.Service{ObjectMeta: metav1.ObjectMeta{Name: "svc-" + name, Namespace: metav1.NamespaceDefault}}
+		pod := &apiv1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: metav1.NamespaceDefault}}
+		_, err := gpm.kubernetesClient.CoreV1().Services(svc.Namespace).Create(ctx, svc, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatalf("Error creating service: %v", err)
+		}
+		_, err = gpm.kubernetesClient.CoreV1().Pods(pod.Namespace).Create(ctx, pod, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatalf("Error creating pod: %v", err)
+		}
+		fsvc := fscache.FuncSvc{
+			Name:        name,
+			Function:    &fn.ObjectMeta,
+			Environment: env,
+			Address:     "svc-" + name + ".default:8888",
+			KubernetesObjects: []apiv1.ObjectReference{
+				{Kind: "pod", Name: pod.Name, Namespace: pod.Namespace},
+				{Kind: "service", Name: svc.Name, Namespace: svc.Namespace},
+			},
+			Executor: fv1.ExecutorTypePoolmgr,
+		}
+		gpm.fsCache.AddFunc(ctx, fsvc, fn.GetRequestPerPod())
+		// the request is done
+		gpm.fsCache.MarkAvailable(crd.CacheKey(&fn.ObjectMeta), fsvc.Address)
+	}
+	fsvcs, err := gpm.fsCache.ListOldForPool(0)
+	if err != nil {
+		t.Fatalf("Error listing function services: %v", err)
+	}
+	for _, fsvc := range fsvcs {
+		fsvc.Atime = time.Now().Add(-accessed[fsvc.Name])
+	}
+
+	gpm.reapIdleServices(ctx, time.Minute)
+
+	reaped := func(name string) bool {
+		_, svcErr := gpm.kubernetesClient.CoreV1().Services(metav1.NamespaceDefault).Get(ctx, "svc-"+name, metav1.GetOptions{})
+		_, podErr := gpm.kubernetesClient.CoreV1().Pods(metav1.NamespaceDefault).Get(ctx, name, metav1.GetOptions{})
+		return k8serrors.IsNotFound(svcErr) && k8serrors.IsNotFound(podErr)
+	}
+	for _, name := range []string{"idle", "short"} {
+		err := wait.PollImmediateWithContext(ctx, 10*time.Millisecond, 5*time.Second, func(ctx context.Context) (bool, error) {
+			return reaped(name), nil
+		})
+		if err != nil {
+			t.Errorf("objects of function service %q accessed %v ago weren't reaped: %v", name, accessed[name], err)
+		}
+	}
+	if reaped("recent") {
+		t.Errorf("objects of function service %q accessed %v ago were reaped", "recent", accessed["recent"])
+	}
+}
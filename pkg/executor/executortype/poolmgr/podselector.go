@@ -0,0 +1,146 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"math/rand"
+	"sync"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corelisters "k8s.io/client-go/listers/core/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+const (
+	PodSelectorRandom = "random"
+	PodSelectorSpread = "spread"
+)
+
+type (
+	// PodSelector picks the preferred pod to specialize among the ready pods
+	// of a pool. choosePod prefers pods running on the same node as the
+	// selected one. Selectors draw random numbers from the source of the
+	// pool, so that tests can make the choice deterministic.
+	PodSelector interface {
+		Choose(readyPods []*apiv1.Pod, r *rand.Rand) *apiv1.Pod
+	}
+
+	// RandomSelector picks a ready pod at random.
+	RandomSelector struct{}
+
+	// SpreadSelector picks a ready pod on the node running the fewest
+	// specialized pods, to avoid concentrating functions on a single node.
+	SpreadSelector struct {
+		podLister corelisters.PodLister
+		namespace string
+		selector  labels.Selector
+	}
+
+	// lockedSource is a random source safe for concurrent use, pods are
+	// chosen by concurrent requests.
+	lockedSource struct {
+		mu  sync.Mutex
+		src rand.Source64
+	}
+)
+
+// Choose returns a random pod from readyPods.
+func (s *RandomSelector) Choose(readyPods []*apiv1.Pod, r *rand.Rand) *apiv1.Pod {
+	if len(readyPods) == 0 {
+		return nil
+	}
+	return readyPods[r.Intn(len(readyPods))]
+}
+
+// NewSpreadSelector returns a SpreadSelector counting the specialized pods
+// of the poolmgr executor in the namespace.
+func NewSpreadSelector(podLister corelisters.PodLister, namespace string) *SpreadSelector {
+	return &SpreadSelector{
+		podLister: podLister,
+		namespace: namespace,
+		selector: labels.SelectorFromSet(map[string]string{
+			fv1.EXECUTOR_TYPE: string(fv1.ExecutorTypePoolmgr),
+			fv1.MANAGED:       "false",
+		}),
+	}
+}
+
+// Choose returns the ready pod running on the node with the fewest specialized pods.
+func (s *SpreadSelector) Choose(readyPods []*apiv1.Pod, r *rand.Rand) *apiv1.Pod {
+	if len(readyPods) == 0 {
+		return nil
+	}
+	specializedPods, err := s.podLister.Pods(s.namespace).List(s.selector)
+	if err != nil {
+		return nil
+	}
+	specializedPerNode := make(map[string]int)
+	for _, pod := range specializedPods {
+		if IsPodActive(pod) {
+			specializedPerNode[pod.Spec.NodeName]++
+		}
+	}
+
+	chosen := readyPods[0]
+	for _, pod := range readyPods[1:] {
+		if specializedPerNode[pod.Spec.NodeName] < specializedPerNode[chosen.Spec.NodeName] {
+			chosen = pod
+		}
+	}
+	return chosen
+}
+
+// makePodSelector returns the pod selector with the given name, nil
+// selector means pods are chosen in the order they become ready.
+func makePodSelector(name string, podLister corelisters.PodLister, namespace string) PodSelector {
+	switch name {
+	case PodSelectorRandom:
+		return &RandomSelector{}
+	case PodSelectorSpread:
+		if podLister == nil {
+			return nil
+		}
+		return NewSpreadSelector(podLister, namespace)
+	default:
+		return nil
+	}
+}
+
+// newRand returns a random source for pod selection, safe for concurrent use.
+func newRand(seed int64) *rand.Rand {
+	return rand.New(&lockedSource{src: rand.NewSource(seed).(rand.Source64)})
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Uint64()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(seed)
+}
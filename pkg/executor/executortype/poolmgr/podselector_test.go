@@ -0,0 +1,140 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package poolmgr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	k8sCache "k8s.io/client-go/tools/cache"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+)
+
+func newSpecializedPod(name string, node string) *apiv1.Pod {
+	pod := newTestPod(name, "10.0.1.1", true)
+	pod.Labels = map[string]string{
+		fv1.EXECUTOR_TYPE: string(fv1.ExecutorTypePoolmgr),
+		"managed":         "false",
+	}
+	pod.Spec.NodeName = node
+	return pod
+}
+
+func newSpreadSelector(t *testing.T, pods ...*apiv1.Pod) *SpreadSelector {
+	t.Helper()
+	indexer := k8sCache.NewIndexer(k8sCache.MetaNamespaceKeyFunc, k8sCache.Indexers{k8sCache.NamespaceIndex: k8sCache.MetaNamespaceIndexFunc})
+	for _, pod := range pods {
+		err := indexer.Add(pod)
+		if err != nil {
+			t.Fatalf("Error adding pod to indexer: %v", err)
+		}
+	}
+	return NewSpreadSelector(corelisters.NewPodLister(indexer), metav1.NamespaceDefault)
+}
+
+func TestSpreadSelectorChoose(t *testing.T) {
+	selector := newSpreadSelector(t,
+		newSpecializedPod("fn-1", "node-a"),
+		newSpecializedPod("fn-2", "node-a"),
+		newSpecializedPod("fn-3", "node-b"),
+	)
+
+	ready := func(name, node string) *apiv1.Pod {
+		pod := newTestPod(name, "10.0.0.1", true)
+		pod.Spec.NodeName = node
+		return pod
+	}
+
+	tests := []struct {
+		name      string
+		readyPods []*apiv1.Pod
+		want      string
+	}{
+		{"no ready pods", nil, ""},
+		{"single ready pod", []*apiv1.Pod{ready("p1", "node-a")}, "p1"},
+		{"prefers node without specialized pods", []*apiv1.Pod{ready("p1", "node-a"), ready("p2", "node-b"), ready("p3", "node-c")}, "p3"},
+		{"prefers least loaded node", []*apiv1.Pod{ready("p1", "node-a"), ready("p2", "node-b")}, "p2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := selector.Choose(tt.readyPods, newRand(1))
+			if tt.want == "" {
+				if got != nil {
+					t.Fatalf("chose pod %q, want none", got.Name)
+				}
+				return
+			}
+			if got == nil || got.Name != tt.want {
+				t.Fatalf("chose pod %v, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRandomSelectorChoose(t *testing.T) {
+	readyPods := []*apiv1.Pod{
+		newTestPod("p1", "10.0.0.1", true),
+		newTestPod("p2", "10.0.0.2", true),
+		newTestPod("p3", "10.0.0.3", true),
+	}
+	selector := &RandomSelector{}
+	if got := selector.Choose(nil, newRand(1)); got != nil {
+		t.Fatalf("chose pod %q, want none", got.Name)
+	}
+
+	// the same seed chooses the same pods
+	r1, r2 := newRand(42), newRand(42)
+	chosen := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		got, want := selector.Choose(readyPods, r1), selector.Choose(readyPods, r2)
+		if got.Name != want.Name {
+			t.Fatalf("choice %d: chose pod %q, want %q", i, got.Name, want.Name)
+		}
+		chosen[got.Name] = true
+	}
+	if len(chosen) != len(readyPods) {
+		t.Errorf("chose pods %v, want all of them chosen eventually", chosen)
+	}
+}
+
+func TestChoosePodPrefersSelectedNode(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	hot := newTestPod("hot", "10.0.0.1", true)
+	hot.Spec.NodeName = "node-a"
+	cold := newTestPod("cold", "10.0.0.2", true)
+	cold.Spec.NodeName = "node-b"
+
+	gp := newTestPool(t, hot, cold)
+	defer gp.readyPodQueue.ShutDown()
+	gp.podSelector = newSpreadSelector(t, newSpecializedPod("fn-1", "node-a"))
+
+	fn := &metav1.ObjectMeta{Name: "fn", Namespace: metav1.NamespaceDefault, UID: "fn-uid"}
+	_, pod, err := gp.choosePod(ctx, gp.labelsForFunction(fn))
+	if err != nil {
+		t.Fatalf("Error choosing pod: %v", err)
+	}
+	if pod.Name != "cold" {
+		t.Fatalf("chose pod %q, want %q", pod.Name, "cold")
+	}
+}
@@ -37,6 +37,7 @@ import (
 
 	fv1 "github.com/fission/fission/pkg/apis/core/v1"
 	"github.com/fission/fission/pkg/executor/fscache"
+	fetcherConfig "github.com/fission/fission/pkg/fetcher/config"
 	genInformer "github.com/fission/fission/pkg/generated/informers/externalversions"
 	flisterv1 "github.com/fission/fission/pkg/generated/listers/core/v1"
 	"github.com/fission/fission/pkg/utils"
@@ -70,6 +71,7 @@ type (
 func NewPoolPodController(ctx context.Context, logger *zap.Logger,
 	kubernetesClient kubernetes.Interface,
 	enableIstio bool,
+	fetcherConfig *fetcherConfig.Config,
 	finformerFactory map[string]genInformer.SharedInformerFactory,
 	gpmInformerFactory map[string]k8sInformers.SharedInformerFactory) *PoolPodController {
 	logger = logger.Named("pool_pod_controller")
@@ -87,8 +89,10 @@ func NewPoolPodController(ctx context.Context, logger *zap.Logger,
 		spCleanupPodQueue:    workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "SpecializedPodCleanupQueue"),
 	}
 	if p.enableIstio {
+		fetcherPort, runtimePort := getPorts(fetcherConfig)
 		for _, factory := range finformerFactory {
-			factory.Core().V1().Functions().Informer().AddEventHandler(FunctionEventHandlers(ctx, p.logger, p.kubernetesClient, p.nsResolver.ResolveNamespace(p.nsResolver.FunctionNamespace), p.enableIstio))
+			factory.Core().V1().Functions().Informer().AddEventHandler(FunctionEventHandlers(ctx, p.logger, p.kubernetesClient,
+				p.nsResolver.ResolveNamespace(p.nsResolver.FunctionNamespace), p.enableIstio, fetcherPort, runtimePort))
 		}
 	}
 	for ns, informer := range finformerFactory {
@@ -136,7 +140,7 @@ func (p *PoolPodController) processRS(rs *apps.ReplicaSet) {
 		p.logger.Error("Failed to parse label selector", zap.Error(err))
 		return
 	}
-	rsLabelMap["managed"] = "false"
+	rsLabelMap[fv1.MANAGED] = "false"
 	specializedPods, err := p.podLister[rs.Namespace].Pods(rs.Namespace).List(labels.SelectorFromSet(rsLabelMap))
 	if err != nil {
 		logger.Error("Failed to list specialized pods", zap.Error(err))
@@ -51,15 +51,15 @@ func TestPoolPodControllerPodCleanup(t *testing.T) {
 	}
 	gpmInformerFactory := utils.GetInformerFactoryByExecutor(kubernetesClient, executorLabel, time.Minute*30)
 
-	ppc := NewPoolPodController(ctx, logger, kubernetesClient, false,
-		factory, gpmInformerFactory)
-
-	executorInstanceID := strings.ToLower(uniuri.NewLen(8))
-	metricsClient := metricsclient.NewSimpleClientset()
 	fetcherConfig, err := fetcherConfig.MakeFetcherConfig("/userfunc")
 	if err != nil {
 		t.Fatalf("Error creating fetcher config: %v", err)
 	}
+	ppc := NewPoolPodController(ctx, logger, kubernetesClient, false, fetcherConfig,
+		factory, gpmInformerFactory)
+
+	executorInstanceID := strings.ToLower(uniuri.NewLen(8))
+	metricsClient := metricsclient.NewSimpleClientset()
 	executor, err := MakeGenericPoolManager(ctx,
 		logger,
 		fissionClient, kubernetesClient, metricsClient,
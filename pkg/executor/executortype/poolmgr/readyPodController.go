@@ -1,12 +1,16 @@
 package poolmgr
 
 import (
+	"context"
 	"time"
 
+	"github.com/pkg/errors"
 	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/labels"
 	k8sCache "k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
 
+	"github.com/fission/fission/pkg/executor/metrics"
 	"github.com/fission/fission/pkg/utils"
 )
 
@@ -18,6 +22,12 @@ func (gp *GenericPool) readyPodEventHandlers() k8sCache.ResourceEventHandlerFunc
 				gp.readyPodQueue.AddAfter(key, 100*time.Millisecond)
 				gp.logger.Debug("add func called", zap.String("key", key))
 			}
+			gp.updateReadyPodsMetric()
+		},
+		// pods turning ready or unready don't change the queue, choosePod
+		// checks readiness, but they change the ready pods of the pool
+		UpdateFunc: func(_, _ interface{}) {
+			gp.updateReadyPodsMetric()
 		},
 		DeleteFunc: func(obj interface{}) {
 			key, err := k8sCache.DeletionHandlingMetaNamespaceKeyFunc(obj)
@@ -25,21 +35,116 @@ func (gp *GenericPool) readyPodEventHandlers() k8sCache.ResourceEventHandlerFunc
 				gp.readyPodQueue.Done(key)
 				gp.logger.Debug("delete func called", zap.String("key", key))
 			}
+			gp.updateReadyPodsMetric()
 		},
 	}
 }
 
+// updateReadyPodsMetric sets the ready pods gauge of the pool from the ready
+// pod lister and tracks since when the pool has no ready pods.
+func (gp *GenericPool) updateReadyPodsMetric() {
+	pods, err := gp.readyPodLister.List(labels.Everything())
+	if err != nil {
+		return
+	}
+	ready := 0
+	for _, pod := range pods {
+		if utils.IsReadyPod(pod) {
+			ready++
+		}
+	}
+	metrics.PoolReadyPods.WithLabelValues(gp.env.ObjectMeta.Name, gp.env.ObjectMeta.Namespace).Set(float64(ready))
+	gp.trackReadyPods(ready)
+}
+
+// trackReadyPods records when the pool ran out of ready pods, the pool may
+// oscillate between none and some as pods get specialized and replaced.
+func (gp *GenericPool) trackReadyPods(ready int) {
+	if ready > 0 {
+		gp.noReadyPodsSince.Store(0)
+	} else {
+		gp.noReadyPodsSince.CompareAndSwap(0, time.Now().UnixNano())
+	}
+}
+
+// noReadyPodsFor returns how long the pool has had no ready pods, 0 if it
+// has some.
+func (gp *GenericPool) noReadyPodsFor() time.Duration {
+	since := gp.noReadyPodsSince.Load()
+	if since == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, since))
+}
+
+// nextReadyPodKey returns the next key of the ready pod queue. Unlike Get, it
+// gives up at the deadline, once ctx is done or once the pool has had no
+// ready pods for longer than noReadyPodsThreshold. It polls the queue every
+// readyPodsPollInterval rather than block in Get, which couldn't be
+// abandoned.
+func (gp *GenericPool) nextReadyPodKey(ctx context.Context, deadline time.Time) (string, error) {
+	for {
+		key, ok, err := gp.pollReadyPodKey()
+		if err != nil || ok {
+			return key, err
+		}
+		delay := gp.readyPodsPollInterval
+		if remaining := time.Until(deadline); delay > remaining {
+			delay = remaining
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(delay):
+		}
+		if time.Now().After(deadline) {
+			return "", ErrPodReadyTimeout
+		}
+		if ready, err := gp.readyPodCount(); err == nil {
+			gp.trackReadyPods(int(ready))
+		}
+		if noReady := gp.noReadyPodsFor(); gp.noReadyPodsThreshold > 0 && noReady > gp.noReadyPodsThreshold {
+			return "", errors.Wrapf(ErrNoReadyPods, "no pod turned ready in %v", noReady.Round(time.Second))
+		}
+	}
+}
+
+// pollReadyPodKey gets a key of the ready pod queue if it has one. Callers
+// check the queue length and get the key under readyPodQueueMu, so that Get
+// doesn't block when another caller took the last key in between.
+func (gp *GenericPool) pollReadyPodKey() (string, bool, error) {
+	gp.readyPodQueueMu.Lock()
+	defer gp.readyPodQueueMu.Unlock()
+	if gp.readyPodQueue.ShuttingDown() {
+		return "", false, errors.New("readypod controller is not running")
+	}
+	if gp.readyPodQueue.Len() == 0 {
+		return "", false, nil
+	}
+	key, quit := gp.readyPodQueue.Get()
+	if quit {
+		return "", false, errors.New("readypod controller is not running")
+	}
+	return key.(string), true, nil
+}
+
 func (gp *GenericPool) setupReadyPodController() error {
 	gp.readyPodQueue = workqueue.NewDelayingQueue()
-	informerFactory, err := utils.GetInformerFactoryByReadyPod(gp.kubernetesClient, gp.fnNamespace, gp.deployment.Spec.Selector)
+	informerFactory, err := utils.GetInformerFactoryByReadyPod(gp.kubernetesClient, gp.fnNamespace, gp.deployment.Load().Spec.Selector)
 	if err != nil {
 		return err
 	}
 	podInformer := informerFactory.Core().V1().Pods()
 	gp.readyPodLister = podInformer.Lister()
 	gp.readyPodListerSynced = podInformer.Informer().HasSynced
+	// the pool starts without ready pods, see noReadyPodsFor
+	gp.trackReadyPods(0)
 	podInformer.Informer().AddEventHandler(gp.readyPodEventHandlers())
 	go podInformer.Informer().Run(gp.stopReadyPodControllerCh)
+	err = gp.watchAdoptedPods()
+	if err != nil {
+		return err
+	}
 	gp.logger.Info("readyPod controller started", zap.String("env", gp.env.ObjectMeta.Name), zap.String("envID", string(gp.env.ObjectMeta.UID)))
 	return nil
 }
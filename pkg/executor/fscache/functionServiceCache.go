@@ -57,9 +57,15 @@ type (
 		Function          *metav1.ObjectMeta      // function this pod/service is for
 		Environment       *fv1.Environment        // function's environment
 		Address           string                  // Host:Port or IP:Port that the function's service can be reached at.
+		PodIP             string                  // IP of the specialized pod, set by poolmgr to bypass the service
+		Port              int32                   // port the function is served on at PodIP
+		NodePort          int32                   // node port of a NodePort or LoadBalancer function service
+		ExternalAddress   string                  // Host:Port of a LoadBalancer function service, once allocated
 		KubernetesObjects []apiv1.ObjectReference // Kubernetes Objects (within the function namespace)
 		Executor          fv1.ExecutorType
 		CPULimit          resource.Quantity
+		RequestTimeout    time.Duration // how long a request to the function may run, 0 if unknown, e.g. for adopted pods
+		ColdStartDuration time.Duration // how long getting the pod specialized took, 0 if unknown
 
 		Ctime time.Time
 		Atime time.Time
@@ -74,6 +80,7 @@ type (
 		connFunctionCache *PoolCache   // function-key -> funcSvc : map[string]*funcSvc
 		PodToFsvc         sync.Map     // pod-name -> funcSvc: map[string]*FuncSvc
 		WebsocketFsvc     sync.Map     // funcSvc-name -> bool: map[string]bool
+		byServiceName     sync.Map     // service name -> funcSvc: map[string]*FuncSvc
 		requestChannel    chan *fscRequest
 	}
 
@@ -192,6 +199,13 @@ func (fsc *FunctionServiceCache) DumpDebugInfo(ctx context.Context) error {
 	return nil
 }
 
+// UpdateAccessTime records that the function service was accessed, e.g. by
+// a request for its function. Function services not accessed for longer
+// than the idle timeout of their function are reaped by the executors.
+func (fsvc *FuncSvc) UpdateAccessTime() {
+	fsvc.Atime = time.Now()
+}
+
 // GetByFunction gets a function service from cache using function key.
 func (fsc *FunctionServiceCache) GetByFunction(m *metav1.ObjectMeta) (*FuncSvc, error) {
 	key := crd.CacheKey(m)
@@ -201,9 +215,8 @@ func (fsc *FunctionServiceCache) GetByFunction(m *metav1.ObjectMeta) (*FuncSvc,
 		return nil, err
 	}
 
-	// update atime
 	fsvc := fsvcI.(*FuncSvc)
-	fsvc.Atime = time.Now()
+	fsvc.UpdateAccessTime()
 
 	fsvcCopy := *fsvc
 	return &fsvcCopy, nil
@@ -219,8 +232,7 @@ func (fsc *FunctionServiceCache) GetFuncSvc(ctx context.Context, m *metav1.Objec
 		return nil, err
 	}
 
-	// update atime
-	fsvc.Atime = time.Now()
+	fsvc.UpdateAccessTime()
 
 	fsvcCopy := *fsvc
 	return &fsvcCopy, nil
@@ -240,9 +252,8 @@ func (fsc *FunctionServiceCache) GetByFunctionUID(uid types.UID) (*FuncSvc, erro
 		return nil, err
 	}
 
-	// update atime
 	fsvc := fsvcI.(*FuncSvc)
-	fsvc.Atime = time.Now()
+	fsvc.UpdateAccessTime()
 
 	fsvcCopy := *fsvc
 	return &fsvcCopy, nil
@@ -254,6 +265,7 @@ func (fsc *FunctionServiceCache) AddFunc(ctx context.Context, fsvc FuncSvc, requ
 	now := time.Now()
 	fsvc.Ctime = now
 	fsvc.Atime = now
+	fsc.indexByServiceName(&fsvc)
 }
 
 // SetCPUUtilizaton updates/sets CPUutilization in the pool cache
@@ -266,6 +278,44 @@ func (fsc *FunctionServiceCache) MarkAvailable(key string, svcHost string) {
 	fsc.connFunctionCache.MarkAvailable(key, svcHost)
 }
 
+// ActiveRequests returns the number of requests in flight to the pool cache
+// function services of an environment.
+func (fsc *FunctionServiceCache) ActiveRequests(environment types.UID) int {
+	return fsc.connFunctionCache.ActiveRequests(environment)
+}
+
+// ListFunctionVersions returns the versions of the function, by UID and
+// resource version, with function services in the pool cache. Versions are
+// cached under keys of their own, so several versions of a function can be
+// served side by side.
+func (fsc *FunctionServiceCache) ListFunctionVersions(namespace, name string) []metav1.ObjectMeta {
+	fsvcs := fsc.connFunctionCache.ListFunctionVersions(types.NamespacedName{Namespace: namespace, Name: name})
+	versions := make([]metav1.ObjectMeta, 0, len(fsvcs))
+	for _, fsvc := range fsvcs {
+		versions = append(versions, *fsvc.Function)
+	}
+	return versions
+}
+
+// GetFuncSvcVersion gets a function service of the version of the function
+// with the given UID from the pool cache, like GetFuncSvc does for the
+// version it's given. If several resource versions of that UID are cached,
+// the most recently specialized one is used.
+func (fsc *FunctionServiceCache) GetFuncSvcVersion(ctx context.Context, namespace, name string, uid types.UID,
+	requestsPerPod int, concurrency int) (*FuncSvc, error) {
+	var version *FuncSvc
+	for _, fsvc := range fsc.connFunctionCache.ListFunctionVersions(types.NamespacedName{Namespace: namespace, Name: name}) {
+		if fsvc.Function.UID == uid && (version == nil || fsvc.Ctime.After(version.Ctime)) {
+			version = fsvc
+		}
+	}
+	if version == nil {
+		return nil, ferror.MakeError(ferror.ErrorNotFound,
+			fmt.Sprintf("version %v of function %v/%v not found", uid, namespace, name))
+	}
+	return fsc.GetFuncSvc(ctx, version.Function, requestsPerPod, concurrency)
+}
+
 func (fsc *FunctionServiceCache) MarkSpecializationFailure(key string) {
 	fsc.connFunctionCache.MarkSpecializationFailure(key)
 }
@@ -288,6 +338,7 @@ func (fsc *FunctionServiceCache) Add(fsvc FuncSvc) (*FuncSvc, error) {
 	now := time.Now()
 	fsvc.Ctime = now
 	fsvc.Atime = now
+	fsc.indexByServiceName(&fsvc)
 
 	// Add to byAddress cache. Ignore NameExists errors
 	// because of multiple-specialization. See issue #331.
@@ -339,7 +390,7 @@ func (fsc *FunctionServiceCache) _touchByAddress(address string) error {
 		return err
 	}
 	fsvc := fsvcI.(*FuncSvc)
-	fsvc.Atime = time.Now()
+	fsvc.UpdateAccessTime()
 	return nil
 }
 
@@ -373,6 +424,8 @@ func (fsc *FunctionServiceCache) DeleteEntry(fsvc *FuncSvc) {
 		)
 	}
 
+	fsc.unindexByServiceName(fsvc)
+
 	metrics.FuncRunningSummary.WithLabelValues(fsvc.Function.Name, fsvc.Function.Namespace).Observe(fsvc.Atime.Sub(fsvc.Ctime).Seconds())
 }
 
@@ -387,6 +440,49 @@ func (fsc *FunctionServiceCache) DeleteFunctionSvc(ctx context.Context, fsvc *Fu
 			zap.Error(err),
 		)
 	}
+	fsc.unindexByServiceName(fsvc)
+}
+
+// GetByServiceName gets a function service from cache using the name of the
+// Kubernetes service created for it.
+func (fsc *FunctionServiceCache) GetByServiceName(name string) (*FuncSvc, bool) {
+	fsvcI, ok := fsc.byServiceName.Load(name)
+	if !ok {
+		return nil, false
+	}
+	fsvcCopy := *fsvcI.(*FuncSvc)
+	return &fsvcCopy, true
+}
+
+// serviceName returns the name of the Kubernetes service of a function
+// service, or an empty string if the function is served without one.
+func serviceName(fsvc *FuncSvc) string {
+	for _, obj := range fsvc.KubernetesObjects {
+		if obj.Kind == "service" {
+			return obj.Name
+		}
+	}
+	return ""
+}
+
+func (fsc *FunctionServiceCache) indexByServiceName(fsvc *FuncSvc) {
+	if name := serviceName(fsvc); len(name) > 0 {
+		fsc.byServiceName.Store(name, fsvc)
+	}
+}
+
+// unindexByServiceName removes the service name of a function service from
+// the index, unless the name was taken over by a function service at
+// another address in the meantime.
+func (fsc *FunctionServiceCache) unindexByServiceName(fsvc *FuncSvc) {
+	name := serviceName(fsvc)
+	if len(name) == 0 {
+		return
+	}
+	fsvcI, ok := fsc.byServiceName.Load(name)
+	if ok && fsvcI.(*FuncSvc).Address == fsvc.Address {
+		fsc.byServiceName.CompareAndDelete(name, fsvcI)
+	}
 }
 
 func (fsc *FunctionServiceCache) SetCPUUtilization(key string, svcHost string, cpuUsage resource.Quantity) {
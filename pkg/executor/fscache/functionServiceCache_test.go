@@ -12,8 +12,10 @@ import (
 	apiv1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 
 	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/crd"
 )
 
 func panicIf(err error) {
@@ -211,3 +213,97 @@ func TestFunctionServiceNewCache(t *testing.T) {
 	}
 	fsc.DeleteFunctionSvc(ctx, fsvc)
 }
+
+func TestGetByServiceName(t *testing.T) {
+	logger, err := zap.NewDevelopment()
+	panicIf(err)
+	fsc := MakeFunctionServiceCache(logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fsvc := &FuncSvc{
+		Name:     "pod-1",
+		Function: &metav1.ObjectMeta{Name: "foo", UID: "1212"},
+		Address:  "svc-foo-1212.fission-function:8888",
+		KubernetesObjects: []apiv1.ObjectReference{
+			{Kind: "pod", Name: "pod-1", Namespace: "fission-function"},
+			{Kind: "service", Name: "svc-foo-1212", Namespace: "fission-function"},
+		},
+		CPULimit: resource.MustParse("5m"),
+	}
+	fsc.AddFunc(ctx, *fsvc, 1)
+
+	got, ok := fsc.GetByServiceName("svc-foo-1212")
+	if !ok {
+		t.Fatal("function service not found by service name")
+	}
+	if got.Name != fsvc.Name || got.Function.UID != fsvc.Function.UID {
+		t.Fatalf("found function service %q of function %q, want %q of %q", got.Name, got.Function.UID, fsvc.Name, fsvc.Function.UID)
+	}
+	if _, ok := fsc.GetByServiceName("pod-1"); ok {
+		t.Fatal("found function service by the name of its pod")
+	}
+
+	// a copy of the function service, as returned by the cache, evicts it too
+	fsc.DeleteFunctionSvc(ctx, got)
+	if _, ok := fsc.GetByServiceName("svc-foo-1212"); ok {
+		t.Fatal("found function service by service name after deleting it")
+	}
+
+	// function services without a service of their own aren't indexed
+	podOnly := *fsvc
+	podOnly.Address = "10.0.0.1:8888"
+	podOnly.KubernetesObjects = fsvc.KubernetesObjects[:1]
+	_, err = fsc.Add(podOnly)
+	if err != nil {
+		t.Fatalf("error adding function service: %v", err)
+	}
+	if _, ok := fsc.GetByServiceName("svc-foo-1212"); ok {
+		t.Fatal("found function service without a service by service name")
+	}
+}
+
+func TestFunctionVersions(t *testing.T) {
+	logger, err := zap.NewDevelopment()
+	panicIf(err)
+	fsc := MakeFunctionServiceCache(logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	v1 := &metav1.ObjectMeta{Name: "foo", Namespace: "default", UID: "uid-1", ResourceVersion: "1"}
+	v2 := &metav1.ObjectMeta{Name: "foo", Namespace: "default", UID: "uid-2", ResourceVersion: "5"}
+	other := &metav1.ObjectMeta{Name: "bar", Namespace: "default", UID: "uid-3", ResourceVersion: "1"}
+	for _, fsvc := range []FuncSvc{
+		{Name: "pod-1", Function: v1, Address: "10.0.0.1:8888"},
+		{Name: "pod-2", Function: v2, Address: "10.0.0.2:8888"},
+		{Name: "pod-3", Function: other, Address: "10.0.0.3:8888"},
+	} {
+		fsc.AddFunc(ctx, fsvc, 1)
+		// the request that specialized the pod is done with it
+		fsc.MarkAvailable(crd.CacheKey(fsvc.Function), fsvc.Address)
+	}
+
+	versions := fsc.ListFunctionVersions("default", "foo")
+	uids := make(map[types.UID]bool)
+	for _, version := range versions {
+		uids[version.UID] = true
+	}
+	if len(versions) != 2 || !uids[v1.UID] || !uids[v2.UID] {
+		t.Fatalf("versions = %v, want %s and %s", versions, v1.UID, v2.UID)
+	}
+
+	for uid, address := range map[types.UID]string{v1.UID: "10.0.0.1:8888", v2.UID: "10.0.0.2:8888"} {
+		fsvc, err := fsc.GetFuncSvcVersion(ctx, "default", "foo", uid, 1, 1)
+		if err != nil {
+			t.Fatalf("error getting version %s: %v", uid, err)
+		}
+		if fsvc.Address != address {
+			t.Errorf("version %s at %q, want %q", uid, fsvc.Address, address)
+		}
+	}
+	if _, err := fsc.GetFuncSvcVersion(ctx, "default", "foo", other.UID, 1, 1); err == nil {
+		t.Error("got a version of foo with the UID of another function")
+	}
+}
@@ -24,6 +24,7 @@ import (
 
 	"go.uber.org/zap"
 	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
 
 	ferror "github.com/fission/fission/pkg/error"
 	otelUtils "github.com/fission/fission/pkg/utils/otel"
@@ -40,6 +41,8 @@ const (
 	setCPUUtilization
 	markSpecializationFailure
 	logFuncSvc
+	activeRequests
+	listFunctionVersions
 )
 
 type (
@@ -75,12 +78,15 @@ type (
 		cpuUsage        resource.Quantity
 		responseChannel chan *response
 		concurrency     int
+		environment     types.UID
+		functionName    types.NamespacedName
 	}
 	response struct {
 		error
 		allValues    []*FuncSvc
 		value        *FuncSvc
 		svcWaitValue *svcWait
+		active       int
 	}
 	svcWait struct {
 		svcChannel chan *FuncSvc
@@ -290,6 +296,33 @@ func (c *PoolCache) service() {
 				}
 			}
 			req.responseChannel <- resp
+		case activeRequests:
+			for _, values := range c.cache {
+				for _, value := range values.svcs {
+					if value.val.Environment != nil && value.val.Environment.ObjectMeta.UID == req.environment {
+						resp.active += value.activeRequests
+					}
+				}
+			}
+			req.responseChannel <- resp
+		case listFunctionVersions:
+			for _, values := range c.cache {
+				var latest *FuncSvc
+				for _, value := range values.svcs {
+					fn := value.val.Function
+					if fn == nil || fn.Namespace != req.functionName.Namespace || fn.Name != req.functionName.Name {
+						continue
+					}
+					if latest == nil || value.val.Ctime.After(latest.Ctime) {
+						latest = value.val
+					}
+				}
+				if latest != nil {
+					fsvc := *latest
+					resp.allValues = append(resp.allValues, &fsvc)
+				}
+			}
+			req.responseChannel <- resp
 		default:
 			resp.error = ferror.MakeError(ferror.ErrorInvalidArgument,
 				fmt.Sprintf("invalid request type: %v", req.requestType))
@@ -384,6 +417,33 @@ func (c *PoolCache) DeleteValue(ctx context.Context, function, address string) e
 	return resp.error
 }
 
+// ActiveRequests returns the number of requests in flight to the function
+// services of the environment with the given UID.
+func (c *PoolCache) ActiveRequests(environment types.UID) int {
+	respChannel := make(chan *response)
+	c.requestChannel <- &request{
+		requestType:     activeRequests,
+		environment:     environment,
+		responseChannel: respChannel,
+	}
+	resp := <-respChannel
+	return resp.active
+}
+
+// ListFunctionVersions returns a copy of the most recently specialized
+// function service of every version of the named function, i.e. of every
+// function key with function services, whatever their active requests.
+func (c *PoolCache) ListFunctionVersions(function types.NamespacedName) []*FuncSvc {
+	respChannel := make(chan *response)
+	c.requestChannel <- &request{
+		requestType:     listFunctionVersions,
+		functionName:    function,
+		responseChannel: respChannel,
+	}
+	resp := <-respChannel
+	return resp.allValues
+}
+
 // ReduceSpecializationInProgress reduces the svcWaiting count
 func (c *PoolCache) MarkSpecializationFailure(function string) {
 	c.requestChannel <- &request{
@@ -48,6 +48,82 @@ var (
 		},
 		functionLabels,
 	)
+	// stage: the stage of specialization which failed, fetch or load
+	SpecializeErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fission_function_specialize_errors_total",
+			Help: "Count of failures to specialize a pod by function_name, function_namespace and the failed stage.",
+		},
+		[]string{"function_name", "function_namespace", "stage"},
+	)
+
+	// environment_name: the environment's name
+	// environment_namespace: the environment's namespace
+	poolLabels    = []string{"environment_name", "environment_namespace"}
+	PoolReadyPods = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "fission_pool_ready_pods",
+			Help: "Number of generic pods ready to be specialized in the pool.",
+		},
+		poolLabels,
+	)
+	PoolStarvedRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fission_pool_starved_requests_total",
+			Help: "Count of requests which found no ready pod in the pool and had to wait for one.",
+		},
+		poolLabels,
+	)
+	PoolNoReadyPods = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fission_pool_no_ready_pods_total",
+			Help: "Count of requests which failed because the pool had no ready pods for longer than the threshold.",
+		},
+		poolLabels,
+	)
+	PoolSpecializeDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "fission_pool_specialize_duration_seconds",
+			Help:    "Time taken to specialize a generic pod in seconds.",
+			Buckets: prometheus.ExponentialBuckets(0.05, 2, 12),
+		},
+		poolLabels,
+	)
+	PoolRelabelFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fission_pool_relabel_failures_total",
+			Help: "Count of failures to relabel a chosen pod, after which another pod is tried.",
+		},
+		poolLabels,
+	)
+	PoolSpecializedPodFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fission_pool_specialized_pod_failures_total",
+			Help: "Count of specialized pods which failed or crash looped while serving their function.",
+		},
+		poolLabels,
+	)
+	PoolRespecializations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fission_pool_respecializations_total",
+			Help: "Count of functions re-specialized because their pod was about to be evicted, e.g. by a node drain.",
+		},
+		poolLabels,
+	)
+	FetchBreakerState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "fission_fetch_breaker_state",
+			Help: "State of the breaker of an environment pool which fails specializations fast while fetchers can't reach the package source: 0 closed, 1 open, 2 half open.",
+		},
+		poolLabels,
+	)
+	FetchBreakerTrips = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fission_fetch_breaker_trips_total",
+			Help: "Count of times the fetch breaker of an environment pool opened after fetchers couldn't reach the package source.",
+		},
+		poolLabels,
+	)
 )
 
 func init() {
@@ -55,4 +131,14 @@ func init() {
 	registry.MustRegister(ColdStarts)
 	registry.MustRegister(FuncRunningSummary)
 	registry.MustRegister(ColdStartsError)
+	registry.MustRegister(SpecializeErrors)
+	registry.MustRegister(PoolReadyPods)
+	registry.MustRegister(PoolStarvedRequests)
+	registry.MustRegister(PoolNoReadyPods)
+	registry.MustRegister(FetchBreakerState)
+	registry.MustRegister(FetchBreakerTrips)
+	registry.MustRegister(PoolSpecializeDuration)
+	registry.MustRegister(PoolRelabelFailures)
+	registry.MustRegister(PoolSpecializedPodFailures)
+	registry.MustRegister(PoolRespecializations)
 }
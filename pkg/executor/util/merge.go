@@ -142,6 +142,10 @@ func MergePodSpec(srcPodSpec *apiv1.PodSpec, targetPodSpec *apiv1.PodSpec) (*api
 		srcPodSpec.DNSPolicy = targetPodSpec.DNSPolicy
 	}
 
+	if targetPodSpec.DNSConfig != nil {
+		srcPodSpec.DNSConfig = targetPodSpec.DNSConfig
+	}
+
 	if targetPodSpec.ServiceAccountName != "" {
 		srcPodSpec.ServiceAccountName = targetPodSpec.ServiceAccountName
 	}
@@ -188,6 +192,7 @@ func MergePodSpec(srcPodSpec *apiv1.PodSpec, targetPodSpec *apiv1.PodSpec) (*api
 
 	srcPodSpec.ImagePullSecrets = append(srcPodSpec.ImagePullSecrets, targetPodSpec.ImagePullSecrets...)
 	srcPodSpec.Tolerations = append(srcPodSpec.Tolerations, targetPodSpec.Tolerations...)
+	srcPodSpec.TopologySpreadConstraints = append(srcPodSpec.TopologySpreadConstraints, targetPodSpec.TopologySpreadConstraints...)
 	srcPodSpec.HostAliases = append(srcPodSpec.HostAliases, targetPodSpec.HostAliases...)
 
 	err = mergo.Merge(&srcPodSpec.NodeSelector, targetPodSpec.NodeSelector)
@@ -203,6 +208,9 @@ func mergeContainerList(dst []apiv1.Container, src []apiv1.Container) ([]apiv1.C
 
 	list := append(dst, src...)
 	containers := make(map[string]*apiv1.Container, len(list))
+	// keep containers in the order they first appear, map order would
+	// shuffle them on every merge
+	var names []string
 
 	for i, c := range list {
 		container, ok := containers[c.Name]
@@ -216,12 +224,13 @@ func mergeContainerList(dst []apiv1.Container, src []apiv1.Container) ([]apiv1.C
 			}
 		} else {
 			containers[c.Name] = &list[i]
+			names = append(names, c.Name)
 		}
 	}
 
 	var containerList []apiv1.Container
-	for _, c := range containers {
-		containerList = append(containerList, *c)
+	for _, name := range names {
+		containerList = append(containerList, *containers[name])
 	}
 
 	if errs.ErrorOrNil() != nil {
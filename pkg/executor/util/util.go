@@ -46,10 +46,21 @@ const (
 // Second, Fission no longer need to handle "secret not found" error
 // when creating the environment deployment since kubelet will retry to
 // pull image until successes.
+// Secrets already in the pod spec, e.g. from the runtime pod spec patch,
+// are kept.
 func ApplyImagePullSecret(secret string, podspec apiv1.PodSpec) *apiv1.PodSpec {
-	if len(secret) > 0 {
-		podspec.ImagePullSecrets = []apiv1.LocalObjectReference{{Name: secret}}
+	if len(secret) == 0 {
+		return &podspec
 	}
+	for _, s := range podspec.ImagePullSecrets {
+		if s.Name == secret {
+			return &podspec
+		}
+	}
+	// copy the secrets, the pod spec may share them with the pod spec patch
+	secrets := make([]apiv1.LocalObjectReference, 0, len(podspec.ImagePullSecrets)+1)
+	secrets = append(secrets, podspec.ImagePullSecrets...)
+	podspec.ImagePullSecrets = append(secrets, apiv1.LocalObjectReference{Name: secret})
 	return &podspec
 }
 
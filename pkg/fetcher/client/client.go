@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"io"
+	"net"
 	"net/http"
 	"strings"
 	"time"
@@ -23,18 +24,74 @@ type (
 		logger     *zap.Logger
 		url        string
 		httpClient *http.Client
+		maxRetries int
+		authToken  string
 	}
+
+	// HTTPError is a fetcher response with a status other than 200.
+	HTTPError struct {
+		StatusCode int
+		// Stage is the stage a specialize request failed at, see
+		// fetcher.SpecializeStageHeader. It's empty for other requests
+		// and for fetchers of older releases.
+		Stage string
+		Err   error
+	}
+)
+
+func (e *HTTPError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *HTTPError) Unwrap() error {
+	return e.Err
+}
+
+// DefaultMaxRetries is the number of attempts made for a fetcher request
+// failing with a connection error or a retriable status, see isRetriable.
+const DefaultMaxRetries = 20
+
+// retryBackoff is the delay before the first retry, it doubles after each
+// failed attempt up to maxRetryBackoff.
+const (
+	retryBackoff    = 50 * time.Millisecond
+	maxRetryBackoff = 2 * time.Second
 )
 
+// dialTimeout bounds the time to establish a connection to fetcher, the
+// overall request duration is bounded by the context passed by the caller.
+const dialTimeout = 10 * time.Second
+
 func MakeClient(logger *zap.Logger, fetcherUrl string) *Client {
-	hc := &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   dialTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	hc := &http.Client{Transport: otelhttp.NewTransport(transport)}
 	return &Client{
 		logger:     logger.Named("fetcher_client"),
 		url:        strings.TrimSuffix(fetcherUrl, "/"),
 		httpClient: hc,
+		maxRetries: DefaultMaxRetries,
 	}
 }
 
+// WithMaxRetries sets the number of attempts made for a request, values
+// less than one are ignored.
+func (c *Client) WithMaxRetries(maxRetries int) *Client {
+	if maxRetries > 0 {
+		c.maxRetries = maxRetries
+	}
+	return c
+}
+
+// WithAuthToken sets the bearer token sent to fetchers requiring authentication.
+func (c *Client) WithAuthToken(token string) *Client {
+	c.authToken = token
+	return c
+}
+
 func (c *Client) getSpecializeUrl() string {
 	return c.url + "/specialize"
 }
@@ -47,18 +104,45 @@ func (c *Client) getUploadUrl() string {
 	return c.url + "/upload"
 }
 
-func (c *Client) Specialize(ctx context.Context, req *fetcher.FunctionSpecializeRequest) error {
-	_, err := sendRequest(c.logger, ctx, c.httpClient, req, c.getSpecializeUrl())
-	return err
+func (c *Client) getResetUrl() string {
+	return c.url + "/reset"
+}
+
+// Specialize asks the fetcher to fetch the function and load it into the
+// runtime. The response is nil for fetchers which don't report the
+// function they wrote.
+func (c *Client) Specialize(ctx context.Context, req *fetcher.FunctionSpecializeRequest) (*fetcher.FunctionSpecializeResponse, error) {
+	body, err := c.sendRequest(ctx, req, c.getSpecializeUrl())
+	if err != nil {
+		return nil, err
+	}
+	if len(body) == 0 {
+		return nil, nil
+	}
+
+	specializeResp := fetcher.FunctionSpecializeResponse{}
+	err = json.Unmarshal(body, &specializeResp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &specializeResp, nil
 }
 
 func (c *Client) Fetch(ctx context.Context, fr *fetcher.FunctionFetchRequest) error {
-	_, err := sendRequest(c.logger, ctx, c.httpClient, fr, c.getFetchUrl())
+	_, err := c.sendRequest(ctx, fr, c.getFetchUrl())
+	return err
+}
+
+// Reset asks the fetcher to remove the function, secrets and config maps
+// it fetched, see fetcher.Reset.
+func (c *Client) Reset(ctx context.Context) error {
+	_, err := c.sendRequest(ctx, struct{}{}, c.getResetUrl())
 	return err
 }
 
 func (c *Client) Upload(ctx context.Context, fr *fetcher.ArchiveUploadRequest) (*fetcher.ArchiveUploadResponse, error) {
-	body, err := sendRequest(c.logger, ctx, c.httpClient, fr, c.getUploadUrl())
+	body, err := c.sendRequest(ctx, fr, c.getUploadUrl())
 	if err != nil {
 		return nil, err
 	}
@@ -72,17 +156,28 @@ func (c *Client) Upload(ctx context.Context, fr *fetcher.ArchiveUploadRequest) (
 	return &uploadResp, nil
 }
 
-func sendRequest(logger *zap.Logger, ctx context.Context, httpClient *http.Client, req interface{}, url string) ([]byte, error) {
+func (c *Client) sendRequest(ctx context.Context, req interface{}, url string) ([]byte, error) {
+	logger := c.logger
+	maxRetries := c.maxRetries
 	body, err := json.Marshal(req)
 	if err != nil {
 		return nil, err
 	}
 
-	maxRetries := 20
 	var resp *http.Response
+	backoff := retryBackoff
 
 	for i := 0; i < maxRetries; i++ {
-		resp, err = ctxhttp.Post(ctx, httpClient, url, "application/json", bytes.NewReader(body))
+		var httpReq *http.Request
+		httpReq, err = http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		httpReq.Header.Set("Content-Type", "application/json")
+		if len(c.authToken) > 0 {
+			httpReq.Header.Set("Authorization", "Bearer "+c.authToken)
+		}
+		resp, err = ctxhttp.Do(ctx, c.httpClient, httpReq)
 
 		if err == nil {
 			if resp.StatusCode == 200 {
@@ -93,11 +188,22 @@ func sendRequest(logger *zap.Logger, ctx context.Context, httpClient *http.Clien
 				defer resp.Body.Close()
 				return body, err
 			}
-			err = ferror.MakeErrorFromHTTP(resp)
+			statusCode := resp.StatusCode
+			err = &HTTPError{
+				StatusCode: statusCode,
+				Stage:      resp.Header.Get(fetcher.SpecializeStageHeader),
+				Err:        ferror.MakeErrorFromHTTP(resp),
+			}
+			// other statuses won't succeed on retry, e.g. 4xx when the
+			// package doesn't exist
+			if !isRetriable(statusCode) {
+				logger.Error("error specializing/fetching/uploading package", zap.Error(err), zap.String("url", url), zap.Int("status", statusCode))
+				return nil, err
+			}
 		}
 
 		// skip retry and return directly due to context deadline exceeded
-		if err == context.DeadlineExceeded {
+		if errors.Is(err, context.DeadlineExceeded) {
 			msg := "error specializing function pod, either increase the specialization timeout for function or check function pod log would help."
 			err = errors.Wrap(err, msg)
 			logger.Error(msg, zap.Error(err), zap.String("url", url))
@@ -105,11 +211,30 @@ func sendRequest(logger *zap.Logger, ctx context.Context, httpClient *http.Clien
 		}
 
 		if i < maxRetries-1 {
-			time.Sleep(50 * time.Duration(2*i) * time.Millisecond)
 			logger.Error("error specializing/fetching/uploading package, retrying", zap.Error(err), zap.String("url", url))
+			select {
+			case <-ctx.Done():
+				return nil, errors.Wrapf(ctx.Err(), "error before retrying %s: %v", url, err)
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > maxRetryBackoff {
+				backoff = maxRetryBackoff
+			}
 			continue
 		}
 	}
 
 	return nil, err
 }
+
+// isRetriable returns true for the statuses of transient failures, when the
+// fetcher or a service it depends on is briefly unavailable.
+func isRetriable(statusCode int) bool {
+	switch statusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
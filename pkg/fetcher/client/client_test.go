@@ -0,0 +1,195 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	ferror "github.com/fission/fission/pkg/error"
+	"github.com/fission/fission/pkg/fetcher"
+	"github.com/fission/fission/pkg/utils/loggerfactory"
+)
+
+// refusingServer drops the connection of the first refused requests
+// and replies with the given status code afterwards.
+func refusingServer(t *testing.T, refused int32, status int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var attempts atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) <= refused {
+			hj, ok := w.(http.Hijacker)
+			if !ok {
+				t.Error("response writer is not a hijacker")
+				return
+			}
+			conn, _, err := hj.Hijack()
+			if err != nil {
+				t.Errorf("error hijacking connection: %v", err)
+				return
+			}
+			conn.Close()
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	return ts, &attempts
+}
+
+func TestSpecializeRetry(t *testing.T) {
+	logger := loggerfactory.GetLogger()
+
+	tests := []struct {
+		name         string
+		refused      int32
+		status       int
+		maxRetries   int
+		wantErr      bool
+		wantAttempts int32
+	}{
+		{"succeeds after refused connections", 3, http.StatusOK, 5, false, 4},
+		{"gives up after max retries", 3, http.StatusOK, 2, true, 2},
+		{"retries on 5xx", 0, http.StatusServiceUnavailable, 3, true, 3},
+		{"retries on bad gateway", 0, http.StatusBadGateway, 3, true, 3},
+		{"retries on gateway timeout", 0, http.StatusGatewayTimeout, 3, true, 3},
+		{"fails fast on internal error", 0, http.StatusInternalServerError, 5, true, 1},
+		{"fails fast on 4xx", 0, http.StatusNotFound, 5, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts, attempts := refusingServer(t, tt.refused, tt.status)
+			defer ts.Close()
+
+			_, err := MakeClient(logger, ts.URL).WithMaxRetries(tt.maxRetries).
+				Specialize(context.Background(), &fetcher.FunctionSpecializeRequest{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Specialize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := attempts.Load(); got != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", got, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestSpecializeResponse(t *testing.T) {
+	logger := loggerfactory.GetLogger()
+
+	tests := []struct {
+		name     string
+		body     string
+		wantResp *fetcher.FunctionSpecializeResponse
+	}{
+		{"fetcher without report", "", nil},
+		{"fetcher reports function", `{"filename":"fn","size":42}`, &fetcher.FunctionSpecializeResponse{Filename: "fn", Size: 42}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, err := w.Write([]byte(tt.body))
+				if err != nil {
+					t.Errorf("error writing response: %v", err)
+				}
+			}))
+			defer ts.Close()
+
+			resp, err := MakeClient(logger, ts.URL).Specialize(context.Background(), &fetcher.FunctionSpecializeRequest{})
+			if err != nil {
+				t.Fatalf("Specialize() error = %v", err)
+			}
+			if !reflect.DeepEqual(resp, tt.wantResp) {
+				t.Errorf("Specialize() = %+v, want %+v", resp, tt.wantResp)
+			}
+		})
+	}
+}
+
+func TestSpecializeHTTPError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(fetcher.SpecializeStageHeader, fetcher.SpecializeStageLoad)
+		http.Error(w, "runtime failed", http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	_, err := MakeClient(loggerfactory.GetLogger(), ts.URL).WithMaxRetries(1).
+		Specialize(context.Background(), &fetcher.FunctionSpecializeRequest{})
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		t.Fatalf("Specialize() error = %v, want an HTTPError", err)
+	}
+	if httpErr.StatusCode != http.StatusBadGateway || httpErr.Stage != fetcher.SpecializeStageLoad {
+		t.Errorf("got status %d and stage %q, want %d and %q",
+			httpErr.StatusCode, httpErr.Stage, http.StatusBadGateway, fetcher.SpecializeStageLoad)
+	}
+	if code, msg := ferror.GetHTTPError(err); code != http.StatusInternalServerError || msg != "runtime failed" {
+		t.Errorf("GetHTTPError() = %d, %q, want the fetcher error", code, msg)
+	}
+}
+
+func TestAuthToken(t *testing.T) {
+	ts := httptest.NewServer(fetcher.AuthHandler("secret", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	logger := loggerfactory.GetLogger()
+	_, err := MakeClient(logger, ts.URL).WithAuthToken("secret").
+		Specialize(context.Background(), &fetcher.FunctionSpecializeRequest{})
+	if err != nil {
+		t.Fatalf("Specialize() with token error = %v", err)
+	}
+	_, err = MakeClient(logger, ts.URL).
+		Specialize(context.Background(), &fetcher.FunctionSpecializeRequest{})
+	if err == nil {
+		t.Fatal("Specialize() without token succeeded, want unauthorized error")
+	}
+}
+
+func TestSpecializeRequestEscaping(t *testing.T) {
+	logger := loggerfactory.GetLogger()
+	want := fetcher.FunctionSpecializeRequest{
+		FetchReq: fetcher.FunctionFetchRequest{
+			FetchType: fv1.FETCH_URL,
+			Url:       `http://example.com/code.zip?sig="a\\b"&user=<fn>`,
+			Filename:  "user",
+		},
+	}
+
+	var got fetcher.FunctionSpecializeRequest
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := json.NewDecoder(r.Body).Decode(&got)
+		if err != nil {
+			t.Errorf("error decoding specialize request: %v", err)
+		}
+	}))
+	defer ts.Close()
+
+	_, err := MakeClient(logger, ts.URL).Specialize(context.Background(), &want)
+	if err != nil {
+		t.Fatalf("Specialize() error = %v", err)
+	}
+	if !reflect.DeepEqual(got.FetchReq, want.FetchReq) {
+		t.Errorf("fetcher got fetch request %+v, want %+v", got.FetchReq, want.FetchReq)
+	}
+}
@@ -1,10 +1,12 @@
 package container
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 
 	"github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
@@ -12,6 +14,7 @@ import (
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
 
 	fv1 "github.com/fission/fission/pkg/apis/core/v1"
 	"github.com/fission/fission/pkg/fetcher"
@@ -32,6 +35,39 @@ type Config struct {
 	sharedCfgMapPath string
 
 	serviceAccount string
+
+	// port the fetcher serves on and port of the runtime container
+	// the fetcher sends specialize requests to
+	port        int32
+	runtimePort int32
+
+	// token fetcher requires from callers, empty disables authentication
+	authToken string
+	// secret holding authToken under authTokenSecretKey, in the namespaces
+	// of the pods fetchers are added to
+	authTokenSecret string
+
+	// volumes added by callers, mounted in both the fetcher and the main container
+	extraVolumes      []apiv1.Volume
+	extraVolumeMounts []apiv1.VolumeMount
+}
+
+const DefaultFetcherPort = 8000
+
+// authTokenSecretKey is the key of the fetcher auth token in its secret.
+const authTokenSecretKey = "token"
+
+// getPort returns the port set in the environment variable or the default.
+func getPort(env string, defaultPort int32) (int32, error) {
+	val := os.Getenv(env)
+	if len(val) == 0 {
+		return defaultPort, nil
+	}
+	port, err := strconv.ParseInt(val, 10, 32)
+	if err != nil || port <= 0 || port > 65535 {
+		return 0, errors.Errorf("invalid port %q in %s", val, env)
+	}
+	return int32(port), nil
 }
 
 func getFetcherResources() (apiv1.ResourceRequirements, error) {
@@ -77,6 +113,23 @@ func MakeFetcherConfig(sharedMountPath string) (*Config, error) {
 		fetcherImagePullPolicy = "IfNotPresent"
 	}
 
+	port, err := getPort("FETCHER_PORT", DefaultFetcherPort)
+	if err != nil {
+		return nil, err
+	}
+	runtimePort, err := getPort("RUNTIME_PORT", fetcher.DefaultRuntimePort)
+	if err != nil {
+		return nil, err
+	}
+
+	// fetchers get the token from a secret, it must not show up in plain
+	// text in the pod specs
+	authToken := os.Getenv(fetcher.AuthTokenEnv)
+	authTokenSecret := os.Getenv("FETCHER_AUTH_TOKEN_SECRET")
+	if len(authToken) > 0 && len(authTokenSecret) == 0 {
+		return nil, errors.Errorf("%s is set but FETCHER_AUTH_TOKEN_SECRET doesn't name the secret fetchers get it from", fetcher.AuthTokenEnv)
+	}
+
 	return &Config{
 		resourceRequirements:   resources,
 		fetcherImage:           fetcherImage,
@@ -85,9 +138,50 @@ func MakeFetcherConfig(sharedMountPath string) (*Config, error) {
 		sharedSecretPath:       "/secrets",
 		sharedCfgMapPath:       "/configs",
 		serviceAccount:         fv1.FissionFetcherSA,
+		port:                   port,
+		runtimePort:            runtimePort,
+		authToken:              authToken,
+		authTokenSecret:        authTokenSecret,
 	}, nil
 }
 
+// AuthToken returns the token to send in requests to fetcher.
+func (cfg *Config) AuthToken() string {
+	return cfg.authToken
+}
+
+// CheckAuthTokenSecret checks that the secret fetchers get the auth token
+// from is in the namespace and holds the token. Pods referencing a missing
+// secret or key never start, and fetchers with another token reject every
+// request.
+func (cfg *Config) CheckAuthTokenSecret(ctx context.Context, client kubernetes.Interface, namespace string) error {
+	if len(cfg.authToken) == 0 {
+		return nil
+	}
+	secret, err := client.CoreV1().Secrets(namespace).Get(ctx, cfg.authTokenSecret, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "error getting fetcher auth token secret %s/%s", namespace, cfg.authTokenSecret)
+	}
+	token, ok := secret.Data[authTokenSecretKey]
+	if !ok {
+		return errors.Errorf("fetcher auth token secret %s/%s has no %q key", namespace, cfg.authTokenSecret, authTokenSecretKey)
+	}
+	if string(token) != cfg.authToken {
+		return errors.Errorf("fetcher auth token secret %s/%s doesn't hold the token of %s", namespace, cfg.authTokenSecret, fetcher.AuthTokenEnv)
+	}
+	return nil
+}
+
+// Port returns the port the fetcher serves on.
+func (cfg *Config) Port() int32 {
+	return cfg.port
+}
+
+// RuntimePort returns the port the runtime container serves specialize requests on.
+func (cfg *Config) RuntimePort() int32 {
+	return cfg.runtimePort
+}
+
 func (cfg *Config) SharedMountPath() string {
 	return cfg.sharedMountPath
 }
@@ -106,6 +200,10 @@ func (cfg *Config) NewSpecializeRequest(fn *fv1.Function, env *fv1.Environment)
 			targetFilename = "deployarchive"
 		}
 	}
+	if filename := env.ObjectMeta.Annotations[fv1.ANNOTATION_FETCHER_FILENAME]; len(filename) > 0 &&
+		env.Spec.AllowedFunctionsPerContainer != fv1.AllowedFunctionsPerContainerInfinite && ValidTargetFilename(filename) {
+		targetFilename = filename
+	}
 
 	return fetcher.FunctionSpecializeRequest{
 		FetchReq: fetcher.FunctionFetchRequest{
@@ -129,6 +227,12 @@ func (cfg *Config) NewSpecializeRequest(fn *fv1.Function, env *fv1.Environment)
 	}
 }
 
+// ValidTargetFilename returns whether fetchers can write a function as name,
+// which has to stay in the shared volume.
+func ValidTargetFilename(name string) bool {
+	return len(name) > 0 && name != "." && name != ".." && filepath.Base(name) == name
+}
+
 func (cfg *Config) AddFetcherToPodSpec(podSpec *apiv1.PodSpec, mainContainerName string) error {
 	return cfg.addFetcherToPodSpecWithCommand(podSpec, mainContainerName, cfg.fetcherCommand())
 }
@@ -155,12 +259,28 @@ func (cfg *Config) fetcherCommand(extraArgs ...string) []string {
 		"-secret-dir", cfg.sharedSecretPath,
 		"-cfgmap-dir", cfg.sharedCfgMapPath,
 	}
+	// only pass non-default ports, so fetcher images without
+	// the flags keep working
+	if cfg.port != DefaultFetcherPort {
+		command = append(command, "-port", strconv.Itoa(int(cfg.port)))
+	}
+	if cfg.runtimePort != fetcher.DefaultRuntimePort {
+		command = append(command, "-runtime-port", strconv.Itoa(int(cfg.runtimePort)))
+	}
 
 	command = append(command, extraArgs...)
 	command = append(command, cfg.sharedMountPath)
 	return command
 }
 
+// AddVolumes adds volumes to the pods the fetcher is added to, e.g. a
+// configmap or a scratch volume. The mounts are added to both the fetcher
+// and the main container.
+func (cfg *Config) AddVolumes(volumes []apiv1.Volume, mounts []apiv1.VolumeMount) {
+	cfg.extraVolumes = append(cfg.extraVolumes, volumes...)
+	cfg.extraVolumeMounts = append(cfg.extraVolumeMounts, mounts...)
+}
+
 func (cfg *Config) volumesWithMounts() ([]apiv1.Volume, []apiv1.VolumeMount) {
 
 	items := make([]apiv1.DownwardAPIVolumeFile, 0)
@@ -227,6 +347,8 @@ func (cfg *Config) volumesWithMounts() ([]apiv1.Volume, []apiv1.VolumeMount) {
 			MountPath: fv1.PodInfoMount,
 		},
 	}
+	volumes = append(volumes, cfg.extraVolumes...)
+	mounts = append(mounts, cfg.extraVolumeMounts...)
 
 	return volumes, mounts
 }
@@ -250,7 +372,7 @@ func (cfg *Config) addFetcherToPodSpecWithCommand(podSpec *apiv1.PodSpec, mainCo
 					Path: "/readiness-healthz",
 					Port: intstr.IntOrString{
 						Type:   intstr.Int,
-						IntVal: 8000,
+						IntVal: cfg.port,
 					},
 				},
 			},
@@ -263,13 +385,24 @@ func (cfg *Config) addFetcherToPodSpecWithCommand(podSpec *apiv1.PodSpec, mainCo
 					Path: "/healthz",
 					Port: intstr.IntOrString{
 						Type:   intstr.Int,
-						IntVal: 8000,
+						IntVal: cfg.port,
 					},
 				},
 			},
 		},
 		Env: otel.OtelEnvForContainer(),
 	}
+	if len(cfg.authToken) > 0 {
+		c.Env = append(c.Env, apiv1.EnvVar{
+			Name: fetcher.AuthTokenEnv,
+			ValueFrom: &apiv1.EnvVarSource{
+				SecretKeyRef: &apiv1.SecretKeySelector{
+					LocalObjectReference: apiv1.LocalObjectReference{Name: cfg.authTokenSecret},
+					Key:                  authTokenSecretKey,
+				},
+			},
+		})
+	}
 
 	// Pod is removed from endpoints list for service when it's
 	// state became "Termination". We used preStop hook as the
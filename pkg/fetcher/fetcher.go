@@ -19,13 +19,16 @@ package fetcher
 import (
 	"bytes"
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/mholt/archiver/v3"
@@ -54,9 +57,35 @@ import (
 	otelUtils "github.com/fission/fission/pkg/utils/otel"
 )
 
+// AuthTokenEnv is the environment variable holding the token the fetcher
+// requires from callers, requests are not authenticated when it's empty.
+const AuthTokenEnv = "FETCHER_AUTH_TOKEN"
+
+// DefaultRuntimePort is the port the environment runtime container serves
+// specialize requests on.
+const DefaultRuntimePort = 8888
+
+// SpecializeStageHeader tells the stage a failed specialize request failed
+// at: SpecializeStageFetch or SpecializeStageLoad.
+const SpecializeStageHeader = "X-Fission-Specialize-Stage"
+
+const (
+	// SpecializeStageFetch is fetching the function to the shared volume.
+	SpecializeStageFetch = "fetch"
+	// SpecializeStageLoad is the runtime loading the function.
+	SpecializeStageLoad = "load"
+)
+
 type (
+	// loadError is an error of the runtime loading the function, as opposed
+	// to an error fetching it.
+	loadError struct {
+		error
+	}
+
 	Fetcher struct {
 		logger           *zap.Logger
+		runtimePort      int
 		sharedVolumePath string
 		sharedSecretPath string
 		sharedConfigPath string
@@ -64,6 +93,9 @@ type (
 		kubeClient       kubernetes.Interface
 		httpClient       *http.Client
 		Info             PodInfo
+		// checksums of the function files on the shared volume, computed
+		// when they're fetched, filename -> *fv1.Checksum
+		checksums sync.Map
 	}
 	PodInfo struct {
 		Name      string
@@ -113,6 +145,7 @@ func MakeFetcher(logger *zap.Logger, sharedVolumePath string, sharedSecretPath s
 	hc := &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
 	return &Fetcher{
 		logger:           fLogger,
+		runtimePort:      DefaultRuntimePort,
 		sharedVolumePath: sharedVolumePath,
 		sharedSecretPath: sharedSecretPath,
 		sharedConfigPath: sharedConfigPath,
@@ -126,6 +159,15 @@ func MakeFetcher(logger *zap.Logger, sharedVolumePath string, sharedSecretPath s
 	}, nil
 }
 
+// WithRuntimePort sets the port of the runtime container to send
+// specialize requests to.
+func (fetcher *Fetcher) WithRuntimePort(port int) *Fetcher {
+	if port > 0 {
+		fetcher.runtimePort = port
+	}
+	return fetcher
+}
+
 func verifyChecksum(fileChecksum, checksum *fv1.Checksum) error {
 	if checksum.Type != fv1.ChecksumTypeSHA256 {
 		return ferror.MakeError(ferror.ErrorInvalidArgument, "Unsupported checksum type")
@@ -147,6 +189,22 @@ func writeSecretOrConfigMap(dataMap map[string][]byte, dirPath string) error {
 	return nil
 }
 
+// AuthHandler rejects requests not carrying the bearer token, it returns
+// the handler as is when the token is empty.
+func AuthHandler(token string, handler http.HandlerFunc) http.HandlerFunc {
+	if len(token) == 0 {
+		return handler
+	}
+	expected := []byte("Bearer " + token)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
 func (fetcher *Fetcher) VersionHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	_, err := w.Write([]byte(info.BuildInfo().String()))
@@ -237,17 +295,166 @@ func (fetcher *Fetcher) SpecializeHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = fetcher.SpecializePod(ctx, req.FetchReq, req.LoadReq)
+	resp, err := fetcher.SpecializePod(ctx, req.FetchReq, req.LoadReq)
 	if err != nil {
 		logger.Error("error specializing pod", zap.Error(err))
+		stage := SpecializeStageFetch
+		status := http.StatusInternalServerError
+		if errors.As(err, &loadError{}) {
+			stage = SpecializeStageLoad
+		} else if isSourceUnavailable(err) {
+			status = http.StatusBadGateway
+		}
+		w.Header().Set(SpecializeStageHeader, stage)
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	rBody, err := json.Marshal(resp)
+	if err != nil {
+		logger.Error("error encoding specialize response", zap.Error(err))
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	// all done
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(rBody)
+	if err != nil {
+		logger.Error("error writing HTTP response", zap.Error(err))
+	}
+}
+
+// ResetHandler wipes the function, secrets and config maps fetched into
+// the shared volumes, see Reset.
+func (fetcher *Fetcher) ResetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "only POST is supported on this endpoint", http.StatusMethodNotAllowed)
+		return
+	}
+	logger := otelUtils.LoggerWithTraceID(r.Context(), fetcher.logger)
+
+	err := fetcher.Reset()
+	if err != nil {
+		logger.Error("error resetting shared volumes", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logger.Info("reset shared volumes")
 	w.WriteHeader(http.StatusOK)
 }
 
+// Reset removes everything fetched into the shared volumes, so that a pod
+// handed back to its pool is specialized from scratch: Fetch skips
+// functions already on the shared volume, and the secrets and config maps
+// of the previous function must not be readable by the next one.
+func (fetcher *Fetcher) Reset() error {
+	fetcher.checksums.Range(func(key, _ interface{}) bool {
+		fetcher.checksums.Delete(key)
+		return true
+	})
+	for _, dir := range []string{fetcher.sharedVolumePath, fetcher.sharedSecretPath, fetcher.sharedConfigPath} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return errors.Wrapf(err, "error reading directory %s", dir)
+		}
+		for _, entry := range entries {
+			err = os.RemoveAll(filepath.Join(dir, entry.Name()))
+			if err != nil {
+				return errors.Wrapf(err, "error removing %s from directory %s", entry.Name(), dir)
+			}
+		}
+	}
+	return nil
+}
+
+// functionInfo returns the size of the function written to the shared
+// volume, and its checksum if it's a single file. It returns an error if
+// the function is missing or empty.
+func (fetcher *Fetcher) functionInfo(filename string) (*FunctionSpecializeResponse, error) {
+	path := filepath.Join(fetcher.sharedVolumePath, filename)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error checking function %s", filename)
+	}
+
+	resp := &FunctionSpecializeResponse{Filename: filename}
+	if info.IsDir() {
+		err = filepath.Walk(path, func(_ string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if fi.Mode().IsRegular() {
+				resp.Size += fi.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "error checking function %s", filename)
+		}
+	} else {
+		resp.Size = info.Size()
+		resp.Checksum, err = fetcher.fileChecksum(filename)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error computing checksum of function %s", filename)
+		}
+	}
+
+	if resp.Size == 0 {
+		return nil, errors.Errorf("function %s is empty", filename)
+	}
+	return resp, nil
+}
+
+// fileChecksum returns the checksum of a function file on the shared
+// volume. It's computed when the function is fetched, or on first use for
+// files fetched before the fetcher restarted, rather than for each
+// specialization.
+func (fetcher *Fetcher) fileChecksum(filename string) (*fv1.Checksum, error) {
+	if sum, ok := fetcher.checksums.Load(filename); ok {
+		return sum.(*fv1.Checksum), nil
+	}
+	sum, err := utils.GetFileChecksum(filepath.Join(fetcher.sharedVolumePath, filename))
+	if err != nil {
+		return nil, err
+	}
+	fetcher.checksums.Store(filename, sum)
+	return sum, nil
+}
+
+// isSourceUnavailable returns true for errors downloading a package from a
+// source which is down or unreachable, as opposed to errors of the package
+// itself, e.g. a URL which doesn't exist.
+func isSourceUnavailable(err error) bool {
+	var downloadErr *utils.DownloadError
+	if errors.As(err, &downloadErr) {
+		switch downloadErr.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		default:
+			return false
+		}
+	}
+	// connection errors, but not hosts which don't exist
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return !dnsErr.IsNotFound
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
+
+// downloadStatus returns the status to reply for a failed package download:
+// 502 when the source is unavailable, so callers can tell it from a bad
+// package and retry.
+func downloadStatus(err error) int {
+	if isSourceUnavailable(err) {
+		return http.StatusBadGateway
+	}
+	return http.StatusBadRequest
+}
+
 // Fetch takes FetchRequest and makes the fetch call
 // It returns the HTTP code and error if any
 func (fetcher *Fetcher) Fetch(ctx context.Context, pkg *fv1.Package, req FunctionFetchRequest) (int, error) {
@@ -271,6 +478,10 @@ func (fetcher *Fetcher) Fetch(ctx context.Context, pkg *fv1.Package, req Functio
 
 	tmpFile := req.Filename + ".tmp"
 	tmpPath := filepath.Join(fetcher.sharedVolumePath, tmpFile)
+	// checksum of the fetched file, when it's computed along the way
+	var checksum *fv1.Checksum
+	// whether the function is an unpacked archive, which has no checksum
+	var unarchived bool
 
 	if req.FetchType == fv1.FETCH_URL {
 		otelUtils.SpanTrackEvent(ctx, "fetch_url", otelUtils.MapToAttributes(map[string]string{
@@ -283,7 +494,7 @@ func (fetcher *Fetcher) Fetch(ctx context.Context, pkg *fv1.Package, req Functio
 		if err != nil {
 			e := "failed to download url"
 			logger.Error(e, zap.Error(err), zap.String("url", req.Url))
-			return http.StatusBadRequest, errors.Wrapf(err, "%s: %s", e, req.Url)
+			return downloadStatus(err), errors.Wrapf(err, "%s: %s", e, req.Url)
 		}
 	} else {
 		var archive *fv1.Archive
@@ -316,6 +527,10 @@ func (fetcher *Fetcher) Fetch(ctx context.Context, pkg *fv1.Package, req Functio
 				logger.Error(e, zap.Error(err), zap.String("location", tmpPath))
 				return http.StatusInternalServerError, errors.Wrapf(err, "%s %s", e, tmpPath)
 			}
+			checksum, err = utils.GetChecksum(bytes.NewReader(archive.Literal))
+			if err != nil {
+				logger.Error("failed to get checksum", zap.Error(err))
+			}
 			otelUtils.SpanTrackEvent(ctx, "archiveLiteral", otelUtils.GetAttributesForPackage(pkg)...)
 		} else {
 			// download and verify
@@ -328,12 +543,12 @@ func (fetcher *Fetcher) Fetch(ctx context.Context, pkg *fv1.Package, req Functio
 			if err != nil {
 				e := "failed to download url"
 				logger.Error(e, zap.Error(err), zap.String("url", req.Url))
-				return http.StatusBadRequest, errors.Wrapf(err, "%s %s", e, req.Url)
+				return downloadStatus(err), errors.Wrapf(err, "%s %s", e, req.Url)
 			}
 
 			// check file integrity only if checksum is not empty.
 			if len(archive.Checksum.Sum) > 0 {
-				checksum, err := utils.GetFileChecksum(tmpPath)
+				checksum, err = utils.GetFileChecksum(tmpPath)
 				if err != nil {
 					e := "failed to get checksum"
 					logger.Error(e, zap.Error(err))
@@ -371,6 +586,7 @@ func (fetcher *Fetcher) Fetch(ctx context.Context, pkg *fv1.Package, req Functio
 		}
 
 		tmpPath = tmpUnarchivePath
+		unarchived = true
 	}
 
 	// move tmp file to requested filename
@@ -383,6 +599,18 @@ func (fetcher *Fetcher) Fetch(ctx context.Context, pkg *fv1.Package, req Functio
 			zap.String("rename_path", renamePath))
 		return http.StatusInternalServerError, err
 	}
+	if !unarchived {
+		if checksum == nil {
+			checksum, err = utils.GetFileChecksum(renamePath)
+			if err != nil {
+				// functionInfo computes it when the function is specialized
+				logger.Error("failed to get checksum", zap.Error(err), zap.String("location", renamePath))
+			}
+		}
+		if checksum != nil {
+			fetcher.checksums.Store(req.Filename, checksum)
+		}
+	}
 
 	otelUtils.SpanTrackEvent(ctx, "packageFetched", otelUtils.GetAttributesForPackage(pkg)...)
 	logger.Info("successfully placed", zap.String("location", renamePath))
@@ -672,7 +900,7 @@ func (fetcher *Fetcher) getPkgInformation(ctx context.Context, req FunctionFetch
 	return nil, err
 }
 
-func (fetcher *Fetcher) SpecializePod(ctx context.Context, fetchReq FunctionFetchRequest, loadReq FunctionLoadRequest) error {
+func (fetcher *Fetcher) SpecializePod(ctx context.Context, fetchReq FunctionFetchRequest, loadReq FunctionLoadRequest) (*FunctionSpecializeResponse, error) {
 	logger := otelUtils.LoggerWithTraceID(ctx, fetcher.logger)
 	startTime := time.Now()
 	defer func() {
@@ -682,55 +910,44 @@ func (fetcher *Fetcher) SpecializePod(ctx context.Context, fetchReq FunctionFetc
 
 	pkg, err := fetcher.getPkgInformation(ctx, fetchReq)
 	if err != nil {
-		return errors.Wrap(err, "error getting package information")
+		return nil, errors.Wrap(err, "error getting package information")
 	}
 
 	_, err = fetcher.Fetch(ctx, pkg, fetchReq)
 	if err != nil {
-		return errors.Wrap(err, "error fetching deploy package")
+		return nil, errors.Wrap(err, "error fetching deploy package")
+	}
+
+	// make sure the function landed in the shared volume before
+	// asking the runtime to load it
+	fnInfo, err := fetcher.functionInfo(fetchReq.Filename)
+	if err != nil {
+		return nil, err
 	}
 
 	_, err = fetcher.FetchSecretsAndCfgMaps(ctx, fetchReq.Secrets, fetchReq.ConfigMaps)
 	if err != nil {
-		return errors.Wrap(err, "error fetching secrets/configs")
+		return nil, errors.Wrap(err, "error fetching secrets/configs")
 	}
 
 	// Specialize the pod
 
 	maxRetries := 30
-	var contentType string
-	var specializeURL string
-	var reader *bytes.Reader
-
-	loadPayload, err := json.Marshal(loadReq)
+	specializeURL, contentType, payload, err := envSpecializeRequest(fetcher.runtimePort, loadReq)
 	if err != nil {
-		return errors.Wrap(err, "error encoding load request")
-	}
-
-	// Instead of using "localhost", here we use "127.0.0.1" for
-	// inter-pod communication to prevent wrongly record returned from DNS.
-
-	if loadReq.EnvVersion >= 2 {
-		contentType = "application/json"
-		specializeURL = "http://127.0.0.1:8888/v2/specialize"
-		reader = bytes.NewReader(loadPayload)
-		logger.Info("calling environment v2 specialization endpoint")
-	} else {
-		contentType = "text/plain"
-		specializeURL = "http://127.0.0.1:8888/specialize"
-		reader = bytes.NewReader([]byte{})
-		logger.Info("calling environment v1 specialization endpoint")
+		return nil, loadError{err}
 	}
+	logger.Info("calling environment specialization endpoint", zap.String("url", specializeURL))
 
 	for i := 0; i < maxRetries; i++ {
 		otelUtils.SpanTrackEvent(ctx, "specializeCall", otelUtils.MapToAttributes(map[string]string{
 			"url": specializeURL,
 		})...)
-		resp, err := ctxhttp.Post(ctx, fetcher.httpClient, specializeURL, contentType, reader)
+		resp, err := ctxhttp.Post(ctx, fetcher.httpClient, specializeURL, contentType, bytes.NewReader(payload))
 		if err == nil && resp.StatusCode < 300 {
 			// Success
 			resp.Body.Close()
-			return nil
+			return fnInfo, nil
 		}
 
 		netErr := network.Adapter(err)
@@ -748,10 +965,32 @@ func (fetcher *Fetcher) SpecializePod(ctx context.Context, fetchReq FunctionFetc
 			err = ferror.MakeErrorFromHTTP(resp)
 		}
 
-		return errors.Wrap(err, "error specializing function pod")
+		return nil, loadError{errors.Wrap(err, "error specializing function pod")}
 	}
 
-	return errors.Wrapf(err, "error specializing function pod after %v times", maxRetries)
+	return nil, errors.Wrapf(err, "error specializing function pod after %v times", maxRetries)
+}
+
+func (e loadError) Unwrap() error {
+	return e.error
+}
+
+// envSpecializeRequest returns the endpoint of the environment runtime to
+// load the function, along with the request body. v2 environments get the
+// load request as JSON, describing which function to load and where the
+// fetcher put it; its envVersion tells them the version of the payload. v1
+// environments load the function from a fixed path and get an empty body.
+func envSpecializeRequest(runtimePort int, loadReq FunctionLoadRequest) (url string, contentType string, payload []byte, err error) {
+	// Instead of using "localhost", here we use "127.0.0.1" for
+	// inter-pod communication to prevent wrongly record returned from DNS.
+	if loadReq.EnvVersion < 2 {
+		return fmt.Sprintf("http://127.0.0.1:%d/specialize", runtimePort), "text/plain", []byte{}, nil
+	}
+	payload, err = json.Marshal(loadReq)
+	if err != nil {
+		return "", "", nil, errors.Wrap(err, "error encoding load request")
+	}
+	return fmt.Sprintf("http://127.0.0.1:%d/v2/specialize", runtimePort), "application/json", payload, nil
 }
 
 // WsStartHandler is used to generate websocket events in Kubernetes
@@ -0,0 +1,313 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fetcher
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	fv1 "github.com/fission/fission/pkg/apis/core/v1"
+	"github.com/fission/fission/pkg/utils"
+)
+
+func TestFunctionInfo(t *testing.T) {
+	dir := t.TempDir()
+	fetcher := &Fetcher{sharedVolumePath: dir}
+
+	writeFile := func(name string, content string) {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		err := os.MkdirAll(filepath.Dir(path), 0750)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(path, []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("file", "hello")
+	writeFile("empty", "")
+	writeFile("archive/main.py", "print(1)")
+	writeFile("archive/lib/util.py", "x = 1")
+	err := os.MkdirAll(filepath.Join(dir, "emptydir"), 0750)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		filename     string
+		wantSize     int64
+		wantChecksum bool
+		wantErr      bool
+	}{
+		{"single file", "file", 5, true, false},
+		{"archive directory", "archive", 13, false, false},
+		{"missing function", "missing", 0, false, true},
+		{"empty file", "empty", 0, false, true},
+		{"empty directory", "emptydir", 0, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := fetcher.functionInfo(tt.filename)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("functionInfo() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if resp.Size != tt.wantSize {
+				t.Errorf("size = %d, want %d", resp.Size, tt.wantSize)
+			}
+			if (resp.Checksum != nil) != tt.wantChecksum {
+				t.Errorf("checksum = %v, want checksum %v", resp.Checksum, tt.wantChecksum)
+			}
+		})
+	}
+}
+
+// TestFunctionInfoChecksum checks that the checksum of a function is
+// computed when it's fetched rather than for each specialization, and
+// forgotten when the pod is reset.
+func TestFunctionInfoChecksum(t *testing.T) {
+	ctx := context.Background()
+	fetcher := &Fetcher{
+		logger:           zap.NewNop(),
+		sharedVolumePath: t.TempDir(),
+		sharedSecretPath: t.TempDir(),
+		sharedConfigPath: t.TempDir(),
+	}
+	fetch := func(code string) {
+		t.Helper()
+		pkg := &fv1.Package{
+			Spec:   fv1.PackageSpec{Deployment: fv1.Archive{Literal: []byte(code)}},
+			Status: fv1.PackageStatus{BuildStatus: fv1.BuildStatusSucceeded},
+		}
+		_, err := fetcher.Fetch(ctx, pkg, FunctionFetchRequest{FetchType: fv1.FETCH_DEPLOYMENT, Filename: "user"})
+		if err != nil {
+			t.Fatalf("Error fetching function: %v", err)
+		}
+	}
+	checksum := func(code string) string {
+		t.Helper()
+		sum, err := utils.GetChecksum(strings.NewReader(code))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sum.Sum
+	}
+
+	fetch("first")
+	// the file isn't read again once fetched
+	err := os.WriteFile(filepath.Join(fetcher.sharedVolumePath, "user"), []byte("changed"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		resp, err := fetcher.functionInfo("user")
+		if err != nil {
+			t.Fatalf("functionInfo() error = %v", err)
+		}
+		if resp.Checksum == nil || resp.Checksum.Sum != checksum("first") {
+			t.Errorf("checksum = %v, want the checksum of the fetched function", resp.Checksum)
+		}
+	}
+
+	err = fetcher.Reset()
+	if err != nil {
+		t.Fatalf("Error resetting fetcher: %v", err)
+	}
+	fetch("second")
+	resp, err := fetcher.functionInfo("user")
+	if err != nil {
+		t.Fatalf("functionInfo() error = %v", err)
+	}
+	if resp.Checksum == nil || resp.Checksum.Sum != checksum("second") {
+		t.Errorf("checksum after a reset = %v, want the checksum of the function fetched since", resp.Checksum)
+	}
+}
+
+// TestResetFetchesFreshCode specializes a pod for a function, resets it
+// as when it's handed back to the pool, and specializes it for another
+// function written under the same name.
+func TestResetFetchesFreshCode(t *testing.T) {
+	ctx := context.Background()
+	fetcher := &Fetcher{
+		logger:           zap.NewNop(),
+		sharedVolumePath: t.TempDir(),
+		sharedSecretPath: t.TempDir(),
+		sharedConfigPath: t.TempDir(),
+	}
+	fetch := func(code string) {
+		t.Helper()
+		pkg := &fv1.Package{
+			Spec:   fv1.PackageSpec{Deployment: fv1.Archive{Literal: []byte(code)}},
+			Status: fv1.PackageStatus{BuildStatus: fv1.BuildStatusSucceeded},
+		}
+		_, err := fetcher.Fetch(ctx, pkg, FunctionFetchRequest{FetchType: fv1.FETCH_DEPLOYMENT, Filename: "user"})
+		if err != nil {
+			t.Fatalf("Error fetching function: %v", err)
+		}
+	}
+	fetched := func() string {
+		t.Helper()
+		code, err := os.ReadFile(filepath.Join(fetcher.sharedVolumePath, "user"))
+		if err != nil {
+			t.Fatalf("Error reading function: %v", err)
+		}
+		return string(code)
+	}
+
+	fetch("first")
+	err := os.WriteFile(filepath.Join(fetcher.sharedSecretPath, "secret"), []byte("first"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// without a reset, the function already on the shared volume is kept
+	fetch("second")
+	if code := fetched(); code != "first" {
+		t.Fatalf("fetched %q without a reset, want the function already fetched", code)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/reset", nil)
+	rr := httptest.NewRecorder()
+	fetcher.ResetHandler(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("reset status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	for _, dir := range []string{fetcher.sharedVolumePath, fetcher.sharedSecretPath, fetcher.sharedConfigPath} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("Error reading directory: %v", err)
+		}
+		if len(entries) > 0 {
+			t.Errorf("%d entries left in %s after a reset", len(entries), dir)
+		}
+	}
+
+	fetch("second")
+	if code := fetched(); code != "second" {
+		t.Errorf("fetched %q after a reset, want %q", code, "second")
+	}
+}
+
+func TestDownloadStatus(t *testing.T) {
+	refused := &url.Error{Op: "Get", URL: "http://storagesvc", Err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}}
+	noHost := &url.Error{Op: "Get", URL: "http://nohost", Err: &net.OpError{Op: "dial", Net: "tcp",
+		Err: &net.DNSError{Err: "no such host", Name: "nohost", IsNotFound: true}}}
+	badScheme := &url.Error{Op: "Get", URL: "ftp://storagesvc", Err: errors.New("unsupported protocol scheme")}
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"source unavailable", &utils.DownloadError{StatusCode: http.StatusServiceUnavailable}, http.StatusBadGateway},
+		{"source gateway timeout", &utils.DownloadError{StatusCode: http.StatusGatewayTimeout}, http.StatusBadGateway},
+		{"package not found", &utils.DownloadError{StatusCode: http.StatusNotFound}, http.StatusBadRequest},
+		{"source internal error", &utils.DownloadError{StatusCode: http.StatusInternalServerError}, http.StatusBadRequest},
+		{"connection refused", refused, http.StatusBadGateway},
+		{"host not found", noHost, http.StatusBadRequest},
+		{"unsupported scheme", badScheme, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := downloadStatus(errors.Wrap(tt.err, "failed to download url")); got != tt.want {
+				t.Errorf("downloadStatus() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthHandler(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		header string
+		want   int
+	}{
+		{"no token configured", "", "", http.StatusOK},
+		{"missing header", "secret", "", http.StatusUnauthorized},
+		{"wrong token", "secret", "Bearer wrong", http.StatusUnauthorized},
+		{"valid token", "secret", "Bearer secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/specialize", nil)
+			if len(tt.header) > 0 {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rr := httptest.NewRecorder()
+			AuthHandler(tt.token, ok)(rr, req)
+			if rr.Code != tt.want {
+				t.Errorf("status = %d, want %d", rr.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnvSpecializeRequest(t *testing.T) {
+	loadReq := FunctionLoadRequest{
+		FilePath:         "/userfunc/deployarchive",
+		FunctionName:     "main.handler",
+		FunctionMetadata: &metav1.ObjectMeta{Name: "fn", Namespace: "default", UID: "fn-uid"},
+		EnvVersion:       2,
+	}
+	url, contentType, payload, err := envSpecializeRequest(8888, loadReq)
+	if err != nil {
+		t.Fatalf("envSpecializeRequest() error = %v", err)
+	}
+	if url != "http://127.0.0.1:8888/v2/specialize" || contentType != "application/json" {
+		t.Errorf("v2 request to %s as %s, want /v2/specialize as application/json", url, contentType)
+	}
+	var got FunctionLoadRequest
+	err = json.Unmarshal(payload, &got)
+	if err != nil {
+		t.Fatalf("Error decoding payload: %v", err)
+	}
+	if !reflect.DeepEqual(got, loadReq) {
+		t.Errorf("payload = %+v, want %+v", got, loadReq)
+	}
+
+	loadReq.EnvVersion = 1
+	url, _, payload, err = envSpecializeRequest(8888, loadReq)
+	if err != nil {
+		t.Fatalf("envSpecializeRequest() error = %v", err)
+	}
+	if url != "http://127.0.0.1:8888/specialize" || len(payload) != 0 {
+		t.Errorf("v1 request to %s with %d bytes, want /specialize with an empty body", url, len(payload))
+	}
+}
@@ -33,6 +33,15 @@ type (
 		LoadReq  FunctionLoadRequest
 	}
 
+	// FunctionSpecializeResponse describes the function the fetcher wrote
+	// to the shared volume before specializing the pod. Checksum is only
+	// set when the function is a single file.
+	FunctionSpecializeResponse struct {
+		Filename string        `json:"filename"`
+		Size     int64         `json:"size"`
+		Checksum *fv1.Checksum `json:"checksum,omitempty"`
+	}
+
 	FunctionFetchRequest struct {
 		FetchType     FetchRequestType         `json:"fetchType"`
 		Package       metav1.ObjectMeta        `json:"package"`
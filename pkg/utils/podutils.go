@@ -39,10 +39,25 @@ func IsReadyPod(pod *v1.Pod) bool {
 		return false
 	}
 
+	// the kubelet has not reported on the containers yet
+	if len(pod.Status.ContainerStatuses) == 0 {
+		return false
+	}
+
+	ready := make(map[string]bool, len(pod.Status.ContainerStatuses))
 	for _, cStatus := range pod.Status.ContainerStatuses {
 		if !cStatus.Ready {
 			return false
 		}
+		ready[cStatus.Name] = true
+	}
+
+	// every container, e.g. the fetcher next to the runtime, must have
+	// reported ready, not only the ones with a status so far
+	for _, container := range pod.Spec.Containers {
+		if !ready[container.Name] {
+			return false
+		}
 	}
 
 	return true
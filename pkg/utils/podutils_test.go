@@ -0,0 +1,61 @@
+/*
+Copyright 2023 The Fission Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIsReadyPod(t *testing.T) {
+	pod := func(statuses ...v1.ContainerStatus) *v1.Pod {
+		return &v1.Pod{
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{{Name: "runtime"}, {Name: "fetcher"}},
+			},
+			Status: v1.PodStatus{PodIP: "10.0.0.1", ContainerStatuses: statuses},
+		}
+	}
+	terminating := pod(v1.ContainerStatus{Name: "runtime", Ready: true}, v1.ContainerStatus{Name: "fetcher", Ready: true})
+	now := metav1.Now()
+	terminating.DeletionTimestamp = &now
+	noIP := pod(v1.ContainerStatus{Name: "runtime", Ready: true}, v1.ContainerStatus{Name: "fetcher", Ready: true})
+	noIP.Status.PodIP = ""
+
+	tests := []struct {
+		name string
+		pod  *v1.Pod
+		want bool
+	}{
+		{"nil pod", nil, false},
+		{"all containers ready", pod(v1.ContainerStatus{Name: "runtime", Ready: true}, v1.ContainerStatus{Name: "fetcher", Ready: true}), true},
+		{"fetcher not ready", pod(v1.ContainerStatus{Name: "runtime", Ready: true}, v1.ContainerStatus{Name: "fetcher"}), false},
+		{"fetcher not reported", pod(v1.ContainerStatus{Name: "runtime", Ready: true}), false},
+		{"no container reported", pod(), false},
+		{"terminating", terminating, false},
+		{"no ip", noIP, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsReadyPod(tt.pod); got != tt.want {
+				t.Errorf("IsReadyPod() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
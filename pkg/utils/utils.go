@@ -175,12 +175,26 @@ func IsURL(str string) bool {
 	return strings.HasPrefix(str, "http://") || strings.HasPrefix(str, "https://")
 }
 
+// DownloadError is returned by DownloadUrl when the server replies with a
+// status other than 2xx.
+type DownloadError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *DownloadError) Error() string {
+	return fmt.Sprintf("error downloading %s: server replied %d %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
 func DownloadUrl(ctx context.Context, httpClient *http.Client, url string, localPath string) error {
 	resp, err := ctxhttp.Get(ctx, httpClient, url)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &DownloadError{URL: url, StatusCode: resp.StatusCode}
+	}
 
 	w, err := os.Create(localPath)
 	if err != nil {